@@ -17,7 +17,7 @@ type ServiceConfig struct {
 	// 要求字符串类型的字段小写且去掉前后空格
 	ServiceName string            `json:"servicename,omitempty"` // 服务器类型名，用来分组【内部会转化成去空格的小写】
 	ServiceId   string            `json:"serviceid,omitempty"`   // 服务器唯一ID【内部会转化成去空格的小写】
-	ServiceAddr string            `json:"serviceaddr,omitempty"` // 服务器对外暴露的地址
+	ServiceAddr string            `json:"serviceaddr,omitempty"` // 服务器对外暴露的地址 Tcp服务支持unix:///path/to/xxx.sock格式
 	ServicePort int               `json:"serviceport,omitempty"` // 服务器对外暴露的端口
 	Metadata    map[string]string `json:"metadata,omitempty"`    // 配置的元数据
 	RoutingTag  []string          `json:"routertag,omitempty"`   // 支持路由tag 可以将服务器分到多个组中【内部会转化成去空格的小写并去重排序】
@@ -1,7 +1,18 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -12,6 +23,7 @@ import (
 	_ "github.com/yuwf/gobase/log"
 	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/nacos"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/rs/zerolog/log"
@@ -228,3 +240,270 @@ func BenchmarkTCPBackendGoRedis(b *testing.B) {
 
 	utils.ExitWait()
 }
+
+// 生成自签名证书文件 返回证书和私钥路径
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gobase-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestTcpTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+
+	c := &TcpTLSConfig{
+		ServiceNames: []string{" Gate* "},
+		CAFile:       certFile,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ServerName:   "gate.local",
+	}
+	c.normalize()
+	conf, err := c.clientConfig("gateway")
+	if err != nil || conf == nil {
+		t.Fatalf("gateway tls config %v %v", conf, err)
+	}
+	if conf.ServerName != "gate.local" || len(conf.Certificates) != 1 || conf.RootCAs == nil {
+		t.Fatalf("gateway tls config %+v", conf)
+	}
+	// 不匹配的服务不使用TLS
+	if conf, err := c.clientConfig("logic"); conf != nil || err != nil {
+		t.Fatalf("logic tls config %v %v", conf, err)
+	}
+
+	// 证书加载失败 不允许退化成明文连接
+	c = &TcpTLSConfig{CertFile: "notexist.pem", KeyFile: "notexist.key"}
+	c.normalize()
+	if _, err := c.clientConfig("gateway"); err == nil {
+		t.Fatal("expect error")
+	}
+
+	// 没有TLS配置
+	var nilConf *TcpTLSConfig
+	if conf, err := nilConf.clientConfig("gateway"); conf != nil || err != nil {
+		t.Fatalf("nil tls config %v %v", conf, err)
+	}
+}
+
+type cipherTcpHandler struct {
+	TcpHandler
+	connected chan *TcpService[TcpServiceInfo]
+	resp      chan *utils.TestHeatBeatResp
+}
+
+func (h *cipherTcpHandler) OnMsgReg(md *msger.MsgDispatch) {
+	md.RegMsg(utils.TestHeatBeatRespMsg.MsgID(), func(ctx context.Context, msg *utils.TestHeatBeatResp, ts *TcpService[TcpServiceInfo]) {
+		h.resp <- msg
+	})
+}
+
+func (h *cipherTcpHandler) OnConnected(ctx context.Context, ts *TcpService[TcpServiceInfo]) {
+	h.connected <- ts
+}
+
+func (h *cipherTcpHandler) OnTick(ctx context.Context, ts *TcpService[TcpServiceInfo]) {
+}
+
+func TestTcpServiceCipher(t *testing.T) {
+	TcpParamConf.Get().Cipher = &TcpCipherConfig{ServiceNames: []string{"Gate*"}}
+	TcpParamConf.Get().Normalize()
+	defer func() { TcpParamConf.Get().Cipher = nil }()
+	if TcpParamConf.Get().Cipher.serviceConfig("logic") != nil {
+		t.Fatal("logic cipher config")
+	}
+
+	// 写队列丢弃最早的数据时不能开启加密
+	TcpParamConf.Get().Conn.MQPolicy = tcp.MQPolicyDropOldest
+	_, err := NewTcpService[TcpServiceInfo](&ServiceConfig{ServiceName: "gate", ServiceId: "gate1", ServiceAddr: "127.0.0.1", ServicePort: 1258}, nil)
+	TcpParamConf.Get().Conn.MQPolicy = tcp.MQPolicyBlock
+	if err != tcp.ErrCipherMQPolicy {
+		t.Fatal(err)
+	}
+
+	// 服务器端 收到心跳请求回复心跳
+	l, err := net.Listen("tcp", "127.0.0.1:1258")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c, _ := tcp.NewCipher(tcp.CipherConfig{Suite: tcp.CipherAESGCM}, true, func(b []byte) error {
+			_, err := conn.Write(b)
+			return err
+		})
+		var pending []byte
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			pending = append(pending, buf[:n]...)
+			l, err := c.Recv(pending, nil, func(plain []byte) (int, error) {
+				m, l, err := utils.TestDecodeMsg(plain)
+				if m != nil && m.Msgid == utils.TestHeatBeatReqMsg.Msgid {
+					data, _ := utils.TestHeatBeatRespMsg.MsgMarshal()
+					c.Send(data)
+				}
+				return l, err
+			})
+			if err != nil {
+				return
+			}
+			pending = pending[l:]
+		}
+	}()
+
+	h := &cipherTcpHandler{connected: make(chan *TcpService[TcpServiceInfo], 8), resp: make(chan *utils.TestHeatBeatResp, 8)}
+	tb, err := NewTcpBackend[TcpServiceInfo, utils.TestMsg](h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.UpdateServices([]*ServiceConfig{{ServiceName: "gate", ServiceId: "gate1", ServiceAddr: "127.0.0.1", ServicePort: 1258}})
+	defer tb.UpdateServices(nil)
+
+	// 握手完成后回调OnConnected
+	var ts *TcpService[TcpServiceInfo]
+	select {
+	case ts = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+	if err := ts.SendMsg(context.TODO(), utils.TestHeatBeatReqMsg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-h.resp:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait resp timeout")
+	}
+}
+
+type compressTcpHandler struct {
+	cipherTcpHandler
+	recv chan []byte
+}
+
+func (h *compressTcpHandler) OnMsg(ctx context.Context, mr msger.RecvMsger, ts *TcpService[TcpServiceInfo]) {
+	m, _ := mr.(*utils.TestMsg)
+	h.recv <- append([]byte(nil), m.RecvData...)
+}
+
+func TestTcpServiceCompress(t *testing.T) {
+	TcpParamConf.Get().Cipher = &TcpCipherConfig{}
+	TcpParamConf.Get().Compress = &TcpCompressConfig{ServiceNames: []string{"Gate*"}, Algos: []string{"Snappy"}, MinSize: 64}
+	TcpParamConf.Get().Normalize()
+	defer func() { TcpParamConf.Get().Cipher, TcpParamConf.Get().Compress = nil, nil }()
+	if TcpParamConf.Get().Compress.serviceConfig("logic") != nil {
+		t.Fatal("logic compress config")
+	}
+
+	// 服务器端 加密后压缩，收到大消息原样返回
+	l, err := net.Listen("tcp", "127.0.0.1:1260")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c, _ := tcp.NewCipher(tcp.CipherConfig{}, true, func(b []byte) error {
+			_, err := conn.Write(b)
+			return err
+		})
+		cc, _ := tcp.NewCompressor(tcp.CompressConfig{MinSize: 64}, true, c.Send, nil)
+		var pending []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			pending = append(pending, buf[:n]...)
+			l, err := c.Recv(pending, nil, func(plain []byte) (int, error) {
+				return cc.Recv(plain, func(plain []byte) (int, error) {
+					m, l, err := utils.TestDecodeMsg(plain)
+					if m != nil && m.Msgid == 99 {
+						cc.Send(plain[:l])
+					}
+					return l, err
+				})
+			})
+			if err != nil {
+				return
+			}
+			pending = pending[l:]
+		}
+	}()
+
+	h := &compressTcpHandler{
+		cipherTcpHandler: cipherTcpHandler{connected: make(chan *TcpService[TcpServiceInfo], 8), resp: make(chan *utils.TestHeatBeatResp, 8)},
+		recv:             make(chan []byte, 8),
+	}
+	tb, err := NewTcpBackend[TcpServiceInfo, utils.TestMsg](h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.UpdateServices([]*ServiceConfig{{ServiceName: "gate", ServiceId: "gate1", ServiceAddr: "127.0.0.1", ServicePort: 1260}})
+	defer tb.UpdateServices(nil)
+
+	var ts *TcpService[TcpServiceInfo]
+	select {
+	case ts = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+	// 等待压缩协商完成
+	for i := 0; i < 100 && ts.compress.Load().Algo() != tcp.CompressSnappy; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if algo := ts.compress.Load().Algo(); algo != tcp.CompressSnappy {
+		t.Fatalf("algo %s", algo)
+	}
+
+	body := bytes.Repeat([]byte("gobase compress "), 256)
+	msg := make([]byte, 8+len(body))
+	msg[0] = 99
+	msg[4], msg[5] = byte(len(body)), byte(len(body)>>8)
+	copy(msg[8:], body)
+	if err := ts.Send(context.TODO(), msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-h.recv:
+		if !bytes.Equal(b, body) {
+			t.Fatalf("recv %d", len(b))
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait recv timeout")
+	}
+}
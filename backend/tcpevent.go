@@ -57,6 +57,13 @@ type TcpEvent[ServiceInfo any] interface {
 	OnTick(ctx context.Context, ts *TcpService[ServiceInfo])
 }
 
+// TcpEvent可选择实现的接口，连接的写队列达到高水位和回落到低水位时调用，可用来控制发送频率
+// 发送协程或写协程调用，不要阻塞
+type TcpWaterEvent[ServiceInfo any] interface {
+	OnHighWater(ts *TcpService[ServiceInfo])
+	OnLowWater(ts *TcpService[ServiceInfo])
+}
+
 // TcpEventHandler TcpEvent的内置实现
 // 如果不想实现TcpEvent的所有接口，可以继承它实现部分方法
 type TcpEventHandler[ServiceInfo any] struct {
@@ -113,3 +120,18 @@ type TCPHook[ServiceInfo any] interface {
 	// 接受消息数据，消息解码后调用
 	OnRecvMsg(ts *TcpService[ServiceInfo], mr msger.RecvMsger, len int)
 }
+
+// TCPHook可选择实现的接口，开启加密的连接握手失败或者解密失败时调用 err为tcp.ErrCipher*或者连接的错误
+type TCPCipherHook[ServiceInfo any] interface {
+	OnCipherFail(ts *TcpService[ServiceInfo], err error)
+}
+
+// TCPHook可选择实现的接口，开启压缩的连接发送或者接受一个消息后调用，send表示发送，rawLen为原始长度，len为压缩后的长度，未压缩的消息两者相同
+type TCPCompressHook[ServiceInfo any] interface {
+	OnCompress(ts *TcpService[ServiceInfo], send bool, rawLen, len int)
+}
+
+// TCPHook可选择实现的接口，写队列满了MQPolicyDropOldest丢弃数据时调用，len为丢弃数据的长度
+type TCPDropHook[ServiceInfo any] interface {
+	OnDropOldest(ts *TcpService[ServiceInfo], len int)
+}
@@ -3,7 +3,17 @@ package backend
 // https://github.com/yuwf/gobase
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+
 	"github.com/yuwf/gobase/loader"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/rs/zerolog/log"
 )
 
 // 参数配置
@@ -11,6 +21,40 @@ type TcpParamConfig struct {
 	MsgSeq       bool    `json:"msgseq,omitempty"`       // 消息顺序执行 默认为按顺序执行
 	Immediately  bool    `json:"immediately,omitempty"`  // 立即模式 如果服务器发现逻辑服务器不存在了立刻删除服务对象，否则等socket失去连接后删除服务对象
 	TickInterval float32 `json:"tickinterval,omitempty"` // 心跳间隔 单位秒 默认1秒
+
+	Conn tcp.TCPConnConfig `json:"conn,omitempty"` // 连接参数 写队列、合并写入、重连退避等，新创建的TcpService生效
+	TLS  *TcpTLSConfig     `json:"tls,omitempty"`  // TLS连接参数 为空表示不使用TLS，新创建的TcpService生效
+
+	Cipher   *TcpCipherConfig   `json:"cipher,omitempty"`   // 数据加密参数 为空表示不加密，需要和服务器的ParamConfig.Cipher一致开启，Conn.MQPolicy不能为MQPolicyDropOldest，新创建的TcpService生效
+	Compress *TcpCompressConfig `json:"compress,omitempty"` // 消息压缩参数 为空表示不压缩，需要和服务器的ParamConfig.Compress一致开启，新创建的TcpService生效
+}
+
+// 数据加密参数 加密套件由服务器选择
+type TcpCipherConfig struct {
+	ServiceNames []string `json:"servicenames,omitempty"` // 使用加密的服务名 支持?*通配符 不区分大小写 为空表示所有服务
+	MaxFrameSize int      `json:"maxframesize,omitempty"` // 一帧明文的最大长度 默认16M
+	PSK          string   `json:"psk,omitempty"`          // 预共享密钥 和服务器一致，用于认证服务器防止中间人 为空时握手是匿名的
+}
+
+// 消息压缩参数 压缩算法由服务器选择
+type TcpCompressConfig struct {
+	ServiceNames []string `json:"servicenames,omitempty"` // 使用压缩的服务名 支持?*通配符 不区分大小写 为空表示所有服务
+	Algos        []string `json:"algos,omitempty"`        // 支持的压缩算法 按优先顺序 flate zstd snappy 默认[flate,snappy]
+	MinSize      int      `json:"minsize,omitempty"`      // 消息长度达到后才压缩 默认1024
+	MaxSize      int      `json:"maxsize,omitempty"`      // 一帧和解压后的最大长度 默认16M
+}
+
+// TLS连接参数
+type TcpTLSConfig struct {
+	ServiceNames       []string `json:"servicenames,omitempty"`       // 使用TLS连接的服务名 支持?*通配符 不区分大小写 为空表示所有服务
+	CAFile             string   `json:"cafile,omitempty"`             // 校验服务端证书的CA文件 为空使用系统CA
+	CertFile           string   `json:"certfile,omitempty"`           // 客户端证书文件 配置后做双向认证
+	KeyFile            string   `json:"keyfile,omitempty"`            // 客户端私钥文件
+	ServerName         string   `json:"servername,omitempty"`         // SNI和证书校验使用的域名 为空使用服务地址，unix地址必须配置
+	InsecureSkipVerify bool     `json:"insecureskipverify,omitempty"` // 不校验服务端证书 测试使用
+
+	config *tls.Config // Normalize时生成
+	err    error       // 生成config的错误 有错误时不创建连接，防止退化成明文连接
 }
 
 var TcpParamConf loader.JsonLoader[TcpParamConfig]
@@ -19,3 +63,104 @@ func (c *TcpParamConfig) Create() {
 	c.MsgSeq = true // 默认为按顺序执行
 	c.TickInterval = 1.0
 }
+
+func (c *TcpParamConfig) Normalize() {
+	if c.TLS != nil {
+		c.TLS.normalize()
+	}
+	if c.Cipher != nil {
+		normalizeServices(c.Cipher.ServiceNames)
+	}
+	if c.Compress != nil {
+		normalizeServices(c.Compress.ServiceNames)
+	}
+}
+
+// ServiceNames统一小写
+func normalizeServices(names []string) {
+	for i, name := range names {
+		names[i] = strings.TrimSpace(strings.ToLower(name))
+	}
+}
+
+// 服务名是否在names中 支持?*通配符 names为空表示所有服务
+func matchService(names []string, service string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if utils.IsMatch(name, service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TcpTLSConfig) normalize() {
+	normalizeServices(c.ServiceNames)
+	c.config, c.err = c.build()
+	if c.err != nil {
+		log.Error().Err(c.err).Str("CAFile", c.CAFile).Str("CertFile", c.CertFile).Str("KeyFile", c.KeyFile).Msg("TcpTLSConfig build fail")
+	}
+}
+
+func (c *TcpTLSConfig) build() (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("invalid ca file " + c.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// 获取服务使用的TLS配置 返回nil表示不使用TLS
+func (c *TcpTLSConfig) clientConfig(serviceName string) (*tls.Config, error) {
+	if c == nil || !matchService(c.ServiceNames, serviceName) {
+		return nil, nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.config == nil {
+		// 未经过Normalize的配置
+		return c.build()
+	}
+	return c.config, nil
+}
+
+// 获取服务使用的加密配置 返回nil表示不加密
+func (c *TcpCipherConfig) serviceConfig(serviceName string) *tcp.CipherConfig {
+	if c == nil || !matchService(c.ServiceNames, serviceName) {
+		return nil
+	}
+	conf := &tcp.CipherConfig{Enable: true, MaxFrameSize: c.MaxFrameSize, PSK: c.PSK}
+	conf.Normalize()
+	return conf
+}
+
+// 获取服务使用的压缩配置 返回nil表示不压缩
+func (c *TcpCompressConfig) serviceConfig(serviceName string) *tcp.CompressConfig {
+	if c == nil || !matchService(c.ServiceNames, serviceName) {
+		return nil
+	}
+	conf := &tcp.CompressConfig{Enable: true, Algos: append([]string(nil), c.Algos...), MinSize: c.MinSize, MaxSize: c.MaxSize}
+	conf.Normalize()
+	return conf
+}
@@ -41,6 +41,12 @@ type TcpService[ServiceInfo any] struct {
 	info     *ServiceInfo           // 客户端信息，内容修改需要外层加锁控制
 	conn     *tcp.TCPConn           // 连接对象，协程安全
 
+	cipherConf *tcp.CipherConfig          // 数据加密配置 为nil表示不加密
+	cipher     atomic.Pointer[tcp.Cipher] // 数据加密 每次连接成功后创建，握手完成前发送数据返回tcp.ErrCipherHandShake
+
+	compressConf *tcp.CompressConfig            // 消息压缩配置 为nil表示不压缩
+	compress     atomic.Pointer[tcp.Compressor] // 消息压缩 每次连接成功后创建，开启加密时在加密握手完成后协商
+
 	confDestroy int32 // 表示配置是否已经销毁了 原子操作，如果conn正在连接中，直接销毁该对象
 	connLogined int32 // 表示连接是否登录成功了 原子操作
 
@@ -60,7 +66,7 @@ func NewTcpService[ServiceInfo any](conf *ServiceConfig, g *TcpGroup[ServiceInfo
 		g:           g,
 		conf:        conf,
 		confDestroy: 0,
-		address:     fmt.Sprintf("%s:%d", conf.ServiceAddr, conf.ServicePort),
+		address:     serviceAddress(conf),
 		info:        new(ServiceInfo),
 		connLogined: 0,
 		ctx:         context.WithValue(context.TODO(), CtxKey_scheme, "tcp"),
@@ -69,7 +75,21 @@ func NewTcpService[ServiceInfo any](conf *ServiceConfig, g *TcpGroup[ServiceInfo
 		quitState:   0,
 		closed:      make(chan struct{}),
 	}
-	conn, err := tcp.NewTCPConn(ts.address, ts)
+	paramConf := TcpParamConf.Get()
+	ts.cipherConf = paramConf.Cipher.serviceConfig(conf.ServiceName)
+	ts.compressConf = paramConf.Compress.serviceConfig(conf.ServiceName)
+	tlsConf, err := paramConf.TLS.clientConfig(conf.ServiceName)
+	if err == nil && ts.cipherConf != nil {
+		err = tcp.CheckCipherConn(&paramConf.Conn)
+	}
+	var conn *tcp.TCPConn
+	if err == nil {
+		if tlsConf != nil {
+			conn, err = tcp.NewTCPConnTLS(ts.address, ts, &paramConf.Conn, tlsConf)
+		} else {
+			conn, err = tcp.NewTCPConnWithConfig(ts.address, ts, &paramConf.Conn)
+		}
+	}
 	if err != nil {
 		log.Error().Str("ServiceName", conf.ServiceName).
 			Str("ServiceId", conf.ServiceId).Err(err).
@@ -91,6 +111,14 @@ func NewTcpService[ServiceInfo any](conf *ServiceConfig, g *TcpGroup[ServiceInfo
 	return ts, nil
 }
 
+// 服务的连接地址 ServiceAddr为unix:///path/to/xxx.sock格式时使用unix domain socket连接，忽略ServicePort
+func serviceAddress(conf *ServiceConfig) string {
+	if tcp.IsUnixAddress(conf.ServiceAddr) {
+		return conf.ServiceAddr
+	}
+	return fmt.Sprintf("%s:%d", conf.ServiceAddr, conf.ServicePort)
+}
+
 // 重连或者关闭连接时调用
 func (ts *TcpService[ServiceInfo]) clear() {
 	// 清空下rpc
@@ -165,6 +193,11 @@ func (ts *TcpService[ServiceInfo]) HealthStatus() (int, bool) {
 	}
 }
 
+// 写队列是否处于高水位，业务层可根据此值控制发送频率
+func (ts *TcpService[ServiceInfo]) HighWater() bool {
+	return ts.conn.HighWater()
+}
+
 func (ts *TcpService[ServiceInfo]) Send(ctx context.Context, data []byte) error {
 	var err error
 	if len(data) == 0 {
@@ -180,7 +213,7 @@ func (ts *TcpService[ServiceInfo]) Send(ctx context.Context, data []byte) error
 		}
 	}()
 	// 发送
-	err = ts.conn.Send(data)
+	err = ts.send(data)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("Send %s error", ts.ConnName())
 		return err
@@ -210,7 +243,7 @@ func (ts *TcpService[ServiceInfo]) SendMsg(ctx context.Context, msg msger.Msger)
 		}
 	}()
 	// 发送
-	err = ts.conn.Send(data)
+	err = ts.send(data)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", ts.ConnName())
 		return err
@@ -263,7 +296,7 @@ func (ts *TcpService[ServiceInfo]) SendRPCMsg(ctx context.Context, rpcId interfa
 		}
 	}()
 	// 发送
-	err = ts.conn.Send(data)
+	err = ts.send(data)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", ts.ConnName())
 		return nil, err
@@ -352,7 +385,7 @@ func (ts *TcpService[ServiceInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId in
 		}
 	}()
 	// 发送
-	err = ts.conn.Send(data)
+	err = ts.send(data)
 	if err != nil {
 		// 发送失败，先删除channel记录
 		if _, ok := ts.rpc.LoadAndDelete(rpcIdV); ok {
@@ -480,6 +513,30 @@ func (ts *TcpService[ServiceInfo]) loopTick() {
 	close(ts.closed)
 }
 
+// 写入连接 开启压缩时压缩后写入
+func (ts *TcpService[ServiceInfo]) send(data []byte) error {
+	if ts.compressConf != nil {
+		c := ts.compress.Load()
+		if c == nil {
+			return errors.New("net not connect")
+		}
+		return c.Send(data)
+	}
+	return ts.sendFrame(data)
+}
+
+// 开启加密时加密后写入
+func (ts *TcpService[ServiceInfo]) sendFrame(data []byte) error {
+	if ts.cipherConf != nil {
+		c := ts.cipher.Load()
+		if c == nil {
+			return tcp.ErrCipherHandShake
+		}
+		return c.Send(data)
+	}
+	return ts.conn.Send(data)
+}
+
 // 此函数会等待网络彻底关闭，会调用OnDisConnect
 func (ts *TcpService[ServiceInfo]) close() {
 	// 关闭网络
@@ -492,9 +549,11 @@ func (ts *TcpService[ServiceInfo]) close() {
 }
 
 func (ts *TcpService[ServiceInfo]) OnDialFail(err error, t *tcp.TCPConn) error {
-	log.Error().Err(err).Str("DialAddr", ts.address).Int32("ConfDestroy", atomic.LoadInt32(&ts.confDestroy)).Msgf("Connect %s fail", ts.ConnName())
-	if atomic.LoadInt32(&ts.confDestroy) == 1 {
-		// 服务器发现配置已经不存在了，停止loop，直接从group中删除
+	log.Error().Err(err).Str("DialAddr", ts.address).Int32("ConfDestroy", atomic.LoadInt32(&ts.confDestroy)).
+		Int("Attempt", t.DialAttempt()).Dur("NextDelay", t.DialNextDelay()).Msgf("Connect %s fail", ts.ConnName())
+	giveup := t.DialNextDelay() == 0 // 达到重连次数上限
+	if atomic.LoadInt32(&ts.confDestroy) == 1 || giveup {
+		// 服务器发现配置已经不存在了或者不再重连，停止loop，直接从group中删除，重连次数上限的等服务器发现再次创建
 		utils.Submit(func() {
 			// 使用协程，因为在group的update中调用功能close removeSevice会阻塞
 			ts.g.removeSevice(ts.conf.ServiceId) // 先删
@@ -503,6 +562,9 @@ func (ts *TcpService[ServiceInfo]) OnDialFail(err error, t *tcp.TCPConn) error {
 			close(ts.quit)
 			<-ts.closed
 		}
+		if giveup {
+			return errors.New("dial max attempts")
+		}
 		return errors.New("config destroy")
 	}
 	return nil
@@ -514,7 +576,51 @@ func (ts *TcpService[ServiceInfo]) OnDialSuccess(t *tcp.TCPConn) {
 	// 修改连接版本
 	ts.g.tb.addConnVersion(ts.g.serviceName)
 
-	if ts.g.tb.event != nil {
+	if ts.compressConf != nil {
+		// 开启加密时加密握手完成后协商
+		c, err := tcp.NewCompressor(*ts.compressConf, false, ts.sendFrame, func(send bool, rawLen, len int) {
+			// 回调
+			defer utils.HandlePanic()
+			for _, h := range ts.g.tb.hook {
+				if ch, ok := h.(TCPCompressHook[ServiceInfo]); ok {
+					ch.OnCompress(ts, send, rawLen, len)
+				}
+			}
+		})
+		if err == nil {
+			ts.compress.Store(c)
+			if ts.cipherConf == nil {
+				err = c.Start()
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Connect %s compress error", ts.ConnName())
+			t.Reconn()
+		}
+	}
+
+	if ts.cipherConf != nil {
+		// 发送握手数据 握手完成后回调OnConnected
+		c, err := tcp.NewCipher(*ts.cipherConf, false, t.Send)
+		if err != nil {
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range ts.g.tb.hook {
+					if ch, ok := h.(TCPCipherHook[ServiceInfo]); ok {
+						ch.OnCipherFail(ts, err)
+					}
+				}
+			}()
+		} else {
+			ts.cipher.Store(c) // 先保存 防止握手回复先到
+			err = c.Start()    // 失败时OnDisConnect中回调OnCipherFail
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Connect %s cipher error", ts.ConnName())
+			t.Reconn()
+		}
+	} else if ts.g.tb.event != nil {
 		ts.seq.Submit(func() {
 			ctx := utils.CtxSetTrace(ts.ctx, 0, "Connected")
 			ts.g.tb.event.OnConnected(ctx, ts)
@@ -546,8 +652,28 @@ func (ts *TcpService[ServiceInfo]) OnDisConnect(err error, t *tcp.TCPConn) error
 	ts.g.tb.addLoginVersion(ts.g.serviceName)
 
 	ts.clear()
+	ts.compress.Store(nil)
+
+	established := true // 是否回调过OnConnected
+	if c := ts.cipher.Swap(nil); c != nil {
+		established = c.Established()
+		if cerr := c.Fail(); cerr != nil {
+			log.Warn().Err(cerr).Msgf("CipherFail %s", ts.ConnName())
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range ts.g.tb.hook {
+					if ch, ok := h.(TCPCipherHook[ServiceInfo]); ok {
+						ch.OnCipherFail(ts, cerr)
+					}
+				}
+			}()
+		}
+	} else if ts.cipherConf != nil {
+		established = false
+	}
 
-	if ts.g.tb.event != nil {
+	if ts.g.tb.event != nil && established {
 		ts.seq.Submit(func() {
 			ctx := utils.CtxSetTrace(ts.ctx, 0, "DisConnected")
 			ts.g.tb.event.OnDisConnect(ctx, ts)
@@ -585,7 +711,28 @@ func (ts *TcpService[ServiceInfo]) OnRecv(data []byte, tc *tcp.TCPConn) (int, er
 			h.OnRecvData(ts, len(data))
 		}
 	}()
-	len, err := ts.recv(data)
+	if ts.cipherConf != nil {
+		c := ts.cipher.Load()
+		if c == nil {
+			return len(data), nil
+		}
+		// 先解密 握手完成时回调OnConnected
+		return c.Recv(data, func() {
+			log.Info().Msgf("Connect %s cipher handshake", ts.ConnName())
+			if cc := ts.compress.Load(); cc != nil {
+				if err := cc.Start(); err != nil {
+					log.Error().Err(err).Msgf("Connect %s compress error", ts.ConnName())
+				}
+			}
+			if ts.g.tb.event != nil {
+				ts.seq.Submit(func() {
+					ctx := utils.CtxSetTrace(ts.ctx, 0, "Connected")
+					ts.g.tb.event.OnConnected(ctx, ts)
+				})
+			}
+		}, ts.recvFrame)
+	}
+	len, err := ts.recvFrame(data)
 	//if err != nil {
 	//	tc.Close(err) // 不需要关，tcpconn会根据err关闭掉，并调用OnDisConnect
 	//}
@@ -603,6 +750,52 @@ func (ts *TcpService[ServiceInfo]) OnSend(data []byte, tc *tcp.TCPConn) ([]byte,
 	return data, nil
 }
 
+func (ts *TcpService[ServiceInfo]) OnHighWater(tc *tcp.TCPConn) {
+	log.Warn().Int("MQLen", tc.MQLen()).Int64("MQBytes", tc.MQBytes()).Msgf("HighWater %s", ts.ConnName())
+	if we, ok := ts.g.tb.event.(TcpWaterEvent[ServiceInfo]); ok {
+		func() {
+			defer utils.HandlePanic()
+			we.OnHighWater(ts)
+		}()
+	}
+}
+
+func (ts *TcpService[ServiceInfo]) OnLowWater(tc *tcp.TCPConn) {
+	log.Info().Int("MQLen", tc.MQLen()).Int64("MQBytes", tc.MQBytes()).Msgf("LowWater %s", ts.ConnName())
+	if we, ok := ts.g.tb.event.(TcpWaterEvent[ServiceInfo]); ok {
+		func() {
+			defer utils.HandlePanic()
+			we.OnLowWater(ts)
+		}()
+	}
+}
+
+func (ts *TcpService[ServiceInfo]) OnDropOldest(data []byte, tc *tcp.TCPConn) {
+	if tc.MQDropped() == 1 { // 只输出第一次 后面的通过Hook统计
+		log.Warn().Int("MQLen", tc.MQLen()).Int64("MQBytes", tc.MQBytes()).Msgf("DropOldest %s", ts.ConnName())
+	}
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range ts.g.tb.hook {
+			if dh, ok := h.(TCPDropHook[ServiceInfo]); ok {
+				dh.OnDropOldest(ts, len(data))
+			}
+		}
+	}()
+}
+
+// 处理解密后的数据 开启压缩时解压后处理
+func (ts *TcpService[ServiceInfo]) recvFrame(data []byte) (int, error) {
+	if ts.compressConf != nil {
+		c := ts.compress.Load()
+		if c == nil {
+			return len(data), nil
+		}
+		return c.Recv(data, ts.recv)
+	}
+	return ts.recv(data)
+}
+
 func (ts *TcpService[ServiceInfo]) recv(data []byte) (int, error) {
 	if ts.g.tb.event == nil {
 		return len(data), nil
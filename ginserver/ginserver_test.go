@@ -2,16 +2,20 @@ package ginserver
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	_ "github.com/yuwf/gobase/log"
+	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/afex/hystrix-go/hystrix"
 	"github.com/gin-gonic/gin"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -93,3 +97,129 @@ func BenchmarkGinServerHystrix(b *testing.B) {
 
 	utils.ExitWait()
 }
+
+type wsClientInfo struct {
+}
+
+type wsHandler struct {
+	WSEventHandler[wsClientInfo]
+	conns chan *WSClient[wsClientInfo]
+}
+
+func (h *wsHandler) OnMsgReg(md *msger.MsgDispatch) {
+	md.RegMsg(utils.TestHeatBeatReqMsg.MsgID(), h.onHeatBeatReq)
+}
+
+func (h *wsHandler) OnConnected(ctx context.Context, wc *WSClient[wsClientInfo]) {
+	h.conns <- wc
+}
+
+func (h *wsHandler) DecodeMsg(ctx context.Context, data []byte, wc *WSClient[wsClientInfo]) (msger.RecvMsger, int, error) {
+	if ctx.Value(CtxKey_Text) != nil {
+		return &utils.TestMsg{TestMsgHead: utils.TestMsgHead{Msgid: 0, Len: uint32(len(data))}, RecvData: data}, len(data), nil
+	}
+	return utils.TestDecodeMsg(data)
+}
+
+func (h *wsHandler) OnMsg(ctx context.Context, mr msger.RecvMsger, wc *WSClient[wsClientInfo]) {
+	if ctx.Value(CtxKey_Text) != nil {
+		wc.SendText(ctx, mr.(*utils.TestMsg).RecvData) // 原路返回
+	}
+}
+
+func (h *wsHandler) onHeatBeatReq(ctx context.Context, msg *utils.TestHeatBeatReq, wc *WSClient[wsClientInfo]) {
+	wc.SendMsg(ctx, utils.TestHeatBeatRespMsg)
+}
+
+func TestWSServer(t *testing.T) {
+	server := NewGinServer(1255)
+	server.RegHandler("GET", "/health", func(c *gin.Context) { c.String(http.StatusOK, "success") })
+	h := &wsHandler{conns: make(chan *WSClient[wsClientInfo], 4)}
+	wss, err := NewWSServer[int, wsClientInfo, utils.TestMsg](server, "/ws", h, func(c *gin.Context) {
+		if c.Query("token") != "abc" {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wss.Start()
+	server.Start()
+	defer server.Stop()
+	defer wss.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	// 同一个端口的http接口
+	resp, err := http.Get("http://127.0.0.1:1255/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "success" {
+		t.Fatalf("health %s", body)
+	}
+
+	// 验证不通过
+	if _, _, _, err := ws.Dial(context.TODO(), "ws://127.0.0.1:1255/ws?token=bad"); err != ws.StatusError(http.StatusForbidden) {
+		t.Fatalf("token %v", err)
+	}
+
+	conn, _, _, err := ws.Dial(context.TODO(), "ws://127.0.0.1:1255/ws?token=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wc := <-h.conns
+	if wc.Request().URL.Path != "/ws" {
+		t.Fatalf("path %s", wc.Request().URL.Path)
+	}
+	wss.AddClient(1, wc)
+	if n, _ := wss.ConnCount(); n != 1 || wss.ClientCount() != 1 || wss.GetClient(1) != wc {
+		t.Fatalf("count %d %d", n, wss.ClientCount())
+	}
+
+	// 二进制消息经过MsgDispatch分发
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if err := wsutil.WriteClientBinary(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	data, err = wsutil.ReadServerBinary(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := utils.TestDecodeMsg(data)
+	if err != nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+
+	// 文本消息交给OnMsg
+	if err := wsutil.WriteClientText(conn, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	text, err := wsutil.ReadServerText(conn)
+	if err != nil || string(text) != "hello" {
+		t.Fatalf("text %s %v", text, err)
+	}
+
+	// 服务器关闭 客户端回复关闭帧后断开
+	wc.Close(wsutil.ClosedError{Code: 4000, Reason: "bye"})
+	f, err := ws.ReadFrame(conn)
+	if err != nil || f.Header.OpCode != ws.OpClose {
+		t.Fatalf("close %v %v", f.Header, err)
+	}
+	if code, reason := ws.ParseCloseFrameData(f.Payload); code != 4000 || reason != "bye" {
+		t.Fatalf("close %d %s", code, reason)
+	}
+	wsutil.WriteClientMessage(conn, ws.OpClose, f.Payload)
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("not closed %v", err)
+	}
+	for start := time.Now(); wss.ClientCount() != 0 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if wss.ClientCount() != 0 {
+		t.Fatal("client not removed")
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"strings"
 
 	"github.com/yuwf/gobase/loader"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/afex/hystrix-go/hystrix"
 )
 
+const CtxKey_WS = utils.CtxKey("ws")     // 存在表示ws连接 值：不受限制 一般写1
+const CtxKey_Text = utils.CtxKey("text") // 存在表示数据为text格式，否则为二进制格式 值：不受限制 一般写1
+
 // 参数配置
 type ParamConfig struct {
 	// 日志级别和zerolog.Level一致
@@ -35,6 +39,11 @@ type ParamConfig struct {
 	// SleepWindow: 熔断器被打开后 SleepWindow的时间就是控制过多久后去尝试服务是否可用了 单位为毫秒
 	// ErrorPercentThreshold: 错误百分比 请求数量大于等于 RequestVolumeThreshold 并且错误率到达这个百分比后就会启动熔断
 	Hystrix map[string]*hystrix.CommandConfig `json:"hystrix,omitempty"` // 熔断器 [path:Config]，path 支持?*通配符 不区分大小写 目前不支持动态删除
+
+	// WSServer使用
+	WSMsgSeq          bool                `json:"wsmsgseq,omitempty"`          // 消息顺序执行 默认true
+	WSReadIdleTimeout int                 `json:"wsreadidletimeout,omitempty"` // 读空闲超时 超过时间未收到数据关闭连接 单位秒 <=0表示不检查
+	WSDeflate         tcp.WSDeflateConfig `json:"wsdeflate,omitempty"`         // websocket permessage-deflate压缩 新建立的连接生效
 }
 
 var ParamConf loader.JsonLoader[ParamConfig]
@@ -51,6 +60,7 @@ func (c *ParamConfig) Create() {
 	c.TimeOutCheck = 8 // 8秒超时报警
 	c.Cors = defaultCorsOptions
 	c.BodyLogLimit = 256
+	c.WSMsgSeq = true // 默认为按顺序执行
 }
 
 func (c *ParamConfig) Normalize() {
@@ -78,6 +88,7 @@ func (c *ParamConfig) Normalize() {
 		hystrix.ConfigureCommand("gin_"+path, *config) // 加个gin_前缀，区别其他模块使用
 	}
 	c.Cors.Normalize()
+	c.WSDeflate.Normalize()
 }
 
 func (c *ParamConfig) GetLogLevel(path, ip string) int {
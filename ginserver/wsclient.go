@@ -0,0 +1,746 @@
+package ginserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type ClientNamer interface {
+	ClientName() string
+}
+type ClientCreater interface {
+	ClientCreate()
+}
+
+// 发送关闭帧后等待客户端回复的时间 超时后直接关闭连接
+const wsCloseTimeout = time.Second * 3
+
+// 写超时 超时后关闭连接
+const wsWriteTimeout = time.Second * 10
+
+// websocket状态
+const (
+	wsStateOpen    = iota // 握手完成
+	wsStateClosing        // 服务器发送了关闭帧 等待客户端回复
+	wsStateClosed         // 客户端发起了关闭 不再处理收到的数据
+)
+
+// WSClient是WSServer在gin路由上升级的websocket连接对象，实现了msger.ConnTermianl
+// ClientInfo是和业务相关的客户端信息结构
+// 如果ClientInfo存在ClientCreate函数，创建链接时会调用
+// 如果ClientInfo存在ClientName函数，输出日志是会调用
+type WSClient[ClientInfo any] struct {
+	// 本身不可修改对象
+	conn       net.Conn            // 升级后的连接
+	rd         *bufio.Reader       // 升级时http服务的读缓存 可能已经缓存了数据
+	removeAddr net.Addr            // 优先使用请求头中的真实ip
+	localAddr  net.Addr            //
+	request    *http.Request       // 握手请求
+	event      WSEvent[ClientInfo] // 事件处理器
+	md         *msger.MsgDispatch  // 消息分发
+	hook       []WSHook[ClientInfo]
+	seq        utils.Sequence      // 消息顺序处理工具 协程安全
+	groupSeq   utils.GroupSequence // 分组执行的消息, 消息设置为非顺序处理的才会分组
+	info       *ClientInfo         // 客户端信息 内容修改需要外层加锁控制
+	connName   func() string       // 日志调使用，输出连接名字，优先会调用ClientInfo.ClientName()函数
+	deflate    *tcp.WSDeflate      // permessage-deflate压缩 没有开启为nil
+
+	ctx          context.Context // 本连接的上下文
+	lastRecvTime int64           // 最近一次接受数据的时间戳 微妙 原子访问
+	lastSendTime int64           // 最近一次接受数据的时间戳 微妙 原子访问
+
+	//RPC消息使用 [rpcid:chan interface{}]
+	rpc *sync.Map
+
+	wmu         sync.Mutex // 写锁 一个消息的帧头和数据需要连续写入
+	state       int32      // websocket状态 原子访问
+	closeReason error      // 关闭原因 wmu保护
+}
+
+func newWSClient[ClientInfo any](conn net.Conn, rd *bufio.Reader, r *http.Request, event WSEvent[ClientInfo], md *msger.MsgDispatch, hook []WSHook[ClientInfo]) *WSClient[ClientInfo] {
+	wc := &WSClient[ClientInfo]{
+		conn:         conn,
+		rd:           rd,
+		removeAddr:   conn.RemoteAddr(),
+		localAddr:    conn.LocalAddr(),
+		request:      r,
+		event:        event,
+		md:           md,
+		hook:         hook,
+		info:         new(ClientInfo),
+		ctx:          context.WithValue(context.TODO(), CtxKey_WS, 1),
+		lastRecvTime: time.Now().UnixMicro(),
+		rpc:          new(sync.Map),
+		state:        wsStateOpen,
+	}
+	// 查找真正的ip
+	if addr := utils.ClientTCPIPHeader(r.Header); addr != nil {
+		wc.removeAddr = addr
+	}
+	// 调用对象的ClientCreate函数
+	creater, ok := any(wc.info).(ClientCreater)
+	if ok {
+		creater.ClientCreate()
+	}
+	return wc
+}
+
+// 销毁时调用
+func (wc *WSClient[ClientInfo]) clear() {
+	// 清空下rpc
+	wc.rpc.Range(func(key, value interface{}) bool {
+		rpc, ok := wc.rpc.LoadAndDelete(key)
+		if ok {
+			ch := rpc.(chan msger.RecvMsger)
+			close(ch) // 删除的地方负责关闭
+		}
+		return true
+	})
+}
+
+func (wc *WSClient[ClientInfo]) RemoteAddr() net.Addr {
+	return wc.removeAddr
+}
+
+func (wc *WSClient[ClientInfo]) LocalAddr() net.Addr {
+	return wc.localAddr
+}
+
+// 握手请求 Body已不可用，可获取Path、Query、Header、Cookie等信息
+func (wc *WSClient[ClientInfo]) Request() *http.Request {
+	return wc.request
+}
+
+func (wc *WSClient[ClientInfo]) Info() *ClientInfo {
+	return wc.info
+}
+
+func (wc *WSClient[ClientInfo]) InfoI() interface{} {
+	return wc.info
+}
+
+func (wc *WSClient[ClientInfo]) ConnName() string {
+	if wc.connName == nil {
+		return wc.removeAddr.String()
+	}
+	return wc.connName()
+}
+
+// 消息堆积数量，顺序处理和分组处理的才能计算
+func (wc *WSClient[ClientInfo]) RecvSeqCount() int {
+	return wc.seq.Len() + wc.groupSeq.Len()
+}
+
+func (wc *WSClient[ClientInfo]) LastRecvTime() time.Time {
+	return time.UnixMicro(atomic.LoadInt64(&wc.lastRecvTime))
+}
+
+func (wc *WSClient[ClientInfo]) LastSendTime() time.Time {
+	return time.UnixMicro(atomic.LoadInt64(&wc.lastSendTime))
+}
+
+// Send 发送二进制数据
+func (wc *WSClient[ClientInfo]) Send(ctx context.Context, data []byte) error {
+	var err error
+	if len(data) == 0 {
+		err = errors.New("data is empty")
+		utils.LogCtx(log.Error(), ctx).Msgf("Send %s error", wc.ConnName())
+		return err
+	}
+	// 回调
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSend(wc, len(data))
+		}
+	}()
+	// 发送
+	err = wc.write(ws.OpBinary, data)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("Send %s error", wc.ConnName())
+		return err
+	}
+	// 日志
+	utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("Send %s", wc.ConnName())
+	return nil
+}
+
+// SendMsg 发送消息对象，会调用消息对象的MsgMarshal来编码消息
+// 消息对象可实现zerolog.LogObjectMarshaler接口，更好的输出日志，通过ParamConf.LogLevelMsg配置可控制日志级别
+func (wc *WSClient[ClientInfo]) SendMsg(ctx context.Context, msg msger.Msger) error {
+	if msg == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Msgf("SendMsg %s error", wc.ConnName())
+		return err
+	}
+	data, err := msg.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", wc.ConnName())
+		return err
+	}
+	// 回调
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSendMsg(wc, msg, len(data))
+		}
+	}()
+	// 发送
+	err = wc.write(ws.OpBinary, data)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", wc.ConnName())
+		return err
+	}
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(msg)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Interface("msger", msg).Msgf("SendMsg %s", wc.ConnName())
+	}
+	return nil
+}
+
+// SendText 发送文本数据
+func (wc *WSClient[ClientInfo]) SendText(ctx context.Context, data []byte) error {
+	var err error
+	if len(data) == 0 {
+		err = errors.New("data is empty")
+		utils.LogCtx(log.Error(), ctx).Msgf("SendText %s error", wc.ConnName())
+		return err
+	}
+	// 回调
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSendText(wc, len(data))
+		}
+	}()
+	// 发送
+	err = wc.write(ws.OpText, data)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("SendText %s error", wc.ConnName())
+		return err
+	}
+	// 日志
+	utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("SendText %s", wc.ConnName())
+	return nil
+}
+
+// SendRPCMsg 发送RPC消息并等待消息回复，需要依赖event.DecodeMsg返回消息的RPCId()来判断是否rpc调用
+// 消息对象可实现zerolog.LogObjectMarshaler接口，更好的输出日志，通过ParamConf.LogLevelMsg配置可控制日志级别
+// respBody: 解析后的消息体 ，如果respBody不是nil，会调用RecvMsger的BodyUnMarshal解析消息体
+// 返回值
+// - resp: 回复的消息对象
+func (wc *WSClient[ClientInfo]) SendRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, respBody interface{}) (msger.RecvMsger, error) {
+	rpcIdV := fmt.Sprintf("%v", rpcId)
+	if req == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s error", wc.ConnName())
+		return nil, err
+	}
+	data, err := req.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", wc.ConnName())
+		return nil, err
+	}
+
+	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
+	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
+	if _, loaded := wc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+		close(ch) // 关闭新创建的channel
+		err := errors.New("rpcId exist")
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", wc.ConnName())
+		return nil, err
+	}
+	defer func() {
+		if _, ok := wc.rpc.LoadAndDelete(rpcIdV); ok {
+			close(ch) // 删除的地方负责关闭
+		}
+	}()
+	// 回调
+	entry := time.Now()
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSendRPCMsg(wc, rpcId, req, time.Since(entry), len(data))
+		}
+	}()
+	// 发送
+	err = wc.write(ws.OpBinary, data)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", wc.ConnName())
+		return nil, err
+	}
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s", wc.ConnName())
+	}
+	// 等待rpc回复
+	timer := time.NewTimer(timeout)
+	var resp msger.RecvMsger
+	select {
+	case resp = <-ch:
+		if !timer.Stop() {
+			select {
+			case <-timer.C: // try to drain the channel
+			default:
+			}
+		}
+	case <-timer.C:
+		err = errors.New("timeout")
+	}
+	if resp == nil && err == nil { //clear函数的调用会触发此情况
+		err = errors.New("close")
+	}
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s resp error", wc.ConnName())
+		return nil, err
+	}
+
+	// 解析消息体
+	if respBody != nil {
+		err = resp.BodyUnMarshal(respBody)
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s resp error", wc.ConnName())
+			return resp, err
+		}
+	}
+
+	// 日志
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", resp).Msgf("SendRPCMsg %s resp", wc.ConnName())
+	}
+	return resp, nil
+}
+
+// SendRPCMsgAsync 发送异步RPC消息，需要依赖event.DecodeMsg返回消息的RPCId()来判断是否rpc调用
+// 消息对象可实现zerolog.LogObjectMarshaler接口，更好的输出日志，通过ParamConf.LogLevelMsg配置可控制日志级别
+// 返回值 为nil时，才会调用callback
+// callback 参考msger.AsyncRPCCallback说明
+func (wc *WSClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, callback interface{}) error {
+	rpcIdV := fmt.Sprintf("%v", rpcId)
+	if req == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s error", wc.ConnName())
+		return err
+	}
+	data, err := req.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", wc.ConnName())
+		return err
+	}
+
+	cb, err := msger.GetAsyncCallback(callback)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", wc.ConnName())
+		return err
+	}
+
+	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
+	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
+	if _, loaded := wc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+		close(ch) // 关闭新创建的channel
+		err := errors.New("rpcId exist")
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", wc.ConnName())
+		return err
+	}
+	// 回调
+	entry := time.Now()
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSendRPCMsg(wc, rpcId, req, time.Since(entry), len(data))
+		}
+	}()
+	// 发送
+	err = wc.write(ws.OpBinary, data)
+	if err != nil {
+		// 发送失败，先删除channel记录
+		if _, ok := wc.rpc.LoadAndDelete(rpcIdV); ok {
+			close(ch) // 删除的地方负责关闭
+		}
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", wc.ConnName())
+		return err
+	}
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s", wc.ConnName())
+	}
+
+	// 异步等待回复
+	utils.Submit(func() {
+		defer func() {
+			if _, ok := wc.rpc.LoadAndDelete(rpcIdV); ok {
+				close(ch) // 删除的地方负责关闭
+			}
+		}()
+		// 等待rpc回复
+		timer := time.NewTimer(timeout)
+		var resp msger.RecvMsger
+		select {
+		case resp = <-ch:
+			if !timer.Stop() {
+				select {
+				case <-timer.C: // try to drain the channel
+				default:
+				}
+			}
+		case <-timer.C:
+			err = errors.New("timeout")
+		}
+		if resp == nil && err == nil { //clear函数的调用会触发此情况
+			err = errors.New("close")
+		}
+
+		handle := func(resp msger.RecvMsger, body interface{}, err error) {
+			if cb == nil {
+				return
+			}
+			// 消息放入协程池中
+			if ParamConf.Get().WSMsgSeq {
+				wc.seq.Submit(func() {
+					cb.Call(resp, body, err)
+				})
+			} else {
+				if resp == nil {
+					cb.Call(resp, body, err) // 已经在异步协程中了 直接调用
+					return
+				}
+				groupId := resp.GroupId()
+				if groupId != nil {
+					wc.groupSeq.Submit(groupId, func() {
+						cb.Call(resp, body, err)
+					})
+				} else {
+					cb.Call(resp, body, err) // 已经在异步协程中了 直接调用
+				}
+			}
+		}
+
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s resp error", wc.ConnName())
+			handle(nil, nil, err)
+			return
+		}
+
+		// 解析消息体
+		var respBody interface{}
+		if cb != nil {
+			if respBodyType := cb.RespBodyElemType(); respBodyType != nil {
+				respBody = reflect.New(respBodyType).Interface()
+				err = resp.BodyUnMarshal(respBody)
+				if err != nil {
+					utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s resp error", wc.ConnName())
+					handle(resp, nil, err)
+					return
+				}
+			}
+		}
+
+		// 日志
+		if logLevel >= int(log.Logger.GetLevel()) {
+			utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", resp).Msgf("SendAsyncRPCMsg %s resp", wc.ConnName())
+		}
+		handle(resp, respBody, nil)
+	})
+
+	return nil
+}
+
+// 会回调event的OnDisConnect
+// 若想不回调使用 WSServer.CloseClient
+// 先发送关闭帧，err为wsutil.ClosedError时可指定状态码和原因，等客户端回复关闭帧或者超时后再关闭连接
+func (wc *WSClient[ClientInfo]) Close(err error) {
+	if !atomic.CompareAndSwapInt32(&wc.state, wsStateOpen, wsStateClosing) {
+		return
+	}
+	wc.wmu.Lock()
+	wc.closeReason = err
+	wc.wmu.Unlock()
+	if wc.write(ws.OpClose, wsCloseBody(err)) != nil {
+		wc.conn.Close()
+		return
+	}
+	// 读协程等待客户端回复 超时后退出
+	wc.conn.SetReadDeadline(time.Now().Add(wsCloseTimeout))
+}
+
+// 关闭原因
+func (wc *WSClient[ClientInfo]) reason() error {
+	wc.wmu.Lock()
+	defer wc.wmu.Unlock()
+	return wc.closeReason
+}
+
+// 写入一个消息 协商了压缩时，达到MinSize的数据会压缩
+// 写失败后关闭连接，读协程会退出
+func (wc *WSClient[ClientInfo]) write(op ws.OpCode, data []byte) error {
+	wc.wmu.Lock()
+	defer wc.wmu.Unlock()
+	err := wc.deflate.WriteServerMessage(wsWriter(wc.writeConn), op, data)
+	if err != nil {
+		wc.conn.Close()
+		return err
+	}
+	atomic.StoreInt64(&wc.lastSendTime, time.Now().UnixMicro())
+	return nil
+}
+
+func (wc *WSClient[ClientInfo]) writeConn(b []byte) (int, error) {
+	wc.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	n, err := wc.conn.Write(b)
+	// 回调
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range wc.hook {
+			h.OnSendData(wc, n)
+		}
+	}()
+	return n, err
+}
+
+// 读协程 返回关闭原因
+func (wc *WSClient[ClientInfo]) loopRead() error {
+	rd := &wsReader{r: wc.rd}
+	for {
+		messages, err := wc.deflate.ReadClientMessage(rd, nil)
+		if rd.n > 0 {
+			atomic.StoreInt64(&wc.lastRecvTime, time.Now().UnixMicro())
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range wc.hook {
+					h.OnRecvData(wc, rd.n)
+				}
+			}()
+			rd.n = 0
+		}
+		if err != nil {
+			if atomic.LoadInt32(&wc.state) == wsStateClosing {
+				return wc.reason() // 等待客户端回复关闭帧超时
+			}
+			return err
+		}
+		// 分片消息中间的控制帧在前面
+		for _, message := range messages {
+			switch message.OpCode {
+			case ws.OpText:
+				if atomic.LoadInt32(&wc.state) != wsStateOpen {
+					continue // 关闭中 丢弃数据
+				}
+				ctx := context.WithValue(wc.ctx, CtxKey_Text, 1)
+				if err := wc.recv(ctx, message.Payload); err != nil {
+					return err
+				}
+			case ws.OpBinary:
+				if atomic.LoadInt32(&wc.state) != wsStateOpen {
+					continue
+				}
+				if err := wc.recv(wc.ctx, message.Payload); err != nil {
+					return err
+				}
+			case ws.OpClose:
+				code, reason := ws.ParseCloseFrameData(message.Payload)
+				if !atomic.CompareAndSwapInt32(&wc.state, wsStateOpen, wsStateClosed) {
+					return wc.reason() // 服务器发起的关闭 收到客户端的回复
+				}
+				// 客户端发起关闭 回复关闭帧后关闭连接
+				var body []byte
+				if !code.Empty() {
+					if ws.CheckCloseFrameData(code, reason) != nil {
+						body = ws.NewCloseFrameBody(ws.StatusProtocolError, "")
+					} else {
+						body = ws.NewCloseFrameBody(code, "")
+					}
+				}
+				wc.write(ws.OpClose, body)
+				return wsutil.ClosedError{Code: code, Reason: reason}
+			case ws.OpPing:
+				wc.write(ws.OpPong, message.Payload)
+			case ws.OpPong:
+				// 不需要操作 收到数据时已更新了lastRecvTime
+			default:
+			}
+		}
+	}
+}
+
+// 收到一个websocket消息时调用 消息必须全部解码完
+func (wc *WSClient[ClientInfo]) recv(ctx context.Context, buf []byte) error {
+	if wc.event == nil {
+		return nil
+	}
+
+	readlen := 0
+	for {
+		mr, l, err := wc.decode(ctx, buf[readlen:])
+		if err != nil {
+			return err
+		}
+		if l < 0 || l > len(buf[readlen:]) {
+			err = fmt.Errorf("decode return len is %d, readbuf len is %d", l, len(buf[readlen:]))
+			return err
+		}
+		if l > 0 {
+			readlen += l
+		}
+		if l == 0 || mr == nil {
+			break
+		}
+		if mr != nil {
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range wc.hook {
+					h.OnRecvMsg(wc, mr, l)
+				}
+			}()
+
+			traceName := mr.MsgID()
+			if mner, _ := any(mr).(msger.MsgerName); mner != nil {
+				traceName = mner.MsgName()
+			}
+			ctx2 := utils.CtxSetTrace(ctx, mr.TraceId(), traceName) // 拷贝出一个新的context，防止污染了其他消息
+
+			rpcId := mr.RPCId()
+			if rpcId != nil {
+				// rpc
+				rpcIdV := fmt.Sprintf("%v", rpcId)
+				rpc, ok := wc.rpc.LoadAndDelete(rpcIdV)
+				if ok {
+					ch := rpc.(chan msger.RecvMsger)
+					ch <- mr
+					close(ch) // 删除的地方负责关闭
+				} else {
+					// 没找到可能是超时了也可能是DecodeMsg没正确返回 也交给OnMsg执行
+					wc.handle(ctx2, mr)
+				}
+			} else {
+				wc.handle(ctx2, mr)
+			}
+		}
+		if len(buf)-readlen == 0 {
+			break // 不需要继续读取了
+		}
+	}
+	//websocket消息没有解析出完整的消息数据
+	if readlen != len(buf) {
+		return errors.New("wsframe not decode msg")
+	}
+	return nil
+}
+
+func (wc *WSClient[ClientInfo]) decode(ctx context.Context, buf []byte) (msger.RecvMsger, int, error) {
+	var mr msger.RecvMsger
+	var l int
+	var err error
+	defer utils.HandlePanic2(func(r any) {
+		err = fmt.Errorf("decode panic: %v", r)
+	})
+
+	mr, l, err = wc.event.DecodeMsg(ctx, buf, wc)
+	return mr, l, err
+}
+
+func (wc *WSClient[ClientInfo]) handle(ctx context.Context, mr msger.RecvMsger) {
+	// 熔断
+	if name, ok := msger.ParamConf.Get().IsHystrixMsg(mr.MsgID()); ok {
+		hystrix.DoC(ctx, name, func(ctx context.Context) error {
+			wc.submit(ctx, mr)
+			return nil
+		}, func(ctx context.Context, err error) error {
+			utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", mr).Msg("RecvMsg Hystrix")
+			return err
+		})
+	} else {
+		wc.submit(ctx, mr)
+	}
+}
+
+// 消息放入协程池中
+func (wc *WSClient[ClientInfo]) submit(ctx context.Context, mr msger.RecvMsger) {
+	if ParamConf.Get().WSMsgSeq {
+		wc.seq.Submit(func() {
+			wc.onMsg(ctx, mr)
+		})
+	} else {
+		groupId := mr.GroupId()
+		if groupId != nil {
+			wc.groupSeq.Submit(groupId, func() {
+				wc.onMsg(ctx, mr)
+			})
+		} else {
+			utils.Submit(func() {
+				wc.onMsg(ctx, mr)
+			})
+		}
+	}
+}
+
+func (wc *WSClient[ClientInfo]) onMsg(ctx context.Context, mr msger.RecvMsger) {
+	if handle, _ := wc.md.Dispatch(ctx, mr, wc, fmt.Sprintf("RecvMsg %s Dispatch", wc.ConnName())); handle {
+	} else {
+		// 日志
+		logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(mr)
+		if logLevel >= int(log.Logger.GetLevel()) {
+			utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Interface("msger", mr).Msgf("RecvMsg %s", wc.ConnName())
+		}
+		wc.event.OnMsg(ctx, mr, wc)
+	}
+}
+
+// 服务器关闭时发送的关闭帧数据
+// err为wsutil.ClosedError时使用其中的状态码和原因，ErrServerShutdown使用StatusGoingAway，其他错误使用StatusPolicyViolation
+func wsCloseBody(err error) []byte {
+	var ce wsutil.ClosedError
+	switch {
+	case err == nil:
+		return ws.NewCloseFrameBody(ws.StatusNormalClosure, "")
+	case errors.As(err, &ce):
+		return ws.NewCloseFrameBody(ce.Code, ce.Reason)
+	case err == ErrServerShutdown:
+		return ws.NewCloseFrameBody(ws.StatusGoingAway, err.Error())
+	}
+	return ws.NewCloseFrameBody(ws.StatusPolicyViolation, err.Error())
+}
+
+// 写入连接的函数 给WSDeflate使用
+type wsWriter func(b []byte) (int, error)
+
+func (w wsWriter) Write(b []byte) (int, error) {
+	return w(b)
+}
+
+// 统计读取的数据大小 OnRecvData使用
+type wsReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *wsReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += n
+	return n, err
+}
@@ -0,0 +1,98 @@
+package ginserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+type WSEvent[ClientInfo any] interface {
+	// 消息注册
+	OnMsgReg(md *msger.MsgDispatch)
+
+	// 收到连接 握手已完成
+	// 异步顺序调用
+	OnConnected(ctx context.Context, wc *WSClient[ClientInfo])
+
+	// 用户掉线
+	// 异步顺序调用
+	OnDisConnect(ctx context.Context, wc *WSClient[ClientInfo])
+
+	// DecodeMsg 解码消息实现
+	// 读协程调用
+	// ctx       包括 CtxKey_WS,[CtxKey_Text]
+	// 返回值为   msg,len,err
+	// msg       解码出的消息体
+	// len       解码消息的数据长度，一个websocket消息必须全部解码完
+	// err       解码错误，若发生error，将关闭连接
+	DecodeMsg(ctx context.Context, data []byte, wc *WSClient[ClientInfo]) (msger.RecvMsger, int, error)
+
+	// OnRecv 收到消息，解码成功后调用，rpc不会调用此函数
+	// 异步顺序调用 or 异步调用
+	// ctx    包括 CtxKey_WS,[CtxKey_Text],CtxKey_traceId,CtxKey_msgId
+	OnMsg(ctx context.Context, mr msger.RecvMsger, wc *WSClient[ClientInfo])
+
+	// OnTick 每秒调用一次
+	// 异步顺序调用
+	// ctx    包括 CtxKey_WS,CtxKey_traceId,CtxKey_msgId(固定为：_tick_)
+	OnTick(ctx context.Context, wc *WSClient[ClientInfo])
+}
+
+// WSEventHandler WSEvent的内置实现
+// 如果不想实现WSEvent的所有接口，可以继承它实现部分方法
+type WSEventHandler[ClientInfo any] struct {
+}
+
+func (*WSEventHandler[ClientInfo]) OnMsgReg(md *msger.MsgDispatch) {
+}
+func (*WSEventHandler[ClientInfo]) OnConnected(ctx context.Context, wc *WSClient[ClientInfo]) {
+}
+func (*WSEventHandler[ClientInfo]) OnDisConnect(ctx context.Context, wc *WSClient[ClientInfo]) {
+}
+func (*WSEventHandler[ClientInfo]) DecodeMsg(ctx context.Context, data []byte, wc *WSClient[ClientInfo]) (msger.RecvMsger, int, error) {
+	return nil, len(data), errors.New("DecodeMsg not Implementation")
+}
+func (*WSEventHandler[ClientInfo]) OnMsg(ctx context.Context, mr msger.RecvMsger, wc *WSClient[ClientInfo]) {
+	utils.LogCtx(log.Warn(), ctx).Interface("msger", mr).Msgf("Msg Not Handle %s", wc.ConnName())
+}
+func (*WSEventHandler[ClientInfo]) OnTick(ctx context.Context, wc *WSClient[ClientInfo]) {
+}
+
+// Hook
+type WSHook[ClientInfo any] interface {
+	// 收到连接 握手已完成
+	OnConnected(wc *WSClient[ClientInfo])
+	// 用户掉线，removeClient表示是否引起RemoveClient，但不会调用OnRemoveClient
+	OnDisConnect(wc *WSClient[ClientInfo], removeClient bool, closeReason error)
+
+	// 添加Client
+	OnAddClient(wc *WSClient[ClientInfo])
+	// 添加Client
+	OnRemoveClient(wc *WSClient[ClientInfo])
+
+	// 发送数据 所有的发送 开启压缩时为压缩后的大小
+	OnSendData(wc *WSClient[ClientInfo], len int)
+	// 接受数据 所有的接受 开启压缩时为压缩后的大小
+	OnRecvData(wc *WSClient[ClientInfo], len int)
+
+	// Send后调用
+	OnSend(wc *WSClient[ClientInfo], len int)
+	// SendMsg后调用
+	OnSendMsg(wc *WSClient[ClientInfo], mr msger.Msger, len int)
+	// SendText后调用
+	OnSendText(wc *WSClient[ClientInfo], len int)
+	// SendRPCMsg后调用， 收到的Resp在OnRecvMsg中调用，会在此函数前调用
+	OnSendRPCMsg(wc *WSClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int)
+	// 接受消息数据，消息解码后调用
+	OnRecvMsg(wc *WSClient[ClientInfo], mr msger.RecvMsger, len int)
+
+	// 定时调用
+	OnTick()
+}
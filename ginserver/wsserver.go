@@ -0,0 +1,362 @@
+package ginserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobwas/ws"
+	"github.com/rs/zerolog/log"
+)
+
+// 服务器关闭连接的原因 WSHook.OnDisConnect的closeReason
+var (
+	ErrReadIdleTimeout = errors.New("read idle timeout")
+	ErrServerShutdown  = errors.New("server shutdown")
+)
+
+// WSServer 在GinServer的路由上接受websocket连接，和tcpserver一样走DecodeMsg、MsgDispatch分发、hook回调的流程
+// 一个gin端口可以同时提供http接口和二进制的消息协议
+// ClientId客户端ID类型
+// ClientInfo是和业务相关的客户端信息结构类型
+type WSServer[ClientId any, ClientInfo any] struct {
+	// 消息分发
+	*msger.MsgDispatch
+	// 不可需改
+	Path string // 路由路径
+
+	event WSEvent[ClientInfo] // event
+	state int32               // 运行状态 0:未运行 1：接受连接
+	quit  chan struct{}       // Start时创建 Stop时关闭 通知tick协程退出
+
+	//所有的连接的客户端 [*WSClient:*wClient]
+	connMap *sync.Map
+
+	//外层添加的用户映射 [ClientId:*wClient]
+	clientMap *sync.Map
+
+	// 请求处理完后回调 不使用锁，默认要求提前注册好
+	hook []WSHook[ClientInfo]
+}
+
+// WSClient过渡对象，便于保存id，减少WSClient的复杂度
+type wClient[ClientId any, ClientInfo any] struct {
+	wc *WSClient[ClientInfo]
+	id ClientId // 调用WSServer.AddClient设置的id 目前无锁 不存在复杂使用
+
+	added int32 // 是否调用过AddClient 原子访问
+}
+
+// 创建服务器 在gs上注册path的GET路由，Start后请求升级为websocket连接
+// Msg表示消息类型，必须实现util.Msger接口，否则消息无法分发
+// optionsHandlers会在升级前执行，可用来做鉴权，Abort后不会升级
+func NewWSServer[ClientId any, ClientInfo any, Msg any](gs *GinServer, path string, event WSEvent[ClientInfo], optionsHandlers ...gin.HandlerFunc) (*WSServer[ClientId, ClientInfo], error) {
+	md, err := msger.NewMsgDispatch[Msg, WSClient[ClientInfo]]()
+	if err != nil {
+		return nil, err
+	}
+	s := &WSServer[ClientId, ClientInfo]{
+		MsgDispatch: md,
+		Path:        path,
+		event:       event,
+		state:       0,
+		connMap:     new(sync.Map),
+		clientMap:   new(sync.Map),
+	}
+	gs.RegHandler(http.MethodGet, path, s.upgrade, optionsHandlers...)
+	return s, nil
+}
+
+func (s *WSServer[ClientId, ClientInfo]) Start() error {
+	if !atomic.CompareAndSwapInt32(&s.state, 0, 1) {
+		log.Error().Str("Path", s.Path).Msg("WSServer already Start")
+		return nil
+	}
+
+	// 先让外层注册消息
+	if s.event != nil {
+		s.event.OnMsgReg(s.MsgDispatch)
+	}
+
+	// 开启tick协程
+	s.quit = make(chan struct{})
+	go s.loopTick(s.quit)
+
+	log.Info().Str("Path", s.Path).Msg("WSServer Start")
+	return nil
+}
+
+// 不再接受新连接，关闭所有连接，关闭原因为ErrServerShutdown
+// GinServer.Stop不会关闭已升级的连接，需要单独调用
+func (s *WSServer[ClientId, ClientInfo]) Stop() error {
+	if !atomic.CompareAndSwapInt32(&s.state, 1, 0) {
+		log.Error().Str("Path", s.Path).Msg("WSServer already Stop")
+		return nil
+	}
+
+	close(s.quit)
+	s.RangeClient(func(wc *WSClient[ClientInfo]) bool {
+		wc.Close(ErrServerShutdown)
+		return true
+	})
+
+	log.Info().Str("Path", s.Path).Msg("WSServer Stop")
+	return nil
+}
+
+// 添加用户映射
+func (s *WSServer[ClientId, ClientInfo]) AddClient(id ClientId, wc *WSClient[ClientInfo]) {
+	// 先检查下是否存在连接
+	client, ok := s.connMap.Load(wc)
+	if !ok {
+		return
+	}
+	client.(*wClient[ClientId, ClientInfo]).id = id
+	atomic.StoreInt32(&client.(*wClient[ClientId, ClientInfo]).added, 1)
+	s.clientMap.Store(id, client)
+
+	// 回调回调hook
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range s.hook {
+			h.OnAddClient(wc)
+		}
+	}()
+}
+
+func (s *WSServer[ClientId, ClientInfo]) GetClient(id ClientId) *WSClient[ClientInfo] {
+	client, ok := s.clientMap.Load(id)
+	if ok {
+		return client.(*wClient[ClientId, ClientInfo]).wc
+	}
+	return nil
+}
+
+func (s *WSServer[ClientId, ClientInfo]) RemoveClient(id ClientId) *WSClient[ClientInfo] {
+	client, ok := s.clientMap.Load(id)
+	if ok {
+		s.clientMap.Delete(id)
+		wc := client.(*wClient[ClientId, ClientInfo]).wc
+
+		// 回调hook
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				h.OnRemoveClient(wc)
+			}
+		}()
+		return wc
+	}
+	return nil
+}
+
+// 主动关闭 不会回调event的OnDisConnect
+// 使用WSClient.Close会回调OnDisConnect
+func (s *WSServer[ClientId, ClientInfo]) CloseClient(id ClientId, err error) {
+	client, ok := s.clientMap.Load(id)
+	if ok {
+		wc := client.(*wClient[ClientId, ClientInfo]).wc
+		log.Info().Err(err).Msgf("Closed CloseClient %s", wc.ConnName()) // 日志为Closed 便于和下面的OnClosed统一查找
+		s.connMap.Delete(wc)
+		s.clientMap.Delete(id)
+		wc.Close(err) // 读协程退出时找不到对象 不会回调 所以上面先删除对象
+		wc.clear()
+
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				h.OnDisConnect(wc, true, err)
+			}
+		}()
+	}
+}
+
+// 遍历Client f函数返回false 停止遍历
+func (s *WSServer[ClientId, ClientInfo]) RangeClient(f func(wc *WSClient[ClientInfo]) bool) {
+	s.connMap.Range(func(key, value interface{}) bool {
+		return f(value.(*wClient[ClientId, ClientInfo]).wc)
+	})
+}
+
+// 连接都是握手完成的
+func (s *WSServer[ClientId, ClientInfo]) ConnCount() (int, int) {
+	count := 0
+	s.connMap.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count, count
+}
+
+func (s *WSServer[ClientId, ClientInfo]) ClientCount() int {
+	count := 0
+	s.clientMap.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// 队列中还未处理的消息
+func (s *WSServer[ClientId, ClientInfo]) RecvSeqCount() map[string]int {
+	rst := map[string]int{}
+	s.connMap.Range(func(key, value interface{}) bool {
+		wc := value.(*wClient[ClientId, ClientInfo]).wc
+		rst[wc.ConnName()] = wc.RecvSeqCount()
+		return true
+	})
+	return rst
+}
+
+// 注册hook
+func (s *WSServer[ClientId, ClientInfo]) RegHook(h WSHook[ClientInfo]) {
+	s.hook = append(s.hook, h)
+}
+
+// gin路由的处理函数 升级成功后开启读协程，gin的处理函数直接返回
+func (s *WSServer[ClientId, ClientInfo]) upgrade(ctx context.Context, c *gin.Context) {
+	if atomic.LoadInt32(&s.state) != 1 {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	var deflate *tcp.WSDeflate
+	upgrader := ws.HTTPUpgrader{}
+	if conf := ParamConf.Get().WSDeflate; conf.Enable {
+		deflate = tcp.NewWSDeflate(conf)
+		upgrader.Negotiate = deflate.Negotiate
+	}
+	conn, rw, _, err := upgrader.Upgrade(c.Request, c.Writer)
+	if err != nil {
+		c.Set("err", err) // 日志使用 Upgrader已回复了错误
+		utils.LogCtx(log.Warn(), ctx).Err(err).Str("RemoteAddr", c.Request.RemoteAddr).Str("path", c.Request.URL.Path).Msg("WSServer Upgrade error")
+		return
+	}
+	conn.SetDeadline(time.Time{}) // 清除http服务设置的超时
+
+	wc := newWSClient(conn, rw.Reader, c.Request, s.event, s.MsgDispatch, s.hook)
+	wc.deflate = deflate
+	client := &wClient[ClientId, ClientInfo]{
+		wc: wc,
+	}
+	// 给wc.connName赋值 优先调用对象的ClientName函数
+	connName := func() string {
+		name := fmt.Sprintf("%v", client.id)
+		if len(name) == 0 || name == "0" {
+			return wc.removeAddr.String()
+		}
+		return wc.removeAddr.String() + "-" + name
+	}
+	wc.connName = connName
+	namer, ok := any(wc.info).(ClientNamer)
+	if ok {
+		wc.connName = func() string {
+			name := namer.ClientName()
+			if len(name) == 0 || name == "0" {
+				return connName()
+			}
+			return name
+		}
+	}
+	utils.LogCtx(log.Info(), ctx).Str("RemoveAddr", wc.removeAddr.String()+"("+conn.RemoteAddr().String()+")").Str("path", c.Request.URL.Path).Msg("WSServer HandShake")
+
+	s.connMap.Store(wc, client)
+	if s.event != nil {
+		wc.seq.Submit(func() {
+			ctx := utils.CtxSetTrace(wc.ctx, 0, "Connected")
+			s.event.OnConnected(ctx, wc)
+		})
+	}
+
+	// 回调
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range s.hook {
+			h.OnConnected(wc)
+		}
+	}()
+
+	go s.loopRead(client)
+}
+
+func (s *WSServer[ClientId, ClientInfo]) loopRead(client *wClient[ClientId, ClientInfo]) {
+	wc := client.wc
+	err := wc.loopRead()
+	wc.conn.Close()
+	if reason := wc.reason(); reason != nil {
+		err = reason
+	}
+
+	_, ok := s.connMap.LoadAndDelete(wc)
+	if !ok {
+		return // CloseClient已经删除
+	}
+	log.Info().Err(err).Str("RemoveAddr", wc.removeAddr.String()).Msgf("OnDisConnect %s", wc.ConnName())
+	delClient := false
+	if atomic.LoadInt32(&client.added) == 1 {
+		delClient = s.clientMap.CompareAndDelete(client.id, client)
+	}
+	wc.clear()
+	if s.event != nil {
+		wc.seq.Submit(func() {
+			ctx := utils.CtxSetTrace(wc.ctx, 0, "DisConnected")
+			s.event.OnDisConnect(ctx, wc)
+		})
+	}
+
+	// 回调
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range s.hook {
+			h.OnDisConnect(wc, delClient, err)
+		}
+	}()
+}
+
+func (s *WSServer[ClientId, ClientInfo]) loopTick(quit chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		// 每秒tick下
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		idleTimeout := time.Duration(ParamConf.Get().WSReadIdleTimeout) * time.Second
+		now := time.Now()
+		s.connMap.Range(func(key, value interface{}) bool {
+			wc := value.(*wClient[ClientId, ClientInfo]).wc
+			if idleTimeout > 0 && now.Sub(wc.LastRecvTime()) >= idleTimeout {
+				wc.Close(ErrReadIdleTimeout)
+				return true
+			}
+			if s.event != nil {
+				ctx := utils.CtxSetTrace(wc.ctx, 0, "Tick")
+				wc.seq.Submit(func() {
+					s.event.OnTick(ctx, wc)
+				})
+			}
+			return true
+		})
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				h.OnTick()
+			}
+		}()
+	}
+}
@@ -7,14 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/afex/hystrix-go/hystrix"
-	"github.com/gobwas/ws/wsutil"
+	"github.com/gobwas/ws"
 	"github.com/panjf2000/gnet"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -32,29 +35,35 @@ type ClientCreater interface {
 // 如果ClientInfo存在ClientName函数，输出日志是会调用
 type GNetClient[ClientInfo any] struct {
 	// 本身不可修改对象
-	conn       gnet.Conn             // gnet连接对象
-	removeAddr net.TCPAddr           // 拷贝出来 防止conn关闭时发生变化
-	localAddr  net.TCPAddr           //
-	event      GNetEvent[ClientInfo] // 事件处理器
-	md         *msger.MsgDispatch    // 消息分发
-	hook       []GNetHook[ClientInfo]
-	seq        utils.Sequence             // 消息顺序处理工具 协程安全
-	groupSeq   utils.GroupSequence        // 分组执行的消息, 消息设置为非顺序处理的才会分组
-	info       *ClientInfo                // 客户端信息 内容修改需要外层加锁控制
-	connName   func() string              // 日志调使用，输出连接名字，优先会调用ClientInfo.ClientName()函数
-	wsh        *gnetWSHandler[ClientInfo] // websocket处理
+	conn      gnet.Conn             // gnet连接对象
+	localAddr net.TCPAddr           //
+	proxyAddr *net.TCPAddr          // 经过PROXY protocol的连接 代理(负载均衡器)的地址
+	event     GNetEvent[ClientInfo] // 事件处理器
+	md        *msger.MsgDispatch    // 消息分发
+	hook      []GNetHook[ClientInfo]
+	seq       utils.Sequence             // 消息顺序处理工具 协程安全
+	groupSeq  utils.GroupSequence        // 分组执行的消息, 消息设置为非顺序处理的才会分组
+	info      *ClientInfo                // 客户端信息 内容修改需要外层加锁控制
+	connName  func() string              // 日志调使用，输出连接名字，优先会调用ClientInfo.ClientName()函数
+	wsh       *gnetWSHandler[ClientInfo] // websocket处理
+	cipher    *tcp.Cipher                // 数据加密 开启ParamConfig.Cipher的非websocket连接
+	tls       *tlsConn                   // 开启TLS的连接 读写都经过tls.Conn
 
 	ctx          context.Context // 本连接的上下文
 	lastRecvTime int64           // 最近一次接受数据的时间戳 微妙 原子访问
 	lastSendTime int64           // 最近一次接受数据的时间戳 微妙 原子访问
 
-	closeReason error // 关闭原因
+	//RPC消息使用 [rpcid:chan interface{}]
+	rpc *sync.Map
+
+	closeReason atomic.Pointer[error]       // 关闭原因 保留第一个非nil的原因
+	removeAddr  atomic.Pointer[net.TCPAddr] // 对端地址 拷贝出来 防止conn关闭时发生变化 PROXY protocol和websocket握手时会修改
+
 }
 
 func newGNetClient[ClientInfo any](conn gnet.Conn, event GNetEvent[ClientInfo], md *msger.MsgDispatch, hook []GNetHook[ClientInfo]) *GNetClient[ClientInfo] {
 	gc := &GNetClient[ClientInfo]{
 		conn:         conn,
-		removeAddr:   *conn.RemoteAddr().(*net.TCPAddr),
 		localAddr:    *conn.LocalAddr().(*net.TCPAddr),
 		event:        event,
 		md:           md,
@@ -62,7 +71,10 @@ func newGNetClient[ClientInfo any](conn gnet.Conn, event GNetEvent[ClientInfo],
 		info:         new(ClientInfo),
 		ctx:          context.TODO(),
 		lastRecvTime: time.Now().UnixMicro(),
+		rpc:          new(sync.Map),
 	}
+	addr := *conn.RemoteAddr().(*net.TCPAddr)
+	gc.removeAddr.Store(&addr)
 	// 调用对象的ClientCreate函数
 	creater, ok := any(gc.info).(ClientCreater)
 	if ok {
@@ -71,12 +83,37 @@ func newGNetClient[ClientInfo any](conn gnet.Conn, event GNetEvent[ClientInfo],
 	return gc
 }
 
-func (gc *GNetClient[ClientInfo]) RemoteAddr() net.TCPAddr {
-	return gc.removeAddr
+// 销毁时调用
+func (gc *GNetClient[ClientInfo]) clear() {
+	// 清空下rpc
+	gc.rpc.Range(func(key, value interface{}) bool {
+		rpc, ok := gc.rpc.LoadAndDelete(key)
+		if ok {
+			ch := rpc.(chan msger.RecvMsger)
+			close(ch) // 删除的地方负责关闭
+		}
+		return true
+	})
+}
+
+func (gc *GNetClient[ClientInfo]) RemoteAddr() net.Addr {
+	addr := *gc.remote()
+	return &addr
+}
+
+// 对端地址 不能修改返回的对象
+func (gc *GNetClient[ClientInfo]) remote() *net.TCPAddr {
+	return gc.removeAddr.Load()
+}
+
+func (gc *GNetClient[ClientInfo]) LocalAddr() net.Addr {
+	addr := gc.localAddr
+	return &addr
 }
 
-func (gc *GNetClient[ClientInfo]) LocalAddr() net.TCPAddr {
-	return gc.localAddr
+// 代理(负载均衡器)的地址 不是经过PROXY protocol的连接返回nil
+func (gc *GNetClient[ClientInfo]) ProxyAddr() *net.TCPAddr {
+	return gc.proxyAddr
 }
 
 func (gc *GNetClient[ClientInfo]) Info() *ClientInfo {
@@ -89,7 +126,7 @@ func (gc *GNetClient[ClientInfo]) InfoI() interface{} {
 
 func (gc *GNetClient[ClientInfo]) ConnName() string {
 	if gc.connName == nil {
-		return gc.removeAddr.String()
+		return gc.remote().String()
 	}
 	return gc.connName()
 }
@@ -123,9 +160,9 @@ func (gc *GNetClient[ClientInfo]) Send(ctx context.Context, data []byte) error {
 	}()
 	// 发送
 	if gc.wsh != nil {
-		err = wsutil.WriteServerBinary(gc.wsh, data)
+		err = gc.wsh.write(ws.OpBinary, data)
 	} else {
-		err = gc.conn.AsyncWrite(data)
+		err = gc.send(data)
 	}
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Msgf("Send %s error", gc.ConnName())
@@ -159,9 +196,9 @@ func (gc *GNetClient[ClientInfo]) SendMsg(ctx context.Context, msg msger.Msger)
 	}()
 	// 发送
 	if gc.wsh != nil {
-		err = wsutil.WriteServerBinary(gc.wsh, data)
+		err = gc.wsh.write(ws.OpBinary, data)
 	} else {
-		err = gc.conn.AsyncWrite(data)
+		err = gc.send(data)
 	}
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", gc.ConnName())
@@ -192,9 +229,9 @@ func (gc *GNetClient[ClientInfo]) SendText(ctx context.Context, data []byte) err
 	}()
 	// 发送
 	if gc.wsh != nil {
-		err = wsutil.WriteServerText(gc.wsh, data)
+		err = gc.wsh.write(ws.OpText, data)
 	} else {
-		err = gc.conn.AsyncWrite(data)
+		err = gc.send(data)
 	}
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("SendText %s error", gc.ConnName())
@@ -206,16 +243,281 @@ func (gc *GNetClient[ClientInfo]) SendText(ctx context.Context, data []byte) err
 	return nil
 }
 
+// SendRPCMsg 发送RPC消息并等待消息回复，需要依赖event.DecodeMsg返回消息的RPCId()来判断是否rpc调用
+// 消息对象可实现zerolog.LogObjectMarshaler接口，更好的输出日志，通过ParamConf.LogLevelMsg配置可控制日志级别
+// respBody: 解析后的消息体 ，如果respBody不是nil，会调用RecvMsger的BodyUnMarshal解析消息体
+// 返回值
+// - resp: 回复的消息对象
+func (gc *GNetClient[ClientInfo]) SendRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, respBody interface{}) (msger.RecvMsger, error) {
+	rpcIdV := fmt.Sprintf("%v", rpcId)
+	if req == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s error", gc.ConnName())
+		return nil, err
+	}
+	data, err := req.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", gc.ConnName())
+		return nil, err
+	}
+
+	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
+	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
+	if _, loaded := gc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+		close(ch) // 关闭新创建的channel
+		err := errors.New("rpcId exist")
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", gc.ConnName())
+		return nil, err
+	}
+	defer func() {
+		if _, ok := gc.rpc.LoadAndDelete(rpcIdV); ok {
+			close(ch) // 删除的地方负责关闭
+		}
+	}()
+	// 回调
+	entry := time.Now()
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range gc.hook {
+			h.OnSendRPCMsg(gc, rpcId, req, time.Since(entry), len(data))
+		}
+	}()
+	// 发送
+	if gc.wsh != nil {
+		err = gc.wsh.write(ws.OpBinary, data)
+	} else {
+		err = gc.send(data)
+	}
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", gc.ConnName())
+		return nil, err
+	}
+	atomic.StoreInt64(&gc.lastSendTime, time.Now().UnixMicro())
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s", gc.ConnName())
+	}
+	// 等待rpc回复
+	timer := time.NewTimer(timeout)
+	var resp msger.RecvMsger
+	select {
+	case resp = <-ch:
+		if !timer.Stop() {
+			select {
+			case <-timer.C: // try to drain the channel
+			default:
+			}
+		}
+	case <-timer.C:
+		err = errors.New("timeout")
+	}
+	if resp == nil && err == nil { //clear函数的调用会触发此情况
+		err = errors.New("close")
+	}
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s resp error", gc.ConnName())
+		return nil, err
+	}
+
+	// 解析消息体
+	if respBody != nil {
+		err = resp.BodyUnMarshal(respBody)
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s resp error", gc.ConnName())
+			return resp, err
+		}
+	}
+
+	// 日志
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", resp).Msgf("SendRPCMsg %s resp", gc.ConnName())
+	}
+	return resp, nil
+}
+
+// SendRPCMsgAsync 发送异步RPC消息，需要依赖event.DecodeMsg返回消息的RPCId()来判断是否rpc调用
+// 消息对象可实现zerolog.LogObjectMarshaler接口，更好的输出日志，通过ParamConf.LogLevelMsg配置可控制日志级别
+// 返回值 为nil时，才会调用callback
+// callback 参考msger.AsyncRPCCallback说明
+func (gc *GNetClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, callback interface{}) error {
+	rpcIdV := fmt.Sprintf("%v", rpcId)
+	if req == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s error", gc.ConnName())
+		return err
+	}
+	data, err := req.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", gc.ConnName())
+		return err
+	}
+
+	cb, err := msger.GetAsyncCallback(callback)
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", gc.ConnName())
+		return err
+	}
+
+	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
+	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
+	if _, loaded := gc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+		close(ch) // 关闭新创建的channel
+		err := errors.New("rpcId exist")
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", gc.ConnName())
+		return err
+	}
+	// 回调
+	entry := time.Now()
+	defer func() {
+		defer utils.HandlePanic()
+		for _, h := range gc.hook {
+			h.OnSendRPCMsg(gc, rpcId, req, time.Since(entry), len(data))
+		}
+	}()
+	// 发送
+	if gc.wsh != nil {
+		err = gc.wsh.write(ws.OpBinary, data)
+	} else {
+		err = gc.send(data)
+	}
+	if err != nil {
+		// 发送失败，先删除channel记录
+		if _, ok := gc.rpc.LoadAndDelete(rpcIdV); ok {
+			close(ch) // 删除的地方负责关闭
+		}
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", gc.ConnName())
+		return err
+	}
+	atomic.StoreInt64(&gc.lastSendTime, time.Now().UnixMicro())
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s", gc.ConnName())
+	}
+
+	// 异步等待回复
+	utils.Submit(func() {
+		defer func() {
+			if _, ok := gc.rpc.LoadAndDelete(rpcIdV); ok {
+				close(ch) // 删除的地方负责关闭
+			}
+		}()
+		// 等待rpc回复
+		timer := time.NewTimer(timeout)
+		var resp msger.RecvMsger
+		select {
+		case resp = <-ch:
+			if !timer.Stop() {
+				select {
+				case <-timer.C: // try to drain the channel
+				default:
+				}
+			}
+		case <-timer.C:
+			err = errors.New("timeout")
+		}
+		if resp == nil && err == nil { //clear函数的调用会触发此情况
+			err = errors.New("close")
+		}
+
+		handle := func(resp msger.RecvMsger, body interface{}, err error) {
+			if cb == nil {
+				return
+			}
+			// 消息放入协程池中
+			if ParamConf.Get().MsgSeq {
+				gc.seq.Submit(func() {
+					cb.Call(resp, body, err)
+				})
+			} else {
+				if resp == nil {
+					cb.Call(resp, body, err) // 已经在异步协程中了 直接调用
+					return
+				}
+				groupId := resp.GroupId()
+				if groupId != nil {
+					gc.groupSeq.Submit(groupId, func() {
+						cb.Call(resp, body, err)
+					})
+				} else {
+					cb.Call(resp, body, err) // 已经在异步协程中了 直接调用
+				}
+			}
+		}
+
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s resp error", gc.ConnName())
+			handle(nil, nil, err)
+			return
+		}
+
+		// 解析消息体
+		var respBody interface{}
+		if cb != nil {
+			if respBodyType := cb.RespBodyElemType(); respBodyType != nil {
+				respBody = reflect.New(respBodyType).Interface()
+				err = resp.BodyUnMarshal(respBody)
+				if err != nil {
+					utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s resp error", gc.ConnName())
+					handle(resp, nil, err)
+					return
+				}
+			}
+		}
+
+		// 日志
+		if logLevel >= int(log.Logger.GetLevel()) {
+			utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("rpcId", rpcIdV).Interface("msger", resp).Msgf("SendAsyncRPCMsg %s resp", gc.ConnName())
+		}
+		handle(resp, respBody, nil)
+	})
+
+	return nil
+}
+
 // 会回调event的OnDisConnect
 // 若想不回调使用 GNetServer.CloseClient
+// websocket连接会先发送关闭帧，err为wsutil.ClosedError时可指定状态码和原因，等客户端回复关闭帧后再关闭连接
 func (gc *GNetClient[ClientInfo]) Close(err error) {
-	//if gc.wsh != nil && gc.wsh.upgrade { // 这个不需要 发送客户可能还会回OpClose
-	//	wsutil.WriteServerMessage(gc.wsh, ws.OpClose, nil)
-	//}
-	gc.closeReason = err
+	gc.setReason(err)
+	if gc.wsh != nil && gc.wsh.close(err) {
+		return
+	}
 	gc.conn.Close()
 }
 
+// 记录关闭原因 已经有原因的不覆盖
+func (gc *GNetClient[ClientInfo]) setReason(err error) {
+	if err != nil {
+		gc.closeReason.CompareAndSwap(nil, &err)
+	}
+}
+
+// 关闭原因
+func (gc *GNetClient[ClientInfo]) reason() error {
+	if err := gc.closeReason.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// 写入非websocket连接 开启加密时加密后写入
+func (gc *GNetClient[ClientInfo]) send(data []byte) error {
+	if gc.cipher != nil {
+		return gc.cipher.Send(data)
+	}
+	return gc.write(data)
+}
+
+// 写入连接 开启TLS时经过tls.Conn加密，握手完成前缓存
+func (gc *GNetClient[ClientInfo]) write(data []byte) error {
+	if gc.tls != nil {
+		return gc.tls.write(data)
+	}
+	return gc.conn.AsyncWrite(data)
+}
+
 // 收到数据时调用
 func (gc *GNetClient[ClientInfo]) recv(ctx context.Context, buf []byte) (int, error) {
 	if gc.event == nil {
@@ -239,14 +541,6 @@ func (gc *GNetClient[ClientInfo]) recv(ctx context.Context, buf []byte) (int, er
 			break
 		}
 		if mr != nil {
-			traceName := mr.MsgID()
-			if mner, _ := any(mr).(msger.MsgerName); mner != nil {
-				traceName = mner.MsgName()
-			}
-			ctx2 := utils.CtxSetTrace(ctx, 0, traceName) // 拷贝出一个新的context，防止污染了其他消息
-
-			gc.handle(ctx2, mr)
-
 			// 回调
 			func() {
 				defer utils.HandlePanic()
@@ -254,6 +548,29 @@ func (gc *GNetClient[ClientInfo]) recv(ctx context.Context, buf []byte) (int, er
 					h.OnRecvMsg(gc, mr, l)
 				}
 			}()
+
+			traceName := mr.MsgID()
+			if mner, _ := any(mr).(msger.MsgerName); mner != nil {
+				traceName = mner.MsgName()
+			}
+			ctx2 := utils.CtxSetTrace(ctx, 0, traceName) // 拷贝出一个新的context，防止污染了其他消息
+
+			rpcId := mr.RPCId()
+			if rpcId != nil {
+				// rpc
+				rpcIdV := fmt.Sprintf("%v", rpcId)
+				rpc, ok := gc.rpc.LoadAndDelete(rpcIdV)
+				if ok {
+					ch := rpc.(chan msger.RecvMsger)
+					ch <- mr
+					close(ch) // 删除的地方负责关闭
+				} else {
+					// 没找到可能是超时了也可能是DecodeMsg没正确返回 也交给OnMsg执行
+					gc.handle(ctx2, mr)
+				}
+			} else {
+				gc.handle(ctx2, mr)
+			}
 		}
 		if len(buf)-readlen == 0 {
 			break // 不需要继续读取了
@@ -5,6 +5,8 @@ package gnetserver
 import (
 	"context"
 	"errors"
+	"net"
+	"time"
 
 	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/utils"
@@ -44,6 +46,21 @@ type GNetEvent[ClientInfo any] interface {
 	OnTick(ctx context.Context, gc *GNetClient[ClientInfo])
 }
 
+// GNetEvent可选择实现的接口，开启ParamConfig.PingInterval后，连接读空闲达到间隔时调用，用来发送服务器心跳
+// 异步顺序调用
+// ctx    包括 [CtxKey_WS],CtxKey_traceId
+type GNetPingEvent[ClientInfo any] interface {
+	OnPing(ctx context.Context, gc *GNetClient[ClientInfo])
+}
+
+// GNetEvent可选择实现的接口，websocket握手请求检查通过后，回复客户端之前调用
+// protocols为客户端请求的Sec-WebSocket-Protocol，返回选择的子协议，为空表示不使用子协议，不在protocols中的也不使用
+// 可通过gc.WSPath、WSQuery、WSCookie获取请求信息做验证，返回error拒绝握手，默认回复403，可返回ws.RejectConnectionError指定状态码
+// 网络协程调用
+type GNetWSUpgradeEvent[ClientInfo any] interface {
+	OnWSUpgrade(gc *GNetClient[ClientInfo], protocols []string) (string, error)
+}
+
 // GNetEventHandler GNetEvent的内置实现
 // 如果不想实现GNetEvent的所有接口，可以继承它实现部分方法
 type GNetEventHandler[ClientInfo any] struct {
@@ -78,9 +95,9 @@ type GNetHook[ClientInfo any] interface {
 	// 添加Client
 	OnRemoveClient(gc *GNetClient[ClientInfo])
 
-	// 发送数据 所有的发送
+	// 发送数据 所有的发送 websocket开启压缩时为压缩后的大小
 	OnSendData(gc *GNetClient[ClientInfo], len int)
-	// 接受数据 所有的接受
+	// 接受数据 所有的接受 websocket开启压缩时为压缩后的大小
 	OnRecvData(gc *GNetClient[ClientInfo], len int)
 
 	// Send后调用
@@ -89,9 +106,21 @@ type GNetHook[ClientInfo any] interface {
 	OnSendMsg(gc *GNetClient[ClientInfo], mr msger.Msger, len int)
 	// SendText后调用
 	OnSendText(gc *GNetClient[ClientInfo], len int)
+	// SendRPCMsg后调用， 收到的Resp在OnRecvMsg中调用，会在此函数前调用
+	OnSendRPCMsg(gc *GNetClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int)
 	// 接受消息数据，消息解码后调用
 	OnRecvMsg(gc *GNetClient[ClientInfo], mr msger.RecvMsger, len int)
 
 	// 定时调用
 	OnTick()
 }
+
+// GNetHook可选择实现的接口，连接被准入控制拒绝时调用 reason为tcp.ErrAdmit*
+type GNetRejectHook interface {
+	OnReject(addr net.Addr, reason error)
+}
+
+// GNetHook可选择实现的接口，开启加密的连接握手失败或者解密失败时调用 err为tcp.ErrCipher*或者连接的错误
+type GNetCipherHook[ClientInfo any] interface {
+	OnCipherFail(gc *GNetClient[ClientInfo], err error)
+}
@@ -5,21 +5,31 @@ package gnetserver
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/utils"
 
+	"github.com/gobwas/ws"
 	"github.com/panjf2000/gnet"
 
 	"github.com/rs/zerolog/log"
 )
 
+// 服务器超时检查关闭连接的原因 GNetHook.OnDisConnect的closeReason
+var (
+	ErrReadIdleTimeout  = errors.New("read idle timeout")
+	ErrHandShakeTimeout = errors.New("handshake timeout")
+)
+
 // GNetServer
 // ClientId客户端ID类型
 // ClientInfo是和业务相关的客户端信息结构类型
@@ -30,6 +40,9 @@ type GNetServer[ClientId any, ClientInfo any] struct {
 	// 不可需改
 	Address string // 监听地址
 	Scheme  string // scheme支持tcp和ws，为空表示tcp
+	// 不为nil时开启TLS，Start前设置，热更新证书使用tls.Config.GetCertificate，参考tcp.CertReloader
+	// 开启TLS后不再使用ParamConfig.Cipher加密
+	TLSConfig *tls.Config
 
 	event GNetEvent[ClientInfo] //event
 	state int32                 // 运行状态 0:未运行 1：开启监听
@@ -42,6 +55,12 @@ type GNetServer[ClientId any, ClientInfo any] struct {
 
 	// 请求处理完后回调 不使用锁，默认要求提前注册好
 	hook []GNetHook[ClientInfo]
+
+	// 连接准入控制
+	admission *tcp.Admission
+
+	// 房间
+	rooms *utils.Rooms[*GNetClient[ClientInfo]]
 }
 
 // GNetClient过渡对象，便于保存id，减少GNetClient的复杂度
@@ -49,6 +68,13 @@ type gClient[ClientId any, ClientInfo any] struct {
 	gc      *GNetClient[ClientInfo]
 	id      ClientId // 调用GNetServer.AddClient设置的id 目前无锁 不存在复杂使用
 	readbuf bytes.Buffer
+
+	proxyWait int64  // 等待PROXY protocol头的截止时间戳 微妙 0表示不需要等待 原子访问
+	ip        string // 准入控制计数使用的ip
+
+	added    int32     // 是否调用过AddClient 原子访问
+	connTime time.Time // 连接时间
+	pingTime time.Time // 上次回调OnPing的时间 tick协程访问
 }
 
 // 创建服务器
@@ -68,6 +94,8 @@ func NewGNetServer[ClientId any, ClientInfo any, Msg any](port int, event GNetEv
 		EventServer: &gnet.EventServer{},
 		connMap:     new(sync.Map),
 		clientMap:   new(sync.Map),
+		admission:   tcp.NewAdmission(),
+		rooms:       utils.NewRooms[*GNetClient[ClientInfo]](),
 	}
 
 	return s, nil
@@ -87,11 +115,43 @@ func NewGNetServerWS[ClientId any, ClientInfo any, Msg any](port int, event GNet
 		EventServer: &gnet.EventServer{},
 		connMap:     new(sync.Map),
 		clientMap:   new(sync.Map),
+		admission:   tcp.NewAdmission(),
+		rooms:       utils.NewRooms[*GNetClient[ClientInfo]](),
 	}
 
 	return s, nil
 }
 
+// 创建TLS服务器 证书文件修改后自动重新加载
+func NewGNetServerTLS[ClientId any, ClientInfo any, Msg any](port int, event GNetEvent[ClientInfo], certFile, keyFile string) (*GNetServer[ClientId, ClientInfo], error) {
+	s, err := NewGNetServer[ClientId, ClientInfo, Msg](port, event)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tcp.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		log.Error().Err(err).Str("Addr", s.Address).Msg("NewGNetServerTLS error")
+		return nil, err
+	}
+	s.TLSConfig = cert.TLSConfig()
+	return s, nil
+}
+
+// 创建wss服务器 证书文件修改后自动重新加载
+func NewGNetServerWSS[ClientId any, ClientInfo any, Msg any](port int, event GNetEvent[ClientInfo], certFile, keyFile string) (*GNetServer[ClientId, ClientInfo], error) {
+	s, err := NewGNetServerWS[ClientId, ClientInfo, Msg](port, event)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tcp.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		log.Error().Err(err).Str("Addr", s.Address).Msg("NewGNetServerWSS error")
+		return nil, err
+	}
+	s.TLSConfig = cert.TLSConfig()
+	return s, nil
+}
+
 // 开启监听
 func (s *GNetServer[ClientId, ClientInfo]) Start() error {
 	if !atomic.CompareAndSwapInt32(&s.state, 0, 1) {
@@ -147,6 +207,7 @@ func (s *GNetServer[ClientId, ClientInfo]) AddClient(id ClientId, gc *GNetClient
 		return
 	}
 	client.(*gClient[ClientId, ClientInfo]).id = id
+	atomic.StoreInt32(&client.(*gClient[ClientId, ClientInfo]).added, 1)
 	s.clientMap.Store(id, client)
 
 	// 回调回调hook
@@ -193,7 +254,12 @@ func (s *GNetServer[ClientId, ClientInfo]) CloseClient(id ClientId, err error) {
 		log.Info().Err(err).Msgf("Closed CloseClient %s", gc.ConnName()) // 日志为GNetServer Closed 便于和下面的OnClosed统一查找
 		s.connMap.Delete(gc.conn)
 		s.clientMap.Delete(id)
+		s.LeaveAllRoom(gc)
 		gc.Close(err) // 会回调GNetServer的OnClosed 所以上面先删除对象
+		gc.clear()
+		if gc.tls != nil {
+			gc.tls.close() // OnClosed中找不到对象 这里结束TLS握手
+		}
 
 		// 回调
 		func() {
@@ -240,7 +306,7 @@ func (s *GNetServer[ClientId, ClientInfo]) ConnCount() (int, int) {
 	s.connMap.Range(func(key, value interface{}) bool {
 		count++
 		gc := value.(*gClient[ClientId, ClientInfo]).gc
-		if gc.wsh != nil && gc.wsh.upgrade {
+		if gc.wsh != nil && gc.wsh.upgraded() {
 			handshakecount++
 		}
 		return true
@@ -311,26 +377,42 @@ func (s *GNetServer[ClientId, ClientInfo]) OnShutdown(server gnet.Server) {
 }
 
 func (s *GNetServer[ClientId, ClientInfo]) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
-	logOut := !ParamConf.Get().IsIgnoreIp(c.RemoteAddr().String())
-	if logOut {
-		log.Info().Str("RemoveAddr", c.RemoteAddr().String()).Str("LocalAddr", c.LocalAddr().String()).Msg("OnOpened")
-	}
-
 	gc := newGNetClient(c, s.event, s.MsgDispatch, s.hook)
+	if s.TLSConfig != nil {
+		gc.tls = newTLSConn(c, s.TLSConfig, ParamConf.Get().tlsBuffer())
+	}
 	if s.Scheme == "ws" {
 		gc.ctx = context.WithValue(gc.ctx, CtxKey_WS, 1)
 		gc.wsh = newGNetWSHandler(gc)
+	} else if conf := &ParamConf.Get().Cipher; conf.Enable && gc.tls == nil {
+		var err error
+		gc.cipher, err = tcp.NewCipher(*conf, true, c.AsyncWrite)
+		if err != nil {
+			log.Error().Err(err).Str("RemoveAddr", gc.remote().String()).Msg("OnOpened NewCipher error")
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range s.hook {
+					if ch, ok := h.(GNetCipherHook[ClientInfo]); ok {
+						ch.OnCipherFail(gc, err)
+					}
+				}
+			}()
+			action = gnet.Close
+			return
+		}
 	}
 	client := &gClient[ClientId, ClientInfo]{
-		gc: gc,
+		gc:       gc,
+		connTime: time.Now(),
 	}
 	// 给gc.connName赋值 优先调用对象的ClientName函数
 	connName := func() string {
 		name := fmt.Sprintf("%v", client.id)
 		if len(name) == 0 || name == "0" {
-			return gc.removeAddr.String()
+			return gc.remote().String()
 		}
-		return gc.removeAddr.String() + "-" + name
+		return gc.remote().String() + "-" + name
 	}
 	gc.connName = connName
 	namer, ok := any(gc.info).(ClientNamer)
@@ -343,8 +425,63 @@ func (s *GNetServer[ClientId, ClientInfo]) OnOpened(c gnet.Conn) (out []byte, ac
 			return name
 		}
 	}
+
+	// 可信来源的连接 读取到PROXY protocol头后再做准入控制和回调连接成功
+	proxyConf := &ParamConf.Get().Proxy
+	if proxyConf.IsTrusted(c.RemoteAddr()) {
+		atomic.StoreInt64(&client.proxyWait, time.Now().Add(proxyConf.HeaderTimeout()).UnixMicro())
+		s.connMap.Store(c, client)
+		return
+	}
+	if s.admit(client) != nil {
+		action = gnet.Close
+		return
+	}
 	s.connMap.Store(c, client)
-	if s.event != nil {
+	s.opened(client)
+	return
+}
+
+// 准入控制 通过后OnClosed中释放
+func (s *GNetServer[ClientId, ClientInfo]) admit(client *gClient[ClientId, ClientInfo]) error {
+	gc := client.gc
+	ip := tcp.AddrIP(gc.remote())
+	err := s.admission.Admit(&ParamConf.Get().Admission, ip)
+	if err == nil {
+		client.ip = ip
+		return nil
+	}
+	if !ParamConf.Get().IsIgnoreIp(gc.remote().String()) {
+		log.Warn().Err(err).Str("RemoveAddr", gc.remote().String()).Str("LocalAddr", gc.localAddr.String()).Msg("OnOpened reject")
+	}
+	// 回调
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range s.hook {
+			if rh, ok := h.(GNetRejectHook); ok {
+				rh.OnReject(gc.remote(), err)
+			}
+		}
+	}()
+	return err
+}
+
+// 连接建立成功
+func (s *GNetServer[ClientId, ClientInfo]) opened(client *gClient[ClientId, ClientInfo]) {
+	gc := client.gc
+	logOut := !ParamConf.Get().IsIgnoreIp(gc.remote().String())
+	if logOut {
+		l := log.Info().Str("RemoveAddr", gc.remote().String()).Str("LocalAddr", gc.localAddr.String())
+		if gc.proxyAddr != nil {
+			l = l.Str("ProxyAddr", gc.proxyAddr.String())
+		}
+		l.Msg("OnOpened")
+	}
+	if gc.tls != nil {
+		go s.handshakeTLS(client)
+	}
+
+	if s.event != nil && gc.cipher == nil { // 开启加密的连接握手完成后回调
 		gc.seq.Submit(func() {
 			ctx := utils.CtxSetTrace(gc.ctx, 0, "Connected")
 			s.event.OnConnected(ctx, gc)
@@ -357,27 +494,79 @@ func (s *GNetServer[ClientId, ClientInfo]) OnOpened(c gnet.Conn) (out []byte, ac
 			h.OnConnected(gc)
 		}
 	}()
-	return
+}
+
+// 解析PROXY protocol头 返回是否解析完成
+func (s *GNetServer[ClientId, ClientInfo]) readProxyHeader(client *gClient[ClientId, ClientInfo]) bool {
+	gc := client.gc
+	addr, n, err := tcp.ParseProxyHeader(client.readbuf.Bytes())
+	if err != nil {
+		log.Error().Err(err).Str("ProxyAddr", gc.remote().String()).Msg("GNetServer read proxy header error")
+		gc.Close(err)
+		return false
+	}
+	if n == 0 {
+		return false // 数据不完整
+	}
+	client.readbuf.Next(n)
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		gc.proxyAddr = gc.remote()
+		addr := *ta
+		gc.removeAddr.Store(&addr)
+	}
+	if s.admit(client) != nil {
+		s.connMap.Delete(gc.conn)
+		gc.conn.Close()
+		return false
+	}
+	atomic.StoreInt64(&client.proxyWait, 0)
+	s.opened(client)
+	return true
 }
 
 func (s *GNetServer[ClientId, ClientInfo]) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
 	client, ok := s.connMap.Load(c)
 	if ok {
 		gc := client.(*gClient[ClientId, ClientInfo]).gc
-		logOut := !ParamConf.Get().IsIgnoreIp(gc.removeAddr.String())
+		if gc.tls != nil {
+			gc.tls.close() // 结束TLS握手
+		}
+		if atomic.LoadInt64(&client.(*gClient[ClientId, ClientInfo]).proxyWait) != 0 {
+			// 还未读取到PROXY protocol头 没有回调过连接成功
+			s.connMap.Delete(c)
+			return
+		}
+		s.admission.Release(client.(*gClient[ClientId, ClientInfo]).ip)
+		if reason := gc.reason(); reason != nil {
+			err = reason
+		}
+		if err == nil {
+			// linux下对端正常关闭，err是nil，填充一个错误，编译理解
+			err = io.EOF
+		}
+		logOut := !ParamConf.Get().IsIgnoreIp(gc.remote().String())
 		if logOut {
-			if gc.closeReason != nil {
-				err = gc.closeReason
-			}
-			if err == nil {
-				// linux下对端正常关闭，err是nil，填充一个错误，编译理解
-				err = io.EOF
-			}
-			log.Info().Err(err).Str("RemoveAddr", gc.removeAddr.String()).Msgf("Closed %s", gc.ConnName())
+			log.Info().Err(err).Str("RemoveAddr", gc.remote().String()).Msgf("Closed %s", gc.ConnName())
 		}
 		s.connMap.Delete(c)
 		_, delClient := s.clientMap.LoadAndDelete(client.(*gClient[ClientId, ClientInfo]).id)
-		if s.event != nil {
+		s.LeaveAllRoom(gc)
+		gc.clear()
+		if gc.cipher != nil {
+			if cerr := gc.cipher.Fail(); cerr != nil {
+				log.Warn().Err(cerr).Str("RemoveAddr", gc.remote().String()).Msgf("CipherFail %s", gc.ConnName())
+				// 回调
+				func() {
+					defer utils.HandlePanic()
+					for _, h := range s.hook {
+						if ch, ok := h.(GNetCipherHook[ClientInfo]); ok {
+							ch.OnCipherFail(gc, cerr)
+						}
+					}
+				}()
+			}
+		}
+		if s.event != nil && (gc.cipher == nil || gc.cipher.Established()) {
 			gc.seq.Submit(func() {
 				ctx := utils.CtxSetTrace(gc.ctx, 0, "Closed")
 				s.event.OnDisConnect(ctx, gc)
@@ -399,71 +588,162 @@ func (s *GNetServer[ClientId, ClientInfo]) React(packet []byte, c gnet.Conn) (ou
 	client, ok := s.connMap.Load(c)
 	if ok {
 		gclient := client.(*gClient[ClientId, ClientInfo])
-		gclient.readbuf.Write(packet)
 		gc := gclient.gc
+		if len(packet) == 0 {
+			// TLS握手完成后Wake 解密握手期间收到的数据
+			if gc.tls != nil {
+				s.readTLS(gclient)
+			}
+			return
+		}
+		gclient.readbuf.Write(packet)
+		recvLen := len(packet)
+		if atomic.LoadInt64(&gclient.proxyWait) != 0 {
+			if !s.readProxyHeader(gclient) {
+				return
+			}
+			recvLen = gclient.readbuf.Len() // 去掉头的数据
+			if recvLen == 0 {
+				return
+			}
+		}
 		atomic.StoreInt64(&gc.lastRecvTime, time.Now().UnixMicro())
 		// 回调
 		func() {
 			defer utils.HandlePanic()
 			for _, h := range s.hook {
-				h.OnRecvData(gc, len(packet))
+				h.OnRecvData(gc, recvLen)
 			}
 		}()
 
-		// 是否websock
-		if gc.wsh != nil {
-			len, handshake, err := gc.wsh.recv(gclient.readbuf.Bytes())
+		if gc.tls != nil {
+			// 密文写入管道 握手完成后解密
+			err := gc.tls.feed(gclient.readbuf.Bytes())
+			gclient.readbuf.Reset()
 			if err != nil {
+				log.Error().Err(err).Str("RemoveAddr", gc.remote().String()).Msgf("React %s error", gc.ConnName())
 				gc.Close(err)
 				return
 			}
+			s.readTLS(gclient)
+			return
+		}
+		s.recv(gclient, &gclient.readbuf)
+	}
+	return
+}
 
-			if handshake {
-				// 查找真正的ip
-				addr := utils.ClientTCPIPHeader(gc.wsh.Header)
-				if addr != nil {
-					gc.removeAddr = *addr
-				}
-				log.Info().Str("RemoveAddr", gc.removeAddr.String()+"("+gc.conn.RemoteAddr().String()+")").Interface("Header", gc.wsh.Header).Msg("HandShake")
+// 处理收到的数据 event-loop协程调用，TLS连接为解密后的数据
+func (s *GNetServer[ClientId, ClientInfo]) recv(client *gClient[ClientId, ClientInfo], readbuf *bytes.Buffer) {
+	gc := client.gc
+	// 是否websock
+	if gc.wsh != nil {
+		len, handshake, err := gc.wsh.recv(readbuf.Bytes())
+		if err != nil {
+			gc.Close(err)
+			return
+		}
 
-				// 回调
-				func() {
-					defer utils.HandlePanic()
-					for _, h := range s.hook {
-						h.OnWSHandShake(gc)
-					}
-				}()
+		if handshake {
+			// 查找真正的ip
+			addr := utils.ClientTCPIPHeader(gc.wsh.Header)
+			if addr != nil {
+				gc.removeAddr.Store(addr)
 			}
-			if len > 0 {
-				gclient.readbuf.Next(len)
-			}
-		} else {
-			len, err := gc.recv(gc.ctx, gclient.readbuf.Bytes())
-			if err != nil {
-				gc.Close(err)
-				return
-			}
-			if len > 0 {
-				gclient.readbuf.Next(len)
+			log.Info().Str("RemoveAddr", gc.remote().String()+"("+gc.conn.RemoteAddr().String()+")").Interface("Header", gc.wsh.Header).Msg("HandShake")
+
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range s.hook {
+					h.OnWSHandShake(gc)
+				}
+			}()
+		}
+		if len > 0 {
+			readbuf.Next(len)
+		}
+	} else if gc.cipher != nil {
+		// 先解密 握手完成时回调OnConnected
+		len, err := gc.cipher.Recv(readbuf.Bytes(), func() {
+			log.Debug().Str("RemoveAddr", gc.remote().String()).Msgf("CipherHandShake %s", gc.ConnName())
+			if s.event != nil {
+				gc.seq.Submit(func() {
+					ctx := utils.CtxSetTrace(gc.ctx, 0, "Connected")
+					s.event.OnConnected(ctx, gc)
+				})
 			}
+		}, func(plain []byte) (int, error) {
+			return gc.recv(gc.ctx, plain)
+		})
+		if err != nil {
+			gc.Close(err)
+			return
+		}
+		if len > 0 {
+			readbuf.Next(len)
+		}
+	} else {
+		len, err := gc.recv(gc.ctx, readbuf.Bytes())
+		if err != nil {
+			gc.Close(err)
+			return
+		}
+		if len > 0 {
+			readbuf.Next(len)
+		}
+	}
+}
+
+// TLS握手 crypto/tls没有非阻塞的握手接口，在单独的协程中完成，完成后协程退出
+// 连接关闭时关闭管道，握手返回错误后退出
+func (s *GNetServer[ClientId, ClientInfo]) handshakeTLS(client *gClient[ClientId, ClientInfo]) {
+	defer utils.HandlePanic()
+	gc := client.gc
+	if err := gc.tls.handshake(); err != nil {
+		if !gc.tls.isClosed() {
+			log.Warn().Err(err).Str("RemoveAddr", gc.remote().String()).Msgf("TLSHandShake %s error", gc.ConnName())
+			gc.Close(err)
 		}
 	}
-	return
+}
+
+// 解密TLS连接收到的数据，和明文连接一样处理 event-loop协程调用
+func (s *GNetServer[ClientId, ClientInfo]) readTLS(client *gClient[ClientId, ClientInfo]) {
+	gc := client.gc
+	err := gc.tls.read(func(readbuf *bytes.Buffer) {
+		s.recv(client, readbuf)
+	})
+	if err != nil && !gc.tls.isClosed() {
+		gc.Close(err)
+	}
 }
 
 func (s *GNetServer[ClientId, ClientInfo]) Tick() (delay time.Duration, action gnet.Action) {
 	delay = time.Second
-	if s.event != nil {
-		s.connMap.Range(func(key, value interface{}) bool {
-			gclient := value.(*gClient[ClientId, ClientInfo])
-			gc := gclient.gc
+	conf := ParamConf.Get()
+	now := time.Now()
+	s.connMap.Range(func(key, value interface{}) bool {
+		gclient := value.(*gClient[ClientId, ClientInfo])
+		gc := gclient.gc
+		if wait := atomic.LoadInt64(&gclient.proxyWait); wait != 0 {
+			if now.UnixMicro() > wait {
+				log.Error().Str("ProxyAddr", gc.remote().String()).Msg("GNetServer read proxy header timeout")
+				gc.Close(errors.New("proxy header timeout"))
+			}
+			return true
+		}
+		if s.checkTimeout(gclient, conf, now) {
+			return true
+		}
+		if s.event != nil {
 			ctx := utils.CtxSetTrace(gc.ctx, 0, "Tick")
 			gc.seq.Submit(func() {
 				s.event.OnTick(ctx, gc)
 			})
-			return true
-		})
-	}
+		}
+		return true
+	})
 	// 回调
 	func() {
 		defer utils.HandlePanic()
@@ -473,3 +753,32 @@ func (s *GNetServer[ClientId, ClientInfo]) Tick() (delay time.Duration, action g
 	}()
 	return
 }
+
+// 检查握手超时和读空闲超时，返回true表示已关闭连接
+// 读空闲达到PingInterval时回调GNetPingEvent.OnPing，没有实现时websocket连接发送ping帧
+func (s *GNetServer[ClientId, ClientInfo]) checkTimeout(client *gClient[ClientId, ClientInfo], conf *ParamConfig, now time.Time) bool {
+	gc := client.gc
+	if conf.HandShakeTimeout > 0 && atomic.LoadInt32(&client.added) == 0 && now.Sub(client.connTime) >= time.Duration(conf.HandShakeTimeout)*time.Second {
+		gc.Close(ErrHandShakeTimeout)
+		return true
+	}
+	idle := now.Sub(gc.LastRecvTime())
+	if conf.ReadIdleTimeout > 0 && idle >= time.Duration(conf.ReadIdleTimeout)*time.Second {
+		gc.Close(ErrReadIdleTimeout)
+		return true
+	}
+	if interval := time.Duration(conf.PingInterval) * time.Second; interval > 0 && idle >= interval && now.Sub(client.pingTime) >= interval {
+		if pe, ok := s.event.(GNetPingEvent[ClientInfo]); ok {
+			client.pingTime = now
+			ctx := utils.CtxSetTrace(gc.ctx, 0, "Ping")
+			gc.seq.Submit(func() {
+				pe.OnPing(ctx, gc)
+			})
+		} else if gc.wsh != nil && gc.wsh.upgraded() {
+			// websocket没有实现OnPing时 发送ping帧
+			client.pingTime = now
+			gc.wsh.write(ws.OpPing, nil)
+		}
+	}
+	return false
+}
@@ -1,8 +1,24 @@
 package gnetserver
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -12,8 +28,14 @@ import (
 	_ "github.com/yuwf/gobase/log"
 	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/nacos"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/tcpserver"
 	"github.com/yuwf/gobase/utils"
 
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -223,3 +245,937 @@ func BenchmarkGNetServerWS(b *testing.B) {
 
 	utils.ExitWait()
 }
+
+func TestGNetServerProxyProto(t *testing.T) {
+	ParamConf.Get().Proxy = tcp.ProxyProtoConfig{Enable: true, Trusted: []string{"127.0.0.1"}}
+	ParamConf.Get().Proxy.Normalize()
+	defer func() { ParamConf.Get().Proxy = tcp.ProxyProtoConfig{} }()
+
+	h := NewHandler()
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1246, h)
+	server.Start()
+	defer server.Stop()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		conn, err = net.Dial("tcp", "127.0.0.1:1246")
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 头和消息分开发送
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 1246\r\n"))
+	time.Sleep(time.Millisecond * 50)
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	conn.Write(data)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := utils.TestDecodeMsg(buf[:n])
+	if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+	count := 0
+	server.RangeClient(func(gc *GNetClient[ClientInfo]) bool {
+		count++
+		addr := gc.RemoteAddr()
+		if addr.String() != "203.0.113.7:40000" || gc.ProxyAddr() == nil {
+			t.Errorf("remote addr %s proxy addr %v", addr.String(), gc.ProxyAddr())
+		}
+		return true
+	})
+	if count != 1 {
+		t.Fatalf("client count %d", count)
+	}
+}
+
+// 只关注准入拒绝的Hook
+type rejectHook struct {
+	rejects chan error
+}
+
+func (h *rejectHook) OnConnected(gc *GNetClient[ClientInfo])                                {}
+func (h *rejectHook) OnWSHandShake(gc *GNetClient[ClientInfo])                              {}
+func (h *rejectHook) OnDisConnect(gc *GNetClient[ClientInfo], removeClient bool, err error) {}
+func (h *rejectHook) OnAddClient(gc *GNetClient[ClientInfo])                                {}
+func (h *rejectHook) OnRemoveClient(gc *GNetClient[ClientInfo])                             {}
+func (h *rejectHook) OnSendData(gc *GNetClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnRecvData(gc *GNetClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnSend(gc *GNetClient[ClientInfo], len int)                            {}
+func (h *rejectHook) OnSendMsg(gc *GNetClient[ClientInfo], mr msger.Msger, len int)         {}
+func (h *rejectHook) OnSendText(gc *GNetClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnSendRPCMsg(gc *GNetClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int) {
+}
+func (h *rejectHook) OnRecvMsg(gc *GNetClient[ClientInfo], mr msger.RecvMsger, len int) {}
+func (h *rejectHook) OnTick()                                                           {}
+
+func (h *rejectHook) OnReject(addr net.Addr, reason error) {
+	h.rejects <- reason
+}
+
+func TestGNetServerAdmission(t *testing.T) {
+	defer loadParamConf(t, nil)
+	loadParamConf(t, func(conf *ParamConfig) { conf.Admission.MaxConnPerIP = 1 })
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1268, NewHandler())
+	hook := &rejectHook{rejects: make(chan error, 8)}
+	server.RegHook(hook)
+	server.Start()
+	defer server.Stop()
+
+	dial := func() net.Conn {
+		for i := 0; i < 100; i++ {
+			conn, err := net.Dial("tcp", "127.0.0.1:1268")
+			if err == nil {
+				return conn
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		t.Fatal("dial fail")
+		return nil
+	}
+	waitCount := func(n int) {
+		for i := 0; i < 250 && server.admission.Count() != n; i++ {
+			time.Sleep(time.Millisecond * 20)
+		}
+		if c := server.admission.Count(); c != n {
+			t.Fatalf("admission count %d want %d", c, n)
+		}
+	}
+	// 被拒绝的连接回调OnReject并关闭
+	waitReject := func(conn net.Conn, want error) {
+		select {
+		case err := <-hook.rejects:
+			if err != want {
+				t.Fatalf("reject %v want %v", err, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("wait reject timeout")
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("reject conn not closed")
+		}
+	}
+
+	conn1 := dial()
+	defer conn1.Close()
+	waitCount(1)
+	conn2 := dial()
+	defer conn2.Close()
+	waitReject(conn2, tcp.ErrAdmitMaxConnPerIP)
+
+	// 断开后释放
+	conn1.Close()
+	waitCount(0)
+	conn3 := dial()
+	defer conn3.Close()
+	waitCount(1)
+
+	// 热更新
+	loadParamConf(t, func(conf *ParamConfig) { conf.Admission.MaxConnPerIP = 2 })
+	conn4 := dial()
+	defer conn4.Close()
+	waitCount(2)
+	conn5 := dial()
+	defer conn5.Close()
+	waitReject(conn5, tcp.ErrAdmitMaxConnPerIP)
+	if len(hook.rejects) != 0 {
+		t.Fatal("reject count")
+	}
+}
+
+type timeoutHandler struct {
+	Handler
+	ping   chan int
+	closed chan error
+}
+
+func (h *timeoutHandler) OnDisConnect(ctx context.Context, gc *GNetClient[ClientInfo]) {
+	h.closed <- gc.reason()
+}
+
+func (h *timeoutHandler) OnPing(ctx context.Context, gc *GNetClient[ClientInfo]) {
+	h.ping <- 1
+}
+
+// 替换整个配置 服务器的tick协程会读取配置，不能直接修改ParamConf.Get()返回的对象
+func loadParamConf(t testing.TB, f func(conf *ParamConfig)) {
+	conf := new(ParamConfig)
+	conf.Create()
+	if f != nil {
+		f(conf)
+	}
+	if err := ParamConf.LoadBy(conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGNetServerTimeout(t *testing.T) {
+	defer loadParamConf(t, nil)
+	h := &timeoutHandler{ping: make(chan int, 8), closed: make(chan error, 8)}
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1247, h)
+	server.Start()
+	defer server.Stop()
+
+	dial := func() net.Conn {
+		for i := 0; i < 100; i++ {
+			conn, err := net.Dial("tcp", "127.0.0.1:1247")
+			if err == nil {
+				return conn
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		t.Fatal("dial fail")
+		return nil
+	}
+	wait := func(want error) {
+		select {
+		case err := <-h.closed:
+			if err != want {
+				t.Fatalf("close reason %v want %v", err, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("wait %v timeout", want)
+		}
+	}
+
+	// 未AddClient
+	loadParamConf(t, func(conf *ParamConfig) { conf.HandShakeTimeout = 1 })
+	conn := dial()
+	defer conn.Close()
+	wait(ErrHandShakeTimeout)
+
+	// 读空闲 先回调OnPing
+	loadParamConf(t, func(conf *ParamConfig) {
+		conf.ReadIdleTimeout = 3
+		conf.PingInterval = 1
+	})
+	conn2 := dial()
+	defer conn2.Close()
+	select {
+	case <-h.ping:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait ping timeout")
+	}
+	wait(ErrReadIdleTimeout)
+}
+
+type rpcHandler struct {
+	Handler
+	connected chan *GNetClient[ClientInfo]
+}
+
+func (h *rpcHandler) OnConnected(ctx context.Context, gc *GNetClient[ClientInfo]) {
+	h.connected <- gc
+}
+
+func TestGNetServerRPC(t *testing.T) {
+	h := &rpcHandler{connected: make(chan *GNetClient[ClientInfo], 1)}
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1264, h)
+	server.Start()
+	defer server.Stop()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:1264"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var gc *GNetClient[ClientInfo]
+	select {
+	case gc = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+
+	// 客户端收到请求后回复
+	reply := func() {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if m, _, _ := utils.TestDecodeMsg(buf[:n]); m != nil && m.Msgid == utils.TestHeatBeatReqMsg.Msgid {
+			data, _ := utils.TestHeatBeatRespMsg.MsgMarshal()
+			conn.Write(data)
+		}
+	}
+
+	// 同步
+	go reply()
+	resp := &utils.TestHeatBeatResp{}
+	if _, err := gc.SendRPCMsg(context.TODO(), utils.TestHeatBeatReqMsg.Msgid, utils.TestHeatBeatReqMsg, time.Second*5, resp); err != nil || resp.Data != "heatrespmsg" {
+		t.Fatalf("rpc %v %v", resp, err)
+	}
+
+	// 异步
+	go reply()
+	done := make(chan error, 1)
+	err = gc.SendAsyncRPCMsg(context.TODO(), utils.TestHeatBeatReqMsg.Msgid, utils.TestHeatBeatReqMsg, time.Second*5, func(resp *utils.TestHeatBeatResp, err error) {
+		if err == nil && resp.Data != "heatrespmsg" {
+			err = fmt.Errorf("resp %v", resp)
+		}
+		done <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 超时
+	if _, err := gc.SendRPCMsg(context.TODO(), utils.TestHeatBeatReqMsg.Msgid, utils.TestHeatBeatReqMsg, time.Millisecond*100, nil); err == nil {
+		t.Fatal("rpc timeout")
+	}
+
+	// 关闭连接时等待中的rpc返回
+	err = gc.SendAsyncRPCMsg(context.TODO(), utils.TestHeatBeatReqMsg.Msgid, utils.TestHeatBeatReqMsg, time.Second*5, func(resp *utils.TestHeatBeatResp, err error) {
+		done <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("rpc close")
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait rpc close timeout")
+	}
+}
+
+func TestGNetServerRoom(t *testing.T) {
+	h := NewHandler()
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1248, h)
+	server.Start()
+	defer server.Stop()
+
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		var conn net.Conn
+		var err error
+		for j := 0; j < 100; j++ {
+			conn, err = net.Dial("tcp", "127.0.0.1:1248")
+			if err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+	for i := 0; i < 100; i++ {
+		if count, _ := server.ConnCount(); count == len(conns) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	var sender *GNetClient[ClientInfo]
+	server.RangeClient(func(gc *GNetClient[ClientInfo]) bool {
+		server.JoinRoom("room", gc)
+		sender = gc
+		return true
+	})
+	if server.RoomCount("room") != len(conns) {
+		t.Fatalf("room count %d", server.RoomCount("room"))
+	}
+
+	// 除了sender都能收到
+	if err := server.BroadcastMsgExcept(context.TODO(), "room", utils.TestHeatBeatRespMsg, sender); err != nil {
+		t.Fatal(err)
+	}
+	recv := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			continue
+		}
+		m, _, err := utils.TestDecodeMsg(buf[:n])
+		if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+			t.Fatalf("resp %v %v", m, err)
+		}
+		recv++
+	}
+	if recv != len(conns)-1 {
+		t.Fatalf("recv %d", recv)
+	}
+
+	// 断开后自动离开房间
+	conns[0].Close()
+	for i := 0; i < 100 && server.RoomCount("room") != len(conns)-1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.RoomCount("room") != len(conns)-1 {
+		t.Fatalf("room count %d", server.RoomCount("room"))
+	}
+}
+
+type deflateHandler struct {
+	Handler
+}
+
+func (h *deflateHandler) OnMsg(ctx context.Context, mr msger.RecvMsger, gc *GNetClient[ClientInfo]) {
+	if ctx.Value(CtxKey_Text) != nil {
+		gc.SendText(ctx, mr.(*utils.TestMsg).RecvData) // 原路返回
+	}
+}
+
+func TestGNetServerWSDeflate(t *testing.T) {
+	ParamConf.Get().WSDeflate = tcp.WSDeflateConfig{Enable: true, MinSize: 512}
+	defer func() { ParamConf.Get().WSDeflate = tcp.WSDeflateConfig{} }()
+
+	server, _ := NewGNetServerWS[int, ClientInfo, utils.TestMsg](1251, &deflateHandler{})
+	server.Start()
+	defer server.Stop()
+
+	dialer := ws.Dialer{Extensions: []httphead.Option{(wsflate.Parameters{}).Option()}}
+	var conn net.Conn
+	var hs ws.Handshake
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, _, hs, err = dialer.Dial(context.TODO(), "ws://127.0.0.1:1251"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(hs.Extensions) != 1 || string(hs.Extensions[0].Name) != wsflate.ExtensionName {
+		t.Fatalf("extensions %v", hs.Extensions)
+	}
+
+	echo := func(data []byte) ws.Frame {
+		if err := ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewTextFrame(append([]byte(nil), data...)))); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		resp, err := ws.ReadFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var text []byte
+	for i := 0; i < 200; i++ {
+		text = append(text, fmt.Sprintf(`{"name":"gobase","index":%d,"value":%d},`, i, i*i*7919)...)
+	}
+	resp := echo(text)
+	compressed, _ := wsflate.IsCompressed(resp.Header)
+	if !compressed || len(resp.Payload) >= len(text)/2 {
+		t.Fatalf("compressed %v size %d", compressed, len(resp.Payload))
+	}
+	src := io.MultiReader(bytes.NewReader(resp.Payload), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}))
+	if out, err := io.ReadAll(flate.NewReader(src)); err != nil || !bytes.Equal(out, text) {
+		t.Fatalf("inflate %v", err)
+	}
+
+	// 小于MinSize不压缩
+	resp = echo([]byte("hello"))
+	compressed, _ = wsflate.IsCompressed(resp.Header)
+	if compressed || string(resp.Payload) != "hello" {
+		t.Fatalf("compressed %v payload %s", compressed, resp.Payload)
+	}
+}
+
+type wsUpgradeHandler struct {
+	Handler
+	conns chan *GNetClient[ClientInfo]
+}
+
+func (h *wsUpgradeHandler) OnWSUpgrade(gc *GNetClient[ClientInfo], protocols []string) (string, error) {
+	if gc.WSQuery().Get("token") != "abc" {
+		return "", errors.New("token error")
+	}
+	h.conns <- gc
+	if p := gc.WSQuery().Get("protocol"); p != "" {
+		return p, nil
+	}
+	for _, p := range protocols {
+		if p == "chat" {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+func TestGNetServerWSUpgrade(t *testing.T) {
+	ParamConf.Get().WSOrigin = []string{"https://*.example.com"}
+	defer func() { ParamConf.Get().WSOrigin = nil }()
+
+	h := &wsUpgradeHandler{conns: make(chan *GNetClient[ClientInfo], 8)}
+	server, _ := NewGNetServerWS[int, ClientInfo, utils.TestMsg](1254, h)
+	server.Start()
+	defer server.Stop()
+
+	dial := func(url, origin string) (net.Conn, ws.Handshake, error) {
+		dialer := ws.Dialer{
+			Protocols: []string{"json", "chat"},
+			Header: ws.HandshakeHeaderHTTP(http.Header{
+				"Origin": []string{origin},
+				"Cookie": []string{"session=s1"},
+			}),
+		}
+		for i := 0; ; i++ {
+			conn, _, hs, err := dialer.Dial(context.TODO(), url)
+			if _, ok := err.(*net.OpError); ok && i < 100 {
+				time.Sleep(time.Millisecond * 20) // 等待服务器启动
+				continue
+			}
+			return conn, hs, err
+		}
+	}
+	// 读取一个帧
+	read := func(conn net.Conn) ws.Frame {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		f, err := ws.ReadFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// 连接已被服务器关闭
+	closed := func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("not closed %v", err)
+		}
+	}
+
+	// Origin和验证不通过
+	if _, _, err := dial("ws://127.0.0.1:1254/ws?token=abc", "https://example.org"); err != ws.StatusError(http.StatusForbidden) {
+		t.Fatalf("origin %v", err)
+	}
+	if _, _, err := dial("ws://127.0.0.1:1254/ws?token=bad", "https://a.example.com"); err != ws.StatusError(http.StatusForbidden) {
+		t.Fatalf("token %v", err)
+	}
+
+	// 选择的子协议客户端没有请求 不使用子协议完成握手
+	conn0, hs0, err := dial("ws://127.0.0.1:1254/ws?token=abc&protocol=xml", "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gc0 := <-h.conns; hs0.Protocol != "" || gc0.WSProtocol() != "" {
+		t.Fatalf("protocol %s %s", hs0.Protocol, gc0.WSProtocol())
+	}
+	conn0.Close()
+
+	conn, hs, err := dial("ws://127.0.0.1:1254/ws?token=abc", "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	gc := <-h.conns
+	if hs.Protocol != "chat" || gc.WSProtocol() != "chat" || gc.WSPath() != "/ws" {
+		t.Fatalf("protocol %s path %s", hs.Protocol, gc.WSPath())
+	}
+	if c, err := gc.WSCookie("session"); err != nil || c.Value != "s1" {
+		t.Fatalf("cookie %v %v", c, err)
+	}
+
+	// ping回复pong
+	ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewPingFrame([]byte("p"))))
+	if f := read(conn); f.Header.OpCode != ws.OpPong || string(f.Payload) != "p" {
+		t.Fatalf("pong %v %s", f.Header.OpCode, f.Payload)
+	}
+
+	// 服务器发起关闭 客户端回复后关闭连接
+	gc.Close(wsutil.ClosedError{Code: 4000, Reason: "bye"})
+	f := read(conn)
+	code, reason := ws.ParseCloseFrameData(f.Payload)
+	if f.Header.OpCode != ws.OpClose || code != 4000 || reason != "bye" {
+		t.Fatalf("close %v %d %s", f.Header.OpCode, code, reason)
+	}
+	ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewCloseFrame(f.Payload)))
+	closed(conn)
+
+	// 客户端发起关闭 服务器回复后关闭连接
+	conn2, _, err := dial("ws://127.0.0.1:1254/ws?token=abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	<-h.conns
+	ws.WriteFrame(conn2, ws.MaskFrameInPlace(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))))
+	f = read(conn2)
+	if code, _ := ws.ParseCloseFrameData(f.Payload); f.Header.OpCode != ws.OpClose || code != ws.StatusNormalClosure {
+		t.Fatalf("close %v %d", f.Header.OpCode, code)
+	}
+	closed(conn2)
+}
+
+type cipherHandler struct {
+	Handler
+	connected chan *GNetClient[ClientInfo]
+}
+
+func (h *cipherHandler) OnConnected(ctx context.Context, gc *GNetClient[ClientInfo]) {
+	h.connected <- gc
+}
+
+func TestGNetServerCipher(t *testing.T) {
+	ParamConf.Get().Cipher = tcp.CipherConfig{Enable: true}
+	ParamConf.Get().Cipher.Normalize()
+	defer func() { ParamConf.Get().Cipher = tcp.CipherConfig{} }()
+
+	h := &cipherHandler{connected: make(chan *GNetClient[ClientInfo], 8)}
+	server, _ := NewGNetServer[int, ClientInfo, utils.TestMsg](1257, h)
+	server.Start()
+	defer server.Stop()
+
+	var conn net.Conn
+	var err error
+	for j := 0; j < 100; j++ {
+		conn, err = net.Dial("tcp", "127.0.0.1:1257")
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c, err := tcp.NewCipher(tcp.CipherConfig{}, false, func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []byte
+	buf := make([]byte, 1024)
+	// 读取并解密出一个消息 握手时返回nil
+	read := func() (*utils.TestMsg, error) {
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, buf[:n]...)
+			var m *utils.TestMsg
+			handshake := false
+			l, err := c.Recv(pending, func() { handshake = true }, func(plain []byte) (int, error) {
+				mr, l, err := utils.TestDecodeMsg(plain)
+				m = mr
+				return l, err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending = pending[l:]
+			if m != nil || handshake {
+				return m, nil
+			}
+		}
+	}
+	if m, err := read(); m != nil || err != nil || !c.Established() {
+		t.Fatalf("handshake %v %v", m, err)
+	}
+	var gc *GNetClient[ClientInfo]
+	select {
+	case gc = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+
+	// 加密的请求和回复
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if err := c.Send(data); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := read(); err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+
+	// 房间广播也加密
+	server.JoinRoom("room", gc)
+	if err := server.BroadcastMsg(context.TODO(), "room", utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := read(); err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("broadcast %v %v", m, err)
+	}
+
+	// 篡改的帧 服务器关闭连接
+	frame := make([]byte, 4+8+len(data)+16)
+	frame[3] = byte(len(frame) - 4)
+	frame[11] = 1 // 期望的序号
+	conn.Write(frame)
+	if _, err := read(); err != io.EOF {
+		t.Fatalf("tamper %v", err)
+	}
+}
+
+// 生成自签名的服务器证书文件 返回证书和私钥路径
+func writeTestCert(tb testing.TB, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "gobase-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func dialTLS(tb testing.TB, addr string) *tls.Conn {
+	var conn *tls.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+			return conn
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	tb.Fatal(err)
+	return nil
+}
+
+// 发送心跳并读取回复
+func heartTLS(tb testing.TB, conn net.Conn) {
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if _, err := conn.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	resp, _ := utils.TestHeatBeatRespMsg.MsgMarshal()
+	buf := make([]byte, len(resp))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		tb.Fatal(err)
+	}
+	if m, _, err := utils.TestDecodeMsg(buf); err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		tb.Fatalf("resp %v %v", m, err)
+	}
+}
+
+func TestGNetServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, 1)
+
+	h := &cipherHandler{connected: make(chan *GNetClient[ClientInfo], 8)}
+	server, err := NewGNetServerTLS[int, ClientInfo, utils.TestMsg](1265, h, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+
+	conn := dialTLS(t, "127.0.0.1:1265")
+	defer conn.Close()
+	if conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64() != 1 {
+		t.Fatal("cert serial")
+	}
+	var gc *GNetClient[ClientInfo]
+	select {
+	case gc = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+	heartTLS(t, conn)
+
+	// 房间广播也经过TLS
+	server.JoinRoom("room", gc)
+	if err := server.BroadcastMsg(context.TODO(), "room", utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := utils.TestHeatBeatRespMsg.MsgMarshal()
+	buf := make([]byte, len(resp))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, resp) {
+		t.Fatalf("broadcast %v", err)
+	}
+
+	// 证书热更新 新连接使用新证书
+	writeTestCert(t, dir, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	time.Sleep(time.Millisecond * 1100) // 等待检查间隔
+	conn2 := dialTLS(t, "127.0.0.1:1265")
+	defer conn2.Close()
+	if conn2.ConnectionState().PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Fatal("cert not reload")
+	}
+	heartTLS(t, conn2)
+
+	// 非TLS数据 握手失败关闭连接
+	conn3, err := net.Dial("tcp", "127.0.0.1:1265")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Close()
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	conn3.Write(data)
+	conn3.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadAll(conn3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未解密的密文和握手完成前发送的数据超过缓存上限
+	tc := newTLSConn(nil, server.TLSConfig, 8)
+	if err := tc.feed(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.feed(make([]byte, 1)); err != ErrTLSBufferFull {
+		t.Fatal(err)
+	}
+	if err := tc.write(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.write(make([]byte, 1)); err != ErrTLSBufferFull {
+		t.Fatal(err)
+	}
+}
+
+func TestGNetServerWSS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), 1)
+	server, err := NewGNetServerWSS[int, ClientInfo, utils.TestMsg](1266, NewHandler(), certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+
+	dialer := ws.Dialer{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, _, _, err = dialer.Dial(context.TODO(), "wss://127.0.0.1:1266"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if err := wsutil.WriteClientBinary(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	msg, err := wsutil.ReadServerBinary(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _, err := utils.TestDecodeMsg(msg); err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+}
+
+// TLS连接的请求回复 和tcpserver的TLS对比
+func BenchmarkGNetServerTLS(b *testing.B) {
+	certFile, keyFile := writeTestCert(b, b.TempDir(), 1)
+	b.Run("gnet", func(b *testing.B) {
+		server, err := NewGNetServerWSS[int, ClientInfo, utils.TestMsg](1267, NewHandler(), certFile, keyFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		server.Start()
+		defer server.Stop()
+		benchmarkWSS(b, "wss://127.0.0.1:1267")
+	})
+	b.Run("tcpserver", func(b *testing.B) {
+		server, err := tcpserver.NewTCPServerWithWS[int, tcpClientInfo, utils.TestMsg](1268, &tcpHandler{}, certFile, keyFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		server.Start(false)
+		defer server.Stop()
+		benchmarkWSS(b, "wss://127.0.0.1:1268")
+	})
+}
+
+func benchmarkWSS(b *testing.B, addr string) {
+	dialer := ws.Dialer{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, _, _, err = dialer.Dial(context.TODO(), addr); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := wsutil.WriteClientBinary(conn, data); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := wsutil.ReadServerBinary(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type tcpClientInfo struct {
+}
+
+type tcpHandler struct {
+	tcpserver.TCPEventHandler[tcpClientInfo]
+}
+
+func (h *tcpHandler) OnMsgReg(md *msger.MsgDispatch) {
+	md.RegMsg(utils.TestHeatBeatReqMsg.MsgID(), h.onHeatBeatReq)
+}
+
+func (h *tcpHandler) DecodeMsg(ctx context.Context, data []byte, tc *tcpserver.TCPClient[tcpClientInfo]) (msger.RecvMsger, int, error) {
+	return utils.TestDecodeMsg(data)
+}
+
+func (h *tcpHandler) onHeatBeatReq(ctx context.Context, msg *utils.TestHeatBeatReq, tc *tcpserver.TCPClient[tcpClientInfo]) {
+	tc.SendMsg(ctx, utils.TestHeatBeatRespMsg)
+}
@@ -0,0 +1,228 @@
+package gnetserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// gnet不支持TLS，连接收到的密文写入管道，tls.Conn从管道中读取，加密后的数据通过AsyncWrite发送
+// crypto/tls没有非阻塞的握手接口，握手在单独的协程中完成，期间管道的Read阻塞等待密文，发送的数据缓存到握手完成
+// 握手完成后协程退出，管道的Read没有数据时返回临时错误，tls.Conn保留不完整的记录，解密和消息处理都在event-loop协程中，和明文连接一样
+// 未解密的密文或者握手完成前发送的数据超过ParamConfig.TLSBuffer时关闭连接
+
+var ErrTLSBufferFull = errors.New("tls buffer full") // 未解密的密文或者握手完成前发送的数据超过ParamConfig.TLSBuffer
+
+// 握手完成后管道中没有数据时返回 tls.Conn不会把临时错误记录为连接错误
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return "tls would block" }
+func (tlsWouldBlock) Timeout() bool   { return true }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+var errTLSWouldBlock error = tlsWouldBlock{}
+
+// 一次解密读取的大小 一条TLS记录的最大明文长度
+const tlsReadSize = 16 * 1024
+
+type tlsConn struct {
+	conn *tls.Conn
+	pipe *tlsPipe
+
+	wmu        sync.Mutex // 串行写入 握手完成前缓存发送的数据
+	ready      bool
+	pending    [][]byte
+	pendingLen int
+
+	readbuf bytes.Buffer // 解密后的数据 只在event-loop协程使用
+}
+
+func newTLSConn(c gnet.Conn, conf *tls.Config, max int) *tlsConn {
+	pipe := newTLSPipe(c, max)
+	return &tlsConn{conn: tls.Server(pipe, conf), pipe: pipe}
+}
+
+// 握手 在单独的协程中调用 完成后发送缓存的数据，然后Wake连接解密握手期间收到的数据
+func (t *tlsConn) handshake() error {
+	if err := t.conn.Handshake(); err != nil {
+		return err
+	}
+	t.pipe.established.Store(true)
+	t.wmu.Lock()
+	t.ready = true
+	pending := t.pending
+	t.pending, t.pendingLen = nil, 0
+	for _, data := range pending {
+		if _, err := t.conn.Write(data); err != nil {
+			t.wmu.Unlock()
+			return err
+		}
+	}
+	t.wmu.Unlock()
+	return t.pipe.conn.Wake()
+}
+
+// 加密写入 协程安全
+func (t *tlsConn) write(data []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if !t.ready {
+		if t.pendingLen+len(data) > t.pipe.max {
+			return ErrTLSBufferFull
+		}
+		t.pending = append(t.pending, append([]byte(nil), data...))
+		t.pendingLen += len(data)
+		return nil
+	}
+	_, err := t.conn.Write(data)
+	return err
+}
+
+// 写入收到的密文 event-loop协程调用
+func (t *tlsConn) feed(data []byte) error {
+	return t.pipe.feed(data)
+}
+
+// 解密管道中的密文 event-loop协程调用，握手完成前不处理
+// 每次解密出数据后回调recv，没有完整的记录时返回nil
+func (t *tlsConn) read(recv func(readbuf *bytes.Buffer)) error {
+	if !t.pipe.established.Load() {
+		return nil
+	}
+	for {
+		t.readbuf.Grow(tlsReadSize)
+		b := t.readbuf.AvailableBuffer()[:tlsReadSize]
+		n, err := t.conn.Read(b)
+		if n > 0 {
+			t.readbuf.Write(b[:n])
+			recv(&t.readbuf)
+		}
+		if err == errTLSWouldBlock {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 连接关闭时调用 结束握手
+func (t *tlsConn) close() {
+	t.pipe.close()
+}
+
+func (t *tlsConn) isClosed() bool {
+	return t.pipe.isClosed()
+}
+
+type tlsPipe struct {
+	conn        gnet.Conn
+	max         int         // 缓存上限
+	established atomic.Bool // 握手完成 Read不再阻塞
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func newTLSPipe(conn gnet.Conn, max int) *tlsPipe {
+	p := &tlsPipe{conn: conn, max: max}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// 写入收到的密文 event-loop协程调用 超过缓存上限返回ErrTLSBufferFull
+func (p *tlsPipe) feed(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	if len(p.buf)+len(data) > p.max {
+		return ErrTLSBufferFull
+	}
+	p.buf = append(p.buf, data...)
+	p.cond.Signal()
+	return nil
+}
+
+// 连接关闭时调用 唤醒阻塞的Read
+func (p *tlsPipe) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.buf = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *tlsPipe) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// 握手期间阻塞等待密文 握手完成后没有数据返回errTLSWouldBlock
+func (p *tlsPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && !p.closed {
+		if p.established.Load() {
+			return 0, errTLSWouldBlock
+		}
+		p.cond.Wait()
+	}
+	if len(p.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	if len(p.buf) == 0 {
+		p.buf = nil // 释放读完的缓存
+	}
+	return n, nil
+}
+
+func (p *tlsPipe) Write(b []byte) (int, error) {
+	// tls.Conn会复用写缓存 AsyncWrite是异步的需要拷贝
+	err := p.conn.AsyncWrite(append([]byte(nil), b...))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 连接由gnet管理 tls.Conn不关闭底层连接
+func (p *tlsPipe) Close() error {
+	return nil
+}
+
+func (p *tlsPipe) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *tlsPipe) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// 超时由GNetServer的Tick检查
+func (p *tlsPipe) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *tlsPipe) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *tlsPipe) SetWriteDeadline(t time.Time) error {
+	return nil
+}
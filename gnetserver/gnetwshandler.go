@@ -3,14 +3,23 @@ package gnetserver
 // https://github.com/yuwf/gobase
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/utils"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog/log"
 )
 
 // gnet支持websocket类
@@ -22,11 +31,38 @@ var WSHeader = http.Header{
 	"GOARCH":    []string{runtime.GOARCH},
 }
 
+var (
+	ErrWSOrigin   = errors.New("ws origin not allowed")   // Origin不在ParamConfig.WSOrigin中
+	ErrWSHost     = errors.New("ws host not allowed")     // Host不在ParamConfig.WSHost中
+	ErrWSProtocol = errors.New("ws protocol not offered") // GNetWSUpgradeEvent选择的子协议客户端没有请求 不使用子协议完成握手
+)
+
+// 发送关闭帧后等待客户端回复的时间 超时后直接关闭连接
+const wsCloseTimeout = time.Second * 3
+
+// 握手请求的最大长度
+const wsMaxHandShakeSize = 8192
+
+// websocket状态
+const (
+	wsStateHandShake = iota // 等待握手
+	wsStateOpen             // 握手完成
+	wsStateClosing          // 服务器发送了关闭帧 等待客户端回复
+	wsStateClosed           // 握手失败或者客户端发起了关闭 等待数据发送完成后关闭连接 不再处理收到的数据
+)
+
 type gnetWSHandler[ClientInfo any] struct {
 	gc      *GNetClient[ClientInfo] // gnet连接对象
-	upgrade bool                    // 是否已经升级为websocket
+	state   int32                   // websocket状态 原子访问
 	ugrader ws.Upgrader             // 协议升级处理类
 	Header  http.Header             // 请求头
+	deflate *tcp.WSDeflate          // permessage-deflate压缩 没有开启为nil
+
+	// 握手请求的信息 握手完成后不再修改
+	uri       *url.URL
+	host      string
+	protocols []string // 客户端请求的子协议
+	protocol  string   // 选择的子协议
 
 	buf     []byte // 当前要读取的buf
 	readlen int    // 读取的长度
@@ -34,35 +70,103 @@ type gnetWSHandler[ClientInfo any] struct {
 
 func newGNetWSHandler[ClientInfo any](gc *GNetClient[ClientInfo]) *gnetWSHandler[ClientInfo] {
 	wsh := &gnetWSHandler[ClientInfo]{
-		gc:      gc,
-		upgrade: false,
-		Header:  make(http.Header),
+		gc:     gc,
+		state:  wsStateHandShake,
+		Header: make(http.Header),
 	}
 
 	wsh.ugrader = ws.Upgrader{
 		OnHost: func(host []byte) error {
+			wsh.host = string(host)
+			if !ParamConf.Get().IsWSHost(wsh.host) {
+				return wsReject(http.StatusForbidden, ErrWSHost)
+			}
 			return nil
 		},
 		OnHeader: func(key, value []byte) error {
 			wsh.Header.Set(string(key), string(value))
+			if strings.EqualFold(string(key), "Origin") && !ParamConf.Get().IsWSOrigin(string(value)) {
+				return wsReject(http.StatusForbidden, ErrWSOrigin)
+			}
 			return nil
 		},
+		ProtocolCustom: func(value []byte) (string, bool) {
+			// 只收集 握手前由GNetWSUpgradeEvent选择
+			for _, p := range strings.Split(string(value), ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					wsh.protocols = append(wsh.protocols, p)
+				}
+			}
+			return "", true
+		},
 		OnBeforeUpgrade: func() (ws.HandshakeHeader, error) {
-			head := WSHeader
+			if err := wsh.onUpgrade(); err != nil {
+				return nil, err
+			}
+			head := WSHeader.Clone()
 			for k, vs := range ParamConf.Get().WSHeader {
 				for _, v := range vs {
 					head.Add(k, v)
 				}
 			}
+			if wsh.protocol != "" {
+				head.Set("Sec-WebSocket-Protocol", wsh.protocol)
+			}
 			return ws.HandshakeHeaderHTTP(head), nil
 		},
 		OnRequest: func(uri []byte) error {
+			u, err := url.ParseRequestURI(string(uri))
+			if err != nil {
+				return wsReject(http.StatusBadRequest, err)
+			}
+			wsh.uri = u
 			return nil
 		},
 	}
+	if conf := ParamConf.Get().WSDeflate; conf.Enable {
+		wsh.deflate = tcp.NewWSDeflate(conf)
+		wsh.ugrader.Negotiate = wsh.deflate.Negotiate
+	}
 	return wsh
 }
 
+// 握手请求检查通过 回复前调用GNetWSUpgradeEvent
+func (wsh *gnetWSHandler[ClientInfo]) onUpgrade() error {
+	ue, ok := wsh.gc.event.(GNetWSUpgradeEvent[ClientInfo])
+	if !ok {
+		return nil
+	}
+	var protocol string
+	var err error
+	func() {
+		defer utils.HandlePanic()
+		protocol, err = ue.OnWSUpgrade(wsh.gc, wsh.protocols)
+	}()
+	if err != nil {
+		if _, ok := err.(*ws.ConnectionRejectedError); ok {
+			return err
+		}
+		return wsReject(http.StatusForbidden, err)
+	}
+	if protocol != "" {
+		offered := false
+		for _, p := range wsh.protocols {
+			offered = offered || p == protocol
+		}
+		if !offered {
+			// RFC 6455 只能选择客户端请求的子协议，否则不回复Sec-WebSocket-Protocol完成握手，由客户端决定是否继续
+			log.Warn().Err(ErrWSProtocol).Str("protocol", protocol).Strs("protocols", wsh.protocols).Msgf("OnWSUpgrade %s", wsh.gc.ConnName())
+			protocol = ""
+		}
+	}
+	wsh.protocol = protocol
+	return nil
+}
+
+func (wsh *gnetWSHandler[ClientInfo]) upgraded() bool {
+	return atomic.LoadInt32(&wsh.state) != wsStateHandShake
+}
+
 func (wsh *gnetWSHandler[ClientInfo]) Read(b []byte) (n int, err error) {
 	targetLength := len(b)
 	if targetLength < 1 {
@@ -86,56 +190,200 @@ func (wsh *gnetWSHandler[ClientInfo]) Read(b []byte) (n int, err error) {
 }
 
 func (wsh *gnetWSHandler[ClientInfo]) Write(b []byte) (int, error) {
-	err := wsh.gc.conn.AsyncWrite(b)
+	if atomic.LoadInt32(&wsh.state) == wsStateHandShake {
+		b = append([]byte(nil), b...) // 握手时Upgrader写入的是池中bufio的缓存 需要拷贝
+	}
+	err := wsh.gc.write(b)
 	return len(b), err
 }
 
-func (wsh *gnetWSHandler[ClientInfo]) recv(buf []byte) (int, bool, error) {
-	wsh.buf = buf
-	wsh.readlen = 0
-	validlen := 0 //读取后有效，能正确解析出来的后长度
+// 写入一个消息 协商了压缩时，达到MinSize的数据会压缩
+func (wsh *gnetWSHandler[ClientInfo]) write(op ws.OpCode, data []byte) error {
+	return wsh.deflate.WriteServerMessage(wsh, op, data)
+}
 
-	if !wsh.upgrade {
-		_, err := wsh.ugrader.Upgrade(wsh)
-		if err != nil {
-			return 0, false, err // 返回err后会关闭连接，并调用OnDisConnect
-		}
-		wsh.upgrade = true
-		validlen = wsh.readlen
+// 服务器发起关闭 发送关闭帧，等客户端回复关闭帧或者超时后关闭连接
+// 返回false表示没有发送关闭帧，需要直接关闭连接
+func (wsh *gnetWSHandler[ClientInfo]) close(err error) bool {
+	if !atomic.CompareAndSwapInt32(&wsh.state, wsStateOpen, wsStateClosing) {
+		return false
+	}
+	if wsh.write(ws.OpClose, wsCloseBody(err)) != nil {
+		return false
+	}
+	time.AfterFunc(wsCloseTimeout, func() {
+		wsh.gc.conn.Close()
+	})
+	return true
+}
 
-		return validlen, true, nil
+// 发送的数据写入socket后关闭连接 gnet的Close在之前的AsyncWrite之后执行
+func (wsh *gnetWSHandler[ClientInfo]) closeFlushed(err error) {
+	atomic.StoreInt32(&wsh.state, wsStateClosed)
+	wsh.gc.setReason(err)
+	wsh.gc.conn.Close()
+}
+
+func (wsh *gnetWSHandler[ClientInfo]) recv(buf []byte) (int, bool, error) {
+	switch atomic.LoadInt32(&wsh.state) {
+	case wsStateHandShake:
+		return wsh.handshake(buf)
+	case wsStateClosed:
+		return len(buf), false, nil
 	}
 
+	wsh.buf = buf
+	wsh.readlen = 0
+	validlen := 0 //读取后有效，能正确解析出来的后长度
 	for {
-		messages, err := wsutil.ReadClientMessage(wsh, nil)
-		if err == io.EOF {
-			break
+		messages, err := wsh.deflate.ReadClientMessage(wsh, nil)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break // 数据不完整 等待后面的数据
 		}
 		if err != nil {
 			return validlen, false, err // 返回err后会关闭连接，并调用OnDisConnect
 		}
 		validlen = wsh.readlen
-		message := messages[0]
-		switch message.OpCode {
-		case ws.OpText:
-			ctx := context.WithValue(wsh.gc.ctx, CtxKey_Text, 1)
-			_, err := wsh.gc.recv(ctx, message.Payload)
-			if err != nil {
-				return 0, false, err // 返回err后会关闭连接，并调用OnDisConnect
-			}
-		case ws.OpBinary:
-			_, err := wsh.gc.recv(wsh.gc.ctx, message.Payload)
-			if err != nil {
-				return 0, false, err // 返回err后会关闭连接，并调用OnDisConnect
+		// 分片消息中间的控制帧在前面
+		for _, message := range messages {
+			switch message.OpCode {
+			case ws.OpText:
+				if atomic.LoadInt32(&wsh.state) != wsStateOpen {
+					continue // 关闭中 丢弃数据
+				}
+				ctx := context.WithValue(wsh.gc.ctx, CtxKey_Text, 1)
+				_, err := wsh.gc.recv(ctx, message.Payload)
+				if err != nil {
+					return 0, false, err // 返回err后会关闭连接，并调用OnDisConnect
+				}
+			case ws.OpBinary:
+				if atomic.LoadInt32(&wsh.state) != wsStateOpen {
+					continue
+				}
+				_, err := wsh.gc.recv(wsh.gc.ctx, message.Payload)
+				if err != nil {
+					return 0, false, err // 返回err后会关闭连接，并调用OnDisConnect
+				}
+			case ws.OpClose:
+				code, reason := ws.ParseCloseFrameData(message.Payload)
+				if !atomic.CompareAndSwapInt32(&wsh.state, wsStateOpen, wsStateClosed) {
+					// 服务器发起的关闭 收到客户端的回复
+					wsh.closeFlushed(wsh.gc.reason())
+					return len(buf), false, nil
+				}
+				// 客户端发起关闭 回复关闭帧后关闭连接
+				var body []byte
+				if !code.Empty() {
+					if ws.CheckCloseFrameData(code, reason) != nil {
+						body = ws.NewCloseFrameBody(ws.StatusProtocolError, "")
+					} else {
+						body = ws.NewCloseFrameBody(code, "")
+					}
+				}
+				wsh.write(ws.OpClose, body)
+				wsh.closeFlushed(wsutil.ClosedError{Code: code, Reason: reason})
+				return len(buf), false, nil
+			case ws.OpPing:
+				wsh.write(ws.OpPong, message.Payload)
+			case ws.OpPong:
+				// 不需要操作 收到数据时已更新了lastRecvTime
+			default:
 			}
-		case ws.OpClose: // 客户端要求断开
-			return validlen, false, errors.New("ws.OpClose")
-		case ws.OpPing:
-			wsutil.WriteServerMessage(wsh, ws.OpPong, nil)
-		case ws.OpPong:
-			// 不需要操作
-		default:
 		}
 	}
 	return validlen, false, nil
 }
+
+// 握手 只把完整的请求交给Upgrader，请求后面的数据留给下次读取
+func (wsh *gnetWSHandler[ClientInfo]) handshake(buf []byte) (int, bool, error) {
+	end := bytes.Index(buf, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(buf) > wsMaxHandShakeSize {
+			return 0, false, errors.New("ws handshake too large")
+		}
+		return 0, false, nil // 等待完整的请求
+	}
+	wsh.buf = buf[:end+4]
+	wsh.readlen = 0
+	_, err := wsh.ugrader.Upgrade(wsh)
+	if err != nil {
+		// Upgrader已回复了错误 发送完成后关闭连接
+		wsh.closeFlushed(err)
+		return len(buf), false, nil
+	}
+	atomic.StoreInt32(&wsh.state, wsStateOpen)
+	n := len(wsh.buf)
+	if n < len(buf) {
+		// 请求后面的数据 gnet不会再次回调
+		l, _, err := wsh.recv(buf[n:])
+		return n + l, true, err
+	}
+	return n, true, nil
+}
+
+// 握手失败时回复的状态码
+func wsReject(status int, err error) error {
+	return ws.RejectConnectionError(ws.RejectionStatus(status), ws.RejectionReason(err.Error()))
+}
+
+// 服务器关闭时发送的关闭帧数据
+// err为wsutil.ClosedError时使用其中的状态码和原因，其他错误使用StatusPolicyViolation
+func wsCloseBody(err error) []byte {
+	var ce wsutil.ClosedError
+	switch {
+	case err == nil:
+		return ws.NewCloseFrameBody(ws.StatusNormalClosure, "")
+	case errors.As(err, &ce):
+		return ws.NewCloseFrameBody(ce.Code, ce.Reason)
+	}
+	return ws.NewCloseFrameBody(ws.StatusPolicyViolation, err.Error())
+}
+
+// WSPath websocket握手请求的路径 非websocket连接返回空
+func (gc *GNetClient[ClientInfo]) WSPath() string {
+	if gc.wsh == nil || gc.wsh.uri == nil {
+		return ""
+	}
+	return gc.wsh.uri.Path
+}
+
+// WSQuery websocket握手请求的参数
+func (gc *GNetClient[ClientInfo]) WSQuery() url.Values {
+	if gc.wsh == nil || gc.wsh.uri == nil {
+		return url.Values{}
+	}
+	return gc.wsh.uri.Query()
+}
+
+// WSCookie websocket握手请求中的cookie 不存在返回http.ErrNoCookie
+func (gc *GNetClient[ClientInfo]) WSCookie(name string) (*http.Cookie, error) {
+	if gc.wsh == nil {
+		return nil, http.ErrNoCookie
+	}
+	r := http.Request{Header: gc.wsh.Header}
+	return r.Cookie(name)
+}
+
+// WSHost websocket握手请求的Host
+func (gc *GNetClient[ClientInfo]) WSHost() string {
+	if gc.wsh == nil {
+		return ""
+	}
+	return gc.wsh.host
+}
+
+// WSRequestHeader websocket握手请求头 不包括websocket协议相关的头
+func (gc *GNetClient[ClientInfo]) WSRequestHeader() http.Header {
+	if gc.wsh == nil {
+		return nil
+	}
+	return gc.wsh.Header
+}
+
+// WSProtocol websocket握手时选择的子协议
+func (gc *GNetClient[ClientInfo]) WSProtocol() string {
+	if gc.wsh == nil {
+		return ""
+	}
+	return gc.wsh.protocol
+}
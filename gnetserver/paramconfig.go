@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/yuwf/gobase/loader"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/utils"
 )
 
@@ -18,6 +19,22 @@ type ParamConfig struct {
 
 	MsgSeq   bool                `json:"msgseq,omitempty"`   // 消息顺序执行
 	WSHeader map[string][]string `json:"wsheader,omitempty"` // websocket握手时 回复的头
+
+	tcp.WSCheckConfig // websocket握手时 允许的Origin和Host
+
+	Proxy tcp.ProxyProtoConfig `json:"proxy,omitempty"` // PROXY protocol v1/v2 负载均衡器后面获取客户端的真实地址，新建立的连接生效
+
+	Admission tcp.AdmissionConfig `json:"admission,omitempty"` // 连接准入控制 最大连接数、新建连接速率、IP黑白名单
+
+	WSDeflate tcp.WSDeflateConfig `json:"wsdeflate,omitempty"` // websocket permessage-deflate压缩 新建立的连接生效
+
+	Cipher tcp.CipherConfig `json:"cipher,omitempty"` // 数据加密 X25519握手+AEAD加密，websocket连接不使用，新建立的连接生效
+
+	TLSBuffer int `json:"tlsbuffer,omitempty"` // TLS连接未解密的密文和握手完成前发送的数据缓存上限 超过后关闭连接 单位字节 默认1M，新建立的连接生效
+
+	ReadIdleTimeout  int `json:"readidletimeout,omitempty"`  // 读空闲超时 超过时间未收到数据关闭连接 单位秒 <=0表示不检查
+	HandShakeTimeout int `json:"handshaketimeout,omitempty"` // 握手超时 连接后超过时间未AddClient关闭连接 单位秒 <=0表示不检查
+	PingInterval     int `json:"pinginterval,omitempty"`     // 读空闲达到间隔时回调GNetPingEvent.OnPing，没有实现时websocket连接发送ping帧 单位秒 <=0表示不开启
 }
 
 var ParamConf loader.JsonLoader[ParamConfig]
@@ -30,6 +47,18 @@ func (c *ParamConfig) Normalize() {
 	for i := 0; i < len(c.IgnoreIp); i++ {
 		c.IgnoreIp[i] = strings.ToLower(c.IgnoreIp[i])
 	}
+	c.WSCheckConfig.Normalize()
+	c.Proxy.Normalize()
+	c.Admission.Normalize()
+	c.WSDeflate.Normalize()
+	c.Cipher.Normalize()
+}
+
+func (c *ParamConfig) tlsBuffer() int {
+	if c.TLSBuffer <= 0 {
+		return 1024 * 1024
+	}
+	return c.TLSBuffer
 }
 
 func (c *ParamConfig) IsIgnoreIp(ip string) bool {
@@ -0,0 +1,116 @@
+package gnetserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/gobwas/ws"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// JoinRoom 连接加入房间 连接断开时自动离开所有房间
+func (s *GNetServer[ClientId, ClientInfo]) JoinRoom(name string, gc *GNetClient[ClientInfo]) {
+	// 在锁内检查下是否存在连接，防止和断开时的清理交叉
+	s.rooms.Join(name, gc, func() bool {
+		_, ok := s.connMap.Load(gc.conn)
+		return ok
+	})
+}
+
+// LeaveRoom 连接离开房间
+func (s *GNetServer[ClientId, ClientInfo]) LeaveRoom(name string, gc *GNetClient[ClientInfo]) {
+	s.rooms.Leave(name, gc)
+}
+
+// LeaveAllRoom 连接离开加入的所有房间
+func (s *GNetServer[ClientId, ClientInfo]) LeaveAllRoom(gc *GNetClient[ClientInfo]) {
+	s.rooms.LeaveAll(gc)
+}
+
+// Rooms 连接加入的房间
+func (s *GNetServer[ClientId, ClientInfo]) Rooms(gc *GNetClient[ClientInfo]) []string {
+	return s.rooms.Names(gc)
+}
+
+// RoomCount 房间内的连接数量
+func (s *GNetServer[ClientId, ClientInfo]) RoomCount(name string) int {
+	return s.rooms.Count(name)
+}
+
+// RangeRoom 遍历房间内的连接 f函数返回false 停止遍历 遍历的是快照，不持有锁
+func (s *GNetServer[ClientId, ClientInfo]) RangeRoom(name string, f func(gc *GNetClient[ClientInfo]) bool) {
+	for _, gc := range s.rooms.Members(name) {
+		if !f(gc) {
+			return
+		}
+	}
+}
+
+// BroadcastMsg 给房间内所有连接广播消息，消息只编码一次，所有连接发送相同的数据
+func (s *GNetServer[ClientId, ClientInfo]) BroadcastMsg(ctx context.Context, name string, msg msger.Msger) error {
+	return s.BroadcastMsgExcept(ctx, name, msg, nil)
+}
+
+// BroadcastMsgExcept 给房间内除except之外的所有连接广播消息，except一般为发送者
+func (s *GNetServer[ClientId, ClientInfo]) BroadcastMsgExcept(ctx context.Context, name string, msg msger.Msger, except *GNetClient[ClientInfo]) error {
+	if msg == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("room", name).Msg("BroadcastMsg error")
+		return err
+	}
+	members := s.rooms.Members(name)
+	if len(members) == 0 {
+		return nil
+	}
+	data, err := msg.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("room", name).Interface("msger", msg).Msg("BroadcastMsg error")
+		return err
+	}
+	var wsdata []byte // websocket连接使用 延迟编码
+	count := 0
+	for _, gc := range members {
+		if gc == except {
+			continue
+		}
+		if gc.wsh != nil {
+			if wsdata == nil {
+				wsdata, err = ws.CompileFrame(ws.NewBinaryFrame(data))
+				if err != nil {
+					utils.LogCtx(log.Error(), ctx).Err(err).Str("room", name).Interface("msger", msg).Msg("BroadcastMsg error")
+					return err
+				}
+			}
+			err = gc.write(wsdata)
+		} else {
+			err = gc.send(data) // 开启加密的连接各自加密
+		}
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("room", name).Interface("msger", msg).Msgf("BroadcastMsg %s error", gc.ConnName())
+			continue
+		}
+		count++
+		atomic.StoreInt64(&gc.lastSendTime, time.Now().UnixMicro())
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range gc.hook {
+				h.OnSendMsg(gc, msg, len(data))
+			}
+		}()
+	}
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(msg)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("room", name).Int("count", count).Interface("msger", msg).Msg("BroadcastMsg")
+	}
+	return nil
+}
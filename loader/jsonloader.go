@@ -25,7 +25,11 @@ type JsonLoader[T any] struct {
 
 // Get 获取配置 返回的指针一定不为nil 外层不需要再判断
 func (l *JsonLoader[T]) Get() *T {
-	if l.conf == nil {
+	// 先在读锁中判断 Load会在写锁中替换conf
+	l.RLock()
+	conf := l.conf
+	l.RUnlock()
+	if conf == nil {
 		l.Lock()
 		defer l.Unlock()
 		if l.conf == nil {
@@ -44,9 +48,7 @@ func (l *JsonLoader[T]) Get() *T {
 		}
 		return l.conf
 	}
-	l.RLock()
-	defer l.RUnlock()
-	return l.conf
+	return conf
 }
 
 // RegHook 注册配置修改Hook
@@ -23,7 +23,11 @@ type JsonLoaders[T any] struct {
 
 // Get 返回的map对象一定不为nil 外层不需要再判断，外部只读不可修改
 func (l *JsonLoaders[T]) Get() map[string]*T {
-	if l.confs == nil {
+	// 先在读锁中判断 Load会在写锁中替换confs
+	l.RLock()
+	confs := l.confs
+	l.RUnlock()
+	if confs == nil {
 		l.Lock()
 		defer l.Unlock()
 		if l.confs == nil {
@@ -31,9 +35,7 @@ func (l *JsonLoaders[T]) Get() map[string]*T {
 		}
 		return l.confs
 	}
-	l.RLock()
-	defer l.RUnlock()
-	return l.confs
+	return confs
 }
 
 // 不会调用Create和Normalize
@@ -3,6 +3,7 @@ package loader
 // https://github.com/yuwf/gobase
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -32,3 +33,32 @@ func BenchmarkRedis(b *testing.B) {
 	time.Sleep(time.Hour)
 
 }
+
+// 热更新时其他协程在读取配置 go test -race检查
+func TestJsonLoaderGet(t *testing.T) {
+	var l JsonLoader[ConfTest]
+	var ls JsonLoaders[ConfTest]
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if l.Get() == nil || ls.Get() == nil {
+					t.Error("get nil")
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 100; i++ {
+		if err := l.LoadBy(&ConfTest{Server_Id: i}); err != nil {
+			t.Fatal(err)
+		}
+		ls.Set(map[string]*ConfTest{"id": {Server_Id: i}})
+	}
+	wg.Wait()
+	if l.Get().Server_Id != 100 || ls.GetItem("id").Server_Id != 100 {
+		t.Fatal(l.Get().Server_Id, ls.GetItem("id"))
+	}
+}
@@ -3,10 +3,13 @@ package metrics
 // https://github.com/yuwf/gobase
 
 import (
+	"net"
 	"sync"
+	"time"
 
 	"github.com/yuwf/gobase/gnetserver"
 	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -35,8 +38,16 @@ var (
 	gnetSendTextCount prometheus.Counter
 	gnetSendTextSize  prometheus.Counter
 
+	gnetSendRPCMsgCount *prometheus.CounterVec
+	gnetSendRPCMsgSize  *prometheus.CounterVec
+	gnetSendRPCMsgTime  *prometheus.CounterVec
+
 	gnetRecvMsgCount *prometheus.CounterVec
 	gnetRecvMsgSize  *prometheus.CounterVec
+
+	gnetRejectCount *prometheus.CounterVec
+
+	gnetCipherFailCount *prometheus.CounterVec
 )
 
 type gNetHook[ClientInfo any] struct {
@@ -67,8 +78,16 @@ func (h *gNetHook[ClientInfo]) init() {
 		gnetSendTextCount = DefaultReg().NewCounter(prometheus.CounterOpts{Name: "gnet_sendtext_count"})
 		gnetSendTextSize = DefaultReg().NewCounter(prometheus.CounterOpts{Name: "gnet_sendtext_size"})
 
+		gnetSendRPCMsgCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_sendrpcmsg_count"}, []string{"name"})
+		gnetSendRPCMsgSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_sendrpcmsg_size"}, []string{"name"})
+		gnetSendRPCMsgTime = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_sendrpcmsg_time"}, []string{"name"})
+
 		gnetRecvMsgCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_recvmsg_count"}, []string{"name"})
 		gnetRecvMsgSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_recvmsg_size"}, []string{"name"})
+
+		gnetRejectCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_reject_count"}, []string{"addr", "reason"})
+
+		gnetCipherFailCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "gnet_cipherfail_count"}, []string{"addr", "err"})
 	})
 }
 
@@ -152,6 +171,19 @@ func (h *gNetHook[ClientInfo]) OnSendText(gc *gnetserver.GNetClient[ClientInfo],
 	gnetSendTextSize.Add(float64(len_))
 }
 
+func (h *gNetHook[ClientInfo]) OnSendRPCMsg(gc *gnetserver.GNetClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len_ int) {
+	h.init()
+	if mner, _ := any(mr).(msger.MsgerName); mner != nil {
+		gnetSendRPCMsgCount.WithLabelValues(mner.MsgName()).Inc()
+		gnetSendRPCMsgSize.WithLabelValues(mner.MsgName()).Add(float64(len_))
+		gnetSendRPCMsgTime.WithLabelValues(mner.MsgName()).Add(float64(elapsed.Nanoseconds()))
+	} else {
+		gnetSendRPCMsgCount.WithLabelValues(mr.MsgID()).Inc()
+		gnetSendRPCMsgSize.WithLabelValues(mr.MsgID()).Add(float64(len_))
+		gnetSendRPCMsgTime.WithLabelValues(mr.MsgID()).Add(float64(elapsed.Nanoseconds()))
+	}
+}
+
 func (h *gNetHook[ClientInfo]) OnRecvMsg(gc *gnetserver.GNetClient[ClientInfo], mr msger.RecvMsger, len_ int) {
 	h.init()
 	if mner, _ := any(mr).(msger.MsgerName); mner != nil {
@@ -163,6 +195,16 @@ func (h *gNetHook[ClientInfo]) OnRecvMsg(gc *gnetserver.GNetClient[ClientInfo],
 	}
 }
 
+func (h *gNetHook[ClientInfo]) OnReject(addr net.Addr, reason error) {
+	h.init()
+	gnetRejectCount.WithLabelValues(h.addr, reason.Error()).Inc()
+}
+
+func (h *gNetHook[ClientInfo]) OnCipherFail(gc *gnetserver.GNetClient[ClientInfo], err error) {
+	h.init()
+	gnetCipherFailCount.WithLabelValues(h.addr, tcp.CipherFailReason(err)).Inc()
+}
+
 func (h *gNetHook[ClientInfo]) OnTick() {
 	h.init()
 	seqs := h.server.RecvSeqCount()
@@ -12,6 +12,7 @@ import (
 	"github.com/yuwf/gobase/httprequest"
 	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/mysql"
+	"github.com/yuwf/gobase/netserver"
 	"github.com/yuwf/gobase/tcpserver"
 	"github.com/yuwf/gobase/utils"
 
@@ -141,6 +142,19 @@ func RegTCPServer[ClientId any, ClientInfo any](ts *tcpserver.TCPServer[ClientId
 	}
 }
 
+// netserver.Server统计 根据引擎使用RegTCPServer或者RegGNetServer
+func RegServer[ClientId any, ClientInfo any](s netserver.Server[ClientId, ClientInfo]) {
+	if s == nil {
+		return
+	}
+	switch raw := s.Raw().(type) {
+	case *tcpserver.TCPServer[ClientId, ClientInfo]:
+		RegTCPServer(raw)
+	case *gnetserver.GNetServer[ClientId, ClientInfo]:
+		RegGNetServer(raw)
+	}
+}
+
 // TCPBackend统计
 func RegTCPBackend[ServiceInfo any](tb *backend.TcpBackend[ServiceInfo]) {
 	if tb != nil {
@@ -159,5 +173,7 @@ func RegHttpBackend[ServerInfo any](hb *backend.HttpBackend[ServerInfo]) {
 func RegMsgDispatch(md *msger.MsgDispatch) {
 	if md != nil {
 		md.RegHook(msgDispatchHook)
+		md.RegErrHook(msgDispatchErrHook)
+		md.RegTimeOutHook(msgDispatchTimeOutHook)
 	}
 }
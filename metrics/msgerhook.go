@@ -4,6 +4,7 @@ package metrics
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
 
@@ -18,6 +19,12 @@ var (
 	msgerLatency *prometheus.HistogramVec
 	msgerCount   *prometheus.CounterVec
 	msgerSum     *prometheus.CounterVec // 耗时之和
+
+	msgerErrOnce  sync.Once
+	msgerErrCount *prometheus.CounterVec // 处理错误数 code为业务错误码，非业务错误为空
+
+	msgerTimeOutOnce  sync.Once
+	msgerTimeOutCount *prometheus.CounterVec // 处理超时数
 )
 
 func msgDispatchHook(ctx context.Context, mr msger.Msger, elapsed time.Duration) {
@@ -49,3 +56,31 @@ func msgDispatchHook(ctx context.Context, mr msger.Msger, elapsed time.Duration)
 		}
 	}
 }
+
+func msgDispatchErrHook(ctx context.Context, mr msger.Msger, err error) {
+	msgerErrOnce.Do(func() {
+		msgerErrCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "msger_error_count"}, []string{"name", "code"})
+	})
+
+	code := ""
+	if c, ok := msger.ErrorCode(err); ok {
+		code = strconv.Itoa(c)
+	}
+	if mner, _ := any(mr).(msger.MsgerName); mner != nil {
+		msgerErrCount.WithLabelValues(mner.MsgName(), code).Inc()
+	} else {
+		msgerErrCount.WithLabelValues(mr.MsgID(), code).Inc()
+	}
+}
+
+func msgDispatchTimeOutHook(ctx context.Context, mr msger.Msger) {
+	msgerTimeOutOnce.Do(func() {
+		msgerTimeOutCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "msger_timeout_count"}, []string{"name"})
+	})
+
+	if mner, _ := any(mr).(msger.MsgerName); mner != nil {
+		msgerTimeOutCount.WithLabelValues(mner.MsgName()).Inc()
+	} else {
+		msgerTimeOutCount.WithLabelValues(mr.MsgID()).Inc()
+	}
+}
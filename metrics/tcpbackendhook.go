@@ -8,6 +8,7 @@ import (
 
 	"github.com/yuwf/gobase/backend"
 	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -36,6 +37,14 @@ var (
 
 	tcpBackendRecvMsgCount *prometheus.CounterVec
 	tcpBackendRecvMsgSize  *prometheus.CounterVec
+
+	tcpBackendDropCount *prometheus.CounterVec
+	tcpBackendDropSize  *prometheus.CounterVec
+
+	tcpBackendCipherFailCount *prometheus.CounterVec
+
+	tcpBackendCompressRawSize *prometheus.CounterVec
+	tcpBackendCompressSize    *prometheus.CounterVec
 )
 
 type tcpBackendHook[ServiceInfo any] struct {
@@ -64,6 +73,14 @@ func (h *tcpBackendHook[ServiceInfo]) init() {
 
 		tcpBackendRecvMsgCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_recvmsg_count"}, []string{"name"})
 		tcpBackendRecvMsgSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_recvmsg_size"}, []string{"name"})
+
+		tcpBackendDropCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_drop_count"}, []string{"connname"})
+		tcpBackendDropSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_drop_size"}, []string{"connname"})
+
+		tcpBackendCipherFailCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_cipherfail_count"}, []string{"connname", "err"})
+
+		tcpBackendCompressRawSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_compress_rawsize"}, []string{"connname", "dir"})
+		tcpBackendCompressSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpbackend_compress_size"}, []string{"connname", "dir"})
 	})
 }
 
@@ -84,6 +101,10 @@ func (h *tcpBackendHook[ServiceInfo]) OnRemove(ts *backend.TcpService[ServiceInf
 	tcpBackendConnSendMsgCount.DeleteLabelValues(ts.ConnName())
 	tcpBackendConnRecvMsgCount.DeleteLabelValues(ts.ConnName())
 	tcpBackendConnRecvSeqCount.DeleteLabelValues(ts.ConnName())
+	tcpBackendCompressRawSize.DeleteLabelValues(ts.ConnName(), "send")
+	tcpBackendCompressRawSize.DeleteLabelValues(ts.ConnName(), "recv")
+	tcpBackendCompressSize.DeleteLabelValues(ts.ConnName(), "send")
+	tcpBackendCompressSize.DeleteLabelValues(ts.ConnName(), "recv")
 }
 
 func (h *tcpBackendHook[ServiceInfo]) OnConnected(ts *backend.TcpService[ServiceInfo]) {
@@ -151,3 +172,24 @@ func (h *tcpBackendHook[ServiceInfo]) OnRecvMsg(ts *backend.TcpService[ServiceIn
 		tcpBackendRecvMsgSize.WithLabelValues(mr.MsgID()).Add(float64(len_))
 	}
 }
+
+func (h *tcpBackendHook[ServiceInfo]) OnDropOldest(ts *backend.TcpService[ServiceInfo], len_ int) {
+	h.init()
+	tcpBackendDropCount.WithLabelValues(ts.ConnName()).Inc()
+	tcpBackendDropSize.WithLabelValues(ts.ConnName()).Add(float64(len_))
+}
+
+func (h *tcpBackendHook[ServiceInfo]) OnCipherFail(ts *backend.TcpService[ServiceInfo], err error) {
+	h.init()
+	tcpBackendCipherFailCount.WithLabelValues(ts.ConnName(), tcp.CipherFailReason(err)).Inc()
+}
+
+func (h *tcpBackendHook[ServiceInfo]) OnCompress(ts *backend.TcpService[ServiceInfo], send bool, rawLen, len_ int) {
+	h.init()
+	dir := "recv"
+	if send {
+		dir = "send"
+	}
+	tcpBackendCompressRawSize.WithLabelValues(ts.ConnName(), dir).Add(float64(rawLen))
+	tcpBackendCompressSize.WithLabelValues(ts.ConnName(), dir).Add(float64(len_))
+}
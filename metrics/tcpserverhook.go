@@ -3,10 +3,12 @@ package metrics
 // https://github.com/yuwf/gobase
 
 import (
+	"net"
 	"sync"
 	"time"
 
 	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcp"
 	"github.com/yuwf/gobase/tcpserver"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -50,6 +52,19 @@ var (
 
 	tcpServerRecvMsgCount *prometheus.CounterVec
 	tcpServerRecvMsgSize  *prometheus.CounterVec
+
+	tcpServerHighWaterCount *prometheus.CounterVec
+	tcpServerLowWaterCount  *prometheus.CounterVec
+
+	tcpServerDropCount *prometheus.CounterVec
+	tcpServerDropSize  *prometheus.CounterVec
+
+	tcpServerRejectCount *prometheus.CounterVec
+
+	tcpServerCipherFailCount *prometheus.CounterVec
+
+	tcpServerCompressRawSize *prometheus.CounterVec
+	tcpServerCompressSize    *prometheus.CounterVec
 )
 
 type tcpServerHook[ClientInfo any] struct {
@@ -96,6 +111,19 @@ func (h *tcpServerHook[ClientInfo]) init() {
 
 		tcpServerRecvMsgCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_recvmsg_count"}, []string{"name"})
 		tcpServerRecvMsgSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_recvmsg_size"}, []string{"name"})
+
+		tcpServerHighWaterCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_highwater_count"}, []string{"addr"})
+		tcpServerLowWaterCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_lowwater_count"}, []string{"addr"})
+
+		tcpServerDropCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_drop_count"}, []string{"addr"})
+		tcpServerDropSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_drop_size"}, []string{"addr"})
+
+		tcpServerRejectCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_reject_count"}, []string{"addr", "reason"})
+
+		tcpServerCipherFailCount = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_cipherfail_count"}, []string{"addr", "err"})
+
+		tcpServerCompressRawSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_compress_rawsize"}, []string{"addr", "dir"})
+		tcpServerCompressSize = DefaultReg().NewCounterVec(prometheus.CounterOpts{Name: "tcpserver_compress_size"}, []string{"addr", "dir"})
 	})
 }
 
@@ -240,6 +268,42 @@ func (h *tcpServerHook[ClientInfo]) OnRecvMsg(tc *tcpserver.TCPClient[ClientInfo
 	}
 }
 
+func (h *tcpServerHook[ClientInfo]) OnHighWater(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.init()
+	tcpServerHighWaterCount.WithLabelValues(h.addr).Inc()
+}
+
+func (h *tcpServerHook[ClientInfo]) OnLowWater(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.init()
+	tcpServerLowWaterCount.WithLabelValues(h.addr).Inc()
+}
+
+func (h *tcpServerHook[ClientInfo]) OnDropOldest(tc *tcpserver.TCPClient[ClientInfo], len_ int) {
+	h.init()
+	tcpServerDropCount.WithLabelValues(h.addr).Inc()
+	tcpServerDropSize.WithLabelValues(h.addr).Add(float64(len_))
+}
+
+func (h *tcpServerHook[ClientInfo]) OnReject(addr net.Addr, reason error) {
+	h.init()
+	tcpServerRejectCount.WithLabelValues(h.addr, reason.Error()).Inc()
+}
+
+func (h *tcpServerHook[ClientInfo]) OnCipherFail(tc *tcpserver.TCPClient[ClientInfo], err error) {
+	h.init()
+	tcpServerCipherFailCount.WithLabelValues(h.addr, tcp.CipherFailReason(err)).Inc()
+}
+
+func (h *tcpServerHook[ClientInfo]) OnCompress(tc *tcpserver.TCPClient[ClientInfo], send bool, rawLen, len_ int) {
+	h.init()
+	dir := "recv"
+	if send {
+		dir = "send"
+	}
+	tcpServerCompressRawSize.WithLabelValues(h.addr, dir).Add(float64(rawLen))
+	tcpServerCompressSize.WithLabelValues(h.addr, dir).Add(float64(len_))
+}
+
 func (h *tcpServerHook[ClientInfo]) OnTick() {
 	h.init()
 	seqs := h.server.RecvSeqCount()
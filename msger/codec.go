@@ -0,0 +1,102 @@
+package msger
+
+// https://github.com/yuwf/gobase
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// 消息体编码类型 写入Envelope的头中，接收方根据头中的类型解码
+const (
+	CodecJSON    uint8 = 1
+	CodecProto   uint8 = 2
+	CodecMsgpack uint8 = 3
+)
+
+// Codec 消息体的编解码
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = [256]Codec{
+	CodecJSON:    jsonCodec{},
+	CodecProto:   protoCodec{},
+	CodecMsgpack: newMsgpackCodec(),
+}
+
+// 注册编解码 可以替换内置的，不使用锁，要求在收发消息前注册好
+func RegCodec(id uint8, c Codec) {
+	codecs[id] = c
+}
+
+// 获取编解码 没有注册返回nil
+func GetCodec(id uint8) Codec {
+	return codecs[id]
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// 消息体必须实现proto.Message
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct {
+	h *codec.MsgpackHandle
+}
+
+func newMsgpackCodec() msgpackCodec {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true // 使用新版规范 区分str和bin
+	return msgpackCodec{h: h}
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (c msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.h).Encode(v)
+	return data, err
+}
+
+func (c msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.h).Decode(v)
+}
@@ -28,6 +28,7 @@ type MsgHandler struct {
 	MsgType      reflect.Type // 处理消息的类型
 	RespType     reflect.Type // 回复消息的类型
 	RespId       string       // 回复消息的id
+	RetErr       bool         // 处理函数是否返回error
 }
 
 // 获取函数名
@@ -30,10 +30,26 @@ type MsgDispatch struct {
 
 	// 请求处理完后回调 不使用锁，默认要求提前注册好
 	hook []func(ctx context.Context, mr Msger, elapsed time.Duration)
+
+	// 拦截器 不使用锁，默认要求提前注册好
+	interceptor    []Interceptor
+	msgInterceptor map[string][]Interceptor // [msgid:[]Interceptor]
+
+	// 消息限流
+	limiter   msgLimiter
+	limitHook []func(ctx context.Context, info *CallInfo, err error)
+
+	// 错误处理 不使用锁，默认要求提前注册好
+	errorResponder ErrorResponder
+	errHook        []func(ctx context.Context, mr Msger, err error)
+
+	// 消息超时回调 不使用锁，默认要求提前注册好
+	timeOutHook []func(ctx context.Context, mr Msger)
 }
 
 // Msg表示用来透传的消息类型，必须实现Msger接口，否则无法分发
 // Termianl表示用来透传的终端对象，一般为连接对象
+// Termianl为接口类型时，处理函数的终端参数直接写Termianl，不用指针，分发时终端对象实现了该接口即可
 
 func NewMsgDispatch[Msg any, Termianl any]() (*MsgDispatch, error) {
 	var zero Msg
@@ -44,6 +60,9 @@ func NewMsgDispatch[Msg any, Termianl any]() (*MsgDispatch, error) {
 		mType: reflect.TypeOf((*Msg)(nil)),
 		tType: reflect.TypeOf((*Termianl)(nil)),
 	}
+	if md.tType.Elem().Kind() == reflect.Interface {
+		md.tType = md.tType.Elem()
+	}
 	return md, nil
 }
 
@@ -107,6 +126,7 @@ func (md *MsgDispatch) SendResp(fun interface{}) error {
 // (ctx context.Context, m *Msg, msg *具体消息, t *Termianl)
 // (ctx context.Context, req *具体消息, resp *具体消息, t *Termianl)
 // (ctx context.Context, m *Msg, req *具体消息, resp *具体消息, t *Termianl)
+// 返回值可以没有或者为error
 func (md *MsgDispatch) Reg(v interface{}, regMsgID func(msgType reflect.Type) string) {
 	vType := reflect.TypeOf(v)
 	if vType.Kind() != reflect.Pointer || vType.Elem().Kind() != reflect.Struct {
@@ -215,6 +235,7 @@ func (md *MsgDispatch) Reg(v interface{}, regMsgID func(msgType reflect.Type) st
 // fun的参数支持以下写法
 // (ctx context.Context, msg *具体消息, t *Termianl)
 // (ctx context.Context, m *Msg, msg *具体消息, t *Termianl)
+// 返回值可以没有或者为error，返回的error输出日志并回调RegErrHook
 func (md *MsgDispatch) RegMsg(msgid string, fun interface{}) error {
 	// 获取函数类型
 	funType := reflect.TypeOf(fun)
@@ -260,6 +281,12 @@ func (md *MsgDispatch) RegMsg(msgid string, fun interface{}) error {
 		log.Error().Err(err).Str("Func", funName).Str("type", funType.In(paramNum-1).String()).Msg("MsgDispatch RegMsg error")
 		return err
 	}
+	// 返回值没有或者为error
+	retErr, err := funcRetErr(funType)
+	if err != nil {
+		log.Error().Err(err).Str("Func", funName).Msg("MsgDispatch RegMsg error")
+		return err
+	}
 
 	var handler *MsgHandler
 	if paramNum == 3 {
@@ -279,6 +306,7 @@ func (md *MsgDispatch) RegMsg(msgid string, fun interface{}) error {
 			MsgType:      funType.In(2).Elem(),
 		}
 	}
+	handler.RetErr = retErr
 
 	// 检查下消息id
 	if msgid == "" {
@@ -303,6 +331,7 @@ func (md *MsgDispatch) RegMsg(msgid string, fun interface{}) error {
 // fun的参数支持以下写法
 // (ctx context.Context, req *具体消息, resp *具体消息, t *Termianl)
 // (ctx context.Context, m *MSg, req *具体消息, resp *具体消息, t *Termianl)
+// 返回值可以没有或者为error，返回error时使用ErrorResp生成的消息回复
 func (md *MsgDispatch) RegReqResp(reqid, respid string, fun interface{}) error {
 	// 获取函数类型和函数名
 	funType := reflect.TypeOf(fun)
@@ -354,6 +383,12 @@ func (md *MsgDispatch) RegReqResp(reqid, respid string, fun interface{}) error {
 		log.Error().Err(err).Str("Func", funName).Str("type", funType.In(paramNum-1).String()).Msg("MsgDispatch RegReqResp error")
 		return err
 	}
+	// 返回值没有或者为error
+	retErr, err := funcRetErr(funType)
+	if err != nil {
+		log.Error().Err(err).Str("Func", funName).Msg("MsgDispatch RegReqResp error")
+		return err
+	}
 
 	var handler *MsgHandler
 	if paramNum == 4 {
@@ -375,6 +410,7 @@ func (md *MsgDispatch) RegReqResp(reqid, respid string, fun interface{}) error {
 			RespType:     funType.In(3).Elem(),
 		}
 	}
+	handler.RetErr = retErr
 
 	// 检查下消息id
 	if reqid == "" {
@@ -405,6 +441,7 @@ func (md *MsgDispatch) RegReqResp(reqid, respid string, fun interface{}) error {
 // fun的参数支持以下写法
 // (ctx context.Context, req *具体消息, resp *ReplyResp[具体消息], t *Termianl)
 // (ctx context.Context, m *MSg, req *具体消息, resp *ReplyResp[具体消息], t *Termianl)
+// 返回值可以没有或者为error，返回error并且还没有Reply时，使用ErrorResp生成的消息回复
 func (md *MsgDispatch) RegReqReply(reqid, respid string, fun interface{}) error {
 	// 获取函数类型和函数名
 	funType := reflect.TypeOf(fun)
@@ -471,6 +508,12 @@ func (md *MsgDispatch) RegReqReply(reqid, respid string, fun interface{}) error
 		log.Error().Err(err).Str("Func", funName).Str("type", funType.In(paramNum-1).String()).Msg("MsgDispatch RegReqReply error")
 		return err
 	}
+	// 返回值没有或者为error
+	retErr, err := funcRetErr(funType)
+	if err != nil {
+		log.Error().Err(err).Str("Func", funName).Msg("MsgDispatch RegReqReply error")
+		return err
+	}
 
 	var handler *MsgHandler
 	if paramNum == 4 {
@@ -492,6 +535,7 @@ func (md *MsgDispatch) RegReqReply(reqid, respid string, fun interface{}) error
 			RespType:     funType.In(3).Elem(),
 		}
 	}
+	handler.RetErr = retErr
 
 	// 检查下消息id
 	if reqid == "" {
@@ -574,7 +618,7 @@ func (md *MsgDispatch) Dispatch(ctx context.Context, mr RecvMsger, t interface{}
 		return false, err
 	}
 	tType := reflect.ValueOf(t).Type()
-	if tType != md.tType {
+	if tType != md.tType && !(md.tType.Kind() == reflect.Interface && tType.Implements(md.tType)) {
 		err := errors.New("prame must be " + md.mType.String() + ", but is " + tType.String())
 		md.log(ctx, nil, mr, t, int(zerolog.ErrorLevel), logPrefix+" Param error, "+err.Error())
 		return false, err
@@ -628,14 +672,23 @@ func (md *MsgDispatch) handle(ctx context.Context, handler *MsgHandler, mr Msger
 func (md *MsgDispatch) callFunc(ctx context.Context, handler *MsgHandler, mr Msger, msgid string, msg interface{}, t interface{}) interface{} {
 	defer utils.HandlePanic()
 
-	// 消息超时检查
+	// 处理函数超时
+	conf := ParamConf.Get()
+	timeout := conf.msgTimeout(msgid)
+	ctx, cancel := withMsgTimeout(ctx, timeout)
+
+	// 消息超时检查 配置了处理函数超时的使用处理函数超时
+	checkTimeout := time.Duration(conf.TimeOutCheck) * time.Second
+	if timeout > 0 {
+		checkTimeout = timeout
+	}
 	var checkMsgDone chan int
-	if ParamConf.Get().TimeOutCheck > 0 {
+	if checkTimeout > 0 {
 		// 消息处理超时监控逻辑
 		checkMsgDone = make(chan int, 1)
 
 		utils.Submit(func() {
-			timer := time.NewTimer(time.Duration(ParamConf.Get().TimeOutCheck) * time.Second)
+			timer := time.NewTimer(checkTimeout)
 			select {
 			case <-checkMsgDone:
 				if !timer.Stop() {
@@ -647,47 +700,117 @@ func (md *MsgDispatch) callFunc(ctx context.Context, handler *MsgHandler, mr Msg
 			case <-timer.C:
 				// 消息超时了
 				md.log(ctx, handler, mr, t, int(zerolog.ErrorLevel), "MsgDispatch TimeOut")
+				md.callTimeOutHook(ctx, mr)
 			}
 		})
 	}
 
+	info := &CallInfo{
+		MsgID:   msgid,
+		Mr:      mr,
+		Msg:     msg,
+		T:       t,
+		Handler: handler,
+	}
+	var reply ReplyResper
 	switch handler.RegType {
-	case RegType_Msg3:
-		handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(t)})
-		if checkMsgDone != nil {
-			close(checkMsgDone)
+	case RegType_ReqResp4, RegType_ReqResp5:
+		info.Resp = reflect.New(handler.RespType).Interface()
+		defer cancel()
+	case RegType_ReqReply4, RegType_ReqReply5:
+		reply = reflect.New(handler.RespType).Interface().(ReplyResper)
+		// 调用 create(ctx, md)
+		reply.create(md, ctx, mr, msgid, handler.RespId, msg, t, checkMsgDone, cancel)
+		info.Resp = reply.resp()
+	default:
+		defer cancel()
+	}
+
+	called := false // 是否调用了处理函数
+	release, err := md.limiter.acquire(conf, msgid, t)
+	if err != nil {
+		md.log(ctx, handler, mr, t, int(zerolog.DebugLevel), "MsgDispatch Limit, "+err.Error())
+		md.callLimitHook(ctx, info, err)
+	} else {
+		defer release()
+		err = md.intercept(ctx, info, md.invoke(info, reply, &called))
+		if err != nil {
+			if called {
+				md.log(ctx, handler, mr, t, errorLevel(err), "MsgDispatch Error, "+err.Error())
+			} else {
+				md.log(ctx, handler, mr, t, int(zerolog.WarnLevel), "MsgDispatch Intercept, "+err.Error())
+			}
 		}
+	}
+	if err != nil {
+		md.callErrHook(ctx, mr, err)
+	}
 
-	case RegType_Msg4:
-		handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(t)})
-		if checkMsgDone != nil {
-			close(checkMsgDone)
+	if reply != nil {
+		if (!called || err != nil) && !reply.replied() {
+			reply.replyWith(md.errorResp(ctx, info, err)) // 被拦截、限流或者返回错误 直接回复，已经回复过的忽略
 		}
+		return nil // 不返回，外层调用reply的Reply方法
+	}
+	if checkMsgDone != nil {
+		close(checkMsgDone)
+	}
+	if handler.RegType != RegType_ReqResp4 && handler.RegType != RegType_ReqResp5 {
+		return nil // 普通消息没有回复
+	}
+	if !called || err != nil {
+		return md.errorResp(ctx, info, err) // 被拦截、限流或者返回错误的ReqResp也回复
+	}
+	return info.Resp
+}
 
-	case RegType_ReqResp4, RegType_ReqResp5:
-		resp := reflect.New(handler.RespType).Interface()
-		if handler.RegType == RegType_ReqResp4 {
-			handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(resp), reflect.ValueOf(t)})
-		} else {
-			handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(resp), reflect.ValueOf(t)})
+// 处理函数超时的ctx 没有配置时不修改ctx
+func withMsgTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// 调用处理函数 拦截器链的最后一环
+func (md *MsgDispatch) invoke(info *CallInfo, reply ReplyResper, called *bool) func(ctx context.Context) error {
+	handler, mr, msg, t := info.Handler, info.Mr, info.Msg, info.T
+	return func(ctx context.Context) error {
+		*called = true
+		var rets []reflect.Value
+		switch handler.RegType {
+		case RegType_Msg3:
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(t)})
+		case RegType_Msg4:
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(t)})
+		case RegType_ReqResp4:
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(info.Resp), reflect.ValueOf(t)})
+		case RegType_ReqResp5:
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(info.Resp), reflect.ValueOf(t)})
+		case RegType_ReqReply4:
+			reply.setCtx(ctx) // 拦截器可能修改了ctx
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(reply), reflect.ValueOf(t)})
+		case RegType_ReqReply5:
+			reply.setCtx(ctx)
+			rets = handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(reply), reflect.ValueOf(t)})
 		}
-		if checkMsgDone != nil {
-			close(checkMsgDone)
+		if handler.RetErr && len(rets) == 1 && !rets[0].IsNil() {
+			return rets[0].Interface().(error)
 		}
-		return resp
+		return nil
+	}
+}
 
-	case RegType_ReqReply4, RegType_ReqReply5:
-		reply := reflect.New(handler.RespType).Interface()
-		// 调用 create(ctx, md)
-		reply.(ReplyResper).create(md, ctx, mr, msgid, handler.RespId, msg, t, checkMsgDone)
-		if handler.RegType == RegType_ReqReply4 {
-			handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), reflect.ValueOf(reply), reflect.ValueOf(t)})
-		} else {
-			handler.FunValue.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(mr), reflect.ValueOf(msg), reflect.ValueOf(reply), reflect.ValueOf(t)})
-		}
-		return nil // 不返回，外层调用reply的Reply方法
+// 注册消息超时回调 处理时间超过ParamConfig.MsgTimeout或者TimeOutCheck时调用
+func (md *MsgDispatch) RegTimeOutHook(f func(ctx context.Context, mr Msger)) {
+	md.timeOutHook = append(md.timeOutHook, f)
+}
+
+func (md *MsgDispatch) callTimeOutHook(ctx context.Context, mr Msger) {
+	defer utils.HandlePanic()
+	for _, f := range md.timeOutHook {
+		f(ctx, mr)
 	}
-	return nil
 }
 
 func (md *MsgDispatch) callhook(ctx context.Context, mr Msger, elapsed time.Duration) {
@@ -2,8 +2,13 @@ package msger
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +17,7 @@ import (
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type Client[T any] struct {
@@ -67,6 +73,40 @@ func (h *Server) onTestHeatBeatReply(ctx context.Context, req *utils.TestHeatBea
 	reply.Reply()
 }
 
+// 终端类型为接口 不同的连接对象共用处理函数
+type testTerminal interface {
+	SendMsg(msg interface{}) error
+}
+
+func TestInterfaceTerminal(t *testing.T) {
+	md, _ := NewMsgDispatch[utils.TestMsg, testTerminal]()
+	done := make(chan testTerminal, 1)
+	err := md.RegMsg(utils.TestHeatBeatReqMsg.MsgID(), func(ctx context.Context, m *utils.TestMsg, msg *utils.TestHeatBeatReq, c testTerminal) {
+		done <- c
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client[string]{name: "user"}
+	if ok, err := md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, ""); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	select {
+	case got := <-done:
+		if got != testTerminal(c) {
+			t.Fatal("terminal")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("handler not called")
+	}
+
+	// 没有实现接口的终端
+	if ok, err := md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, &struct{}{}, ""); ok || err == nil {
+		t.Fatal(ok, err)
+	}
+}
+
 func BenchmarkReg(b *testing.B) {
 	s := NewServer()
 
@@ -133,3 +173,418 @@ func BenchmarkRegReqRespTimeOut(b *testing.B) {
 
 	s.WaitAllMsgDone(time.Second * 30)
 }
+
+type ctxKey string
+
+func TestInterceptor(t *testing.T) {
+	md, _ := NewMsgDispatch[utils.TestMsg, Client[string]]()
+	var resps []interface{}
+	md.SendResp(func(ctx context.Context, m *utils.TestMsg, c *Client[string], respid string, resp interface{}) {
+		resps = append(resps, resp)
+	})
+	reqid := utils.TestHeatBeatReqMsg.MsgID()
+	respid := utils.TestHeatBeatRespMsg.MsgID()
+
+	var order []string
+	login := false
+	md.RegInterceptor(func(ctx context.Context, info *CallInfo, next func(ctx context.Context) error) error {
+		order = append(order, "global")
+		if info.T.(*Client[string]).name != "user" {
+			t.Fatal("terminal")
+		}
+		if !login {
+			// 未登录 使用Resp回复错误
+			if resp, ok := info.Resp.(*utils.TestHeatBeatResp); ok {
+				resp.Data = "not login"
+			}
+			return errors.New("not login")
+		}
+		return next(context.WithValue(ctx, ctxKey("uid"), 1))
+	})
+	md.RegMsgInterceptor(reqid, func(ctx context.Context, info *CallInfo, next func(ctx context.Context) error) error {
+		order = append(order, "msg")
+		if info.MsgID != reqid || info.Msg.(*utils.TestHeatBeatReq) == nil {
+			t.Fatal("info")
+		}
+		return next(ctx)
+	})
+	handler := func(ctx context.Context) {
+		order = append(order, "handler")
+		if ctx.Value(ctxKey("uid")) != 1 {
+			t.Fatal("ctx not enrich")
+		}
+	}
+	c := &Client[string]{name: "user"}
+	check := func(name string, wantOrder string, wantResp string) {
+		order = nil
+		resps = nil
+		if ok, err := md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, ""); !ok || err != nil {
+			t.Fatal(name, ok, err)
+		}
+		if got := strings.Join(order, ","); got != wantOrder {
+			t.Fatalf("%s order %s", name, got)
+		}
+		if wantResp == "" {
+			if len(resps) != 0 {
+				t.Fatalf("%s resp %v", name, resps)
+			}
+			return
+		}
+		if len(resps) != 1 || resps[0].(*utils.TestHeatBeatResp).Data != wantResp {
+			t.Fatalf("%s resp %v", name, resps)
+		}
+	}
+
+	md.RegMsg(reqid, func(ctx context.Context, msg *utils.TestHeatBeatReq, c *Client[string]) {
+		handler(ctx)
+	})
+	check("msg intercept", "global", "")
+	login = true
+	check("msg", "global,msg,handler", "")
+
+	md.handlers.Delete(reqid)
+	md.RegReqResp(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, resp *utils.TestHeatBeatResp, c *Client[string]) {
+		handler(ctx)
+		resp.Data = "ok"
+	})
+	login = false
+	check("reqresp intercept", "global", "not login")
+	login = true
+	check("reqresp", "global,msg,handler", "ok")
+
+	md.handlers.Delete(reqid)
+	md.RegReqReply(reqid, respid, func(ctx context.Context, m *utils.TestMsg, req *utils.TestHeatBeatReq, reply *ReplyResp[utils.TestHeatBeatResp], c *Client[string]) {
+		handler(ctx)
+		if reply.ctx.Value(ctxKey("uid")) != 1 {
+			t.Fatal("reply ctx")
+		}
+		reply.Resp.Data = "ok"
+		reply.Reply()
+	})
+	login = false
+	check("reqreply intercept", "global", "not login")
+	login = true
+	check("reqreply", "global,msg,handler", "ok")
+}
+
+func TestMsgLimit(t *testing.T) {
+	reqid := utils.TestHeatBeatReqMsg.MsgID()
+	respid := utils.TestHeatBeatRespMsg.MsgID()
+	ParamConf.Get().MsgLimit = map[string]*MsgLimitConfig{reqid: {Rate: 0.001, Burst: 2}}
+	ParamConf.Get().Normalize()
+	defer func() {
+		ParamConf.Get().MsgLimit = nil
+		ParamConf.Get().Normalize()
+	}()
+
+	md, _ := NewMsgDispatch[utils.TestMsg, Client[string]]()
+	var resps []string
+	var mu sync.Mutex
+	md.SendResp(func(ctx context.Context, m *utils.TestMsg, c *Client[string], respid string, resp interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		resps = append(resps, resp.(*utils.TestHeatBeatResp).Data)
+	})
+	var limitErr error
+	md.RegLimitHook(func(ctx context.Context, info *CallInfo, err error) {
+		limitErr = err
+		info.Resp.(*utils.TestHeatBeatResp).Data = "too frequent"
+	})
+	block := make(chan int)
+	md.RegReqResp(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, resp *utils.TestHeatBeatResp, c *Client[string]) {
+		resp.Data = "ok"
+		if c.name == "block" {
+			<-block
+		}
+	})
+
+	// 每个终端单独计算速率
+	c1, c2 := &Client[string]{}, &Client[string]{}
+	for i := 0; i < 3; i++ {
+		md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c1, "")
+	}
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c2, "")
+	if strings.Join(resps, ",") != "ok,ok,too frequent,ok" || limitErr != ErrLimitRate {
+		t.Fatal(resps, limitErr)
+	}
+
+	// 热更新为通配符的并发限制
+	ParamConf.Get().MsgLimit = map[string]*MsgLimitConfig{"*": {MaxConcurrent: 1}}
+	ParamConf.Get().Normalize()
+	resps = nil
+	done := make(chan int)
+	go func() {
+		md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, &Client[string]{name: "block"}, "")
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+		md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c1, "")
+		if limitErr == ErrLimitConcurrent {
+			break
+		}
+	}
+	close(block)
+	<-done
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c1, "")
+	mu.Lock()
+	defer mu.Unlock()
+	if limitErr != ErrLimitConcurrent || resps[len(resps)-1] != "ok" {
+		t.Fatal(resps, limitErr)
+	}
+}
+
+type codeResp struct {
+	Code int
+	Msg  string
+}
+
+func (r *codeResp) SetError(code int, msg string) {
+	r.Code = code
+	r.Msg = msg
+}
+
+func TestHandlerError(t *testing.T) {
+	md, _ := NewMsgDispatch[utils.TestMsg, Client[string]]()
+	var resps []interface{}
+	md.SendResp(func(ctx context.Context, m *utils.TestMsg, c *Client[string], respid string, resp interface{}) {
+		resps = append(resps, resp)
+	})
+	var errs []error
+	md.RegErrHook(func(ctx context.Context, mr Msger, err error) {
+		errs = append(errs, err)
+	})
+	reqid := utils.TestHeatBeatReqMsg.MsgID()
+	respid := utils.TestHeatBeatRespMsg.MsgID()
+	c := &Client[string]{}
+
+	// 返回值不对的注册失败
+	if err := md.RegMsg(reqid, func(ctx context.Context, msg *utils.TestHeatBeatReq, c *Client[string]) int { return 0 }); err == nil {
+		t.Fatal("reg ret int")
+	}
+
+	md.RegMsg(reqid, func(ctx context.Context, msg *utils.TestHeatBeatReq, c *Client[string]) error {
+		return errors.New("msg error")
+	})
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	if len(errs) != 1 || errs[0].Error() != "msg error" || len(resps) != 0 {
+		t.Fatal(errs, resps)
+	}
+
+	// 业务错误码带到回复中
+	md.handlers.Delete(reqid)
+	md.RegReqResp(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, resp *codeResp, c *Client[string]) error {
+		return fmt.Errorf("wrap: %w", NewCodeError(100, "no money"))
+	})
+	resps = nil
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	if len(resps) != 1 || *resps[0].(*codeResp) != (codeResp{Code: 100, Msg: "no money"}) {
+		t.Fatal(resps)
+	}
+	if code, ok := ErrorCode(errs[len(errs)-1]); !ok || code != 100 {
+		t.Fatal(errs)
+	}
+
+	// ReqReply返回错误时没有回复的自动回复，已经回复的不重复回复
+	md.handlers.Delete(reqid)
+	replied := false
+	md.RegReqReply(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, reply *ReplyResp[codeResp], c *Client[string]) error {
+		if replied {
+			reply.Reply()
+		}
+		return NewCodeError(200, "busy")
+	})
+	resps = nil
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	replied = true
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	if len(resps) != 2 || resps[0].(*codeResp).Code != 200 || resps[1].(*codeResp).Code != 0 {
+		t.Fatal(resps)
+	}
+
+	// 自定义错误回复
+	md.ErrorResp(func(ctx context.Context, info *CallInfo, err error) interface{} {
+		if code, ok := ErrorCode(err); ok {
+			return &utils.TestHeatBeatResp{Data: strconv.Itoa(code)}
+		}
+		return nil
+	})
+	replied = false
+	resps = nil
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	if len(resps) != 1 || resps[0].(*utils.TestHeatBeatResp).Data != "200" {
+		t.Fatal(resps)
+	}
+
+	// 普通消息返回错误不调用错误回复
+	md.handlers.Delete(reqid)
+	md.RegMsg(reqid, func(ctx context.Context, msg *utils.TestHeatBeatReq, c *Client[string]) error {
+		return NewCodeError(300, "msg error")
+	})
+	resps = nil
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	if len(resps) != 0 {
+		t.Fatal(resps)
+	}
+}
+
+func TestMsgTimeout(t *testing.T) {
+	reqid := utils.TestHeatBeatReqMsg.MsgID()
+	respid := utils.TestHeatBeatRespMsg.MsgID()
+	ParamConf.Get().MsgTimeout = map[string]int{reqid: 50}
+	defer func() {
+		ParamConf.Get().MsgTimeout = nil
+	}()
+
+	md, _ := NewMsgDispatch[utils.TestMsg, Client[string]]()
+	var resps []interface{}
+	var mu sync.Mutex
+	md.SendResp(func(ctx context.Context, m *utils.TestMsg, c *Client[string], respid string, resp interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		resps = append(resps, resp)
+	})
+	timeouts := make(chan int, 10)
+	md.RegTimeOutHook(func(ctx context.Context, mr Msger) {
+		timeouts <- 1
+	})
+	c := &Client[string]{}
+
+	// 处理函数的ctx带有超时时间
+	md.RegReqResp(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, resp *utils.TestHeatBeatResp, c *Client[string]) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatal("no deadline")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	<-timeouts
+
+	// 超时后回复无效
+	md.handlers.Delete(reqid)
+	replied := make(chan int)
+	md.RegReqReply(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, reply *ReplyResp[utils.TestHeatBeatResp], c *Client[string]) {
+		go func() {
+			<-ctx.Done()
+			reply.Reply()
+			close(replied)
+		}()
+	})
+	mu.Lock()
+	resps = nil
+	mu.Unlock()
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	<-replied
+	<-timeouts
+	mu.Lock()
+	if len(resps) != 0 {
+		t.Fatal(resps)
+	}
+	mu.Unlock()
+
+	// 处理函数返回后取消ctx
+	md.handlers.Delete(reqid)
+	ctxs := make(chan context.Context, 1)
+	md.RegReqResp(reqid, respid, func(ctx context.Context, req *utils.TestHeatBeatReq, resp *utils.TestHeatBeatResp, c *Client[string]) {
+		ctxs <- ctx
+	})
+	md.Dispatch(context.TODO(), utils.TestHeatBeatReqMsg, c, "")
+	ctx := <-ctxs
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Millisecond * 30):
+		t.Fatal("ctx not canceled")
+	}
+}
+
+type envelopeClient struct {
+	sent []Msger
+}
+
+func (c *envelopeClient) SendMsg(ctx context.Context, msg Msger) error {
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func TestEnvelope(t *testing.T) {
+	type body struct {
+		Name string `json:"name"`
+		Num  int    `json:"num"`
+	}
+	// 各种编码 多个消息粘包
+	var data []byte
+	for _, codec := range []uint8{CodecJSON, CodecMsgpack} {
+		e := NewRPCEnvelope("login", codec, 100, &body{Name: GetCodec(codec).Name(), Num: int(codec)})
+		e.Flags |= FlagGroup
+		e.GroupID = 7
+		e.TraceID = 99
+		buf, err := e.MsgMarshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf...)
+	}
+	pb, err := NewEnvelope("pb", CodecProto, wrapperspb.String("proto")).MsgMarshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, pb...)
+
+	// 数据不完整
+	if mr, l, err := DecodeMsg(context.TODO(), data[:10], 0); mr != nil || l != 0 || err != nil {
+		t.Fatal(mr, l, err)
+	}
+	for _, codec := range []uint8{CodecJSON, CodecMsgpack} {
+		mr, l, err := DecodeMsg(context.TODO(), data, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = data[l:]
+		var b body
+		if err := mr.BodyUnMarshal(&b); err != nil || b.Name != GetCodec(codec).Name() || b.Num != int(codec) {
+			t.Fatal(b, err)
+		}
+		if mr.MsgID() != "login" || mr.RPCId() != nil || mr.GroupId() != uint64(7) || mr.TraceId() != 99 {
+			t.Fatal(mr)
+		}
+	}
+	mr, l, err := DecodeMsg(context.TODO(), data, 0)
+	if err != nil || l != len(data) {
+		t.Fatal(l, err)
+	}
+	var s wrapperspb.StringValue
+	if err := mr.BodyUnMarshal(&s); err != nil || s.Value != "proto" {
+		t.Fatal(err)
+	}
+
+	// 长度超过限制
+	bad := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bad, uint32(EnvelopeMaxLen+1))
+	if _, _, err := DecodeMsg(context.TODO(), bad, 0); err == nil {
+		t.Fatal("max len")
+	}
+
+	// 分发和回复
+	md, _ := NewMsgDispatch[Envelope, envelopeClient]()
+	md.SendResp(SendResp[*envelopeClient])
+	md.RegReqResp("login", "loginresp", func(ctx context.Context, req *body, resp *body, c *envelopeClient) {
+		resp.Name = req.Name
+	})
+	req, _ := NewRPCEnvelope("login", CodecJSON, 101, &body{Name: "user"}).MsgMarshal()
+	mr, _, _ = DecodeMsg(context.TODO(), req, 0)
+	c := &envelopeClient{}
+	if ok, err := md.Dispatch(context.TODO(), mr, c, ""); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if len(c.sent) != 1 {
+		t.Fatal(c.sent)
+	}
+	buf, _ := c.sent[0].MsgMarshal()
+	mr, _, _ = DecodeMsg(context.TODO(), buf, 0)
+	var b body
+	mr.BodyUnMarshal(&b)
+	if mr.MsgID() != "loginresp" || mr.RPCId() != uint64(101) || b.Name != "user" {
+		t.Fatal(mr, b)
+	}
+}
@@ -0,0 +1,209 @@
+package msger
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// Envelope的标记
+const (
+	FlagRequest  uint16 = 1 << iota // RPC请求 回复时带上相同的RPCID
+	FlagResponse                    // RPC回复 RPCId()返回RPCID
+	FlagGroup                       // GroupId()返回GroupID 否则不分组
+)
+
+// Envelope编码格式 小端
+// | Len uint32 | HeadLen uint16 | Flags uint16 | Codec uint8 | MsgIDLen uint8 | MsgID | RPCID uint64 | GroupID uint64 | TraceID int64 | Body |
+// Len为后面所有数据的长度，HeadLen为头后面的长度，解析时跳过不认识的头数据，方便后续扩展
+const (
+	envelopeLenSize  = 4
+	envelopeHeadSize = 2 + 2 + 1 + 1 + 8 + 8 + 8 // 头的固定长度 包含HeadLen，不包含MsgID
+)
+
+// 消息的最大长度 超过时DecodeMsg返回错误
+var EnvelopeMaxLen = 16 * 1024 * 1024
+
+type EnvelopeHead struct {
+	Flags   uint16 `json:"flags,omitempty"`
+	Codec   uint8  `json:"codec,omitempty"`
+	Msgid   string `json:"msgid,omitempty"`
+	RPCID   uint64 `json:"rpcid,omitempty"`
+	GroupID uint64 `json:"groupid,omitempty"`
+	TraceID int64  `json:"traceid,omitempty"`
+}
+
+// Envelope 通用的消息格式 实现了RecvMsger
+type Envelope struct {
+	EnvelopeHead
+	// 根据方向不一样，填充的字段不一样
+	Body     interface{} // 发送时填充 *具体消息，使用Codec编码
+	BodyData []byte      // 接收时填充 发送时Body为nil会直接发送BodyData，用来转发
+}
+
+func NewEnvelope(msgid string, codec uint8, body interface{}) *Envelope {
+	return &Envelope{
+		EnvelopeHead: EnvelopeHead{Codec: codec, Msgid: msgid},
+		Body:         body,
+	}
+}
+
+// RPC请求 rpcId需要调用方保证唯一，和SendRPCMsg的rpcId一致
+func NewRPCEnvelope(msgid string, codec uint8, rpcId uint64, body interface{}) *Envelope {
+	return &Envelope{
+		EnvelopeHead: EnvelopeHead{Flags: FlagRequest, Codec: codec, Msgid: msgid, RPCID: rpcId},
+		Body:         body,
+	}
+}
+
+// 生成回复消息 使用请求的编码，带上请求的RPCID、GroupID、TraceID
+func (e *Envelope) Resp(respid string, body interface{}) *Envelope {
+	resp := &Envelope{
+		EnvelopeHead: EnvelopeHead{Flags: e.Flags & FlagGroup, Codec: e.Codec, Msgid: respid, GroupID: e.GroupID, TraceID: e.TraceID},
+		Body:         body,
+	}
+	if e.Flags&FlagRequest != 0 {
+		resp.Flags |= FlagResponse
+		resp.RPCID = e.RPCID
+	}
+	return resp
+}
+
+func (e *Envelope) MsgID() string {
+	return e.Msgid
+}
+
+func (e *Envelope) MsgMarshal() ([]byte, error) {
+	if len(e.Msgid) > 255 {
+		return nil, fmt.Errorf("msgid %s too long", e.Msgid)
+	}
+	body := e.BodyData
+	if e.Body != nil {
+		c := codecs[e.Codec]
+		if c == nil {
+			return nil, fmt.Errorf("codec %d not registered", e.Codec)
+		}
+		var err error
+		body, err = c.Marshal(e.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	headLen := envelopeHeadSize - 2 + len(e.Msgid)
+	l := 2 + headLen + len(body)
+	if l > EnvelopeMaxLen {
+		return nil, fmt.Errorf("msg len %d too long", l)
+	}
+	data := make([]byte, envelopeLenSize+l)
+	binary.LittleEndian.PutUint32(data, uint32(l))
+	binary.LittleEndian.PutUint16(data[4:], uint16(headLen))
+	binary.LittleEndian.PutUint16(data[6:], e.Flags)
+	data[8] = e.Codec
+	data[9] = uint8(len(e.Msgid))
+	pos := 10 + copy(data[10:], e.Msgid)
+	binary.LittleEndian.PutUint64(data[pos:], e.RPCID)
+	binary.LittleEndian.PutUint64(data[pos+8:], e.GroupID)
+	binary.LittleEndian.PutUint64(data[pos+16:], uint64(e.TraceID))
+	copy(data[pos+24:], body)
+	return data, nil
+}
+
+func (e *Envelope) RPCId() interface{} {
+	if e.Flags&FlagResponse != 0 {
+		return e.RPCID
+	}
+	return nil
+}
+
+func (e *Envelope) GroupId() interface{} {
+	if e.Flags&FlagGroup != 0 {
+		return e.GroupID
+	}
+	return nil
+}
+
+func (e *Envelope) TraceId() int64 {
+	return e.TraceID
+}
+
+func (e *Envelope) BodyUnMarshal(dst interface{}) error {
+	c := codecs[e.Codec]
+	if c == nil {
+		return fmt.Errorf("codec %d not registered", e.Codec)
+	}
+	return c.Unmarshal(e.BodyData, dst)
+}
+
+func (e *Envelope) MarshalZerologObject(ev *zerolog.Event) {
+	ev.Interface("Head", &e.EnvelopeHead)
+	if e.Body != nil {
+		ev.Interface("Body", e.Body)
+	}
+	if e.BodyData != nil {
+		ev.Int("BodyLen", len(e.BodyData))
+	}
+}
+
+// 根据二进制解码出Envelope 数据不完整时返回nil,0,nil
+func DecodeEnvelope(data []byte) (*Envelope, int, error) {
+	if len(data) < envelopeLenSize {
+		return nil, 0, nil
+	}
+	l := int(binary.LittleEndian.Uint32(data))
+	if l > EnvelopeMaxLen {
+		return nil, 0, fmt.Errorf("msg len %d too long", l)
+	}
+	if len(data) < envelopeLenSize+l {
+		return nil, 0, nil
+	}
+	data = data[envelopeLenSize : envelopeLenSize+l]
+	if l < envelopeHeadSize {
+		return nil, 0, errors.New("msg format error")
+	}
+	headLen := int(binary.LittleEndian.Uint16(data))
+	msgidLen := int(data[5])
+	if headLen+2 > l || headLen < envelopeHeadSize-2+msgidLen {
+		return nil, 0, errors.New("msg head format error")
+	}
+	e := &Envelope{}
+	e.Flags = binary.LittleEndian.Uint16(data[2:])
+	e.Codec = data[4]
+	e.Msgid = string(data[6 : 6+msgidLen])
+	pos := 6 + msgidLen
+	e.RPCID = binary.LittleEndian.Uint64(data[pos:])
+	e.GroupID = binary.LittleEndian.Uint64(data[pos+8:])
+	e.TraceID = int64(binary.LittleEndian.Uint64(data[pos+16:]))
+	// 底层的读缓存会复用 拷贝一份
+	e.BodyData = append([]byte{}, data[2+headLen:]...)
+	return e, envelopeLenSize + l, nil
+}
+
+// 可以直接作为tcpserver、gnetserver、backend的Event.DecodeMsg实现，解码Envelope
+//
+//	func (h *Handler) DecodeMsg(ctx context.Context, data []byte, tc *tcpserver.TCPClient[ClientInfo]) (msger.RecvMsger, int, error) {
+//		return msger.DecodeMsg(ctx, data, tc)
+//	}
+func DecodeMsg[T any](ctx context.Context, data []byte, t T) (RecvMsger, int, error) {
+	e, l, err := DecodeEnvelope(data)
+	if e == nil {
+		return nil, l, err // 不能返回nil的*Envelope
+	}
+	return e, l, err
+}
+
+// 发送消息的终端 TCPClient、GNetClient、TcpService都实现了
+type MsgSender interface {
+	SendMsg(ctx context.Context, msg Msger) error
+}
+
+// 可以直接作为MsgDispatch.SendResp的参数，使用Envelope发送回复
+// md, _ := msger.NewMsgDispatch[msger.Envelope, tcpserver.TCPClient[ClientInfo]]()
+// md.SendResp(msger.SendResp[*tcpserver.TCPClient[ClientInfo]])
+func SendResp[T MsgSender](ctx context.Context, req *Envelope, t T, respid string, resp interface{}) {
+	t.SendMsg(ctx, req.Resp(respid, resp))
+}
@@ -0,0 +1,102 @@
+package msger
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/rs/zerolog"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// CodeError 带错误码的业务错误 处理函数返回后错误码会带到回复中
+type CodeError struct {
+	Code int
+	Msg  string
+}
+
+func NewCodeError(code int, msg string) *CodeError {
+	return &CodeError{Code: code, Msg: msg}
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("code:%d %s", e.Code, e.Msg)
+}
+
+// 获取错误中的错误码 不是CodeError时返回false
+func ErrorCode(err error) (int, bool) {
+	var ce *CodeError
+	if errors.As(err, &ce) {
+		return ce.Code, true
+	}
+	return 0, false
+}
+
+// 回复消息可选择实现的接口 没有设置ErrorResp时，返回CodeError会调用该接口填充错误码
+type ErrorResper interface {
+	SetError(code int, msg string)
+}
+
+// 生成错误回复 处理函数或者拦截器返回错误、被限流时，RPC类消息(RegReqResp、RegReqReply注册的)调用
+// info.Resp为处理函数的回复消息，可以直接填充后返回，也可以返回其他消息，返回的消息使用SendResp发送，返回nil表示不回复
+type ErrorResponder func(ctx context.Context, info *CallInfo, err error) interface{}
+
+// 设置错误回复 和SendResp一样要求分发消息前设置好
+func (md *MsgDispatch) ErrorResp(f ErrorResponder) {
+	md.errorResponder = f
+}
+
+// 注册错误回调 处理函数或者拦截器返回错误、被限流时调用，不使用锁，要求在分发消息前注册好
+func (md *MsgDispatch) RegErrHook(f func(ctx context.Context, mr Msger, err error)) {
+	md.errHook = append(md.errHook, f)
+}
+
+func (md *MsgDispatch) callErrHook(ctx context.Context, mr Msger, err error) {
+	defer utils.HandlePanic()
+	for _, f := range md.errHook {
+		f(ctx, mr, err)
+	}
+}
+
+// 生成RPC类消息的回复
+func (md *MsgDispatch) errorResp(ctx context.Context, info *CallInfo, err error) (resp interface{}) {
+	if err == nil {
+		return info.Resp
+	}
+	if md.errorResponder != nil {
+		defer utils.HandlePanic()
+		return md.errorResponder(ctx, info, err)
+	}
+	var ce *CodeError
+	if errors.As(err, &ce) {
+		if er, ok := info.Resp.(ErrorResper); ok {
+			er.SetError(ce.Code, ce.Msg)
+		}
+	}
+	return info.Resp
+}
+
+// 处理函数返回错误的日志级别 业务错误使用Warn 其他使用Error
+func errorLevel(err error) int {
+	if _, ok := ErrorCode(err); ok {
+		return int(zerolog.WarnLevel)
+	}
+	return int(zerolog.ErrorLevel)
+}
+
+// 处理函数的返回值 没有返回值或者返回error
+func funcRetErr(funType reflect.Type) (bool, error) {
+	if funType.NumOut() == 0 {
+		return false, nil
+	}
+	if funType.NumOut() == 1 && funType.Out(0) == errorType {
+		return true, nil
+	}
+	return false, errors.New("the return must be error or nothing")
+}
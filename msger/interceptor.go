@@ -0,0 +1,62 @@
+package msger
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+)
+
+// 拦截器调用时的消息信息
+type CallInfo struct {
+	MsgID   string      // 消息ID
+	Mr      Msger       // 原始消息
+	Msg     interface{} // 解析后的消息 *具体消息
+	T       interface{} // 终端对象 Dispatch传入的t
+	Handler *MsgHandler // 处理函数 外层不得修改
+	// 回复消息 *具体消息 RegReqResp、RegReqReply注册的消息不为nil
+	// 拦截器不调用next时，使用Resp回复，可以先填充错误码等信息
+	Resp interface{}
+}
+
+// 消息拦截器 包裹处理函数的调用
+// next调用后续的拦截器和处理函数，传入的ctx会透传下去，可以用来附加信息
+// 不调用next表示拦截，返回的error会输出日志
+// 调用顺序：全局拦截器按注册顺序，然后是消息的拦截器按注册顺序，最后是处理函数
+type Interceptor func(ctx context.Context, info *CallInfo, next func(ctx context.Context) error) error
+
+// 注册全局拦截器 不使用锁，要求在分发消息前注册好
+func (md *MsgDispatch) RegInterceptor(f Interceptor) {
+	md.interceptor = append(md.interceptor, f)
+}
+
+// 注册消息的拦截器 在全局拦截器之后调用，不使用锁，要求在分发消息前注册好
+func (md *MsgDispatch) RegMsgInterceptor(msgid string, f Interceptor) {
+	if md.msgInterceptor == nil {
+		md.msgInterceptor = map[string][]Interceptor{}
+	}
+	md.msgInterceptor[msgid] = append(md.msgInterceptor[msgid], f)
+}
+
+// 按顺序调用拦截器 最后调用call
+func (md *MsgDispatch) intercept(ctx context.Context, info *CallInfo, call func(ctx context.Context) error) error {
+	global := md.interceptor
+	local := md.msgInterceptor[info.MsgID]
+	if len(global) == 0 && len(local) == 0 {
+		return call(ctx)
+	}
+	var next func(ctx context.Context, i int) error
+	next = func(ctx context.Context, i int) error {
+		var f Interceptor
+		if i < len(global) {
+			f = global[i]
+		} else if i-len(global) < len(local) {
+			f = local[i-len(global)]
+		} else {
+			return call(ctx)
+		}
+		return f(ctx, info, func(ctx context.Context) error {
+			return next(ctx, i+1)
+		})
+	}
+	return next(ctx, 0)
+}
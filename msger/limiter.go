@@ -0,0 +1,145 @@
+package msger
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuwf/gobase/utils"
+)
+
+// 消息被限流的原因
+var (
+	ErrLimitRate       = errors.New("msg limit rate")       // 超过终端的消息速率
+	ErrLimitConcurrent = errors.New("msg limit concurrent") // 超过同时处理的数量
+)
+
+// 消息限流配置 每次分发消息时读取，支持热更新
+type MsgLimitConfig struct {
+	Rate          float64 `json:"rate,omitempty"`          // 每个终端每秒的消息数 <=0表示不限制
+	Burst         int     `json:"burst,omitempty"`         // 每个终端的突发数 <=0表示使用Rate
+	MaxConcurrent int     `json:"maxconcurrent,omitempty"` // 所有终端同时处理的最大数量 处理函数返回前都算在处理中 <=0表示不限制
+}
+
+// 生成通配符的匹配顺序 Normalize时调用
+func msgLimitPatterns(limits map[string]*MsgLimitConfig) []string {
+	var patterns []string
+	for msgid := range limits {
+		if strings.ContainsAny(msgid, "?*") {
+			patterns = append(patterns, msgid)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// 获取消息的限流配置 精确匹配优先，然后按字典序匹配通配符 返回匹配的key
+func (c *ParamConfig) msgLimit(msgid string) (string, *MsgLimitConfig) {
+	if len(c.MsgLimit) == 0 {
+		return "", nil
+	}
+	if limit, ok := c.MsgLimit[msgid]; ok {
+		return msgid, limit
+	}
+	for _, pattern := range c.msgLimitPatterns {
+		if utils.IsMatch(pattern, msgid) {
+			return pattern, c.MsgLimit[pattern]
+		}
+	}
+	return "", nil
+}
+
+// 限流状态 匹配同一个配置的消息共用令牌桶和并发数
+type msgLimiter struct {
+	mu         sync.Mutex
+	buckets    map[msgLimitKey]*utils.TokenBucket // 每个终端的速率
+	running    map[string]int                     // [配置key:正在处理的数量]
+	bucketTime time.Time                          // 上次清理buckets的时间
+}
+
+type msgLimitKey struct {
+	t   interface{}
+	key string
+}
+
+// 检查是否限流 通过时返回的release不为nil，处理完后调用
+func (l *msgLimiter) acquire(conf *ParamConfig, msgid string, t interface{}) (func(), error) {
+	key, limit := conf.msgLimit(msgid)
+	if limit == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	// 终端类型不能作为map的key时不限制速率
+	if limit.Rate > 0 && t != nil && reflect.TypeOf(t).Comparable() {
+		if l.buckets == nil {
+			l.buckets = map[msgLimitKey]*utils.TokenBucket{}
+			l.bucketTime = now
+		}
+		bk := msgLimitKey{t: t, key: key}
+		b := l.buckets[bk]
+		if b == nil {
+			b = utils.NewTokenBucket(limit.Rate, limit.Burst)
+			l.buckets[bk] = b
+		} else if rate, burst := b.Limit(); rate != limit.Rate || (limit.Burst > 0 && burst != limit.Burst) {
+			b.SetLimit(limit.Rate, limit.Burst)
+		}
+		if !b.AllowN(now, 1) {
+			return nil, ErrLimitRate
+		}
+	}
+	l.clean(now)
+	if limit.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	if l.running == nil {
+		l.running = map[string]int{}
+	}
+	if l.running[key] >= limit.MaxConcurrent {
+		return nil, ErrLimitConcurrent
+	}
+	l.running[key]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if n := l.running[key]; n <= 1 {
+			delete(l.running, key)
+		} else {
+			l.running[key] = n - 1
+		}
+	}, nil
+}
+
+// 每分钟清理一次已经满了的令牌桶 桶满了和新建的没有区别，同时释放断开的终端对象
+func (l *msgLimiter) clean(now time.Time) {
+	if now.Sub(l.bucketTime) < time.Minute {
+		return
+	}
+	l.bucketTime = now
+	for k, b := range l.buckets {
+		if b.Full(now) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// 注册限流回调 不使用锁，要求在分发消息前注册好
+// err为ErrLimitRate或ErrLimitConcurrent，RPC类消息回调后使用info.Resp回复，可以在回调中填充错误码
+func (md *MsgDispatch) RegLimitHook(f func(ctx context.Context, info *CallInfo, err error)) {
+	md.limitHook = append(md.limitHook, f)
+}
+
+func (md *MsgDispatch) callLimitHook(ctx context.Context, info *CallInfo, err error) {
+	defer utils.HandlePanic()
+	for _, f := range md.limitHook {
+		f(ctx, info, err)
+	}
+}
@@ -3,6 +3,8 @@ package msger
 // https://github.com/yuwf/gobase
 
 import (
+	"time"
+
 	"github.com/yuwf/gobase/loader"
 
 	"github.com/afex/hystrix-go/hystrix"
@@ -15,12 +17,20 @@ type ParamConfig struct {
 	LogMaxLimit  int      `json:"logmaxlimit,omitempty"`  // 日志限制 <=0 表示不限制
 
 	TimeOutCheck int `json:"timeoutcheck,omitempty"` // 消息超时监控 单位秒 默认0不开启监控
+	// 处理函数超时 [msgid:毫秒] 处理函数的ctx设置超时时间，超时后ctx取消，ReplyResp超时后Reply不再回复
+	// 配置了的消息使用这个时间做超时监控，代替TimeOutCheck
+	MsgTimeout map[string]int `json:"msgtimeout,omitempty"`
+
 	// Timeout: 执行 command 的超时时间 单位为毫秒
 	// MaxConcurrentRequests: 最大并发量
 	// RequestVolumeThreshold: 一个统计窗口 10 秒内请求数量 达到这个请求数量后才去判断是否要开启熔断
 	// SleepWindow: 熔断器被打开后 SleepWindow的时间就是控制过多久后去尝试服务是否可用了 单位为毫秒
 	// ErrorPercentThreshold: 错误百分比 请求数量大于等于 RequestVolumeThreshold 并且错误率到达这个百分比后就会启动熔断
 	HystrixMsg map[string]*hystrix.CommandConfig `json:"hystrixmsg,omitempty"` // 熔断器 [msid:Config]，目前不支持动态删除
+
+	// 消息限流 [msgid:Config] msgid支持?*通配符，精确匹配优先，通配符按字典序匹配第一个，匹配同一个配置的消息共用限流
+	MsgLimit         map[string]*MsgLimitConfig `json:"msglimit,omitempty"`
+	msgLimitPatterns []string                   // Normalize时生成
 }
 
 var ParamConf loader.JsonLoader[ParamConfig]
@@ -34,6 +44,7 @@ func (c *ParamConfig) Normalize() {
 		c.HystrixMsg[msgid] = config
 		hystrix.ConfigureCommand("msg_"+msgid, *config) // 加个msg_前缀，区别其他模块使用
 	}
+	c.msgLimitPatterns = msgLimitPatterns(c.MsgLimit)
 }
 
 func (c *ParamConfig) IsHystrixMsg(msgid string) (string, bool) {
@@ -43,3 +54,8 @@ func (c *ParamConfig) IsHystrixMsg(msgid string) (string, bool) {
 	}
 	return "", false
 }
+
+// 处理函数的超时时间 没有配置返回0
+func (c *ParamConfig) msgTimeout(msgid string) time.Duration {
+	return time.Duration(c.MsgTimeout[msgid]) * time.Millisecond
+}
@@ -4,6 +4,7 @@ package msger
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sync/atomic"
 	"time"
@@ -14,8 +15,13 @@ import (
 )
 
 type ReplyResper interface {
+	Reply()
+	replyWith(resp interface{})
+	replied() bool
 	respType() reflect.Type
-	create(md *MsgDispatch, ctx context.Context, mr Msger, reqid, respid string, msg interface{}, t interface{}, checkMsgDone chan int)
+	create(md *MsgDispatch, ctx context.Context, mr Msger, reqid, respid string, msg interface{}, t interface{}, checkMsgDone chan int, cancel context.CancelFunc)
+	resp() interface{}
+	setCtx(ctx context.Context)
 }
 
 type ReplyResp[Resp any] struct {
@@ -29,6 +35,7 @@ type ReplyResp[Resp any] struct {
 	mr           Msger
 	t            interface{}
 	checkMsgDone chan int
+	cancel       context.CancelFunc // 取消处理函数超时的ctx
 
 	entry time.Time // 创建时间
 	reply int32     // 是否回复了 原子操作
@@ -38,17 +45,17 @@ type ReplyResp[Resp any] struct {
 }
 
 func (reply *ReplyResp[Resp]) Reply() {
+	reply.replyWith(reply.Resp)
+}
+
+// 使用指定的消息回复 resp为nil时不发送
+func (reply *ReplyResp[Resp]) replyWith(resp interface{}) {
 	if !atomic.CompareAndSwapInt32(&reply.reply, 0, 1) {
 		return
 	}
-
-	// 调用回复钩子函数
-	func() {
-		defer utils.HandlePanic()
-		for _, hook := range reply.replyHook {
-			hook(reply.ctx, reply)
-		}
-	}()
+	if reply.cancel != nil {
+		defer reply.cancel()
+	}
 
 	elapsed := time.Since(reply.entry)
 
@@ -58,10 +65,27 @@ func (reply *ReplyResp[Resp]) Reply() {
 		reply.checkMsgDone = nil
 	}
 
-	if reply.md.sendRespValue.IsValid() {
-		reply.md.sendRespValue.Call([]reflect.Value{reflect.ValueOf(reply.ctx), reflect.ValueOf(reply.mr), reflect.ValueOf(reply.t), reflect.ValueOf(reply.respId), reflect.ValueOf(reply.Resp)})
-	} else {
-		utils.LogCtx(log.Error(), reply.ctx).Str("ReqID", reply.reqid).Str("RespID", reply.respId).Interface("Resp", reply).Msg("MsgDispatch Dispatch SendResp is nil")
+	// 超过处理函数的超时时间 请求方已经放弃了，不再回复
+	if errors.Is(reply.ctx.Err(), context.DeadlineExceeded) {
+		utils.LogCtx(log.Warn(), reply.ctx).Str("ReqID", reply.reqid).Str("RespID", reply.respId).Dur("Elapsed", elapsed).Msg("MsgDispatch Reply after deadline")
+		reply.md.callhook(reply.ctx, reply.mr, elapsed)
+		return
+	}
+
+	// 调用回复钩子函数
+	func() {
+		defer utils.HandlePanic()
+		for _, hook := range reply.replyHook {
+			hook(reply.ctx, reply)
+		}
+	}()
+
+	if resp != nil {
+		if reply.md.sendRespValue.IsValid() {
+			reply.md.sendRespValue.Call([]reflect.Value{reflect.ValueOf(reply.ctx), reflect.ValueOf(reply.mr), reflect.ValueOf(reply.t), reflect.ValueOf(reply.respId), reflect.ValueOf(resp)})
+		} else {
+			utils.LogCtx(log.Error(), reply.ctx).Str("ReqID", reply.reqid).Str("RespID", reply.respId).Interface("Resp", reply).Msg("MsgDispatch Dispatch SendResp is nil")
+		}
 	}
 
 	reply.md.callhook(reply.ctx, reply.mr, elapsed)
@@ -72,11 +96,16 @@ func (reply *ReplyResp[Resp]) RegHook(hook func(ctx context.Context, reply *Repl
 	reply.replyHook = append(reply.replyHook, hook)
 }
 
+// 是否已经回复
+func (reply *ReplyResp[Resp]) replied() bool {
+	return atomic.LoadInt32(&reply.reply) != 0
+}
+
 func (reply *ReplyResp[Resp]) respType() reflect.Type {
 	return reflect.TypeOf((*Resp)(nil)).Elem()
 }
 
-func (reply *ReplyResp[Resp]) create(md *MsgDispatch, ctx context.Context, mr Msger, reqid, respid string, msg interface{}, t interface{}, checkMsgDone chan int) {
+func (reply *ReplyResp[Resp]) create(md *MsgDispatch, ctx context.Context, mr Msger, reqid, respid string, msg interface{}, t interface{}, checkMsgDone chan int, cancel context.CancelFunc) {
 	reply.Resp = new(Resp)
 	reply.md = md
 	reply.ctx = ctx
@@ -85,7 +114,17 @@ func (reply *ReplyResp[Resp]) create(md *MsgDispatch, ctx context.Context, mr Ms
 	reply.mr = mr
 	reply.t = t
 	reply.checkMsgDone = checkMsgDone
+	reply.cancel = cancel
 
 	reply.reply = 0
 	reply.entry = time.Now()
 }
+
+func (reply *ReplyResp[Resp]) resp() interface{} {
+	return reply.Resp
+}
+
+// 拦截器修改了ctx时 回复使用新的ctx
+func (reply *ReplyResp[Resp]) setCtx(ctx context.Context) {
+	reply.ctx = ctx
+}
@@ -0,0 +1,201 @@
+package netserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/yuwf/gobase/gnetserver"
+	"github.com/yuwf/gobase/msger"
+)
+
+// Event转换为GNetEvent
+type gnetEvent[ClientInfo any] struct {
+	event Event[ClientInfo]
+}
+
+// Event实现了PingEvent时使用，没有实现时websocket连接需要发送ping帧，所以单独定义
+type gnetPingEvent[ClientInfo any] struct {
+	*gnetEvent[ClientInfo]
+	ping PingEvent[ClientInfo]
+}
+
+func newGNetEvent[ClientInfo any](event Event[ClientInfo]) gnetserver.GNetEvent[ClientInfo] {
+	e := &gnetEvent[ClientInfo]{event: event}
+	if pe, ok := event.(PingEvent[ClientInfo]); ok {
+		return &gnetPingEvent[ClientInfo]{gnetEvent: e, ping: pe}
+	}
+	return e
+}
+
+func (e *gnetEvent[ClientInfo]) OnMsgReg(md *msger.MsgDispatch) {
+	e.event.OnMsgReg(md)
+}
+func (e *gnetEvent[ClientInfo]) OnConnected(ctx context.Context, gc *gnetserver.GNetClient[ClientInfo]) {
+	e.event.OnConnected(ctx, gc)
+}
+func (e *gnetEvent[ClientInfo]) OnDisConnect(ctx context.Context, gc *gnetserver.GNetClient[ClientInfo]) {
+	e.event.OnDisConnect(ctx, gc)
+}
+func (e *gnetEvent[ClientInfo]) DecodeMsg(ctx context.Context, data []byte, gc *gnetserver.GNetClient[ClientInfo]) (msger.RecvMsger, int, error) {
+	return e.event.DecodeMsg(ctx, data, gc)
+}
+func (e *gnetEvent[ClientInfo]) OnMsg(ctx context.Context, mr msger.RecvMsger, gc *gnetserver.GNetClient[ClientInfo]) {
+	e.event.OnMsg(ctx, mr, gc)
+}
+func (e *gnetEvent[ClientInfo]) OnTick(ctx context.Context, gc *gnetserver.GNetClient[ClientInfo]) {
+	e.event.OnTick(ctx, gc)
+}
+
+func (e *gnetPingEvent[ClientInfo]) OnPing(ctx context.Context, gc *gnetserver.GNetClient[ClientInfo]) {
+	e.ping.OnPing(ctx, gc)
+}
+
+// Hook转换为GNetHook
+type gnetHook[ClientInfo any] struct {
+	hook Hook[ClientInfo]
+}
+
+func (h *gnetHook[ClientInfo]) OnConnected(gc *gnetserver.GNetClient[ClientInfo]) {
+	h.hook.OnConnected(gc)
+}
+func (h *gnetHook[ClientInfo]) OnWSHandShake(gc *gnetserver.GNetClient[ClientInfo]) {
+	h.hook.OnWSHandShake(gc)
+}
+func (h *gnetHook[ClientInfo]) OnDisConnect(gc *gnetserver.GNetClient[ClientInfo], removeClient bool, closeReason error) {
+	h.hook.OnDisConnect(gc, removeClient, closeReason)
+}
+func (h *gnetHook[ClientInfo]) OnAddClient(gc *gnetserver.GNetClient[ClientInfo]) {
+	h.hook.OnAddClient(gc)
+}
+func (h *gnetHook[ClientInfo]) OnRemoveClient(gc *gnetserver.GNetClient[ClientInfo]) {
+	h.hook.OnRemoveClient(gc)
+}
+func (h *gnetHook[ClientInfo]) OnSendData(gc *gnetserver.GNetClient[ClientInfo], len int) {
+	h.hook.OnSendData(gc, len)
+}
+func (h *gnetHook[ClientInfo]) OnRecvData(gc *gnetserver.GNetClient[ClientInfo], len int) {
+	h.hook.OnRecvData(gc, len)
+}
+func (h *gnetHook[ClientInfo]) OnSend(gc *gnetserver.GNetClient[ClientInfo], len int) {
+	h.hook.OnSend(gc, len)
+}
+func (h *gnetHook[ClientInfo]) OnSendMsg(gc *gnetserver.GNetClient[ClientInfo], mr msger.Msger, len int) {
+	h.hook.OnSendMsg(gc, mr, len)
+}
+func (h *gnetHook[ClientInfo]) OnSendText(gc *gnetserver.GNetClient[ClientInfo], len int) {
+	h.hook.OnSendText(gc, len)
+}
+func (h *gnetHook[ClientInfo]) OnSendRPCMsg(gc *gnetserver.GNetClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int) {
+	h.hook.OnSendRPCMsg(gc, rpcId, mr, elapsed, len)
+}
+func (h *gnetHook[ClientInfo]) OnRecvMsg(gc *gnetserver.GNetClient[ClientInfo], mr msger.RecvMsger, len int) {
+	h.hook.OnRecvMsg(gc, mr, len)
+}
+func (h *gnetHook[ClientInfo]) OnReject(addr net.Addr, reason error) {
+	if rh, ok := h.hook.(RejectHook); ok {
+		rh.OnReject(addr, reason)
+	}
+}
+func (h *gnetHook[ClientInfo]) OnCipherFail(gc *gnetserver.GNetClient[ClientInfo], err error) {
+	if ch, ok := h.hook.(CipherHook[ClientInfo]); ok {
+		ch.OnCipherFail(gc, err)
+	}
+}
+func (h *gnetHook[ClientInfo]) OnTick() {
+	h.hook.OnTick()
+}
+
+// GNetServer转换为Server
+type gnetServer[ClientId any, ClientInfo any] struct {
+	*gnetserver.GNetServer[ClientId, ClientInfo]
+}
+
+// Client转换为GNetClient 不是gnetserver的连接返回nil
+func gnetClient[ClientInfo any](c Client[ClientInfo]) *gnetserver.GNetClient[ClientInfo] {
+	gc, _ := c.(*gnetserver.GNetClient[ClientInfo])
+	return gc
+}
+
+// 防止nil的GNetClient转换为非nil的Client
+func fromGNetClient[ClientInfo any](gc *gnetserver.GNetClient[ClientInfo]) Client[ClientInfo] {
+	if gc == nil {
+		return nil
+	}
+	return gc
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) Addr() string {
+	return s.Address
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) Dispatch() *msger.MsgDispatch {
+	return s.MsgDispatch
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) Raw() interface{} {
+	return s.GNetServer
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) AddClient(id ClientId, c Client[ClientInfo]) {
+	if gc := gnetClient(c); gc != nil {
+		s.GNetServer.AddClient(id, gc)
+	}
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) GetClient(id ClientId) Client[ClientInfo] {
+	return fromGNetClient(s.GNetServer.GetClient(id))
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) RemoveClient(id ClientId) Client[ClientInfo] {
+	return fromGNetClient(s.GNetServer.RemoveClient(id))
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) RangeClient(f func(c Client[ClientInfo]) bool) {
+	s.GNetServer.RangeClient(func(gc *gnetserver.GNetClient[ClientInfo]) bool {
+		return f(gc)
+	})
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) JoinRoom(name string, c Client[ClientInfo]) {
+	if gc := gnetClient(c); gc != nil {
+		s.GNetServer.JoinRoom(name, gc)
+	}
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) LeaveRoom(name string, c Client[ClientInfo]) {
+	if gc := gnetClient(c); gc != nil {
+		s.GNetServer.LeaveRoom(name, gc)
+	}
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) LeaveAllRoom(c Client[ClientInfo]) {
+	if gc := gnetClient(c); gc != nil {
+		s.GNetServer.LeaveAllRoom(gc)
+	}
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) Rooms(c Client[ClientInfo]) []string {
+	if gc := gnetClient(c); gc != nil {
+		return s.GNetServer.Rooms(gc)
+	}
+	return nil
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) RangeRoom(name string, f func(c Client[ClientInfo]) bool) {
+	s.GNetServer.RangeRoom(name, func(gc *gnetserver.GNetClient[ClientInfo]) bool {
+		return f(gc)
+	})
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) BroadcastMsgExcept(ctx context.Context, name string, msg msger.Msger, except Client[ClientInfo]) error {
+	return s.GNetServer.BroadcastMsgExcept(ctx, name, msg, gnetClient(except))
+}
+
+func (s *gnetServer[ClientId, ClientInfo]) RegHook(h Hook[ClientInfo]) {
+	if h != nil {
+		s.GNetServer.RegHook(&gnetHook[ClientInfo]{hook: h})
+	}
+}
@@ -0,0 +1,230 @@
+package netserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/yuwf/gobase/gnetserver"
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcpserver"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 监听引擎
+const (
+	EngineTCP  = "tcp"  // tcpserver.TCPServer
+	EngineGNet = "gnet" // gnetserver.GNetServer
+)
+
+// Client 连接对象的公共接口
+// *tcpserver.TCPClient和*gnetserver.GNetClient直接实现了该接口，需要引擎特有的功能时可以类型断言
+type Client[ClientInfo any] interface {
+	// 连接名
+	ConnName() string
+	// ClientInfo
+	Info() *ClientInfo
+	InfoI() interface{}
+	// 消息堆积数量
+	RecvSeqCount() int
+	LastRecvTime() time.Time
+	LastSendTime() time.Time
+
+	Send(ctx context.Context, data []byte) error
+	SendMsg(ctx context.Context, msg msger.Msger) error
+	SendText(ctx context.Context, data []byte) error
+	// 需要依赖Event.DecodeMsg返回消息的RPCId()来判断是否rpc调用
+	SendRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, respBody interface{}) (msger.RecvMsger, error)
+	SendAsyncRPCMsg(ctx context.Context, rpcId interface{}, req msger.Msger, timeout time.Duration, callback interface{}) error
+	// 会回调Event的OnDisConnect
+	Close(err error)
+}
+
+// Server 监听服务的公共接口
+// MsgDispatch的终端类型为Client[ClientInfo]，消息处理函数写法(ctx context.Context, msg *具体消息, c netserver.Client[ClientInfo])
+type Server[ClientId any, ClientInfo any] interface {
+	msger.ServerTermianl
+
+	// 监听地址
+	Addr() string
+	// 消息注册和分发
+	Dispatch() *msger.MsgDispatch
+	// 原始的服务器对象 *tcpserver.TCPServer或者*gnetserver.GNetServer
+	Raw() interface{}
+
+	Start() error
+	Stop() error
+
+	AddClient(id ClientId, c Client[ClientInfo])
+	GetClient(id ClientId) Client[ClientInfo]
+	RemoveClient(id ClientId) Client[ClientInfo]
+	// 主动关闭 不会回调Event的OnDisConnect
+	CloseClient(id ClientId, err error)
+	// 遍历连接 f函数返回false 停止遍历
+	RangeClient(f func(c Client[ClientInfo]) bool)
+
+	JoinRoom(name string, c Client[ClientInfo])
+	LeaveRoom(name string, c Client[ClientInfo])
+	LeaveAllRoom(c Client[ClientInfo])
+	Rooms(c Client[ClientInfo]) []string
+	RoomCount(name string) int
+	RangeRoom(name string, f func(c Client[ClientInfo]) bool)
+	BroadcastMsg(ctx context.Context, name string, msg msger.Msger) error
+	BroadcastMsgExcept(ctx context.Context, name string, msg msger.Msger, except Client[ClientInfo]) error
+
+	RegHook(h Hook[ClientInfo])
+}
+
+// Event 事件回调 和TCPEvent、GNetEvent一致，连接对象为Client
+type Event[ClientInfo any] interface {
+	// 消息注册 md的终端类型为Client[ClientInfo]
+	OnMsgReg(md *msger.MsgDispatch)
+
+	// 收到连接
+	// 异步顺序调用
+	OnConnected(ctx context.Context, c Client[ClientInfo])
+
+	// 用户掉线
+	// 异步顺序调用
+	OnDisConnect(ctx context.Context, c Client[ClientInfo])
+
+	// DecodeMsg 解码消息实现
+	// 网络协程调用
+	// 返回值为   msg,len,err
+	DecodeMsg(ctx context.Context, data []byte, c Client[ClientInfo]) (msger.RecvMsger, int, error)
+
+	// OnMsg 收到消息，MsgDispatch没有处理的消息调用
+	// 异步顺序调用 or 异步调用
+	OnMsg(ctx context.Context, mr msger.RecvMsger, c Client[ClientInfo])
+
+	// OnTick 每秒调用一次
+	// 异步顺序调用
+	OnTick(ctx context.Context, c Client[ClientInfo])
+}
+
+// Event可选择实现的接口，开启ParamConfig.PingInterval后，连接读空闲达到间隔时调用，用来发送服务器心跳
+// 异步顺序调用
+type PingEvent[ClientInfo any] interface {
+	OnPing(ctx context.Context, c Client[ClientInfo])
+}
+
+// EventHandler Event的内置实现
+// 如果不想实现Event的所有接口，可以继承它实现部分方法
+type EventHandler[ClientInfo any] struct {
+}
+
+func (*EventHandler[ClientInfo]) OnMsgReg(md *msger.MsgDispatch) {
+}
+func (*EventHandler[ClientInfo]) OnConnected(ctx context.Context, c Client[ClientInfo]) {
+}
+func (*EventHandler[ClientInfo]) OnDisConnect(ctx context.Context, c Client[ClientInfo]) {
+}
+func (*EventHandler[ClientInfo]) DecodeMsg(ctx context.Context, data []byte, c Client[ClientInfo]) (msger.RecvMsger, int, error) {
+	return nil, len(data), errors.New("DecodeMsg not Implementation")
+}
+func (*EventHandler[ClientInfo]) OnMsg(ctx context.Context, mr msger.RecvMsger, c Client[ClientInfo]) {
+	utils.LogCtx(log.Warn(), ctx).Interface("msger", mr).Msgf("Msg Not Handle %s", c.ConnName())
+}
+func (*EventHandler[ClientInfo]) OnTick(ctx context.Context, c Client[ClientInfo]) {
+}
+
+// Hook 和TCPHook、GNetHook共有的部分，引擎特有的回调需要直接注册到原始的服务器对象上
+type Hook[ClientInfo any] interface {
+	// 收到连接
+	OnConnected(c Client[ClientInfo])
+	// ws连接握手
+	OnWSHandShake(c Client[ClientInfo])
+	// 用户掉线，removeClient表示是否引起RemoveClient，但不会调用OnRemoveClient
+	OnDisConnect(c Client[ClientInfo], removeClient bool, closeReason error)
+
+	// 添加Client
+	OnAddClient(c Client[ClientInfo])
+	// 删除Client
+	OnRemoveClient(c Client[ClientInfo])
+
+	// 发送数据 所有的发送
+	OnSendData(c Client[ClientInfo], len int)
+	// 接受数据 所有的接受
+	OnRecvData(c Client[ClientInfo], len int)
+
+	// Send后调用
+	OnSend(c Client[ClientInfo], len int)
+	// SendMsg后调用
+	OnSendMsg(c Client[ClientInfo], mr msger.Msger, len int)
+	// SendText后调用
+	OnSendText(c Client[ClientInfo], len int)
+	// SendRPCMsg后调用
+	OnSendRPCMsg(c Client[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int)
+	// 接受消息数据，消息解码后调用
+	OnRecvMsg(c Client[ClientInfo], mr msger.RecvMsger, len int)
+
+	// 定时调用
+	OnTick()
+}
+
+// Hook可选择实现的接口，连接被准入控制拒绝时调用 reason为tcp.ErrAdmit*
+type RejectHook interface {
+	OnReject(addr net.Addr, reason error)
+}
+
+// Hook可选择实现的接口，开启加密的连接握手失败或者解密失败时调用
+type CipherHook[ClientInfo any] interface {
+	OnCipherFail(c Client[ClientInfo], err error)
+}
+
+// 创建服务器 engine为EngineTCP或者EngineGNet，为空使用EngineTCP，切换引擎不需要修改Event和消息处理函数
+// Msg表示消息类型，必须实现util.Msger接口，否则消息无法分发
+func NewServer[ClientId any, ClientInfo any, Msg any](engine string, port int, event Event[ClientInfo]) (Server[ClientId, ClientInfo], error) {
+	switch engine {
+	case "", EngineTCP:
+		return NewTCPServer[ClientId, ClientInfo, Msg](fmt.Sprintf(":%d", port), event)
+	case EngineGNet:
+		return NewGNetServer[ClientId, ClientInfo, Msg](port, event)
+	}
+	return nil, fmt.Errorf("unknown engine %s", engine)
+}
+
+// 使用tcpserver创建服务器 address格式参考tcpserver.NewTCPServerWithAddr
+func NewTCPServer[ClientId any, ClientInfo any, Msg any](address string, event Event[ClientInfo]) (Server[ClientId, ClientInfo], error) {
+	md, err := msger.NewMsgDispatch[Msg, Client[ClientInfo]]()
+	if err != nil {
+		return nil, err
+	}
+	s, err := tcpserver.NewTCPServerWithAddr[ClientId, ClientInfo, Msg](address, newTCPEvent(event))
+	if err != nil {
+		return nil, err
+	}
+	s.MsgDispatch = md // 替换为终端类型为Client的分发器 连接创建时使用
+	return &tcpServer[ClientId, ClientInfo]{s}, nil
+}
+
+// 使用gnetserver创建服务器
+func NewGNetServer[ClientId any, ClientInfo any, Msg any](port int, event Event[ClientInfo]) (Server[ClientId, ClientInfo], error) {
+	md, err := msger.NewMsgDispatch[Msg, Client[ClientInfo]]()
+	if err != nil {
+		return nil, err
+	}
+	s, err := gnetserver.NewGNetServer[ClientId, ClientInfo, Msg](port, newGNetEvent(event))
+	if err != nil {
+		return nil, err
+	}
+	s.MsgDispatch = md // 替换为终端类型为Client的分发器 连接创建时使用
+	return &gnetServer[ClientId, ClientInfo]{s}, nil
+}
+
+// 连接的对端地址
+func RemoteAddr[ClientInfo any](c Client[ClientInfo]) net.Addr {
+	switch c := c.(type) {
+	case *tcpserver.TCPClient[ClientInfo]:
+		return c.RemoteAddr()
+	case *gnetserver.GNetClient[ClientInfo]:
+		return c.RemoteAddr()
+	}
+	return nil
+}
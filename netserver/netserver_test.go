@@ -0,0 +1,125 @@
+package netserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/yuwf/gobase/log"
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/utils"
+)
+
+type ClientInfo struct {
+	heart int32
+}
+
+// 同一个Handler在两个引擎上使用
+type Handler struct {
+	EventHandler[ClientInfo]
+	server    Server[int, ClientInfo]
+	connected chan Client[ClientInfo]
+}
+
+func (h *Handler) OnMsgReg(md *msger.MsgDispatch) {
+	md.RegMsg(utils.TestHeatBeatReqMsg.MsgID(), h.onHeatBeatReq)
+}
+
+func (h *Handler) OnConnected(ctx context.Context, c Client[ClientInfo]) {
+	h.server.AddClient(1, c)
+	h.server.JoinRoom("room", c)
+	h.connected <- c
+}
+
+func (h *Handler) DecodeMsg(ctx context.Context, data []byte, c Client[ClientInfo]) (msger.RecvMsger, int, error) {
+	return utils.TestDecodeMsg(data)
+}
+
+func (h *Handler) onHeatBeatReq(ctx context.Context, msg *utils.TestHeatBeatReq, c Client[ClientInfo]) {
+	atomic.AddInt32(&c.Info().heart, 1)
+	c.SendMsg(ctx, utils.TestHeatBeatRespMsg)
+}
+
+type hook struct {
+	recv int32
+}
+
+func (h *hook) OnConnected(c Client[ClientInfo])                                {}
+func (h *hook) OnWSHandShake(c Client[ClientInfo])                              {}
+func (h *hook) OnDisConnect(c Client[ClientInfo], removeClient bool, err error) {}
+func (h *hook) OnAddClient(c Client[ClientInfo])                                {}
+func (h *hook) OnRemoveClient(c Client[ClientInfo])                             {}
+func (h *hook) OnSendData(c Client[ClientInfo], len int)                        {}
+func (h *hook) OnRecvData(c Client[ClientInfo], len int)                        {}
+func (h *hook) OnSend(c Client[ClientInfo], len int)                            {}
+func (h *hook) OnSendMsg(c Client[ClientInfo], mr msger.Msger, len int)         {}
+func (h *hook) OnSendText(c Client[ClientInfo], len int)                        {}
+func (h *hook) OnReject(addr net.Addr, reason error)                            {}
+func (h *hook) OnCipherFail(c Client[ClientInfo], err error)                    {}
+func (h *hook) OnSendRPCMsg(c Client[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int) {
+}
+func (h *hook) OnTick() {}
+func (h *hook) OnRecvMsg(c Client[ClientInfo], mr msger.RecvMsger, len int) {
+	atomic.AddInt32(&h.recv, 1)
+}
+
+func TestServerEngine(t *testing.T) {
+	for i, engine := range []string{EngineTCP, EngineGNet} {
+		port := 1262 + i
+		h := &Handler{connected: make(chan Client[ClientInfo], 1)}
+		server, err := NewServer[int, ClientInfo, utils.TestMsg](engine, port, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.server = server
+		hk := &hook{}
+		server.RegHook(hk)
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		var conn net.Conn
+		for i := 0; i < 50; i++ {
+			if conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 20) // gnet异步开启监听
+		}
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		var c Client[ClientInfo]
+		select {
+		case c = <-h.connected:
+		case <-time.After(time.Second * 5):
+			t.Fatal(engine, "wait connected timeout")
+		}
+		if server.GetClient(1) != c || server.GetClient(2) != nil || server.RoomCount("room") != 1 {
+			t.Fatal(engine, "client")
+		}
+		if RemoteAddr(c).String() != conn.LocalAddr().String() {
+			t.Fatal(engine, RemoteAddr(c), conn.LocalAddr())
+		}
+
+		data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+		conn.Write(data)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(engine, err)
+		}
+		m, _, err := utils.TestDecodeMsg(buf[:n])
+		if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+			t.Fatal(engine, m, err)
+		}
+		if atomic.LoadInt32(&c.Info().heart) != 1 || atomic.LoadInt32(&hk.recv) != 1 {
+			t.Fatal(engine, "heart", c.Info().heart, hk.recv)
+		}
+		conn.Close()
+		server.Stop()
+	}
+}
@@ -0,0 +1,206 @@
+package netserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/tcpserver"
+)
+
+// Event转换为TCPEvent
+type tcpEvent[ClientInfo any] struct {
+	event Event[ClientInfo]
+}
+
+// Event实现了PingEvent时使用，没有实现时websocket连接需要发送ping帧，所以单独定义
+type tcpPingEvent[ClientInfo any] struct {
+	*tcpEvent[ClientInfo]
+	ping PingEvent[ClientInfo]
+}
+
+func newTCPEvent[ClientInfo any](event Event[ClientInfo]) tcpserver.TCPEvent[ClientInfo] {
+	e := &tcpEvent[ClientInfo]{event: event}
+	if pe, ok := event.(PingEvent[ClientInfo]); ok {
+		return &tcpPingEvent[ClientInfo]{tcpEvent: e, ping: pe}
+	}
+	return e
+}
+
+func (e *tcpEvent[ClientInfo]) OnMsgReg(md *msger.MsgDispatch) {
+	e.event.OnMsgReg(md)
+}
+func (e *tcpEvent[ClientInfo]) OnConnected(ctx context.Context, tc *tcpserver.TCPClient[ClientInfo]) {
+	e.event.OnConnected(ctx, tc)
+}
+func (e *tcpEvent[ClientInfo]) OnDisConnect(ctx context.Context, tc *tcpserver.TCPClient[ClientInfo]) {
+	e.event.OnDisConnect(ctx, tc)
+}
+func (e *tcpEvent[ClientInfo]) DecodeMsg(ctx context.Context, data []byte, tc *tcpserver.TCPClient[ClientInfo]) (msger.RecvMsger, int, error) {
+	return e.event.DecodeMsg(ctx, data, tc)
+}
+func (e *tcpEvent[ClientInfo]) OnMsg(ctx context.Context, mr msger.RecvMsger, tc *tcpserver.TCPClient[ClientInfo]) {
+	e.event.OnMsg(ctx, mr, tc)
+}
+func (e *tcpEvent[ClientInfo]) OnTick(ctx context.Context, tc *tcpserver.TCPClient[ClientInfo]) {
+	e.event.OnTick(ctx, tc)
+}
+
+func (e *tcpPingEvent[ClientInfo]) OnPing(ctx context.Context, tc *tcpserver.TCPClient[ClientInfo]) {
+	e.ping.OnPing(ctx, tc)
+}
+
+// Hook转换为TCPHook TCP特有的回调忽略
+type tcpHook[ClientInfo any] struct {
+	hook Hook[ClientInfo]
+}
+
+func (h *tcpHook[ClientInfo]) OnConnected(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.hook.OnConnected(tc)
+}
+func (h *tcpHook[ClientInfo]) OnWSHandShake(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.hook.OnWSHandShake(tc)
+}
+func (h *tcpHook[ClientInfo]) OnDisConnect(tc *tcpserver.TCPClient[ClientInfo], removeClient bool, closeReason error) {
+	h.hook.OnDisConnect(tc, removeClient, closeReason)
+}
+func (h *tcpHook[ClientInfo]) OnAddClient(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.hook.OnAddClient(tc)
+}
+func (h *tcpHook[ClientInfo]) OnRemoveClient(tc *tcpserver.TCPClient[ClientInfo]) {
+	h.hook.OnRemoveClient(tc)
+}
+func (h *tcpHook[ClientInfo]) OnSendData(tc *tcpserver.TCPClient[ClientInfo], len int) {
+	h.hook.OnSendData(tc, len)
+}
+func (h *tcpHook[ClientInfo]) OnRecvData(tc *tcpserver.TCPClient[ClientInfo], len int) {
+	h.hook.OnRecvData(tc, len)
+}
+func (h *tcpHook[ClientInfo]) OnSend(tc *tcpserver.TCPClient[ClientInfo], len int) {
+	h.hook.OnSend(tc, len)
+}
+func (h *tcpHook[ClientInfo]) OnSendMsg(tc *tcpserver.TCPClient[ClientInfo], mr msger.Msger, len int) {
+	h.hook.OnSendMsg(tc, mr, len)
+}
+func (h *tcpHook[ClientInfo]) OnSendText(tc *tcpserver.TCPClient[ClientInfo], len int) {
+	h.hook.OnSendText(tc, len)
+}
+func (h *tcpHook[ClientInfo]) OnSendRPCMsg(tc *tcpserver.TCPClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int) {
+	h.hook.OnSendRPCMsg(tc, rpcId, mr, elapsed, len)
+}
+func (h *tcpHook[ClientInfo]) OnRecvMsg(tc *tcpserver.TCPClient[ClientInfo], mr msger.RecvMsger, len int) {
+	h.hook.OnRecvMsg(tc, mr, len)
+}
+func (h *tcpHook[ClientInfo]) OnReject(addr net.Addr, reason error) {
+	if rh, ok := h.hook.(RejectHook); ok {
+		rh.OnReject(addr, reason)
+	}
+}
+func (h *tcpHook[ClientInfo]) OnCipherFail(tc *tcpserver.TCPClient[ClientInfo], err error) {
+	if ch, ok := h.hook.(CipherHook[ClientInfo]); ok {
+		ch.OnCipherFail(tc, err)
+	}
+}
+func (h *tcpHook[ClientInfo]) OnTick() {
+	h.hook.OnTick()
+}
+
+// TCPServer转换为Server
+type tcpServer[ClientId any, ClientInfo any] struct {
+	*tcpserver.TCPServer[ClientId, ClientInfo]
+}
+
+// Client转换为TCPClient 不是tcpserver的连接返回nil
+func tcpClient[ClientInfo any](c Client[ClientInfo]) *tcpserver.TCPClient[ClientInfo] {
+	tc, _ := c.(*tcpserver.TCPClient[ClientInfo])
+	return tc
+}
+
+// 防止nil的TCPClient转换为非nil的Client
+func fromTCPClient[ClientInfo any](tc *tcpserver.TCPClient[ClientInfo]) Client[ClientInfo] {
+	if tc == nil {
+		return nil
+	}
+	return tc
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) Addr() string {
+	return s.Address
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) Dispatch() *msger.MsgDispatch {
+	return s.MsgDispatch
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) Raw() interface{} {
+	return s.TCPServer
+}
+
+// 开启端口复用 和gnetserver保持一致
+func (s *tcpServer[ClientId, ClientInfo]) Start() error {
+	return s.TCPServer.Start(true)
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) AddClient(id ClientId, c Client[ClientInfo]) {
+	if tc := tcpClient(c); tc != nil {
+		s.TCPServer.AddClient(id, tc)
+	}
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) GetClient(id ClientId) Client[ClientInfo] {
+	return fromTCPClient(s.TCPServer.GetClient(id))
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) RemoveClient(id ClientId) Client[ClientInfo] {
+	return fromTCPClient(s.TCPServer.RemoveClient(id))
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) RangeClient(f func(c Client[ClientInfo]) bool) {
+	s.TCPServer.RangeClient(func(tc *tcpserver.TCPClient[ClientInfo]) bool {
+		return f(tc)
+	})
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) JoinRoom(name string, c Client[ClientInfo]) {
+	if tc := tcpClient(c); tc != nil {
+		s.TCPServer.JoinRoom(name, tc)
+	}
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) LeaveRoom(name string, c Client[ClientInfo]) {
+	if tc := tcpClient(c); tc != nil {
+		s.TCPServer.LeaveRoom(name, tc)
+	}
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) LeaveAllRoom(c Client[ClientInfo]) {
+	if tc := tcpClient(c); tc != nil {
+		s.TCPServer.LeaveAllRoom(tc)
+	}
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) Rooms(c Client[ClientInfo]) []string {
+	if tc := tcpClient(c); tc != nil {
+		return s.TCPServer.Rooms(tc)
+	}
+	return nil
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) RangeRoom(name string, f func(c Client[ClientInfo]) bool) {
+	s.TCPServer.RangeRoom(name, func(tc *tcpserver.TCPClient[ClientInfo]) bool {
+		return f(tc)
+	})
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) BroadcastMsgExcept(ctx context.Context, name string, msg msger.Msger, except Client[ClientInfo]) error {
+	return s.TCPServer.BroadcastMsgExcept(ctx, name, msg, tcpClient(except))
+}
+
+func (s *tcpServer[ClientId, ClientInfo]) RegHook(h Hook[ClientInfo]) {
+	if h != nil {
+		s.TCPServer.RegHook(&tcpHook[ClientInfo]{hook: h})
+	}
+}
@@ -0,0 +1,71 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// unix domain socket地址前缀 格式 unix:///path/to/xxx.sock
+const UnixScheme = "unix://"
+
+// UDP可靠传输地址前缀 格式 udp://host:port
+const UDPScheme = "udp://"
+
+// IsUnixAddress 是否是unix domain socket地址
+func IsUnixAddress(address string) bool {
+	return strings.HasPrefix(address, UnixScheme)
+}
+
+// IsUDPAddress 是否是UDP可靠传输地址
+func IsUDPAddress(address string) bool {
+	return strings.HasPrefix(address, UDPScheme)
+}
+
+// ResolveAddr 解析地址 支持host:port、unix:///path/to/xxx.sock和udp://host:port三种格式
+// 返回*net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
+func ResolveAddr(address string) (net.Addr, error) {
+	if IsUDPAddress(address) {
+		return net.ResolveUDPAddr("udp", strings.TrimPrefix(address, UDPScheme))
+	}
+	if IsUnixAddress(address) {
+		path := strings.TrimPrefix(address, UnixScheme)
+		if path == "" {
+			return nil, errors.New("unix address path is empty")
+		}
+		return net.ResolveUnixAddr("unix", path)
+	}
+	return net.ResolveTCPAddr("tcp", address)
+}
+
+// 和addr同类型的空地址 拨号模式连接成功前的本地地址
+func emptyAddr(addr net.Addr) net.Addr {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return &net.UnixAddr{Net: "unix"}
+	}
+	if _, ok := addr.(*net.UDPAddr); ok {
+		return &net.UDPAddr{}
+	}
+	return &net.TCPAddr{}
+}
+
+var unixConnSeq int64
+
+// PeerAddr 被动连接的对端地址，unix对端一般没有绑定地址，生成一个唯一的名字，便于日志和hook区分连接
+// 每次调用unix连接都会生成新的名字，已经创建TCPConn的使用TCPConn.RemoteAddr
+func PeerAddr(conn net.Conn) net.Addr {
+	addr := conn.RemoteAddr()
+	la, ok := conn.LocalAddr().(*net.UnixAddr)
+	if !ok || la == nil {
+		return addr
+	}
+	if ua, _ := addr.(*net.UnixAddr); ua == nil || ua.Name == "" || ua.Name == "@" {
+		name := la.Name + "@" + strconv.FormatInt(atomic.AddInt64(&unixConnSeq, 1), 10)
+		return &net.UnixAddr{Name: name, Net: "unix"}
+	}
+	return addr
+}
@@ -0,0 +1,190 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuwf/gobase/utils"
+)
+
+// 连接被拒绝的原因
+var (
+	ErrAdmitDeny         = errors.New("admission deny")            // 在黑名单中
+	ErrAdmitNotAllow     = errors.New("admission not allow")       // 不在白名单中
+	ErrAdmitMaxConn      = errors.New("admission max conn")        // 超过最大连接数
+	ErrAdmitMaxConnPerIP = errors.New("admission max conn per ip") // 超过单IP最大连接数
+	ErrAdmitRate         = errors.New("admission rate")            // 超过每秒新建连接数
+	ErrAdmitRatePerIP    = errors.New("admission rate per ip")     // 超过单IP每秒新建连接数
+)
+
+// 连接准入配置，可嵌入到各模块的ParamConfig中，每次收到连接时读取，支持热更新
+type AdmissionConfig struct {
+	MaxConn      int      `json:"maxconn,omitempty"`      // 最大连接数 <=0表示不限制
+	MaxConnPerIP int      `json:"maxconnperip,omitempty"` // 单IP最大连接数 <=0表示不限制
+	Rate         float64  `json:"rate,omitempty"`         // 每秒新建连接数 <=0表示不限制
+	Burst        int      `json:"burst,omitempty"`        // 新建连接的突发数 <=0表示使用Rate
+	RatePerIP    float64  `json:"rateperip,omitempty"`    // 单IP每秒新建连接数 <=0表示不限制
+	BurstPerIP   int      `json:"burstperip,omitempty"`   // 单IP新建连接的突发数 <=0表示使用RatePerIP
+	Allow        []string `json:"allow,omitempty"`        // IP白名单 支持?*通配符 不区分大小写 配置后只允许白名单中的IP连接
+	Deny         []string `json:"deny,omitempty"`         // IP黑名单 支持?*通配符 不区分大小写 优先于白名单
+}
+
+func (c *AdmissionConfig) Normalize() {
+	for i := range c.Allow {
+		c.Allow[i] = strings.ToLower(strings.TrimSpace(c.Allow[i]))
+	}
+	for i := range c.Deny {
+		c.Deny[i] = strings.ToLower(strings.TrimSpace(c.Deny[i]))
+	}
+}
+
+func (c *AdmissionConfig) isDeny(ip string) bool {
+	for _, o := range c.Deny {
+		if utils.IsMatch(o, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *AdmissionConfig) isAllow(ip string) bool {
+	if len(c.Allow) == 0 {
+		return true
+	}
+	for _, o := range c.Allow {
+		if utils.IsMatch(o, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddrIP 获取地址中的IP 非IP地址返回空
+func AddrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return ""
+}
+
+// Admission 连接准入控制 协程安全
+// 没有IP的连接(如unix)只检查总连接数和总速率
+type Admission struct {
+	mu         sync.Mutex
+	total      int
+	conns      map[string]int                // 每个IP的连接数
+	bucket     *utils.TokenBucket            // 总速率
+	buckets    map[string]*utils.TokenBucket // 每个IP的速率
+	bucketTime time.Time                     // 上次清理buckets的时间
+}
+
+func NewAdmission() *Admission {
+	return &Admission{
+		conns:      map[string]int{},
+		buckets:    map[string]*utils.TokenBucket{},
+		bucketTime: time.Now(),
+	}
+}
+
+// Admit 判断ip是否允许建立连接，允许时计数，连接断开时要调用Release
+// 返回ErrAdmit*错误表示拒绝
+func (a *Admission) Admit(conf *AdmissionConfig, ip string) error {
+	ip = strings.ToLower(ip)
+	if ip != "" {
+		if conf.isDeny(ip) {
+			return ErrAdmitDeny
+		}
+		if !conf.isAllow(ip) {
+			return ErrAdmitNotAllow
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if conf.MaxConn > 0 && a.total >= conf.MaxConn {
+		return ErrAdmitMaxConn
+	}
+	if ip != "" && conf.MaxConnPerIP > 0 && a.conns[ip] >= conf.MaxConnPerIP {
+		return ErrAdmitMaxConnPerIP
+	}
+	now := time.Now()
+	var ipBucket *utils.TokenBucket // 获取了令牌的IP速率 总速率拒绝时归还
+	if ip != "" && conf.RatePerIP > 0 {
+		b := a.buckets[ip]
+		if b == nil {
+			b = utils.NewTokenBucket(conf.RatePerIP, conf.BurstPerIP)
+			a.buckets[ip] = b
+		} else if rate, burst := b.Limit(); rate != conf.RatePerIP || (conf.BurstPerIP > 0 && burst != conf.BurstPerIP) {
+			b.SetLimit(conf.RatePerIP, conf.BurstPerIP)
+		}
+		if !b.AllowN(now, 1) {
+			return ErrAdmitRatePerIP
+		}
+		ipBucket = b
+	}
+	if conf.Rate > 0 {
+		if a.bucket == nil {
+			a.bucket = utils.NewTokenBucket(conf.Rate, conf.Burst)
+		} else if rate, burst := a.bucket.Limit(); rate != conf.Rate || (conf.Burst > 0 && burst != conf.Burst) {
+			a.bucket.SetLimit(conf.Rate, conf.Burst)
+		}
+		if !a.bucket.AllowN(now, 1) {
+			if ipBucket != nil {
+				ipBucket.ReturnN(1)
+			}
+			return ErrAdmitRate
+		}
+	}
+	a.clean(conf, now)
+
+	a.total++
+	if ip != "" {
+		a.conns[ip]++
+	}
+	return nil
+}
+
+// Release 连接断开 Admit成功的连接才能调用
+func (a *Admission) Release(ip string) {
+	ip = strings.ToLower(ip)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.total > 0 {
+		a.total--
+	}
+	if ip != "" {
+		if n := a.conns[ip]; n <= 1 {
+			delete(a.conns, ip)
+		} else {
+			a.conns[ip] = n - 1
+		}
+	}
+}
+
+// Count 当前计数的连接数
+func (a *Admission) Count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.total
+}
+
+// 每分钟清理一次已经满了的IP令牌桶 桶满了和新建的没有区别
+func (a *Admission) clean(conf *AdmissionConfig, now time.Time) {
+	if now.Sub(a.bucketTime) < time.Minute {
+		return
+	}
+	a.bucketTime = now
+	for ip, b := range a.buckets {
+		if conf.RatePerIP <= 0 || b.Full(now) {
+			delete(a.buckets, ip)
+		}
+	}
+}
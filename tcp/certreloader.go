@@ -0,0 +1,101 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 证书文件修改检查间隔
+const certCheckInterval = time.Second
+
+// CertReloader 证书热更新
+// 作为tls.Config.GetCertificate使用，握手时检查证书文件的修改时间，有变化时重新加载，加载失败继续使用旧证书
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime [2]time.Time // 加载时证书和私钥文件的修改时间
+
+	checkTime int64 // 上次检查文件的时间戳 微妙 原子访问
+}
+
+// 创建时加载一次证书 失败返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 重新加载证书 失败时保留旧证书
+func (r *CertReloader) Reload() error {
+	modTime := r.fileModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	atomic.StoreInt64(&r.checkTime, time.Now().UnixMicro())
+	return nil
+}
+
+// tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.check()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// 使用热更新证书的服务器配置
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
+
+// 文件修改时间有变化时重新加载 最多间隔certCheckInterval检查一次
+func (r *CertReloader) check() {
+	now := time.Now().UnixMicro()
+	last := atomic.LoadInt64(&r.checkTime)
+	if now-last < certCheckInterval.Microseconds() || !atomic.CompareAndSwapInt64(&r.checkTime, last, now) {
+		return
+	}
+	modTime := r.fileModTime()
+	r.mu.RLock()
+	changed := modTime != r.modTime
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := r.Reload(); err != nil {
+		log.Error().Err(err).Str("CertFile", r.certFile).Str("KeyFile", r.keyFile).Msg("CertReloader reload error")
+		return
+	}
+	log.Info().Str("CertFile", r.certFile).Str("KeyFile", r.keyFile).Msg("CertReloader reload")
+}
+
+func (r *CertReloader) fileModTime() [2]time.Time {
+	var modTime [2]time.Time
+	if fi, err := os.Stat(r.certFile); err == nil {
+		modTime[0] = fi.ModTime()
+	}
+	if fi, err := os.Stat(r.keyFile); err == nil {
+		modTime[1] = fi.ModTime()
+	}
+	return modTime
+}
@@ -0,0 +1,351 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// 数据流加密层 发送时在MsgMarshal之后加密，接受时在DecodeMsg之前解密，不依赖TLS
+// 握手：客户端连接后发送 [版本][X25519公钥]，服务器回复 [版本][加密套件][X25519公钥][32字节校验]
+// 双方用ECDH的共享密钥通过HKDF-SHA256派生出两个方向的密钥，PSK作为salt，加密套件和双方公钥作为info
+// 校验为HMAC-SHA256(派生的校验密钥, 加密套件+双方公钥)，客户端校验失败返回ErrCipherVerify
+// 数据帧：[4字节长度][8字节序号][密文+tag]，序号每个方向从0开始每帧加1，作为nonce，收到的序号必须等于期望值，防止重放和乱序
+// 所有的帧都有4字节大端长度头，握手帧不加密
+// 加密后的帧不能丢弃，丢弃一帧后对方后面的帧都会返回ErrCipherReplay，所以开启加密的TCPConn不能使用MQPolicyDropOldest，见CheckCipherConn
+//
+// 安全性：
+// 配置了PSK时，不知道PSK的中间人无法派生出密钥，客户端收到服务器回复时校验失败，服务器收到客户端第一帧时解密失败，修改加密套件也会导致校验失败
+// 持有PSK的一方都可以冒充服务器或客户端，PSK只能在可信的服务之间共享，需要区分身份时使用TLS
+// PSK为空时握手是匿名的，只能防御被动窃听，无法防御中间人，只能在中间人不可能存在的网络中使用
+// 每个连接的X25519密钥都是临时生成的，PSK泄露后也无法解密之前记录的流量，不隐藏流量的长度和时间特征
+
+const (
+	CipherAESGCM           = "aes-gcm"           // AES-256-GCM
+	CipherChaCha20Poly1305 = "chacha20-poly1305" // ChaCha20-Poly1305
+)
+
+var (
+	ErrCipherHandShake = errors.New("cipher handshake not complete") // 握手完成前发送数据
+	ErrCipherHello     = errors.New("cipher hello invalid")          // 握手数据错误
+	ErrCipherSuite     = errors.New("cipher suite not support")      // 不支持的加密套件
+	ErrCipherReplay    = errors.New("cipher frame replay")           // 帧序号不是期望值
+	ErrCipherAuth      = errors.New("cipher frame auth failed")      // 解密校验失败
+	ErrCipherTooLarge  = errors.New("cipher frame too large")        // 帧超过MaxFrameSize
+	ErrCipherVerify    = errors.New("cipher server verify failed")   // 服务器回复的校验失败 PSK不一致或者存在中间人
+	ErrCipherMQPolicy  = errors.New("cipher conflicts drop oldest")  // 写队列策略为MQPolicyDropOldest的连接不能开启加密
+)
+
+// CipherFailReason的分类
+var cipherFails = []error{ErrCipherHandShake, ErrCipherHello, ErrCipherSuite, ErrCipherReplay, ErrCipherAuth, ErrCipherTooLarge, ErrCipherVerify, ErrCipherMQPolicy}
+
+// CipherFailReason 加密失败的原因分类 返回对应ErrCipher*的错误信息，其他错误返回"other"，用于统计时固定取值范围
+func CipherFailReason(err error) string {
+	for _, e := range cipherFails {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+	return "other"
+}
+
+const cipherVersion = 1
+
+// 加密套件的编号 服务器握手时告诉客户端
+var cipherSuites = map[string]byte{
+	CipherAESGCM:           1,
+	CipherChaCha20Poly1305: 2,
+}
+
+type CipherConfig struct {
+	Enable       bool   `json:"enable,omitempty"`       // 是否开启 新建立的连接生效
+	Suite        string `json:"suite,omitempty"`        // 加密套件 aes-gcm(默认) chacha20-poly1305 服务器的配置生效，客户端使用服务器选择的套件
+	MaxFrameSize int    `json:"maxframesize,omitempty"` // 一帧明文的最大长度 默认16M
+	PSK          string `json:"psk,omitempty"`          // 预共享密钥 客户端和服务器必须一致，参与密钥派生防止中间人 为空时握手是匿名的 建议32字节以上的随机串
+}
+
+func (c *CipherConfig) Normalize() {
+	c.Suite = strings.ToLower(c.Suite)
+	if c.Suite == "" {
+		c.Suite = CipherAESGCM
+	}
+	if c.MaxFrameSize <= 0 {
+		c.MaxFrameSize = 16 * 1024 * 1024
+	}
+}
+
+// Cipher 一个连接的加密状态
+type Cipher struct {
+	conf   CipherConfig
+	server bool
+	write  func([]byte) error // 写入连接 持有wmu调用，保证序号和写入顺序一致
+	priv   *ecdh.PrivateKey
+
+	// 加密和写入，写入可能阻塞，只阻塞其他的Send
+	wmu         sync.Mutex
+	established int32 // 握手是否完成 原子访问
+	sendAEAD    cipher.AEAD
+	sendSeq     uint64
+
+	mu   sync.Mutex
+	fail error // 加密层的错误
+
+	// 只在读协程中访问
+	recvAEAD cipher.AEAD
+	recvSeq  uint64
+	plain    []byte // 解密后还未处理完的数据
+}
+
+// NewCipher 创建连接的加密状态 server表示是否服务器端，write为写入连接的函数
+func NewCipher(conf CipherConfig, server bool, write func([]byte) error) (*Cipher, error) {
+	conf.Normalize()
+	if _, ok := cipherSuites[conf.Suite]; !ok {
+		return nil, ErrCipherSuite
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{conf: conf, server: server, write: write, priv: priv}, nil
+}
+
+// CheckCipherConn 检查连接参数能否开启加密 MQPolicyDropOldest会丢弃已加密的帧，返回ErrCipherMQPolicy
+func CheckCipherConn(conf *TCPConnConfig) error {
+	if conf != nil && conf.MQPolicy == MQPolicyDropOldest {
+		return ErrCipherMQPolicy
+	}
+	return nil
+}
+
+// Start 客户端连接成功后调用 发送握手数据
+func (c *Cipher) Start() error {
+	if c.server {
+		return nil
+	}
+	return c.write(cipherFrame(append([]byte{cipherVersion}, c.priv.PublicKey().Bytes()...)))
+}
+
+// Established 握手是否完成
+func (c *Cipher) Established() bool {
+	return atomic.LoadInt32(&c.established) == 1
+}
+
+// Fail 返回加密层的错误，握手未完成返回ErrCipherHandShake，正常返回nil
+// 连接断开时检查，上报握手或解密失败
+func (c *Cipher) Fail() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != nil {
+		return c.fail
+	}
+	if atomic.LoadInt32(&c.established) == 0 {
+		return ErrCipherHandShake
+	}
+	return nil
+}
+
+// Send 加密一帧数据并写入连接，握手完成前返回ErrCipherHandShake
+// 加密和写入在wmu内完成，写入阻塞时只等待其他的Send，不影响Recv和Fail
+func (c *Cipher) Send(p []byte) error {
+	if len(p) > c.conf.MaxFrameSize {
+		return ErrCipherTooLarge
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if atomic.LoadInt32(&c.established) == 0 {
+		return ErrCipherHandShake
+	}
+	var head [12]byte
+	binary.BigEndian.PutUint32(head[:], uint32(8+len(p)+c.sendAEAD.Overhead()))
+	binary.BigEndian.PutUint64(head[4:], c.sendSeq)
+	frame := make([]byte, 0, len(head)+len(p)+c.sendAEAD.Overhead())
+	frame = append(frame, head[:]...)
+	frame = c.sendAEAD.Seal(frame, cipherNonce(c.sendSeq), p, head[:])
+	if err := c.write(frame); err != nil {
+		return err // 没写入 序号不增加
+	}
+	c.sendSeq++
+	return nil
+}
+
+// Recv 处理收到的数据，返回使用的长度，不完整的帧留给下次处理
+// 握手完成时调用onHandShake，之后的帧解密后交给recv处理，recv返回处理的长度，未处理的数据缓存起来和后面的数据一起处理
+func (c *Cipher) Recv(data []byte, onHandShake func(), recv func(plain []byte) (int, error)) (int, error) {
+	n, err := c.open(data, onHandShake)
+	if err != nil {
+		c.mu.Lock()
+		c.fail = err
+		c.mu.Unlock()
+		return n, err
+	}
+	if len(c.plain) > 0 && recv != nil {
+		m, err := recv(c.plain)
+		if err != nil {
+			return n, err
+		}
+		c.plain = c.plain[:copy(c.plain, c.plain[m:])]
+	}
+	return n, nil
+}
+
+// 解析完整的帧，解密后的数据放入plain
+func (c *Cipher) open(data []byte, onHandShake func()) (int, error) {
+	n := 0
+	for len(data)-n >= 4 {
+		l := int(binary.BigEndian.Uint32(data[n:]))
+		if l > c.conf.MaxFrameSize+8+chacha20poly1305.Overhead {
+			return n, ErrCipherTooLarge // 两种套件的tag都是16字节
+		}
+		if len(data)-n-4 < l {
+			break // 等待完整的帧
+		}
+		frame := data[n : n+4+l]
+		n += 4 + l
+		if atomic.LoadInt32(&c.established) == 0 {
+			if err := c.handshake(frame[4:]); err != nil {
+				return n, err
+			}
+			if onHandShake != nil {
+				onHandShake()
+			}
+			continue
+		}
+		if l < 8+c.recvAEAD.Overhead() {
+			return n, ErrCipherAuth
+		}
+		if binary.BigEndian.Uint64(frame[4:]) != c.recvSeq {
+			return n, ErrCipherReplay
+		}
+		var err error
+		c.plain, err = c.recvAEAD.Open(c.plain, cipherNonce(c.recvSeq), frame[12:], frame[:12])
+		if err != nil {
+			return n, ErrCipherAuth
+		}
+		c.recvSeq++
+	}
+	return n, nil
+}
+
+// 处理对方的握手数据
+func (c *Cipher) handshake(body []byte) error {
+	suite := cipherSuites[c.conf.Suite]
+	var finished []byte
+	if c.server {
+		if len(body) != 33 || body[0] != cipherVersion {
+			return ErrCipherHello
+		}
+		body = body[1:]
+	} else {
+		if len(body) != 66 || body[0] != cipherVersion {
+			return ErrCipherHello
+		}
+		suite = body[1]
+		finished = body[34:]
+		body = body[2:34]
+	}
+	peer, err := ecdh.X25519().NewPublicKey(body)
+	if err != nil {
+		return ErrCipherHello
+	}
+	shared, err := c.priv.ECDH(peer)
+	if err != nil {
+		return ErrCipherHello
+	}
+	// 握手数据 加密套件+客户端公钥+服务器公钥
+	transcript := []byte{suite}
+	if c.server {
+		transcript = append(append(transcript, peer.Bytes()...), c.priv.PublicKey().Bytes()...)
+	} else {
+		transcript = append(append(transcript, c.priv.PublicKey().Bytes()...), peer.Bytes()...)
+	}
+	psk := []byte(c.conf.PSK)
+	fkey, err := cipherKey(shared, psk, transcript, "gobase cipher finished")
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, fkey)
+	mac.Write(transcript)
+	if !c.server && !hmac.Equal(mac.Sum(nil), finished) {
+		return ErrCipherVerify
+	}
+	c2s, err := cipherAEAD(suite, shared, psk, transcript, "gobase cipher c2s")
+	if err != nil {
+		return err
+	}
+	s2c, err := cipherAEAD(suite, shared, psk, transcript, "gobase cipher s2c")
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.server {
+		c.sendAEAD, c.recvAEAD = s2c, c2s
+		// 先写入回复再标记完成，之后的Send都在回复后面
+		hello := append([]byte{cipherVersion, suite}, c.priv.PublicKey().Bytes()...)
+		hello = mac.Sum(hello)
+		if err := c.write(cipherFrame(hello)); err != nil {
+			return err
+		}
+	} else {
+		c.sendAEAD, c.recvAEAD = c2s, s2c
+	}
+	atomic.StoreInt32(&c.established, 1)
+	return nil
+}
+
+// 派生密钥 psk作为salt，握手数据和用途作为info，中间人修改任何一项双方的密钥都不同
+func cipherKey(shared, psk, transcript []byte, label string) ([]byte, error) {
+	info := append([]byte(label), transcript...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, psk, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// 派生一个方向的密钥
+func cipherAEAD(suite byte, shared, psk, transcript []byte, label string) (cipher.AEAD, error) {
+	key, err := cipherKey(shared, psk, transcript, label)
+	if err != nil {
+		return nil, err
+	}
+	switch suite {
+	case cipherSuites[CipherAESGCM]:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case cipherSuites[CipherChaCha20Poly1305]:
+		return chacha20poly1305.New(key)
+	}
+	return nil, ErrCipherSuite
+}
+
+func cipherNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// 加上长度头
+func cipherFrame(body []byte) []byte {
+	frame := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	return append(frame, body...)
+}
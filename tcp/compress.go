@@ -0,0 +1,350 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 消息压缩层 发送时对每个编码后的消息单独压缩，接受时在DecodeMsg之前解压，DecodeMsg不需要修改
+// 帧：[4字节长度][1字节标记][数据]，标记为0表示未压缩，其他值为压缩算法的编号
+// 协商：客户端连接后发送 [4字节长度][0x80][版本][支持的算法编号...]，服务器选择一个算法回复 [4字节长度][0x80][版本][算法编号]，编号0表示不压缩
+// 帧里带着算法编号，接收方只要支持该算法就能解压，所以服务器收到协商后就可以压缩发送，客户端收到回复后压缩发送，协商完成前发送未压缩的帧
+// 协商的只是压缩算法，所有的帧都带有压缩层的头，客户端和服务器必须同时开启或者同时关闭，只有一方开启时对方无法解析
+
+const (
+	CompressFlate  = "flate"  // compress/flate
+	CompressZstd   = "zstd"   // github.com/klauspost/compress/zstd
+	CompressSnappy = "snappy" // github.com/golang/snappy
+)
+
+var (
+	ErrCompressAlgo     = errors.New("compress algo not support") // 不支持的压缩算法
+	ErrCompressHello    = errors.New("compress hello invalid")    // 协商数据错误
+	ErrCompressTooLarge = errors.New("compress frame too large")  // 帧或者解压后的数据超过MaxSize
+)
+
+const (
+	compressVersion   = 1
+	compressFlagRaw   = 0
+	compressFlagHello = 0x80
+)
+
+// 压缩算法的编号
+var compressAlgos = map[string]byte{
+	CompressFlate:  1,
+	CompressZstd:   2,
+	CompressSnappy: 3,
+}
+
+// zstd的编码器是协程安全的 所有连接共用，解码器使用流式接口，和flate一样限制解压后的长度
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+
+	zstdDecoderPool sync.Pool
+)
+
+func zstdEncode(p []byte) ([]byte, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdEncoder.EncodeAll(p, nil), nil
+}
+
+type CompressConfig struct {
+	Enable  bool     `json:"enable,omitempty"`  // 是否开启 新建立的连接生效
+	Algos   []string `json:"algos,omitempty"`   // 支持的压缩算法 按优先顺序 flate zstd snappy 默认[flate,snappy] 服务器按自己的顺序选择第一个双方都支持的
+	MinSize int      `json:"minsize,omitempty"` // 消息长度达到后才压缩 默认1024
+	MaxSize int      `json:"maxsize,omitempty"` // 一帧和解压后的最大长度 默认16M
+}
+
+func (c *CompressConfig) Normalize() {
+	for i := 0; i < len(c.Algos); i++ {
+		c.Algos[i] = strings.TrimSpace(strings.ToLower(c.Algos[i]))
+	}
+	if len(c.Algos) == 0 {
+		c.Algos = []string{CompressFlate, CompressSnappy}
+	}
+	if c.MinSize <= 0 {
+		c.MinSize = 1024
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = 16 * 1024 * 1024
+	}
+}
+
+// Compressor 一个连接的压缩状态
+type Compressor struct {
+	conf   CompressConfig
+	server bool
+	write  func([]byte) error               // 写入连接
+	stat   func(send bool, rawLen, len int) // 发送或者接受一个消息后调用 未压缩的消息rawLen和len相同 可以为nil
+	algos  []byte                           // 支持的算法编号 按优先顺序
+
+	algo int32 // 发送使用的算法编号 0表示不压缩 原子访问
+
+	// 只在读协程中访问
+	plain []byte // 解压后还未处理完的数据 不超过MaxSize
+}
+
+// NewCompressor 创建连接的压缩状态 server表示是否服务器端，write为写入连接的函数，stat用来统计压缩前后的长度
+func NewCompressor(conf CompressConfig, server bool, write func([]byte) error, stat func(send bool, rawLen, len int)) (*Compressor, error) {
+	conf.Algos = append([]string(nil), conf.Algos...) // 拷贝 不修改外部的配置
+	conf.Normalize()
+	c := &Compressor{conf: conf, server: server, write: write, stat: stat}
+	for _, name := range conf.Algos {
+		id, ok := compressAlgos[name]
+		if !ok {
+			return nil, ErrCompressAlgo
+		}
+		c.algos = append(c.algos, id)
+	}
+	return c, nil
+}
+
+// Start 客户端连接成功后调用 发送协商数据
+func (c *Compressor) Start() error {
+	if c.server {
+		return nil
+	}
+	return c.write(compressFrame(compressFlagHello, append([]byte{compressVersion}, c.algos...)))
+}
+
+// Algo 返回发送使用的压缩算法 为空表示不压缩
+func (c *Compressor) Algo() string {
+	id := byte(atomic.LoadInt32(&c.algo))
+	for name, v := range compressAlgos {
+		if v == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// Send 写入一个消息，消息长度达到MinSize并且协商了压缩算法时压缩后写入
+func (c *Compressor) Send(p []byte) error {
+	id := byte(atomic.LoadInt32(&c.algo))
+	if id != 0 && len(p) >= c.conf.MinSize {
+		data, err := compressEncode(id, p)
+		if err != nil {
+			return err
+		}
+		if len(data) < len(p) { // 压缩后没有变小的发送原始数据
+			if err := c.write(compressFrame(id, data)); err != nil {
+				return err
+			}
+			if c.stat != nil {
+				c.stat(true, len(p), len(data))
+			}
+			return nil
+		}
+	}
+	if err := c.write(compressFrame(compressFlagRaw, p)); err != nil {
+		return err
+	}
+	if c.stat != nil {
+		c.stat(true, len(p), len(p))
+	}
+	return nil
+}
+
+// Recv 处理收到的数据，返回使用的长度，不完整的帧留给下次处理
+// 解压后的数据交给recv处理，recv返回处理的长度，未处理的数据缓存起来和后面的数据一起处理
+func (c *Compressor) Recv(data []byte, recv func(plain []byte) (int, error)) (int, error) {
+	n := 0
+	for len(data)-n >= 5 {
+		l := int(binary.BigEndian.Uint32(data[n:]))
+		if l < 1 || l-1 > c.conf.MaxSize {
+			return n, ErrCompressTooLarge
+		}
+		if len(data)-n-4 < l {
+			break // 等待完整的帧
+		}
+		flag := data[n+4]
+		body := data[n+5 : n+4+l]
+		n += 4 + l
+		switch flag {
+		case compressFlagRaw:
+			c.plain = append(c.plain, body...)
+			if c.stat != nil {
+				c.stat(false, len(body), len(body))
+			}
+		case compressFlagHello:
+			if err := c.handshake(body); err != nil {
+				return n, err
+			}
+		default:
+			var err error
+			size := len(c.plain)
+			c.plain, err = compressDecode(flag, c.conf.MaxSize, c.plain, body)
+			if err != nil {
+				return n, err
+			}
+			if c.stat != nil {
+				c.stat(false, len(c.plain)-size, len(body))
+			}
+		}
+		if len(c.plain) > c.conf.MaxSize {
+			if err := c.recv(recv); err != nil {
+				return n, err
+			}
+			if len(c.plain) > c.conf.MaxSize {
+				return n, ErrCompressTooLarge // recv一直不处理数据
+			}
+		}
+	}
+	if err := c.recv(recv); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// 解压后的数据交给recv处理 删除处理过的数据
+func (c *Compressor) recv(recv func(plain []byte) (int, error)) error {
+	if len(c.plain) == 0 || recv == nil {
+		return nil
+	}
+	m, err := recv(c.plain)
+	if err != nil {
+		return err
+	}
+	c.plain = c.plain[:copy(c.plain, c.plain[m:])]
+	return nil
+}
+
+// 处理对方的协商数据
+func (c *Compressor) handshake(body []byte) error {
+	if len(body) < 1 || body[0] != compressVersion {
+		return ErrCompressHello
+	}
+	body = body[1:]
+	if c.server {
+		// 按自己的顺序选择第一个双方都支持的算法
+		var id byte
+		for _, v := range c.algos {
+			if bytes.IndexByte(body, v) >= 0 {
+				id = v
+				break
+			}
+		}
+		if err := c.write(compressFrame(compressFlagHello, []byte{compressVersion, id})); err != nil {
+			return err
+		}
+		atomic.StoreInt32(&c.algo, int32(id))
+		return nil
+	}
+	if len(body) != 1 || (body[0] != 0 && bytes.IndexByte(c.algos, body[0]) < 0) {
+		return ErrCompressHello
+	}
+	atomic.StoreInt32(&c.algo, int32(body[0]))
+	return nil
+}
+
+func compressEncode(id byte, p []byte) ([]byte, error) {
+	switch id {
+	case compressAlgos[CompressFlate]:
+		var buf bytes.Buffer
+		var w *flate.Writer
+		if v := flateWriterPool.Get(); v != nil {
+			w = v.(*flate.Writer)
+			w.Reset(&buf)
+		} else {
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		defer flateWriterPool.Put(w)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case compressAlgos[CompressZstd]:
+		return zstdEncode(p)
+	case compressAlgos[CompressSnappy]:
+		return snappy.Encode(nil, p), nil
+	}
+	return nil, ErrCompressAlgo
+}
+
+// 解压后追加到dst
+func compressDecode(id byte, maxSize int, dst, p []byte) ([]byte, error) {
+	switch id {
+	case compressAlgos[CompressFlate]:
+		r := flate.NewReader(bytes.NewReader(p))
+		defer r.Close()
+		buf := bytes.NewBuffer(dst)
+		n, err := buf.ReadFrom(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return dst, err
+		}
+		if n > int64(maxSize) {
+			return dst, ErrCompressTooLarge
+		}
+		return buf.Bytes(), nil
+	case compressAlgos[CompressZstd]:
+		var r *zstd.Decoder
+		if v := zstdDecoderPool.Get(); v != nil {
+			r = v.(*zstd.Decoder)
+			if err := r.Reset(bytes.NewReader(p)); err != nil {
+				return dst, err
+			}
+		} else {
+			var err error
+			r, err = zstd.NewReader(bytes.NewReader(p), zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return dst, err
+			}
+		}
+		defer func() {
+			r.Reset(nil)
+			zstdDecoderPool.Put(r)
+		}()
+		buf := bytes.NewBuffer(dst)
+		n, err := buf.ReadFrom(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return dst, err
+		}
+		if n > int64(maxSize) {
+			return dst, ErrCompressTooLarge
+		}
+		return buf.Bytes(), nil
+	case compressAlgos[CompressSnappy]:
+		l, err := snappy.DecodedLen(p)
+		if err != nil {
+			return dst, err
+		}
+		if l > maxSize {
+			return dst, ErrCompressTooLarge
+		}
+		out, err := snappy.Decode(nil, p)
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, out...), nil
+	}
+	return dst, ErrCompressAlgo
+}
+
+// 加上长度头和标记
+func compressFrame(flag byte, body []byte) []byte {
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(1+len(body)))
+	frame[4] = flag
+	return append(frame, body...)
+}
@@ -0,0 +1,239 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PROXY protocol 参考 https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const proxyV1MaxLen = 107 // v1头最大长度 包括\r\n
+
+var ErrNotProxyHeader = errors.New("not proxy protocol header")
+
+// PROXY protocol配置，可嵌入到各模块的ParamConfig中
+type ProxyProtoConfig struct {
+	Enable  bool     `json:"enable,omitempty"`  // 是否开启 开启后可信来源的连接必须携带PROXY protocol头
+	Trusted []string `json:"trusted,omitempty"` // 可信来源 支持CIDR和IP 为空表示不信任任何来源，不可信来源的连接不解析头，使用连接本身的地址
+	Timeout int      `json:"timeout,omitempty"` // 等待头数据的超时时间 单位毫秒 默认5000
+
+	nets []*net.IPNet // Normalize时生成
+}
+
+func (c *ProxyProtoConfig) Normalize() {
+	c.nets = c.nets[:0]
+	for _, s := range c.Trusted {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil {
+				if ip.To4() != nil {
+					s += "/32"
+				} else {
+					s += "/128"
+				}
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Error().Err(err).Str("Trusted", s).Msg("ProxyProtoConfig invalid trusted")
+			continue
+		}
+		c.nets = append(c.nets, ipnet)
+	}
+	if c.Enable && len(c.nets) == 0 {
+		log.Error().Strs("Trusted", c.Trusted).Msg("ProxyProtoConfig enabled but no valid trusted, PROXY protocol header will not be parsed")
+	}
+}
+
+// IsTrusted 判断连接来源是否需要解析PROXY protocol头
+func (c *ProxyProtoConfig) IsTrusted(addr net.Addr) bool {
+	if c == nil || !c.Enable {
+		return false
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range c.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ProxyProtoConfig) HeaderTimeout() time.Duration {
+	if c == nil || c.Timeout <= 0 {
+		return time.Second * 5
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+// ParseProxyHeader 解析PROXY protocol v1/v2头
+// 数据不完整返回n=0 err=nil，成功返回头的长度n
+// addr为nil表示LOCAL或者UNKNOWN，使用连接本身的地址
+func ParseProxyHeader(data []byte) (addr net.Addr, n int, err error) {
+	if len(data) == 0 {
+		return nil, 0, nil
+	}
+	if matchPrefix(data, proxyV2Sig) {
+		return parseProxyV2(data)
+	}
+	if matchPrefix(data, proxyV1Prefix) {
+		return parseProxyV1(data)
+	}
+	return nil, 0, ErrNotProxyHeader
+}
+
+// data和prefix的公共部分是否相同
+func matchPrefix(data, prefix []byte) bool {
+	l := len(prefix)
+	if len(data) < l {
+		l = len(data)
+	}
+	return bytes.Equal(data[:l], prefix[:l])
+}
+
+func parseProxyV1(data []byte) (net.Addr, int, error) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= proxyV1MaxLen {
+			return nil, 0, errors.New("proxy v1 header too long")
+		}
+		return nil, 0, nil
+	}
+	n := end + 2
+	if n > proxyV1MaxLen {
+		return nil, 0, errors.New("proxy v1 header too long")
+	}
+	fields := strings.Split(string(data[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, n, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, fmt.Errorf("proxy v1 header invalid %q", data[:end])
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, 0, fmt.Errorf("proxy v1 header invalid %q", data[:end])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, n, nil
+}
+
+func parseProxyV2(data []byte) (net.Addr, int, error) {
+	if len(data) < 16 {
+		return nil, 0, nil
+	}
+	if data[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("proxy v2 version invalid %d", data[12]>>4)
+	}
+	l := int(binary.BigEndian.Uint16(data[14:16]))
+	n := 16 + l
+	if len(data) < n {
+		return nil, 0, nil
+	}
+	switch data[12] & 0x0f {
+	case 0x0: // LOCAL 负载均衡器自己的健康检查等
+		return nil, n, nil
+	case 0x1: // PROXY
+	default:
+		return nil, 0, fmt.Errorf("proxy v2 command invalid %d", data[12]&0x0f)
+	}
+	body := data[16:n]
+	switch data[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, 0, errors.New("proxy v2 inet address too short")
+		}
+		return &net.TCPAddr{IP: net.IP(append([]byte(nil), body[0:4]...)), Port: int(binary.BigEndian.Uint16(body[8:10]))}, n, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, 0, errors.New("proxy v2 inet6 address too short")
+		}
+		return &net.TCPAddr{IP: net.IP(append([]byte(nil), body[0:16]...)), Port: int(binary.BigEndian.Uint16(body[32:34]))}, n, nil
+	}
+	// AF_UNSPEC AF_UNIX 使用连接本身的地址
+	return nil, n, nil
+}
+
+// ProxyConn 解析过PROXY protocol头的连接，RemoteAddr返回头中的真实地址
+type ProxyConn struct {
+	net.Conn
+	remote net.Addr
+	buf    []byte // 读取头时多读出的数据
+}
+
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyAddr 代理(负载均衡器)的地址
+func (c *ProxyConn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyAddr 获取conn的代理地址 不是经过PROXY protocol的连接返回nil
+func ProxyAddr(conn net.Conn) net.Addr {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if pc, ok := conn.(*ProxyConn); ok {
+		return pc.ProxyAddr()
+	}
+	return nil
+}
+
+// 从conn中读取PROXY protocol头
+func readProxyHeader(conn net.Conn, timeout time.Duration) (*ProxyConn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	var data []byte
+	buf := make([]byte, 256)
+	for {
+		r, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf[:r]...)
+		addr, n, err := ParseProxyHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return &ProxyConn{Conn: conn, remote: addr, buf: data[n:]}, nil
+		}
+	}
+}
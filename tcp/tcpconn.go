@@ -26,8 +26,9 @@ const (
 // TCPConn 事件回调接口
 type TCPConnEvent interface {
 	// OnDialFail 连接失败，等待下次连接 拨号模式调用, 返回nil会再次自动重连，否则不重连
+	// 回调中可通过tc.DialAttempt()获取连续失败次数，tc.DialNextDelay()获取下次重连的等待时间
 	OnDialFail(err error, tc *TCPConn) error
-	// OnDialSuccess 连接成功 拨号模式调用
+	// OnDialSuccess 连接成功 拨号模式调用，回调中可以Send，数据在读写协程开启后发送
 	OnDialSuccess(tc *TCPConn)
 
 	// OnDisConnect 失去连接，主动调用Close也会调用，拨号模式返回nil会自动重连，否则不自动重连，调用后会清空还未发送的消息队列
@@ -38,6 +39,20 @@ type TCPConnEvent interface {
 	OnSend(data []byte, tc *TCPConn) ([]byte, error)
 }
 
+// TCPConnEvent可选择实现的接口，写队列水位变化时调用，发送协程或写协程调用，不要阻塞
+type TCPConnWaterEvent interface {
+	// OnHighWater 写队列字节数达到高水位 TCPConnConfig.HighWater
+	OnHighWater(tc *TCPConn)
+	// OnLowWater 达到高水位后写队列字节数回落到低水位 TCPConnConfig.LowWater
+	OnLowWater(tc *TCPConn)
+}
+
+// TCPConnEvent可选择实现的接口，写队列满了MQPolicyDropOldest丢弃最早的数据时调用，发送协程调用，不要阻塞
+type TCPConnDropEvent interface {
+	// OnDropOldest 丢弃了写队列中的一个数据 data为丢弃的数据
+	OnDropOldest(data []byte, tc *TCPConn)
+}
+
 // TCPConnEvenHandle TCPConnEvent的内置实现
 // 如果不想实现TCPConnEvent的所有接口，可以继承它实现部分方法
 type TCPConnEvenHandle struct {
@@ -61,16 +76,30 @@ func (*TCPConnEvenHandle) OnSend(data []byte, tc *TCPConn) ([]byte, error) {
 // TCPConn tcp连接对象 协程安全
 type TCPConn struct {
 	// 不可修改
-	dialMode   bool // 拨号模式 主动连接对象使用
-	removeAddr net.TCPAddr
-	localAddr  net.TCPAddr  // 拨号模式 非协程安全，待解决，实际情况是修改localAddr时，一般外部没有回调，不会访问localAddr
+	dialMode   bool         // 拨号模式 主动连接对象使用
+	removeAddr net.Addr     // *net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
+	localAddr  net.Addr     // 拨号模式 非协程安全，待解决，实际情况是修改localAddr时，一般外部没有回调，不会访问localAddr
 	event      TCPConnEvent // 事件回调接口
+	conf       TCPConnConfig
+	tlsConf    *tls.Config // 拨号模式 非nil表示使用TLS连接
 
 	// 只有run协程负责修改
 	state int32    // 连接状态 原子操作，只有loop协程负责修改
 	conn  net.Conn // 连接对象
 
-	mq chan []byte // 写消息队列
+	mq        chan []byte   // 写消息队列
+	mqBytes   int64         // 写队列中的字节数 原子操作
+	mqFree    chan struct{} // 写协程取出数据后通知 阻塞等待的Send使用
+	highWater int32         // 是否处于高水位 原子操作
+	mqDropped int64         // MQPolicyDropOldest丢弃的数据个数 原子操作
+	mqPushed  int64         // 累计写入写队列的字节数 原子操作
+	mqFlushed int64         // 写队列中累计写入socket的字节数 丢弃和清空的也计算在内 原子操作
+	batchLen  int64         // 当前合并写入的数据在写队列中的字节数 只有写协程访问
+	writing   int32         // 写协程正在写入socket 原子操作
+	kick      chan error    // 内部要求断开当前连接 拨号模式会继续重连
+
+	dialAttempt   int32 // 拨号模式 连续失败次数 原子操作
+	dialNextDelay int64 // 拨号模式 下次重连的等待时间 原子操作
 
 	// 外部要求退出
 	quit      chan struct{} // 退出chan 外部写 内部读
@@ -79,23 +108,69 @@ type TCPConn struct {
 	reconn    chan struct{} // 重新连接 外部写 内部读 只有在拨号模式下才有效
 }
 
-// NewTCPConn 创建TCP网络主动连接对象，拨号模式，address格式 host:port (客户端)
+// NewTCPConn 创建TCP网络主动连接对象，拨号模式，address格式 host:port、unix:///path/to/xxx.sock 或者 udp://host:port (客户端)
 func NewTCPConn(address string, event TCPConnEvent) (*TCPConn, error) {
+	return NewTCPConnWithConfig(address, event, nil)
+}
+
+// NewTCPConnWithConfig 创建TCP网络主动连接对象，拨号模式，conf为nil使用默认参数，udp://地址需要设置conf.UDPDial
+func NewTCPConnWithConfig(address string, event TCPConnEvent, conf *TCPConnConfig) (*TCPConn, error) {
+	// 检查下地址格式合法性
+	addr, err := ResolveAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := addr.(*net.UDPAddr); ok && (conf == nil || conf.UDPDial == nil) {
+		return nil, ErrUDPTransport
+	}
+	tc := &TCPConn{
+		dialMode:   true,
+		removeAddr: addr,
+		localAddr:  emptyAddr(addr),
+		event:      event,
+		state:      TCPStateInvalid,
+		quit:       make(chan struct{}),
+		closed:     make(chan struct{}),
+		reconn:     make(chan struct{}, 1), // 开一个缓存即可
+	}
+	tc.initMQ(conf)
+	// 开启循环
+	go tc.loop()
+	return tc, nil
+}
+
+// NewTCPConnTLS 创建TLS主动连接对象，拨号模式，address格式 host:port 或者 unix:///path/to/xxx.sock (客户端)
+// tlsConf可设置Certificates做双向认证，ServerName为空时使用address中的host做SNI和证书校验，unix地址必须设置ServerName
+func NewTCPConnTLS(address string, event TCPConnEvent, conf *TCPConnConfig, tlsConf *tls.Config) (*TCPConn, error) {
+	if tlsConf == nil {
+		return nil, errors.New("tls config is nil")
+	}
 	// 检查下地址格式合法性
-	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	addr, err := ResolveAddr(address)
 	if err != nil {
 		return nil, err
 	}
+	tlsConf = tlsConf.Clone()
+	if tlsConf.ServerName == "" {
+		if _, ok := addr.(*net.TCPAddr); ok {
+			tlsConf.ServerName, _, _ = net.SplitHostPort(address)
+		} else if !tlsConf.InsecureSkipVerify {
+			// unix地址没有host 无法校验证书
+			return nil, errors.New("tls ServerName is required for address " + address)
+		}
+	}
 	tc := &TCPConn{
 		dialMode:   true,
-		removeAddr: *tcpAddr,
+		removeAddr: addr,
+		localAddr:  emptyAddr(addr),
 		event:      event,
+		tlsConf:    tlsConf,
 		state:      TCPStateInvalid,
 		quit:       make(chan struct{}),
 		closed:     make(chan struct{}),
 		reconn:     make(chan struct{}, 1), // 开一个缓存即可
-		mq:         make(chan []byte, 10000),
 	}
+	tc.initMQ(conf)
 	// 开启循环
 	go tc.loop()
 	return tc, nil
@@ -103,65 +178,80 @@ func NewTCPConn(address string, event TCPConnEvent) (*TCPConn, error) {
 
 // NewTCPConned 创建TCP网络被动连接对象(监听端)
 func NewTCPConned(conn net.Conn, event TCPConnEvent) (*TCPConn, error) {
+	return NewTCPConnedWithConfig(conn, event, nil)
+}
+
+// NewTCPConnedWithConfig 创建TCP网络被动连接对象(监听端)，conf为nil使用默认参数
+func NewTCPConnedWithConfig(conn net.Conn, event TCPConnEvent, conf *TCPConnConfig) (*TCPConn, error) {
 	if conn == nil {
 		return nil, errors.New("conn is nil")
 	}
 
 	switch conn.(type) {
 	case *net.TCPConn:
+	case *net.UnixConn:
+	case *ProxyConn:
 	case *tls.Conn:
+	case UDPConn:
 	default:
 		return nil, fmt.Errorf("conn type not support %T", conn)
 	}
 	t := &TCPConn{
 		dialMode:   false,
-		removeAddr: *conn.RemoteAddr().(*net.TCPAddr),
-		localAddr:  *conn.LocalAddr().(*net.TCPAddr),
+		removeAddr: PeerAddr(conn),
+		localAddr:  conn.LocalAddr(),
 		event:      event,
 		state:      TCPStateConnected, // 默认连接成功
 		conn:       conn,
 		quit:       make(chan struct{}),
 		closed:     make(chan struct{}),
-		mq:         make(chan []byte, 10000),
 	}
+	t.initMQ(conf)
 	// 开启循环
 	go t.loop()
 	return t, nil
 }
 
-func (tc *TCPConn) RemoteAddr() *net.TCPAddr {
-	return &tc.removeAddr
+func (tc *TCPConn) initMQ(conf *TCPConnConfig) {
+	if conf != nil {
+		tc.conf = *conf // 拷贝一份 外层修改不影响
+	}
+	tc.conf.normalize()
+	tc.mq = make(chan []byte, tc.conf.MQSize)
+	tc.mqFree = make(chan struct{}, 1)
+	tc.kick = make(chan error, 1)
+}
+
+// 对端地址 *net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
+func (tc *TCPConn) RemoteAddr() net.Addr {
+	return tc.removeAddr
 }
 
-func (tc *TCPConn) LocalAddr() *net.TCPAddr {
-	return &tc.localAddr
+// 本地地址 *net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
+func (tc *TCPConn) LocalAddr() net.Addr {
+	return tc.localAddr
 }
 
 func (tc *TCPConn) Connected() bool {
 	return atomic.LoadInt32(&tc.state) == TCPStateConnected
 }
 
+// 连接参数 外层只读
+func (tc *TCPConn) Config() *TCPConnConfig {
+	return &tc.conf
+}
+
+// Send 写入发送队列，写队列满时根据TCPConnConfig.MQPolicy处理，MQPolicyBlock策略最多等待SendTimeout
 func (tc *TCPConn) Send(buf []byte) error {
-	if len(buf) == 0 {
-		return errors.New("send buf is empty")
-	}
-	if atomic.LoadInt32(&tc.state) != TCPStateConnected {
-		return errors.New("net not connect")
-	}
-	// 做一个写超时
-	timer := time.NewTimer(4 * time.Second)
-	select {
-	case tc.mq <- buf:
-	case <-timer.C:
-		return errors.New("send timeout")
-	}
-	if !timer.Stop() {
-		select {
-		case <-timer.C: // try to drain the channel
-		default:
-		}
+	return tc.push(nil, tc.conf.sendTimeout(), buf)
+}
+
+// SendContext 写入发送队列，写队列满时根据TCPConnConfig.MQPolicy处理，MQPolicyBlock策略等待到ctx结束
+func (tc *TCPConn) SendContext(ctx context.Context, buf []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	return nil
+	return tc.push(ctx, 0, buf)
 }
 
 // 还未发送的消息的长度
@@ -169,17 +259,204 @@ func (tc *TCPConn) MQLen() int {
 	return len(tc.mq)
 }
 
+// 还未发送的消息的字节数
+func (tc *TCPConn) MQBytes() int64 {
+	return atomic.LoadInt64(&tc.mqBytes)
+}
+
+// MQPolicyDropOldest累计丢弃的数据个数
+func (tc *TCPConn) MQDropped() int64 {
+	return atomic.LoadInt64(&tc.mqDropped)
+}
+
+// 累计写入写队列的字节数
+func (tc *TCPConn) MQPushed() int64 {
+	return atomic.LoadInt64(&tc.mqPushed)
+}
+
+// 写队列中累计写入socket的字节数，丢弃和清空的也计算在内
+// Send后读取MQPushed，MQFlushed达到该值时表示数据已经写入socket，连接断开时没有达到的数据未发送
+func (tc *TCPConn) MQFlushed() int64 {
+	return atomic.LoadInt64(&tc.mqFlushed)
+}
+
+// 写队列的数据是否已全部写入socket
+func (tc *TCPConn) Flushed() bool {
+	// 写协程先标记writing再从mqBytes中减去，所以先检查mqBytes
+	return atomic.LoadInt64(&tc.mqBytes) == 0 && atomic.LoadInt32(&tc.writing) == 0
+}
+
+// 是否处于高水位
+func (tc *TCPConn) HighWater() bool {
+	return atomic.LoadInt32(&tc.highWater) == 1
+}
+
+// 拨号模式 当前连续连接失败的次数，连接成功后清零
+func (tc *TCPConn) DialAttempt() int {
+	return int(atomic.LoadInt32(&tc.dialAttempt))
+}
+
+// 拨号模式 下次重连的等待时间，达到DialMaxAttempts不再重连时为0
+func (tc *TCPConn) DialNextDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&tc.dialNextDelay))
+}
+
 // 清空未发送的消息
 func (tc *TCPConn) MQClear() {
 	for {
 		select {
-		case <-tc.mq:
+		case buf := <-tc.mq:
+			tc.popped(buf)
+			atomic.AddInt64(&tc.mqFlushed, int64(len(buf)))
 		default:
 			return
 		}
 	}
 }
 
+// ctx为nil时使用timeout做超时，timeout<=0表示不超时
+func (tc *TCPConn) push(ctx context.Context, timeout time.Duration, buf []byte) error {
+	if len(buf) == 0 {
+		return errors.New("send buf is empty")
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	var timer *time.Timer
+	defer func() {
+		if timer != nil && !timer.Stop() {
+			select {
+			case <-timer.C: // try to drain the channel
+			default:
+			}
+		}
+	}()
+	size := int64(len(buf))
+	for {
+		if atomic.LoadInt32(&tc.state) != TCPStateConnected {
+			return errors.New("net not connect")
+		}
+		if tc.reserve(size) {
+			select {
+			case tc.mq <- buf:
+				atomic.AddInt64(&tc.mqPushed, size)
+				tc.checkHighWater()
+				return nil
+			default:
+				atomic.AddInt64(&tc.mqBytes, -size) // 队列长度满了 还原
+			}
+		}
+
+		// 写队列满了
+		switch tc.conf.MQPolicy {
+		case MQPolicyDropOldest:
+			select {
+			case old := <-tc.mq:
+				tc.popped(old)
+				tc.dropped(old)
+			default:
+			}
+		case MQPolicyReject:
+			return tc.fullError()
+		case MQPolicyDisconnect:
+			err := tc.fullError()
+			select {
+			case tc.kick <- err:
+			default:
+			}
+			return err
+		default:
+			var timerC <-chan time.Time
+			if ctx == nil && timeout > 0 {
+				if timer == nil {
+					timer = time.NewTimer(timeout)
+				}
+				timerC = timer.C
+			}
+			select {
+			case <-tc.mqFree:
+			case <-done:
+				return ctx.Err()
+			case <-timerC:
+				timer = nil // 已经触发了
+				return errors.New("send timeout")
+			case <-tc.quit:
+				return errors.New("net closed")
+			}
+		}
+	}
+}
+
+// 预占写队列字节数
+func (tc *TCPConn) reserve(size int64) bool {
+	if tc.conf.MQMaxBytes <= 0 {
+		atomic.AddInt64(&tc.mqBytes, size)
+		return true
+	}
+	for {
+		cur := atomic.LoadInt64(&tc.mqBytes)
+		if cur > 0 && cur+size > tc.conf.MQMaxBytes {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&tc.mqBytes, cur, cur+size) {
+			return true
+		}
+	}
+}
+
+// 数据从写队列中取出后调用
+func (tc *TCPConn) popped(buf []byte) {
+	atomic.AddInt64(&tc.mqBytes, -int64(len(buf)))
+	select {
+	case tc.mqFree <- struct{}{}:
+	default:
+	}
+	tc.checkLowWater()
+}
+
+// 写队列满了丢弃数据后调用
+func (tc *TCPConn) dropped(buf []byte) {
+	atomic.AddInt64(&tc.mqDropped, 1)
+	atomic.AddInt64(&tc.mqFlushed, int64(len(buf)))
+	if de, ok := tc.event.(TCPConnDropEvent); ok {
+		defer utils.HandlePanic()
+		de.OnDropOldest(buf, tc)
+	}
+}
+
+func (tc *TCPConn) fullError() error {
+	return &MQFullError{
+		Policy: tc.conf.MQPolicy,
+		Len:    len(tc.mq),
+		Bytes:  atomic.LoadInt64(&tc.mqBytes),
+	}
+}
+
+func (tc *TCPConn) checkHighWater() {
+	if tc.conf.HighWater <= 0 || atomic.LoadInt64(&tc.mqBytes) < tc.conf.HighWater {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&tc.highWater, 0, 1) {
+		if we, ok := tc.event.(TCPConnWaterEvent); ok {
+			defer utils.HandlePanic()
+			we.OnHighWater(tc)
+		}
+	}
+}
+
+func (tc *TCPConn) checkLowWater() {
+	if tc.conf.HighWater <= 0 || atomic.LoadInt64(&tc.mqBytes) > tc.conf.LowWater {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&tc.highWater, 1, 0) {
+		if we, ok := tc.event.(TCPConnWaterEvent); ok {
+			defer utils.HandlePanic()
+			we.OnLowWater(tc)
+		}
+	}
+}
+
 // Close 关闭连接
 // waitClose 是否等待关闭完成，true：等待 false：不等待，允许在event的回调函数中调用
 func (tc *TCPConn) Close(waitClose bool) {
@@ -223,7 +500,6 @@ func (tc *TCPConn) loop() {
 			select {
 			case <-tc.quit:
 				atomic.StoreInt32(&tc.state, TCPStateStop)
-				cancel() // 取消连接
 			case exitErr := <-lexit:
 				if exitErr == nil {
 					// loopDial填充nil 表示连接成功
@@ -233,6 +509,7 @@ func (tc *TCPConn) loop() {
 					atomic.StoreInt32(&tc.state, TCPStateStop)
 				}
 			}
+			cancel() // 取消连接 连接成功后cancel不影响已建立的连接
 
 			// 要求退出了 直接退出
 			if atomic.LoadInt32(&tc.quitState) != 0 {
@@ -240,6 +517,12 @@ func (tc *TCPConn) loop() {
 			}
 		}
 
+		// 清理上次连接残留的断开请求
+		select {
+		case <-tc.kick:
+		default:
+		}
+
 		// 开启器读写
 		// 不需要等待loopRead 和 loopWrite退出，内部已经处理了合理的退出，否则会导致死锁(在OnRecv的回调中调用了Close(true))
 		rexit := make(chan error, 1) // 读内部退出
@@ -262,6 +545,8 @@ func (tc *TCPConn) loop() {
 			atomic.StoreInt32(&tc.state, TCPStateRWExit)
 		case exitErr = <-wexit:
 			atomic.StoreInt32(&tc.state, TCPStateRWExit)
+		case exitErr = <-tc.kick:
+			atomic.StoreInt32(&tc.state, TCPStateRWExit)
 		case <-tc.reconn:
 			reconn = true
 			exitErr = errors.New("reconn")
@@ -328,9 +613,17 @@ func (tc *TCPConn) loopDial(ctx context.Context, exit chan error) {
 	var conn net.Conn
 	for {
 		var err error
-		// 连接
+		// 连接 TLS模式DialContext内部会完成握手
 		d := net.Dialer{Timeout: time.Second * 30}
-		conn, err = d.DialContext(ctx, "tcp", tc.removeAddr.String())
+		if _, ok := tc.removeAddr.(*net.UDPAddr); ok {
+			// UDP没有连接过程 对端不可达时在UDP.Timeout或者UDP.DeadLink后断开重连
+			conn, err = tc.conf.UDPDial(tc.removeAddr.String())
+		} else if tc.tlsConf != nil {
+			td := tls.Dialer{NetDialer: &d, Config: tc.tlsConf}
+			conn, err = td.DialContext(ctx, tc.removeAddr.Network(), tc.removeAddr.String())
+		} else {
+			conn, err = d.DialContext(ctx, tc.removeAddr.Network(), tc.removeAddr.String())
+		}
 
 		// 先检查下连接状态
 		if atomic.LoadInt32(&tc.state) != TCPStateConnecting {
@@ -343,6 +636,15 @@ func (tc *TCPConn) loopDial(ctx context.Context, exit chan error) {
 			break
 		}
 
+		// 计算下次重连的等待时间
+		attempt := int(atomic.AddInt32(&tc.dialAttempt, 1))
+		giveup := tc.conf.DialMaxAttempts > 0 && attempt >= tc.conf.DialMaxAttempts
+		var delay time.Duration
+		if !giveup {
+			delay = tc.conf.dialBackoff(attempt)
+		}
+		atomic.StoreInt64(&tc.dialNextDelay, int64(delay))
+
 		if tc.event != nil {
 			func() {
 				defer utils.HandlePanic()
@@ -354,10 +656,15 @@ func (tc *TCPConn) loopDial(ctx context.Context, exit chan error) {
 				return
 			}
 		}
-		// 连接失败 1秒后继续连
-		timer := time.NewTimer(time.Second)
+		if giveup {
+			exit <- fmt.Errorf("Dial fail %d attempts", attempt)
+			return
+		}
+		// 连接失败 等待delay后继续连
+		timer := time.NewTimer(delay)
 		select {
 		case <-tc.quit:
+		case <-ctx.Done():
 		case <-timer.C:
 			continue
 		}
@@ -375,8 +682,12 @@ func (tc *TCPConn) loopDial(ctx context.Context, exit chan error) {
 		}
 	}
 	// 连接成功
+	atomic.StoreInt32(&tc.dialAttempt, 0)
+	atomic.StoreInt64(&tc.dialNextDelay, 0)
 	tc.conn = conn
-	tc.localAddr = *conn.LocalAddr().(*net.TCPAddr) // 写下本地地址
+	tc.localAddr = conn.LocalAddr() // 写下本地地址
+	// 先修改状态 OnDialSuccess中可以Send
+	atomic.CompareAndSwapInt32(&tc.state, TCPStateConnecting, TCPStateConnected)
 	if tc.event != nil {
 		func() {
 			defer utils.HandlePanic()
@@ -431,6 +742,7 @@ func (tc *TCPConn) loopRead(conn net.Conn, exit chan error) {
 }
 
 func (tc *TCPConn) loopWrite(conn net.Conn, exit chan error) {
+	bufs := make(net.Buffers, 0, tc.conf.WriteBatch)
 	for {
 		// 先检查下连接状态
 		if atomic.LoadInt32(&tc.state) != TCPStateConnected {
@@ -445,24 +757,32 @@ func (tc *TCPConn) loopWrite(conn net.Conn, exit chan error) {
 		case <-timer.C:
 			continue
 		case buf := <-tc.mq:
-			if tc.event != nil {
-				var err error
-				func() {
-					defer utils.HandlePanic()
-					buf, err = tc.event.OnSend(buf, tc)
-				}()
-				if err != nil {
-					exit <- fmt.Errorf("OnSend %s", err.Error())
-					exitFlag = true
-					break
-				}
+			atomic.StoreInt32(&tc.writing, 1)
+			var err error
+			bufs, err = tc.batch(buf, bufs[:0])
+			if err != nil {
+				exit <- err
+				exitFlag = true
+				break
+			}
+			// WriteTo会修改切片本身，拷贝一份切片头，底层数组复用
+			ws := bufs
+			if len(ws) == 1 {
+				_, err = conn.Write(ws[0])
+			} else {
+				_, err = ws.WriteTo(conn)
+			}
+			for i := range bufs {
+				bufs[i] = nil
 			}
-			_, err := conn.Write(buf)
 			if err != nil {
 				exit <- fmt.Errorf("Write %s", err.Error())
 				exitFlag = true
+			} else {
+				atomic.AddInt64(&tc.mqFlushed, tc.batchLen)
 			}
 		}
+		atomic.StoreInt32(&tc.writing, 0)
 		if !timer.Stop() {
 			select {
 			case <-timer.C: // try to drain the channel
@@ -474,3 +794,54 @@ func (tc *TCPConn) loopWrite(conn net.Conn, exit chan error) {
 		}
 	}
 }
+
+// 以buf开始，从写队列中收集最多WriteBatch个数据，用于一次writev写入
+func (tc *TCPConn) batch(buf []byte, bufs net.Buffers) (net.Buffers, error) {
+	tc.batchLen = 0
+	var err error
+	if bufs, err = tc.batchAppend(buf, bufs); err != nil {
+		return bufs, err
+	}
+	if tc.conf.WriteBatch <= 1 {
+		return bufs, nil
+	}
+	// 队列中的数据不够一批时，等待FlushDelay时间让更多数据进来
+	if delay := tc.conf.flushDelay(); delay > 0 && len(tc.mq) < tc.conf.WriteBatch-1 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-tc.quit:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	for len(bufs) < tc.conf.WriteBatch {
+		select {
+		case buf := <-tc.mq:
+			if bufs, err = tc.batchAppend(buf, bufs); err != nil {
+				return bufs, err
+			}
+		default:
+			return bufs, nil
+		}
+	}
+	return bufs, nil
+}
+
+func (tc *TCPConn) batchAppend(buf []byte, bufs net.Buffers) (net.Buffers, error) {
+	tc.popped(buf)
+	tc.batchLen += int64(len(buf))
+	if tc.event != nil {
+		var err error
+		func() {
+			defer utils.HandlePanic()
+			buf, err = tc.event.OnSend(buf, tc)
+		}()
+		if err != nil {
+			return bufs, fmt.Errorf("OnSend %s", err.Error())
+		}
+	}
+	if len(buf) == 0 {
+		return bufs, nil
+	}
+	return append(bufs, buf), nil
+}
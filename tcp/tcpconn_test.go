@@ -0,0 +1,990 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuwf/gobase/udp"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// 测试用的自签名证书
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	tlsCert tls.Certificate
+}
+
+func newTestCert(t testing.TB, cn string, parent *testCert, isCA bool, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		tmpl.DNSNames = []string{"localhost"}
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		tlsCert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert},
+	}
+}
+
+// 监听端 收到的数据原样返回
+type testEchoListener struct {
+	TCPConnEvenHandle
+}
+
+func (l *testEchoListener) OnShutdown() {}
+func (l *testEchoListener) OnAccept(c net.Conn) {
+	NewTCPConned(c, l)
+}
+func (l *testEchoListener) OnRecv(data []byte, tc *TCPConn) (int, error) {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	tc.Send(buf)
+	return len(data), nil
+}
+
+// 拨号端
+type testDialEvent struct {
+	TCPConnEvenHandle
+	dialFail chan error
+	recv     chan []byte
+}
+
+func (e *testDialEvent) OnDialFail(err error, tc *TCPConn) error {
+	select {
+	case e.dialFail <- err:
+	default:
+	}
+	return nil
+}
+func (e *testDialEvent) OnRecv(data []byte, tc *TCPConn) (int, error) {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	e.recv <- buf
+	return len(data), nil
+}
+
+func startTestTLSListener(t *testing.T, ca, server *testCert) string {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	l, err := NewTCPListenerTLSConfig("127.0.0.1:0", &testEchoListener{}, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.listener.Addr().String()
+}
+
+func TestTCPConnMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	server := newTestCert(t, "server", ca, false, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "client", ca, false, x509.ExtKeyUsageClientAuth)
+	addr := startTestTLSListener(t, ca, server)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	event := &testDialEvent{dialFail: make(chan error, 1), recv: make(chan []byte, 1)}
+	tc, err := NewTCPConnTLS(addr, event, nil, &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{client.tlsCert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	// OnDialSuccess回调时还未进入连接状态 等连接成功后再发
+	for i := 0; i < 500 && !tc.Connected(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := tc.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-event.recv:
+		if string(data) != "hello" {
+			t.Fatalf("recv %q", data)
+		}
+	case err := <-event.dialFail:
+		t.Fatalf("dial fail %v", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestTCPConnMutualTLSNoClientCert(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	server := newTestCert(t, "server", ca, false, x509.ExtKeyUsageServerAuth)
+	addr := startTestTLSListener(t, ca, server)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	event := &testDialEvent{dialFail: make(chan error, 1), recv: make(chan []byte, 1)}
+	// TLS1.3的客户端握手不等待服务端校验客户端证书，限制1.2让握手阶段就失败
+	tc, err := NewTCPConnTLS(addr, event, nil, &tls.Config{
+		RootCAs:    pool,
+		MaxVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	select {
+	case <-event.recv:
+		t.Fatal("connected without client cert")
+	case <-event.dialFail:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestTCPConnTLSUnknownCA(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true, 0)
+	server := newTestCert(t, "server", ca, false, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "client", ca, false, x509.ExtKeyUsageClientAuth)
+	addr := startTestTLSListener(t, ca, server)
+
+	// 不信任服务端的CA
+	other := newTestCert(t, "other-ca", nil, true, 0)
+	pool := x509.NewCertPool()
+	pool.AddCert(other.cert)
+	event := &testDialEvent{dialFail: make(chan error, 1), recv: make(chan []byte, 1)}
+	tc, err := NewTCPConnTLS(addr, event, nil, &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{client.tlsCert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	select {
+	case <-event.recv:
+		t.Fatal("connected with unknown ca")
+	case <-event.dialFail:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestTCPConnTLSUnixServerName(t *testing.T) {
+	address := UnixScheme + filepath.Join(t.TempDir(), "test.sock")
+	// unix地址没有host 必须指定ServerName
+	if _, err := NewTCPConnTLS(address, &TCPConnEvenHandle{}, nil, &tls.Config{}); err == nil {
+		t.Fatal("no ServerName")
+	}
+	tc, err := NewTCPConnTLS(address, &TCPConnEvenHandle{}, nil, &tls.Config{ServerName: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Close(true)
+}
+
+func TestTCPConnUnix(t *testing.T) {
+	address := UnixScheme + filepath.Join(t.TempDir(), "test.sock")
+	l, err := NewTCPListener(address, &testEchoListener{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(true); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	event := &testDialEvent{dialFail: make(chan error, 1), recv: make(chan []byte, 1)}
+	tc, err := NewTCPConn(address, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	for i := 0; i < 500 && !tc.Connected(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if tc.RemoteAddr().Network() != "unix" {
+		t.Fatalf("remote addr %s %s", tc.RemoteAddr().Network(), tc.RemoteAddr())
+	}
+	if err := tc.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-event.recv:
+		if string(data) != "hello" {
+			t.Fatalf("recv %q", data)
+		}
+	case err := <-event.dialFail:
+		t.Fatalf("dial fail %v", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestTCPConnUDP(t *testing.T) {
+	// 没有设置可靠传输的实现
+	l, err := NewTCPListener(UDPScheme+"127.0.0.1:0", &testEchoListener{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(false); err != ErrUDPTransport {
+		t.Fatalf("start %v", err)
+	}
+	if _, err := NewTCPConn(UDPScheme+"127.0.0.1:1", &testDialEvent{}); err != ErrUDPTransport {
+		t.Fatalf("dial %v", err)
+	}
+
+	l.UDPListen = func(pc net.PacketConn) net.Listener {
+		return udp.NewListener(pc, nil)
+	}
+	if err := l.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	event := &testDialEvent{dialFail: make(chan error, 1), recv: make(chan []byte, 1)}
+	conf := &TCPConnConfig{UDPDial: func(address string) (net.Conn, error) {
+		return udp.Dial(address, nil)
+	}}
+	tc, err := NewTCPConnWithConfig(UDPScheme+l.listener.Addr().String(), event, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	for i := 0; i < 500 && !tc.Connected(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := tc.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-event.recv:
+		if string(data) != "hello" {
+			t.Fatalf("recv %q", data)
+		}
+	case err := <-event.dialFail:
+		t.Fatalf("dial fail %v", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestPeerAddrUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peer.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	names := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		sc, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sc.Close()
+		// 匿名的对端生成唯一的名字
+		name := PeerAddr(sc).String()
+		if name == "" || names[name] {
+			t.Fatalf("peer addr %q", name)
+		}
+		names[name] = true
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	v2 := func(cmd, fam byte, body []byte) []byte {
+		b := append([]byte(nil), proxyV2Sig...)
+		b = append(b, 0x20|cmd, fam, byte(len(body)>>8), byte(len(body)))
+		return append(b, body...)
+	}
+	inet := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x1f, 0x90, 0x00, 0x50}
+	inet6 := make([]byte, 36)
+	copy(inet6, net.ParseIP("2001:db8::1"))
+	inet6[32], inet6[33] = 0x04, 0xd2
+
+	cases := []struct {
+		data string
+		addr string
+		n    int
+		err  bool
+	}{
+		{"PROXY TCP4 192.168.1.10 10.0.0.1 56324 443\r\nhello", "192.168.1.10:56324", 44, false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n", "[2001:db8::1]:1234", 45, false},
+		{"PROXY UNKNOWN\r\n", "", 15, false},
+		{"PROXY TCP4 192.168.1.10", "", 0, false}, // 不完整
+		{"PRO", "", 0, false}, // 不完整
+		{"PROXY TCP4 bad 10.0.0.1 1 2\r\n", "", 0, true},
+		{"GET / HTTP/1.1\r\n", "", 0, true},
+		{string(v2(1, 0x11, inet)) + "hello", "1.2.3.4:8080", 28, false},
+		{string(v2(1, 0x21, inet6)), "[2001:db8::1]:1234", 52, false},
+		{string(v2(0, 0x00, nil)), "", 16, false}, // LOCAL
+		{string(v2(1, 0x11, inet)[:20]), "", 0, false},
+		{string(proxyV2Sig[:8]), "", 0, false},
+	}
+	for _, c := range cases {
+		addr, n, err := ParseProxyHeader([]byte(c.data))
+		if (err != nil) != c.err || n != c.n {
+			t.Fatalf("%q n=%d err=%v", c.data, n, err)
+		}
+		s := ""
+		if addr != nil {
+			s = addr.String()
+		}
+		if s != c.addr {
+			t.Fatalf("%q addr=%s want %s", c.data, s, c.addr)
+		}
+	}
+}
+
+func TestProxyProtoTrusted(t *testing.T) {
+	c := &ProxyProtoConfig{Enable: true, Trusted: []string{"10.0.0.0/8", "192.168.1.1", "bad"}}
+	c.Normalize()
+	for addr, trusted := range map[string]bool{
+		"10.1.2.3:80":    true,
+		"192.168.1.1:80": true,
+		"192.168.1.2:80": false,
+		"8.8.8.8:80":     false,
+	} {
+		a, _ := net.ResolveTCPAddr("tcp", addr)
+		if c.IsTrusted(a) != trusted {
+			t.Fatalf("%s trusted %v", addr, !trusted)
+		}
+	}
+	c.Enable = false
+	if c.IsTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Fatal("disabled but trusted")
+	}
+
+	// 为空或者全部无效时不信任任何来源
+	for _, trusted := range [][]string{nil, {"bad", ""}} {
+		c = &ProxyProtoConfig{Enable: true, Trusted: trusted}
+		c.Normalize()
+		if c.IsTrusted(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+			t.Fatal(trusted, "trusted")
+		}
+	}
+}
+
+// 监听端 记录连接的对端地址
+type testAddrListener struct {
+	testEchoListener
+	accept chan net.Conn
+}
+
+func (l *testAddrListener) OnAccept(c net.Conn) {
+	l.accept <- c
+	NewTCPConned(c, l)
+}
+
+func TestTCPListenerProxyProto(t *testing.T) {
+	event := &testAddrListener{accept: make(chan net.Conn, 1)}
+	l, err := NewTCPListener("127.0.0.1:0", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &ProxyProtoConfig{Enable: true, Trusted: []string{"127.0.0.1"}}
+	conf.Normalize()
+	l.ProxyConf = func() *ProxyProtoConfig { return conf }
+	if err := l.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// 头和数据一起发送
+	c.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\nhello"))
+
+	select {
+	case sc := <-event.accept:
+		if sc.RemoteAddr().String() != "203.0.113.7:40000" {
+			t.Fatalf("remote addr %s", sc.RemoteAddr())
+		}
+		if ProxyAddr(sc) == nil {
+			t.Fatal("proxy addr nil")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("accept timeout")
+	}
+	// 头后面的数据不能丢
+	buf := make([]byte, 5)
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo %q %v", buf, err)
+	}
+
+	// 可信来源没有头 关闭连接
+	c2, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.Write([]byte("hello\r\n"))
+	c2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c2.Read(buf); err == nil {
+		t.Fatal("expect closed")
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	conf := &AdmissionConfig{Deny: []string{"10.0.0.*"}, Allow: []string{"10.*", "127.0.0.1"}}
+	conf.Normalize()
+	a := NewAdmission()
+	for ip, want := range map[string]error{
+		"10.0.0.1":  ErrAdmitDeny,
+		"10.1.0.1":  nil,
+		"127.0.0.1": nil,
+		"8.8.8.8":   ErrAdmitNotAllow,
+		"":          nil, // unix连接不检查ip
+	} {
+		if err := a.Admit(conf, ip); err != want {
+			t.Fatalf("%q admit %v want %v", ip, err, want)
+		}
+	}
+	if a.Count() != 3 {
+		t.Fatalf("count %d", a.Count())
+	}
+	a.Release("10.1.0.1")
+	a.Release("127.0.0.1")
+	a.Release("")
+	if a.Count() != 0 {
+		t.Fatalf("count %d", a.Count())
+	}
+
+	// 连接数
+	conf = &AdmissionConfig{MaxConn: 3, MaxConnPerIP: 2}
+	if a.Admit(conf, "1.1.1.1") != nil || a.Admit(conf, "1.1.1.1") != nil {
+		t.Fatal("admit fail")
+	}
+	if err := a.Admit(conf, "1.1.1.1"); err != ErrAdmitMaxConnPerIP {
+		t.Fatalf("admit %v", err)
+	}
+	if a.Admit(conf, "2.2.2.2") != nil {
+		t.Fatal("admit fail")
+	}
+	if err := a.Admit(conf, "3.3.3.3"); err != ErrAdmitMaxConn {
+		t.Fatalf("admit %v", err)
+	}
+	a.Release("1.1.1.1")
+	if a.Admit(conf, "1.1.1.1") != nil {
+		t.Fatal("admit fail after release")
+	}
+	a.Release("1.1.1.1")
+	a.Release("1.1.1.1")
+	a.Release("2.2.2.2")
+
+	// 速率
+	conf = &AdmissionConfig{Rate: 100, Burst: 3, RatePerIP: 1, BurstPerIP: 2}
+	if a.Admit(conf, "1.1.1.1") != nil || a.Admit(conf, "1.1.1.1") != nil {
+		t.Fatal("admit fail")
+	}
+	if err := a.Admit(conf, "1.1.1.1"); err != ErrAdmitRatePerIP {
+		t.Fatalf("admit %v", err)
+	}
+	if a.Admit(conf, "2.2.2.2") != nil {
+		t.Fatal("admit fail")
+	}
+	if err := a.Admit(conf, "3.3.3.3"); err != ErrAdmitRate {
+		t.Fatalf("admit %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	// 被总速率拒绝时IP的令牌归还 可以连续两次
+	if a.Admit(conf, "3.3.3.3") != nil || a.Admit(conf, "3.3.3.3") != nil {
+		t.Fatal("admit fail after refill")
+	}
+}
+
+type testWaterEvent struct {
+	TCPConnEvenHandle
+	water []string
+}
+
+func (e *testWaterEvent) OnHighWater(tc *TCPConn) {
+	e.water = append(e.water, "high")
+}
+func (e *testWaterEvent) OnLowWater(tc *TCPConn) {
+	e.water = append(e.water, "low")
+}
+
+// 不开启读写协程的连接 写队列不会被消费
+func newTestMQConn(event TCPConnEvent, conf *TCPConnConfig) *TCPConn {
+	tc := &TCPConn{
+		event: event,
+		state: TCPStateConnected,
+		quit:  make(chan struct{}),
+	}
+	tc.initMQ(conf)
+	return tc
+}
+
+// 模拟写协程取出一个数据
+func popTestMQ(tc *TCPConn) []byte {
+	buf := <-tc.mq
+	tc.popped(buf)
+	return buf
+}
+
+func TestMQPolicyBlock(t *testing.T) {
+	tc := newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{MQSize: 2, SendTimeout: 50})
+	tc.Send([]byte("1"))
+	tc.Send([]byte("2"))
+	entry := time.Now()
+	if err := tc.Send([]byte("3")); err == nil || time.Since(entry) < 50*time.Millisecond {
+		t.Fatalf("send %v %v", err, time.Since(entry))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tc.SendContext(ctx, []byte("3")); err != context.Canceled {
+		t.Fatalf("send ctx %v", err)
+	}
+	// 写协程取出数据后 阻塞的Send写入
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		popTestMQ(tc)
+	}()
+	if err := tc.SendContext(context.Background(), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if tc.MQLen() != 2 || tc.MQBytes() != 2 {
+		t.Fatal(tc.MQLen(), tc.MQBytes())
+	}
+	if string(popTestMQ(tc)) != "2" || string(popTestMQ(tc)) != "3" {
+		t.Fatal("order")
+	}
+}
+
+type testDropEvent struct {
+	TCPConnEvenHandle
+	dropped []string
+}
+
+func (e *testDropEvent) OnDropOldest(data []byte, tc *TCPConn) {
+	e.dropped = append(e.dropped, string(data))
+}
+
+func TestMQPolicyDropOldest(t *testing.T) {
+	event := &testDropEvent{}
+	tc := newTestMQConn(event, &TCPConnConfig{MQSize: 2, MQPolicy: MQPolicyDropOldest})
+	for _, s := range []string{"1", "22", "333"} {
+		if err := tc.Send([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tc.MQLen() != 2 || tc.MQBytes() != 5 {
+		t.Fatal(tc.MQLen(), tc.MQBytes())
+	}
+	if len(event.dropped) != 1 || event.dropped[0] != "1" || tc.MQDropped() != 1 {
+		t.Fatal(event.dropped, tc.MQDropped())
+	}
+	if string(popTestMQ(tc)) != "22" || string(popTestMQ(tc)) != "333" || tc.MQBytes() != 0 {
+		t.Fatal("drop")
+	}
+}
+
+func TestMQPolicyReject(t *testing.T) {
+	tc := newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{MQSize: 2, MQPolicy: MQPolicyReject})
+	tc.Send([]byte("1"))
+	tc.Send([]byte("2"))
+	err := tc.Send([]byte("3"))
+	var fe *MQFullError
+	if !errors.As(err, &fe) || fe.Policy != MQPolicyReject || fe.Len != 2 || fe.Bytes != 2 {
+		t.Fatalf("send %v", err)
+	}
+	select {
+	case <-tc.kick:
+		t.Fatal("reject should not kick")
+	default:
+	}
+	if tc.MQLen() != 2 {
+		t.Fatal(tc.MQLen())
+	}
+}
+
+func TestMQPolicyDisconnect(t *testing.T) {
+	tc := newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{MQSize: 1, MQPolicy: MQPolicyDisconnect})
+	tc.Send([]byte("1"))
+	err := tc.Send([]byte("2"))
+	var fe *MQFullError
+	if !errors.As(err, &fe) || fe.Policy != MQPolicyDisconnect {
+		t.Fatalf("send %v", err)
+	}
+	select {
+	case kick := <-tc.kick:
+		if kick != err {
+			t.Fatalf("kick %v", kick)
+		}
+	default:
+		t.Fatal("expect kick")
+	}
+}
+
+func TestMQMaxBytes(t *testing.T) {
+	tc := newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{MQMaxBytes: 10, MQPolicy: MQPolicyReject})
+	// 队列为空时单个超限的数据允许写入
+	if err := tc.Send(make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Send(make([]byte, 1)); err == nil {
+		t.Fatal("expect full")
+	}
+	popTestMQ(tc)
+	if tc.Send(make([]byte, 6)) != nil || tc.Send(make([]byte, 4)) != nil {
+		t.Fatal("under cap")
+	}
+	var fe *MQFullError
+	if err := tc.Send(make([]byte, 1)); !errors.As(err, &fe) || fe.Bytes != 10 {
+		t.Fatalf("send %v", err)
+	}
+
+	// 超过字节数时丢弃最早的数据直到能放下
+	tc = newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{MQMaxBytes: 10, MQPolicy: MQPolicyDropOldest})
+	for _, n := range []int{4, 4, 2, 6} {
+		if err := tc.Send(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tc.MQLen() != 2 || tc.MQBytes() != 8 {
+		t.Fatal(tc.MQLen(), tc.MQBytes())
+	}
+}
+
+func TestMQWater(t *testing.T) {
+	event := &testWaterEvent{}
+	tc := newTestMQConn(event, &TCPConnConfig{HighWater: 10, LowWater: 4})
+	tc.Send(make([]byte, 6))
+	if len(event.water) != 0 || tc.HighWater() {
+		t.Fatal(event.water)
+	}
+	tc.Send(make([]byte, 6))
+	tc.Send(make([]byte, 1)) // 已经是高水位 不重复回调
+	if strings.Join(event.water, ",") != "high" || !tc.HighWater() {
+		t.Fatal(event.water)
+	}
+	popTestMQ(tc) // 剩7
+	if len(event.water) != 1 {
+		t.Fatal(event.water)
+	}
+	popTestMQ(tc) // 剩1
+	if strings.Join(event.water, ",") != "high,low" || tc.HighWater() {
+		t.Fatal(event.water)
+	}
+
+	// 没有实现TCPConnWaterEvent的不回调
+	tc = newTestMQConn(&TCPConnEvenHandle{}, &TCPConnConfig{HighWater: 1})
+	tc.Send(make([]byte, 2))
+	popTestMQ(tc)
+	if tc.HighWater() {
+		t.Fatal("water")
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	conf := &TCPConnConfig{DialDelay: 100, DialMultiplier: 2, DialMaxDelay: 1000}
+	conf.normalize()
+	expect := map[int]time.Duration{1: 100, 2: 200, 3: 400, 4: 800, 5: 1000, 50: 1000, 100000: 1000}
+	for attempt, ms := range expect {
+		if d := conf.dialBackoff(attempt); d != ms*time.Millisecond {
+			t.Fatal(attempt, d)
+		}
+	}
+
+	// 默认固定间隔
+	conf = &TCPConnConfig{}
+	conf.normalize()
+	for _, attempt := range []int{1, 2, 10} {
+		if d := conf.dialBackoff(attempt); d != time.Second {
+			t.Fatal(attempt, d)
+		}
+	}
+
+	// 抖动在(0, 计算值]之间
+	conf = &TCPConnConfig{DialDelay: 100, DialMultiplier: 2, DialMaxDelay: 1000, DialJitter: true}
+	conf.normalize()
+	min, max := time.Hour, time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		d := conf.dialBackoff(3)
+		if d <= 0 || d > 400*time.Millisecond {
+			t.Fatal(d)
+		}
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	if min == max {
+		t.Fatal("no jitter", min)
+	}
+}
+
+type testDialFailEvent struct {
+	TCPConnEvenHandle
+	attempts []int
+	delays   []time.Duration
+}
+
+func (e *testDialFailEvent) OnDialFail(err error, tc *TCPConn) error {
+	e.attempts = append(e.attempts, tc.DialAttempt())
+	e.delays = append(e.delays, tc.DialNextDelay())
+	return nil
+}
+
+func TestDialMaxAttempts(t *testing.T) {
+	// 不存在的unix socket 连接立即失败
+	address := "unix://" + filepath.Join(t.TempDir(), "none.sock")
+	event := &testDialFailEvent{}
+	tc, err := NewTCPConnWithConfig(address, event, &TCPConnConfig{DialDelay: 1, DialMultiplier: 2, DialMaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tc.closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("not give up")
+	}
+	if len(event.attempts) != 3 || event.attempts[0] != 1 || event.attempts[1] != 2 || event.attempts[2] != 3 {
+		t.Fatal(event.attempts)
+	}
+	if event.delays[0] != time.Millisecond || event.delays[1] != 2*time.Millisecond || event.delays[2] != 0 {
+		t.Fatal(event.delays)
+	}
+}
+
+// 模拟一次握手 返回客户端和服务器处理握手数据的错误
+func testCipherHandShake(t *testing.T, client, server CipherConfig, tamper func(reply []byte)) (*Cipher, *Cipher, error, error) {
+	var c2s, s2c []byte
+	sc, err := NewCipher(server, true, func(b []byte) error {
+		s2c = append(s2c, b...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewCipher(client, false, func(b []byte) error {
+		c2s = append(c2s, b...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Start()
+	_, serr := sc.Recv(c2s, nil, nil)
+	if tamper != nil {
+		tamper(s2c)
+	}
+	_, cerr := cc.Recv(s2c, nil, nil)
+	return cc, sc, cerr, serr
+}
+
+func TestCipherPSK(t *testing.T) {
+	conf := CipherConfig{PSK: "test psk"}
+	cc, sc, cerr, serr := testCipherHandShake(t, conf, conf, nil)
+	if cerr != nil || serr != nil || !cc.Established() || !sc.Established() {
+		t.Fatal(cerr, serr)
+	}
+	// 握手后的数据
+	var frame []byte
+	cc.write = func(b []byte) error {
+		frame = b
+		return nil
+	}
+	if err := cc.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var plain []byte
+	if _, err := sc.Recv(frame, nil, func(p []byte) (int, error) {
+		plain = append(plain, p...)
+		return len(p), nil
+	}); err != nil || string(plain) != "hello" {
+		t.Fatal(err, string(plain))
+	}
+
+	// PSK不一致 客户端校验失败
+	if _, _, cerr, _ := testCipherHandShake(t, CipherConfig{PSK: "other"}, conf, nil); cerr != ErrCipherVerify {
+		t.Fatal(cerr)
+	}
+	if _, _, cerr, _ := testCipherHandShake(t, CipherConfig{}, conf, nil); cerr != ErrCipherVerify {
+		t.Fatal(cerr)
+	}
+	// 修改服务器选择的加密套件
+	if _, _, cerr, _ := testCipherHandShake(t, conf, conf, func(reply []byte) { reply[5] = cipherSuites[CipherChaCha20Poly1305] }); cerr != ErrCipherVerify {
+		t.Fatal(cerr)
+	}
+}
+
+func TestCipherFailReason(t *testing.T) {
+	if r := CipherFailReason(ErrCipherAuth); r != ErrCipherAuth.Error() {
+		t.Fatal(r)
+	}
+	if r := CipherFailReason(errors.Join(io.ErrUnexpectedEOF, ErrCipherReplay)); r != ErrCipherReplay.Error() {
+		t.Fatal(r)
+	}
+	if r := CipherFailReason(io.ErrUnexpectedEOF); r != "other" {
+		t.Fatal(r)
+	}
+}
+
+func TestCompressZstd(t *testing.T) {
+	var c2s, s2c []byte
+	var recvRaw, recvLen int
+	sc, err := NewCompressor(CompressConfig{Algos: []string{CompressZstd, CompressFlate}, MinSize: 64, MaxSize: 8192}, true, func(b []byte) error {
+		s2c = append(s2c, b...)
+		return nil
+	}, func(send bool, rawLen, len int) {
+		recvRaw += rawLen
+		recvLen += len
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewCompressor(CompressConfig{Algos: []string{CompressFlate, CompressZstd}, MinSize: 64}, false, func(b []byte) error {
+		c2s = append(c2s, b...)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Start()
+	if _, err := sc.Recv(c2s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.Recv(s2c, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 服务器按自己的顺序选择
+	if cc.Algo() != CompressZstd || sc.Algo() != CompressZstd {
+		t.Fatal(cc.Algo(), sc.Algo())
+	}
+
+	data := []byte(strings.Repeat("gobase zstd ", 512))
+	c2s = c2s[:0]
+	if err := cc.Send(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(c2s) >= len(data) || c2s[4] != compressAlgos[CompressZstd] {
+		t.Fatal(len(c2s), c2s[4])
+	}
+	var plain []byte
+	if _, err := sc.Recv(c2s, func(p []byte) (int, error) {
+		plain = append(plain, p...)
+		return len(p), nil
+	}); err != nil || !bytes.Equal(plain, data) {
+		t.Fatal(err, len(plain))
+	}
+
+	// 解压后超过MaxSize
+	c2s = c2s[:0]
+	if err := cc.Send([]byte(strings.Repeat("z", 8193))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Recv(c2s, nil); err != ErrCompressTooLarge {
+		t.Fatal(err)
+	}
+
+	// 未压缩的消息也统计 不处理的数据超过MaxSize
+	recvRaw, recvLen = 0, 0
+	c2s = c2s[:0]
+	for i := 0; i < 3; i++ {
+		if err := cc.Send([]byte(strings.Repeat("r", 32))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sc.Recv(c2s, func(p []byte) (int, error) { return 0, nil }); err != nil || recvRaw != 96 || recvLen != 96 {
+		t.Fatal(err, recvRaw, recvLen)
+	}
+	c2s = c2s[:0]
+	for i := 0; i < 2; i++ {
+		if err := cc.Send([]byte(strings.Repeat("r", 4096))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sc.Recv(c2s, func(p []byte) (int, error) { return 0, nil }); err != ErrCompressTooLarge {
+		t.Fatal(err)
+	}
+}
+
+func TestWSDeflateMaxSize(t *testing.T) {
+	d := NewWSDeflate(WSDeflateConfig{Enable: true, MaxSize: 1024})
+	if _, err := d.Negotiate((wsflate.Parameters{}).Option()); err != nil || !d.Accepted() {
+		t.Fatal(err)
+	}
+	// 帧头的长度超过MaxSize 分配前返回
+	var buf bytes.Buffer
+	ws.WriteHeader(&buf, ws.Header{Fin: true, OpCode: ws.OpBinary, Length: 1 << 40, Masked: true})
+	if _, err := d.ReadClientMessage(&buf, nil); err != ErrWSDeflateTooLarge {
+		t.Fatal(err)
+	}
+	// 分片的消息超过MaxSize
+	buf.Reset()
+	for i := 0; i < 2; i++ {
+		op := ws.OpBinary
+		if i > 0 {
+			op = ws.OpContinuation
+		}
+		ws.WriteFrame(&buf, ws.MaskFrameInPlace(ws.Frame{Header: ws.Header{Fin: i == 1, OpCode: op, Length: 800}, Payload: make([]byte, 800)}))
+	}
+	if _, err := d.ReadClientMessage(&buf, nil); err != ErrWSDeflateTooLarge {
+		t.Fatal(err)
+	}
+}
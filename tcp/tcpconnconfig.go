@@ -0,0 +1,98 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// 写队列满时的处理策略
+const (
+	MQPolicyBlock      = 0 // 阻塞等待 Send最多等待SendTimeout，SendContext等待ctx结束
+	MQPolicyDropOldest = 1 // 丢弃队列中最早的数据，再写入，丢弃时回调TCPConnDropEvent
+	MQPolicyReject     = 2 // 拒绝写入，返回*MQFullError
+	MQPolicyDisconnect = 3 // 断开连接，返回*MQFullError，拨号模式会自动重连
+)
+
+// TCPConn的连接参数，可嵌入到各模块的ParamConfig中
+type TCPConnConfig struct {
+	MQSize      int   `json:"mqsize,omitempty"`      // 写队列长度 默认10000
+	MQMaxBytes  int64 `json:"mqmaxbytes,omitempty"`  // 写队列最大字节数 <=0表示不限制，队列为空时单个超限的数据允许写入
+	MQPolicy    int   `json:"mqpolicy,omitempty"`    // 写队列满时的处理策略 MQPolicy*
+	SendTimeout int   `json:"sendtimeout,omitempty"` // MQPolicyBlock策略下Send的超时时间 单位毫秒 默认4000
+	HighWater   int64 `json:"highwater,omitempty"`   // 写队列字节数高水位，达到后回调OnHighWater <=0表示不开启
+	LowWater    int64 `json:"lowwater,omitempty"`    // 写队列字节数低水位，高水位后回落到此值回调OnLowWater
+	WriteBatch  int   `json:"writebatch,omitempty"`  // 单次writev合并写入的最大数据个数 默认64 1表示不合并
+	FlushDelay  int   `json:"flushdelay,omitempty"`  // 合并写入前等待更多数据的时间 单位微秒 <=0表示不等待
+
+	// 拨号模式重连策略 第n次失败后等待 min(DialMaxDelay, DialDelay*DialMultiplier^(n-1))
+	DialDelay       int     `json:"dialdelay,omitempty"`       // 首次重连等待时间 单位毫秒 默认1000
+	DialMultiplier  float64 `json:"dialmultiplier,omitempty"`  // 等待时间增长倍数 默认1 即固定间隔
+	DialMaxDelay    int     `json:"dialmaxdelay,omitempty"`    // 最大等待时间 单位毫秒 默认30000
+	DialJitter      bool    `json:"dialjitter,omitempty"`      // 开启full jitter 实际等待时间在(0, 计算值]之间随机
+	DialMaxAttempts int     `json:"dialmaxattempts,omitempty"` // 连续失败次数上限 达到后不再重连 <=0表示不限制
+
+	UDPDial UDPDialer `json:"-"` // 拨号模式地址为udp://时建立连接使用 不设置无法创建
+}
+
+func (c *TCPConnConfig) normalize() {
+	if c.MQSize <= 0 {
+		c.MQSize = 10000
+	}
+	if c.SendTimeout <= 0 {
+		c.SendTimeout = 4000
+	}
+	if c.DialDelay <= 0 {
+		c.DialDelay = 1000
+	}
+	if c.DialMultiplier < 1 {
+		c.DialMultiplier = 1
+	}
+	if c.DialMaxDelay <= 0 {
+		c.DialMaxDelay = 30000
+	}
+	if c.DialMaxDelay < c.DialDelay {
+		c.DialMaxDelay = c.DialDelay
+	}
+	if c.WriteBatch <= 0 {
+		c.WriteBatch = 64
+	}
+	if c.LowWater > c.HighWater {
+		c.LowWater = c.HighWater
+	}
+}
+
+func (c *TCPConnConfig) sendTimeout() time.Duration {
+	return time.Duration(c.SendTimeout) * time.Millisecond
+}
+
+func (c *TCPConnConfig) flushDelay() time.Duration {
+	return time.Duration(c.FlushDelay) * time.Microsecond
+}
+
+// 第attempt次连接失败后的等待时间 attempt从1开始
+func (c *TCPConnConfig) dialBackoff(attempt int) time.Duration {
+	d := float64(c.DialDelay) * math.Pow(c.DialMultiplier, float64(attempt-1))
+	if d > float64(c.DialMaxDelay) || math.IsInf(d, 0) || math.IsNaN(d) {
+		d = float64(c.DialMaxDelay)
+	}
+	delay := time.Duration(d * float64(time.Millisecond))
+	if c.DialJitter && delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay))) + 1
+	}
+	return delay
+}
+
+// MQFullError 写队列满时返回的错误，MQPolicyReject和MQPolicyDisconnect策略返回
+type MQFullError struct {
+	Policy int   // 触发的策略
+	Len    int   // 写队列长度
+	Bytes  int64 // 写队列字节数
+}
+
+func (e *MQFullError) Error() string {
+	return fmt.Sprintf("mq full, policy=%d len=%d bytes=%d", e.Policy, e.Len, e.Bytes)
+}
@@ -7,10 +7,13 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"os"
 	"reflect"
 	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 // TCPListener 事件回调接口
@@ -23,9 +26,14 @@ type TCPListenerEvent interface {
 
 type TCPListener struct {
 	// 不可修改
-	ListenAddr net.TCPAddr      //
+	ListenAddr net.Addr         // *net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
 	event      TCPListenerEvent // 事件回调接口
 	TLSConfig  *tls.Config
+	// 获取PROXY protocol配置 为nil表示不支持，每次收到连接时调用，支持配置热更新
+	// 可信来源的连接在协程中读取头后再回调OnAccept，conn为*ProxyConn或者包裹*ProxyConn的*tls.Conn
+	ProxyConf func() *ProxyProtoConfig
+	// 地址为udp://时创建UDP可靠传输的监听 不设置无法Start
+	UDPListen UDPListen
 
 	listener net.Listener
 
@@ -33,27 +41,27 @@ type TCPListener struct {
 }
 
 func NewTCPListener(address string, event TCPListenerEvent) (*TCPListener, error) {
-	// 检查下地址格式合法性
-	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	// 检查下地址格式合法性 支持host:port、unix:///path/to/xxx.sock和udp://host:port
+	addr, err := ResolveAddr(address)
 	if err != nil {
 		return nil, err
 	}
 	tl := &TCPListener{
-		ListenAddr: *tcpAddr,
+		ListenAddr: addr,
 		event:      event,
 	}
 	return tl, nil
 }
 
 func NewTCPListenerTLSConfig(address string, event TCPListenerEvent, config *tls.Config) (*TCPListener, error) {
-	// 检查下地址格式合法性
-	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	// 检查下地址格式合法性 支持host:port、unix:///path/to/xxx.sock和udp://host:port
+	addr, err := ResolveAddr(address)
 	if err != nil {
 		return nil, err
 	}
 
 	tl := &TCPListener{
-		ListenAddr: *tcpAddr,
+		ListenAddr: addr,
 		event:      event,
 		TLSConfig:  config,
 	}
@@ -66,14 +74,38 @@ func (tl *TCPListener) Start(reuse bool) error {
 	}
 
 	l := &net.ListenConfig{}
-	if reuse {
+	if ua, ok := tl.ListenAddr.(*net.UnixAddr); ok {
+		// unix不支持端口复用，清理上次进程残留的socket文件，能连上说明还有进程在监听，不清理
+		if fi, err := os.Stat(ua.Name); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if c, err := net.DialTimeout("unix", ua.Name, time.Second); err == nil {
+				c.Close()
+			} else {
+				os.Remove(ua.Name)
+			}
+		}
+	} else if reuse {
 		l = &net.ListenConfig{Control: ReusePortControl}
 	}
-	listener, err := l.Listen(context.Background(), "tcp", tl.ListenAddr.String())
+	var listener net.Listener
+	var err error
+	_, isUDP := tl.ListenAddr.(*net.UDPAddr)
+	if isUDP {
+		// UDP可靠传输 不支持TLS和PROXY protocol
+		var pc net.PacketConn
+		if tl.UDPListen == nil {
+			err = ErrUDPTransport
+		} else if pc, err = l.ListenPacket(context.Background(), tl.ListenAddr.Network(), tl.ListenAddr.String()); err == nil {
+			listener = tl.UDPListen(pc)
+		}
+	} else {
+		listener, err = l.Listen(context.Background(), tl.ListenAddr.Network(), tl.ListenAddr.String())
+	}
 	if err != nil || listener == nil {
+		atomic.StoreInt32(&tl.state, 0)
 		return err
 	}
-	if tl.TLSConfig != nil {
+	if !isUDP && tl.TLSConfig != nil && tl.ProxyConf == nil {
+		// 开启PROXY protocol时，需要先读取头再进行TLS握手，在accept中处理
 		listener = tls.NewListener(listener, tl.TLSConfig)
 	}
 	tl.listener = listener
@@ -120,15 +152,40 @@ func (tl *TCPListener) loop() {
 			}
 			break
 		}
-		if tl.event != nil {
-			tl.event.OnAccept(c)
+		if tl.ProxyConf != nil {
+			conf := tl.ProxyConf()
+			if conf.IsTrusted(c.RemoteAddr()) {
+				go tl.acceptProxy(c, conf.HeaderTimeout())
+				continue
+			}
 		}
+		tl.accept(c)
 	}
 	if tl.event != nil {
 		tl.event.OnShutdown()
 	}
 }
 
+func (tl *TCPListener) accept(c net.Conn) {
+	if tl.TLSConfig != nil && tl.ProxyConf != nil {
+		c = tls.Server(c, tl.TLSConfig)
+	}
+	if tl.event != nil {
+		tl.event.OnAccept(c)
+	}
+}
+
+// 读取PROXY protocol头，读取失败直接关闭连接
+func (tl *TCPListener) acceptProxy(c net.Conn, timeout time.Duration) {
+	pc, err := readProxyHeader(c, timeout)
+	if err != nil {
+		log.Error().Err(err).Str("ProxyAddr", c.RemoteAddr().String()).Str("Addr", tl.ListenAddr.String()).Msg("TCPListener read proxy header error")
+		c.Close()
+		return
+	}
+	tl.accept(pc)
+}
+
 func ReusePortControl(network, address string, c syscall.RawConn) error {
 	var opErr error
 	err := c.Control(func(fd uintptr) {
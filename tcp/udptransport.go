@@ -0,0 +1,25 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"errors"
+	"net"
+)
+
+// udp://地址的UDP可靠传输 tcp不依赖具体的实现，由调用者设置
+// 拨号模式设置TCPConnConfig.UDPDial，监听设置TCPListener.UDPListen，可使用udp包的实现，tcpserver.NewUDPServer已经设置好
+
+var ErrUDPTransport = errors.New("udp transport not set") // udp://地址没有设置UDPDial或者UDPListen
+
+// UDPDialer 拨号模式建立UDP可靠传输的连接 address格式 host:port
+type UDPDialer func(address string) (net.Conn, error)
+
+// UDPListen 在pc上创建UDP可靠传输的监听 Accept返回的连接需要实现UDPConn
+type UDPListen func(pc net.PacketConn) net.Listener
+
+// UDPConn UDP可靠传输的连接 例如udp.Session
+type UDPConn interface {
+	net.Conn
+	Conv() uint32 // 会话编号
+}
@@ -0,0 +1,60 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/yuwf/gobase/utils"
+)
+
+// websocket握手时Origin和Host的检查，嵌入到tcpserver/gnetserver的ParamConfig中
+type WSCheckConfig struct {
+	WSOrigin []string `json:"wsorigin,omitempty"` // websocket握手时 允许的Origin 可以是完整的Origin或者只是host部分 支持?*通配符 不区分大小写 为空不检查 没有Origin头的请求不检查
+	WSHost   []string `json:"wshost,omitempty"`   // websocket握手时 允许的Host 支持?*通配符 不区分大小写 为空不检查
+}
+
+func (c *WSCheckConfig) Normalize() {
+	for i := 0; i < len(c.WSOrigin); i++ {
+		c.WSOrigin[i] = strings.ToLower(c.WSOrigin[i])
+	}
+	for i := 0; i < len(c.WSHost); i++ {
+		c.WSHost[i] = strings.ToLower(c.WSHost[i])
+	}
+}
+
+func (c *WSCheckConfig) IsWSOrigin(origin string) bool {
+	if len(c.WSOrigin) == 0 || origin == "" {
+		return true
+	}
+	v := strings.ToLower(origin)
+	host := v
+	if u, err := url.Parse(v); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	for _, o := range c.WSOrigin {
+		if utils.IsMatch(o, v) || utils.IsMatch(o, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *WSCheckConfig) IsWSHost(host string) bool {
+	if len(c.WSHost) == 0 {
+		return true
+	}
+	v := strings.ToLower(host)
+	hostname := v
+	if h, _, err := net.SplitHostPort(v); err == nil {
+		hostname = h
+	}
+	for _, o := range c.WSHost {
+		if utils.IsMatch(o, v) || utils.IsMatch(o, hostname) {
+			return true
+		}
+	}
+	return false
+}
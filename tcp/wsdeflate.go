@@ -0,0 +1,239 @@
+package tcp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// websocket permessage-deflate压缩 RFC 7692
+// 握手时和客户端协商，协商成功后发送的数据达到MinSize才压缩，接受的数据根据RSV1位解压
+// 开启后tcpserver/gnetserver的OnSendData/OnRecvData回调的是压缩后的大小，和OnSendMsg/OnSendText/OnRecvMsg/OnRecvText的大小对比即为压缩率
+
+var (
+	ErrWSDeflateTooLarge = errors.New("ws deflate message too large") // 收到的帧或者解压后的数据超过MaxSize
+	ErrWSDeflateData     = errors.New("ws deflate data invalid")      // 压缩数据错误
+)
+
+// 压缩数据结尾的同步标记 压缩时去掉 解压时补上
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// 解压时补上同步标记和一个空的结束块，让flate.Reader正常返回io.EOF
+var inflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// 不保留压缩上下文时 flate.Writer共用
+var flateWriterPool sync.Pool
+
+type WSDeflateConfig struct {
+	Enable                  bool `json:"enable,omitempty"`                  // 是否开启 新建立的连接生效
+	MinSize                 int  `json:"minsize,omitempty"`                 // 发送数据达到此大小才压缩 默认512
+	MaxSize                 int  `json:"maxsize,omitempty"`                 // 收到的消息和解压后数据的最大长度 默认16M
+	ServerNoContextTakeover bool `json:"servernocontexttakeover,omitempty"` // 服务器不保留压缩上下文 每个消息单独压缩 压缩率低一些，但每个连接节省一个flate.Writer的内存
+	ClientNoContextTakeover bool `json:"clientnocontexttakeover,omitempty"` // 要求客户端不保留压缩上下文 服务器不用保存32K的解压上下文
+}
+
+func (c *WSDeflateConfig) Normalize() {
+	if c.MinSize <= 0 {
+		c.MinSize = 512
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = 16 * 1024 * 1024
+	}
+}
+
+// WSDeflate 一个websocket连接的压缩状态
+type WSDeflate struct {
+	conf     WSDeflateConfig
+	params   wsflate.Parameters // 协商的结果
+	accepted bool
+
+	wmu  sync.Mutex
+	fw   *flate.Writer // 保留压缩上下文时使用
+	wbuf bytes.Buffer
+
+	fr   io.ReadCloser // 解压只在读协程中调用 不需要加锁
+	dict []byte        // 保留解压上下文时 最近32K的解压数据
+}
+
+func NewWSDeflate(conf WSDeflateConfig) *WSDeflate {
+	conf.Normalize()
+	return &WSDeflate{conf: conf}
+}
+
+// Negotiate 给ws.Upgrader.Negotiate使用 只接受第一个可用的permessage-deflate请求
+func (d *WSDeflate) Negotiate(opt httphead.Option) (accept httphead.Option, err error) {
+	if !bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) || d.accepted {
+		return accept, nil
+	}
+	var offer wsflate.Parameters
+	if err := offer.Parse(opt); err != nil {
+		return accept, err
+	}
+	// compress/flate固定使用32K的窗口，不支持更小的server_max_window_bits
+	if offer.ServerMaxWindowBits.Defined() && offer.ServerMaxWindowBits < 15 {
+		return accept, nil
+	}
+	d.params = wsflate.Parameters{
+		ServerNoContextTakeover: offer.ServerNoContextTakeover || d.conf.ServerNoContextTakeover,
+		ClientNoContextTakeover: offer.ClientNoContextTakeover || d.conf.ClientNoContextTakeover,
+	}
+	d.accepted = true
+	return d.params.Option(), nil
+}
+
+// Accepted 是否协商成功
+func (d *WSDeflate) Accepted() bool {
+	return d != nil && d.accepted
+}
+
+// Parameters 协商的参数
+func (d *WSDeflate) Parameters() wsflate.Parameters {
+	return d.params
+}
+
+// WriteServerMessage 服务器写入一个消息 没有协商成功或者数据小于MinSize时不压缩
+func (d *WSDeflate) WriteServerMessage(w io.Writer, op ws.OpCode, p []byte) error {
+	if !d.Accepted() || !op.IsData() || len(p) < d.conf.MinSize {
+		return wsutil.WriteServerMessage(w, op, p)
+	}
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	payload, err := d.compress(p)
+	if err != nil {
+		return err
+	}
+	frame := ws.NewFrame(op, true, payload)
+	frame.Header.Rsv = ws.Rsv(true, false, false)
+	// 编码成一块数据再写入，防止多协程发送时头和数据交叉
+	data, err := ws.CompileFrame(frame)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// 调用者加锁 返回的数据下次调用前有效
+func (d *WSDeflate) compress(p []byte) ([]byte, error) {
+	d.wbuf.Reset()
+	var fw *flate.Writer
+	if d.params.ServerNoContextTakeover {
+		if v := flateWriterPool.Get(); v != nil {
+			fw = v.(*flate.Writer)
+			fw.Reset(&d.wbuf)
+		} else {
+			fw, _ = flate.NewWriter(&d.wbuf, flate.DefaultCompression)
+		}
+		defer flateWriterPool.Put(fw)
+	} else {
+		if d.fw == nil {
+			d.fw, _ = flate.NewWriter(&d.wbuf, flate.DefaultCompression)
+		}
+		fw = d.fw
+	}
+	if _, err := fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	out := d.wbuf.Bytes()
+	if !bytes.HasSuffix(out, deflateTail) {
+		return nil, errors.New("ws deflate unexpected compress tail")
+	}
+	return out[:len(out)-len(deflateTail)], nil
+}
+
+// ReadClientMessage 服务器读取一个消息 和wsutil.ReadClientMessage的功能一样，协商成功后解压数据
+func (d *WSDeflate) ReadClientMessage(r io.Reader, m []wsutil.Message) ([]wsutil.Message, error) {
+	if !d.Accepted() {
+		return wsutil.ReadClientMessage(r, m)
+	}
+	var state wsflate.MessageState
+	rd := wsutil.Reader{
+		Source:     r,
+		State:      ws.StateServerSide | ws.StateExtended,
+		Extensions: []wsutil.RecvExtension{&state},
+		OnIntermediate: func(hdr ws.Header, src io.Reader) error {
+			bts, err := io.ReadAll(src)
+			if err != nil {
+				return err
+			}
+			m = append(m, wsutil.Message{OpCode: hdr.OpCode, Payload: bts})
+			return nil
+		},
+	}
+	h, err := rd.NextFrame()
+	if err != nil {
+		return m, err
+	}
+	// 先检查长度再分配 防止客户端指定很大的长度
+	if h.Length > int64(d.conf.MaxSize) {
+		return m, ErrWSDeflateTooLarge
+	}
+	var p []byte
+	if h.Fin {
+		p = make([]byte, h.Length)
+		_, err = io.ReadFull(&rd, p)
+	} else {
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(io.LimitReader(&rd, int64(d.conf.MaxSize)+1))
+		p = buf.Bytes()
+	}
+	if err != nil {
+		return m, err
+	}
+	if len(p) > d.conf.MaxSize {
+		return m, ErrWSDeflateTooLarge
+	}
+	if state.IsCompressed() {
+		p, err = d.decompress(p)
+		if err != nil {
+			return m, err
+		}
+	}
+	if h.OpCode == ws.OpText && !utf8.Valid(p) {
+		return m, ws.ErrProtocolInvalidUTF8
+	}
+	return append(m, wsutil.Message{OpCode: h.OpCode, Payload: p}), nil
+}
+
+func (d *WSDeflate) decompress(p []byte) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(p), bytes.NewReader(inflateTail))
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(src, d.dict)
+	} else if err := d.fr.(flate.Resetter).Reset(src, d.dict); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(d.fr, int64(d.conf.MaxSize)+1))
+	if err == io.ErrUnexpectedEOF {
+		return nil, ErrWSDeflateData // 和数据不完整区分开
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > d.conf.MaxSize {
+		return nil, ErrWSDeflateTooLarge
+	}
+	if !d.params.ClientNoContextTakeover {
+		if len(out) >= wsflate.MaxLZ77WindowSize {
+			d.dict = append(d.dict[:0], out[len(out)-wsflate.MaxLZ77WindowSize:]...)
+		} else {
+			d.dict = append(d.dict, out...)
+			if n := len(d.dict) - wsflate.MaxLZ77WindowSize; n > 0 {
+				d.dict = append(d.dict[:0], d.dict[n:]...)
+			}
+		}
+	}
+	return out, nil
+}
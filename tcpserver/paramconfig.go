@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/yuwf/gobase/loader"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/udp"
 	"github.com/yuwf/gobase/utils"
 )
 
@@ -17,6 +19,26 @@ type ParamConfig struct {
 	IgnoreIp []string            `json:"ignoreip,omitempty"` // 建立连接和失去连接时，log输出忽略的ip， 支持?*通配符 不区分大小写
 	MsgSeq   bool                `json:"msgseq,omitempty"`   // 消息顺序执行
 	WSHeader map[string][]string `json:"wsheader,omitempty"` // websocket握手时 回复的头
+
+	tcp.WSCheckConfig // websocket握手时 允许的Origin和Host
+
+	Conn  tcp.TCPConnConfig    `json:"conn,omitempty"`  // 连接参数 写队列长度、字节上限、满时策略、高低水位，新建立的连接生效
+	Proxy tcp.ProxyProtoConfig `json:"proxy,omitempty"` // PROXY protocol v1/v2 负载均衡器后面获取客户端的真实地址，新建立的连接生效
+	UDP   udp.Config           `json:"udp,omitempty"`   // 地址为udp://时UDP可靠传输的参数，新建立的连接生效
+
+	Admission tcp.AdmissionConfig `json:"admission,omitempty"` // 连接准入控制 最大连接数、新建连接速率、IP黑白名单
+
+	WSDeflate tcp.WSDeflateConfig `json:"wsdeflate,omitempty"` // websocket permessage-deflate压缩 新建立的连接生效
+
+	Cipher   tcp.CipherConfig   `json:"cipher,omitempty"`   // 数据加密 X25519握手+AEAD加密，websocket连接不使用，Conn.MQPolicy不能为MQPolicyDropOldest，新建立的连接生效
+	Compress tcp.CompressConfig `json:"compress,omitempty"` // 消息压缩 连接时协商算法，需要和客户端一致开启，websocket连接不使用，新建立的连接生效
+
+	ReadIdleTimeout  int `json:"readidletimeout,omitempty"`  // 读空闲超时 超过时间未收到数据关闭连接 单位秒 <=0表示不检查
+	HandShakeTimeout int `json:"handshaketimeout,omitempty"` // 握手超时 连接后超过时间未AddClient关闭连接 单位秒 <=0表示不检查
+	PingInterval     int `json:"pinginterval,omitempty"`     // 读空闲达到间隔时回调TCPPingEvent.OnPing，没有实现时websocket连接发送ping帧 单位秒 <=0表示不开启
+
+	ResumeGrace  int `json:"resumegrace,omitempty"`  // 会话恢复 连接断开后会话的保留时间 单位秒 <=0表示不开启
+	ResumeBuffer int `json:"resumebuffer,omitempty"` // 会话断开期间缓存的发送数据个数上限 超过后会话无法恢复 默认256
 }
 
 var ParamConf loader.JsonLoader[ParamConfig]
@@ -29,6 +51,19 @@ func (c *ParamConfig) Normalize() {
 	for i := 0; i < len(c.IgnoreIp); i++ {
 		c.IgnoreIp[i] = strings.ToLower(c.IgnoreIp[i])
 	}
+	c.WSCheckConfig.Normalize()
+	c.Proxy.Normalize()
+	c.Admission.Normalize()
+	c.WSDeflate.Normalize()
+	c.Cipher.Normalize()
+	c.Compress.Normalize()
+}
+
+func (c *ParamConfig) resumeBuffer() int {
+	if c.ResumeBuffer <= 0 {
+		return 256
+	}
+	return c.ResumeBuffer
 }
 
 func (c *ParamConfig) IsIgnoreIp(ip string) bool {
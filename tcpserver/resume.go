@@ -0,0 +1,526 @@
+package tcpserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/gobwas/ws"
+	"github.com/rs/zerolog/log"
+)
+
+// 会话恢复
+// 开启ParamConfig.ResumeGrace后，AddClient时给连接生成会话token，业务层通过TCPClient.ResumeToken获取后发给客户端
+// 连接断开后会话保留ResumeGrace秒，期间保持id映射(GetClient返回原TCPClient)和加入的房间，通过原TCPClient发送和房间广播的数据会缓存下来
+// 保留期过后删除id映射和房间，并回调OnRemoveClient
+// 客户端重连后携带token，业务层调用TCPServer.ResumeClient恢复，新连接使用原来的ClientInfo、ClientId和房间，并重发缓存的数据
+// 只能恢复已断开的会话，每次恢复成功后更换token，旧token失效
+// 恢复后通过原TCPClient发送的数据会转发到新的连接
+// 断开时连接写队列中还未写入socket的数据也放回缓存，恢复后重发，写入socket后才断开的数据可能重复发送
+// AddClient后发送的rpc记录在会话中，断开前后发送的rpc在恢复后的新连接上收到回复
+
+var (
+	ErrResumeToken    = errors.New("resume token invalid")   // token不存在或者已过期
+	ErrResumeOverflow = errors.New("resume buffer overflow") // 断开期间缓存的数据超过上限 无法恢复
+	ErrResumeActive   = errors.New("resume session active")  // 会话的连接还未断开或者正在被恢复
+)
+
+// 断开期间缓存的数据
+type resumeData struct {
+	data []byte
+	text bool
+}
+
+// 写入连接写队列的数据 end为写入后的TCPConn.MQPushed
+type resumePending struct {
+	resumeData
+	end int64
+}
+
+type resumeSession[ClientInfo any] struct {
+	id   interface{} // ClientId
+	info *ClientInfo
+
+	mu       sync.Mutex
+	token    string                 // 修改时同时持有TCPServer.sessionMu和mu，持有其中一个即可读取
+	tc       *TCPClient[ClientInfo] // 当前的连接 断开后为nil
+	resuming *TCPClient[ClientInfo] // 正在恢复的连接 重发缓存的数据期间发送的数据继续缓存
+	last     *TCPClient[ClientInfo] // 断开的连接 保留期间保持id映射和房间
+	client   interface{}            // last对应的*tClient clientMap中的值
+	expire   time.Time              // 断开后会话的过期时间
+	buf      []resumeData
+	replay   []resumeData    // 恢复时正在重发的数据 写入后移到pending
+	pending  []resumePending // 写入tc写队列还未写入socket的数据 断开时放回缓存
+	overflow bool
+	dropped  bool // 会话已删除
+
+	rpc sync.Map // AddClient后发送的rpc [rpcid:chan msger.RecvMsger]
+}
+
+// 连接发送数据前调用
+// 返回buffered=true表示会话断开中，数据已缓存
+// 返回cur不为nil表示会话已被新连接恢复，需转发到cur
+func (rs *resumeSession[ClientInfo]) send(tc *TCPClient[ClientInfo], data []byte, text bool) (cur *TCPClient[ClientInfo], buffered bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.dropped {
+		return nil, false
+	}
+	if rs.tc != nil && rs.resuming == nil {
+		if rs.tc == tc {
+			return nil, false
+		}
+		return rs.tc, false
+	}
+	if !rs.overflow {
+		if len(rs.buf) >= ParamConf.Get().resumeBuffer() {
+			rs.overflow = true
+			rs.buf = nil
+		} else {
+			rs.buf = append(rs.buf, resumeData{data: append([]byte(nil), data...), text: text})
+		}
+	}
+	return nil, true
+}
+
+// 连接写入数据后调用 记录还未写入socket的数据
+// 返回false表示tc已经断开，数据不会发送，需要重新缓存或者转发
+func (rs *resumeSession[ClientInfo]) written(tc *TCPClient[ClientInfo], data []byte, text bool, err error) bool {
+	end := tc.conn.MQPushed()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.dropped {
+		return true
+	}
+	if rs.tc != tc {
+		return false
+	}
+	if err == nil {
+		rs.addPending(tc, resumeData{data: append([]byte(nil), data...), text: text}, end)
+	}
+	return true
+}
+
+// 需要持有mu 删除已经写入socket的数据
+func (rs *resumeSession[ClientInfo]) addPending(tc *TCPClient[ClientInfo], d resumeData, end int64) {
+	flushed := tc.conn.MQFlushed()
+	i := 0
+	for i < len(rs.pending) && rs.pending[i].end <= flushed {
+		i++
+	}
+	rs.pending = append(rs.pending[i:], resumePending{resumeData: d, end: end})
+}
+
+// 会话删除时rpc记录转移到连接上 没有连接的关闭
+func (rs *resumeSession[ClientInfo]) dropRPC(tc *TCPClient[ClientInfo]) {
+	rs.rpc.Range(func(key, value interface{}) bool {
+		if _, ok := rs.rpc.LoadAndDelete(key); ok {
+			if tc != nil {
+				tc.rpc.Store(key, value)
+			} else {
+				close(value.(chan msger.RecvMsger)) // 删除的地方负责关闭
+			}
+		}
+		return true
+	})
+}
+
+// ResumeToken 会话token 没有开启会话恢复或者还未AddClient返回空
+// ResumeClient成功后token会更换，需要通过新连接获取后重新发给客户端
+func (tc *TCPClient[ClientInfo]) ResumeToken() string {
+	if rs := tc.resume.Load(); rs != nil {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		return rs.token
+	}
+	return ""
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AddClient时创建会话 同一个id之前的会话删除
+func (s *TCPServer[ClientId, ClientInfo]) newSession(id ClientId, tc *TCPClient[ClientInfo]) {
+	if ParamConf.Get().ResumeGrace <= 0 {
+		return
+	}
+	rs := &resumeSession[ClientInfo]{
+		id:    id,
+		token: newResumeToken(),
+		info:  tc.info,
+		tc:    tc,
+	}
+	s.sessionMu.Lock()
+	if old, ok := s.sessionIds[id]; ok {
+		s.dropSessionLocked(old)
+	}
+	s.sessions[rs.token] = rs
+	s.sessionIds[id] = rs
+	s.sessionMu.Unlock()
+	tc.resume.Store(rs)
+}
+
+// RemoveClient和CloseClient时删除会话
+func (s *TCPServer[ClientId, ClientInfo]) dropSession(id ClientId) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if rs, ok := s.sessionIds[id]; ok {
+		s.dropSessionLocked(rs)
+	}
+}
+
+// 会话在保留期时离开房间，返回断开的连接和clientMap中的值，由调用者决定是否删除id映射
+func (s *TCPServer[ClientId, ClientInfo]) dropSessionLocked(rs *resumeSession[ClientInfo]) (*TCPClient[ClientInfo], interface{}) {
+	rs.mu.Lock()
+	rs.dropped = true
+	rs.buf, rs.replay, rs.pending = nil, nil, nil
+	last, client := rs.last, rs.client
+	rs.last, rs.client = nil, nil
+	cur := rs.tc
+	if cur == nil {
+		cur = rs.resuming
+	}
+	rs.mu.Unlock()
+	rs.dropRPC(cur)
+	delete(s.sessions, rs.token)
+	if s.sessionIds[rs.id] == rs {
+		delete(s.sessionIds, rs.id)
+	}
+	if last != nil {
+		s.rooms.LeaveAll(last)
+	}
+	return last, client
+}
+
+// 删除会话保留期间保持的id映射
+func (s *TCPServer[ClientId, ClientInfo]) removeDetached(id interface{}, last *TCPClient[ClientInfo], client interface{}) {
+	if last == nil || !s.clientMap.CompareAndDelete(id, client) {
+		return
+	}
+	// 回调hook
+	func() {
+		defer utils.HandlePanic()
+		for _, h := range s.hook {
+			h.OnRemoveClient(last)
+		}
+	}()
+}
+
+// 连接断开时会话进入保留期 返回true表示进入保留期，保持id映射和房间
+func (s *TCPServer[ClientId, ClientInfo]) detachSession(tc *TCPClient[ClientInfo], client interface{}) bool {
+	rs := tc.resume.Load()
+	if rs == nil {
+		return false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.dropped || (rs.tc != tc && rs.resuming != tc) {
+		return false
+	}
+	// 写队列中还未写入socket的数据和未重发的数据放回缓存 OnDisConnect之后写队列会被清空
+	flushed := tc.conn.MQFlushed()
+	var buf []resumeData
+	for _, p := range rs.pending {
+		if p.end > flushed {
+			buf = append(buf, p.resumeData)
+		}
+	}
+	buf = append(buf, rs.replay...)
+	if len(buf) > 0 && !rs.overflow {
+		rs.buf = append(buf, rs.buf...)
+		if len(rs.buf) > ParamConf.Get().resumeBuffer() {
+			rs.overflow = true
+			rs.buf = nil
+		}
+	}
+	rs.replay, rs.pending = nil, nil
+	rs.tc = nil
+	rs.resuming = nil // 恢复过程中断开 未重发的数据继续缓存
+	rs.last = tc
+	rs.client = client
+	rs.expire = time.Now().Add(time.Duration(ParamConf.Get().ResumeGrace) * time.Second)
+	return true
+}
+
+// ResumeClient 使用token恢复会话，tc为客户端重连后的新连接
+// 成功后tc使用原来的ClientInfo，并以原来的ClientId调用AddClient，然后重发断开期间缓存的数据
+// 会话的连接还未断开时返回ErrResumeActive，成功后token更换，通过tc.ResumeToken获取新的token
+func (s *TCPServer[ClientId, ClientInfo]) ResumeClient(ctx context.Context, token string, tc *TCPClient[ClientInfo]) (ClientId, error) {
+	var id ClientId
+	s.sessionMu.Lock()
+	rs, ok := s.sessions[token]
+	s.sessionMu.Unlock()
+	if !ok {
+		utils.LogCtx(log.Warn(), ctx).Err(ErrResumeToken).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, ErrResumeToken
+	}
+
+	rs.mu.Lock()
+	if rs.dropped {
+		rs.mu.Unlock()
+		utils.LogCtx(log.Warn(), ctx).Err(ErrResumeToken).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, ErrResumeToken
+	}
+	if rs.tc != nil || rs.resuming != nil {
+		rs.mu.Unlock()
+		utils.LogCtx(log.Warn(), ctx).Err(ErrResumeActive).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, ErrResumeActive
+	}
+	if rs.overflow {
+		rs.mu.Unlock()
+		s.sessionMu.Lock()
+		last, client := s.dropSessionLocked(rs)
+		s.sessionMu.Unlock()
+		s.removeDetached(rs.id, last, client)
+		utils.LogCtx(log.Warn(), ctx).Err(ErrResumeOverflow).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, ErrResumeOverflow
+	}
+	// 标记正在恢复 期间tc和原连接发送的数据都缓存
+	id = rs.id.(ClientId)
+	last := rs.last
+	rs.resuming = tc
+	rs.mu.Unlock()
+	tc.resume.Store(rs)
+
+	// 锁外回调hook
+	info := tc.info
+	tc.info = rs.info
+	if !s.addClient(id, tc) {
+		tc.info = info
+		rs.mu.Lock()
+		if rs.resuming == tc {
+			rs.resuming = nil
+		}
+		rs.mu.Unlock()
+		tc.resume.Store(nil)
+		err := errors.New("conn not exist")
+		utils.LogCtx(log.Warn(), ctx).Err(err).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, err
+	}
+	// 房间转移到新连接
+	if last != nil {
+		s.rooms.Replace(last, tc)
+	}
+
+	// 更换token
+	s.sessionMu.Lock()
+	rs.mu.Lock()
+	if rs.dropped || rs.resuming != tc {
+		rs.mu.Unlock()
+		s.sessionMu.Unlock()
+		utils.LogCtx(log.Warn(), ctx).Err(ErrResumeToken).Msgf("ResumeClient %s error", tc.ConnName())
+		return id, ErrResumeToken // 恢复过程中会话被删除或者tc断开
+	}
+	delete(s.sessions, rs.token)
+	rs.token = newResumeToken()
+	s.sessions[rs.token] = rs
+	rs.tc = tc
+	rs.last, rs.client = nil, nil
+	rs.mu.Unlock()
+	s.sessionMu.Unlock()
+
+	// 锁外重发，重发期间新发送的数据继续缓存，直到缓存为空，保证缓存的数据在新数据之前发送
+	// 正在重发的数据放在replay中，写入后移到pending，tc断开时detachSession把它们放回缓存
+	resend := 0
+	for {
+		rs.mu.Lock()
+		if rs.resuming != tc {
+			rs.mu.Unlock()
+			break // tc断开了 剩余数据继续缓存
+		}
+		if rs.overflow {
+			rs.resuming = nil
+			rs.mu.Unlock()
+			tc.Close(ErrResumeOverflow)
+			utils.LogCtx(log.Warn(), ctx).Err(ErrResumeOverflow).Msgf("ResumeClient %s error", tc.ConnName())
+			return id, ErrResumeOverflow
+		}
+		if len(rs.replay) == 0 {
+			rs.replay, rs.buf = rs.buf, nil
+			if len(rs.replay) == 0 {
+				rs.resuming = nil
+				rs.mu.Unlock()
+				break
+			}
+		}
+		d := rs.replay[0]
+		rs.mu.Unlock()
+		if err := tc.writeConn(d.data, d.text); err != nil {
+			// 关闭tc后会话进入保留期 未重发的数据放回缓存
+			tc.Close(err)
+			utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(d.data)).Msgf("ResumeClient %s resend error", tc.ConnName())
+			return id, err
+		}
+		end := tc.conn.MQPushed()
+		rs.mu.Lock()
+		if rs.resuming == tc {
+			rs.replay = rs.replay[1:]
+			rs.addPending(tc, d, end)
+		}
+		rs.mu.Unlock()
+		resend++
+	}
+	utils.LogCtx(log.Info(), ctx).Int("resend", resend).Msgf("ResumeClient %s", tc.ConnName())
+	return id, nil
+}
+
+// tick协程调用 删除过期的会话
+func (s *TCPServer[ClientId, ClientInfo]) checkSession(now time.Time) {
+	var expired []*resumeSession[ClientInfo]
+	var lasts []*TCPClient[ClientInfo]
+	var clients []interface{}
+	s.sessionMu.Lock()
+	for _, rs := range s.sessions {
+		rs.mu.Lock()
+		expire := rs.tc == nil && rs.resuming == nil && now.After(rs.expire)
+		rs.mu.Unlock()
+		if expire {
+			last, client := s.dropSessionLocked(rs)
+			expired = append(expired, rs)
+			lasts = append(lasts, last)
+			clients = append(clients, client)
+		}
+	}
+	s.sessionMu.Unlock()
+
+	for i, rs := range expired {
+		s.removeDetached(rs.id, lasts[i], clients[i])
+	}
+
+	re, ok := s.event.(TCPResumeEvent[ClientInfo])
+	if !ok {
+		return
+	}
+	for _, rs := range expired {
+		func() {
+			defer utils.HandlePanic()
+			re.OnResumeExpire(utils.CtxSetTrace(context.TODO(), 0, "ResumeExpire"), rs.info)
+		}()
+	}
+}
+
+// 写入连接 开启会话恢复时记录还未写入socket的数据
+// 写入过程中连接断开的数据重新经过会话缓存或者转发到新连接
+func (tc *TCPClient[ClientInfo]) write(data []byte, text bool) error {
+	err := tc.writeConn(data, text)
+	rs := tc.resume.Load()
+	if rs == nil || rs.written(tc, data, text, err) {
+		return err
+	}
+	cur, buffered := rs.send(tc, data, text)
+	if buffered {
+		return nil
+	}
+	if cur != nil {
+		return cur.write(data, text)
+	}
+	return err
+}
+
+// 发送rpc请求 开启会话恢复时断开期间缓存，已恢复的写入新连接
+func (tc *TCPClient[ClientInfo]) writeRPC(data []byte) (bool, error) {
+	if rs := tc.resume.Load(); rs != nil {
+		cur, buffered := rs.send(tc, data, false)
+		if buffered {
+			return true, nil
+		}
+		if cur != nil {
+			return false, cur.write(data, false)
+		}
+	}
+	return false, tc.write(data, false)
+}
+
+// rpc记录 AddClient开启会话恢复后记录在会话中，恢复后新连接也能收到回复
+func (tc *TCPClient[ClientInfo]) rpcMap() *sync.Map {
+	if rs := tc.resume.Load(); rs != nil {
+		rs.mu.Lock()
+		dropped := rs.dropped
+		rs.mu.Unlock()
+		if !dropped {
+			return &rs.rpc
+		}
+	}
+	return tc.rpc
+}
+
+// 收到回复时取出rpc记录
+func (tc *TCPClient[ClientInfo]) rpcLoad(rpcId string) (chan msger.RecvMsger, bool) {
+	rpc, ok := tc.rpc.LoadAndDelete(rpcId)
+	if !ok {
+		if rs := tc.resume.Load(); rs != nil {
+			rpc, ok = rs.rpc.LoadAndDelete(rpcId)
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return rpc.(chan msger.RecvMsger), true
+}
+
+// 超时或者发送失败时删除rpc记录
+func (tc *TCPClient[ClientInfo]) rpcDelete(rpcId string, ch chan msger.RecvMsger) {
+	if tc.rpc.CompareAndDelete(rpcId, ch) {
+		close(ch) // 删除的地方负责关闭
+		return
+	}
+	if rs := tc.resume.Load(); rs != nil && rs.rpc.CompareAndDelete(rpcId, ch) {
+		close(ch)
+	}
+}
+
+// 直接写入连接 不经过会话恢复的检查
+func (tc *TCPClient[ClientInfo]) writeConn(data []byte, text bool) error {
+	var err error
+	if tc.wsh != nil {
+		if text {
+			err = tc.wsh.write(ws.OpText, data)
+		} else {
+			err = tc.wsh.write(ws.OpBinary, data)
+		}
+	} else {
+		err = tc.send(data)
+	}
+	if err == nil {
+		atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
+	}
+	return err
+}
+
+// 写入非websocket连接 开启压缩时压缩后写入
+func (tc *TCPClient[ClientInfo]) send(data []byte) error {
+	if tc.compress != nil {
+		return tc.compress.Send(data)
+	}
+	return tc.sendFrame(data)
+}
+
+// 开启加密时加密后写入
+func (tc *TCPClient[ClientInfo]) sendFrame(data []byte) error {
+	if tc.cipher != nil {
+		return tc.cipher.Send(data)
+	}
+	return tc.conn.Send(data)
+}
+
+// 处理非websocket连接解密后的数据 开启压缩时解压后处理
+func (tc *TCPClient[ClientInfo]) recvFrame(data []byte) (int, error) {
+	if tc.compress != nil {
+		return tc.compress.Recv(data, func(plain []byte) (int, error) {
+			return tc.recv(tc.ctx, plain)
+		})
+	}
+	return tc.recv(tc.ctx, data)
+}
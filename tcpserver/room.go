@@ -0,0 +1,109 @@
+package tcpserver
+
+// https://github.com/yuwf/gobase
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yuwf/gobase/msger"
+	"github.com/yuwf/gobase/utils"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// JoinRoom 连接加入房间 连接断开时自动离开所有房间，开启会话恢复的在会话删除时离开
+func (s *TCPServer[ClientId, ClientInfo]) JoinRoom(name string, tc *TCPClient[ClientInfo]) {
+	// 在锁内检查下是否存在连接，防止和断开时的清理交叉
+	s.rooms.Join(name, tc, func() bool {
+		_, ok := s.connMap.Load(tc.conn)
+		return ok
+	})
+}
+
+// LeaveRoom 连接离开房间
+func (s *TCPServer[ClientId, ClientInfo]) LeaveRoom(name string, tc *TCPClient[ClientInfo]) {
+	s.rooms.Leave(name, tc)
+}
+
+// LeaveAllRoom 连接离开加入的所有房间
+func (s *TCPServer[ClientId, ClientInfo]) LeaveAllRoom(tc *TCPClient[ClientInfo]) {
+	s.rooms.LeaveAll(tc)
+}
+
+// Rooms 连接加入的房间
+func (s *TCPServer[ClientId, ClientInfo]) Rooms(tc *TCPClient[ClientInfo]) []string {
+	return s.rooms.Names(tc)
+}
+
+// RoomCount 房间内的连接数量
+func (s *TCPServer[ClientId, ClientInfo]) RoomCount(name string) int {
+	return s.rooms.Count(name)
+}
+
+// RangeRoom 遍历房间内的连接 f函数返回false 停止遍历 遍历的是快照，不持有锁
+func (s *TCPServer[ClientId, ClientInfo]) RangeRoom(name string, f func(tc *TCPClient[ClientInfo]) bool) {
+	for _, tc := range s.rooms.Members(name) {
+		if !f(tc) {
+			return
+		}
+	}
+}
+
+// BroadcastMsg 给房间内所有连接广播消息，消息只编码一次，所有连接发送相同的数据
+func (s *TCPServer[ClientId, ClientInfo]) BroadcastMsg(ctx context.Context, name string, msg msger.Msger) error {
+	return s.BroadcastMsgExcept(ctx, name, msg, nil)
+}
+
+// BroadcastMsgExcept 给房间内除except之外的所有连接广播消息，except一般为发送者
+func (s *TCPServer[ClientId, ClientInfo]) BroadcastMsgExcept(ctx context.Context, name string, msg msger.Msger, except *TCPClient[ClientInfo]) error {
+	if msg == nil {
+		err := errors.New("msg is empty")
+		utils.LogCtx(log.Error(), ctx).Str("room", name).Msg("BroadcastMsg error")
+		return err
+	}
+	members := s.rooms.Members(name)
+	if len(members) == 0 {
+		return nil
+	}
+	data, err := msg.MsgMarshal()
+	if err != nil {
+		utils.LogCtx(log.Error(), ctx).Err(err).Str("room", name).Interface("msger", msg).Msg("BroadcastMsg error")
+		return err
+	}
+	count := 0
+	for _, tc := range members {
+		if tc == except {
+			continue
+		}
+		// 会话恢复 断开期间缓存，已恢复的转发到新连接
+		if rs := tc.resume.Load(); rs != nil {
+			if cur, buffered := rs.send(tc, data, false); buffered {
+				count++
+				continue
+			} else if cur != nil {
+				tc = cur
+			}
+		}
+		err = tc.write(data, false) // websocket连接各自压缩，开启加密的连接各自加密
+		if err != nil {
+			utils.LogCtx(log.Error(), ctx).Err(err).Str("room", name).Interface("msger", msg).Msgf("BroadcastMsg %s error", tc.ConnName())
+			continue
+		}
+		count++
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range tc.hook {
+				h.OnSendMsg(tc, msg, len(data))
+			}
+		}()
+	}
+	// 日志
+	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(msg)
+	if logLevel >= int(log.Logger.GetLevel()) {
+		utils.LogCtx(log.WithLevel(zerolog.Level(logLevel)), ctx).Str("room", name).Int("count", count).Interface("msger", msg).Msg("BroadcastMsg")
+	}
+	return nil
+}
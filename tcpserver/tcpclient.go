@@ -17,7 +17,6 @@ import (
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/afex/hystrix-go/hystrix"
-	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -36,8 +35,8 @@ type ClientCreater interface {
 type TCPClient[ClientInfo any] struct {
 	// 本身不可修改对象
 	conn       *tcp.TCPConn         // 连接对象
-	removeAddr net.TCPAddr          // 拷贝出来 防止conn关闭时发生变化
-	localAddr  net.TCPAddr          //
+	removeAddr net.Addr             // 拷贝出来 防止conn关闭时发生变化 *net.TCPAddr、*net.UnixAddr或者*net.UDPAddr
+	localAddr  net.Addr             //
 	event      TCPEvent[ClientInfo] // 事件处理器
 	md         *msger.MsgDispatch   // 消息分发
 	hook       []TCPHook[ClientInfo]
@@ -46,6 +45,8 @@ type TCPClient[ClientInfo any] struct {
 	info       *ClientInfo               // 客户端信息 内容修改需要外层加锁控制
 	connName   func() string             // // 日志调使用，输出连接名字，优先会调用ClientInfo.ClientName()函数
 	wsh        *tcpWSHandler[ClientInfo] // websocket处理
+	cipher     *tcp.Cipher               // 数据加密 开启ParamConfig.Cipher的非websocket连接
+	compress   *tcp.Compressor           // 消息压缩 开启ParamConfig.Compress的非websocket连接
 
 	ctx          context.Context // 本连接的上下文
 	lastRecvTime int64           // 最近一次接受数据的时间戳 微妙 原子访问
@@ -54,14 +55,16 @@ type TCPClient[ClientInfo any] struct {
 	//RPC消息使用 [rpcid:chan interface{}]
 	rpc *sync.Map
 
-	closeReason error // 关闭原因
+	closeReason atomic.Pointer[error] // 关闭原因 保留第一个非nil的原因
+
+	resume atomic.Pointer[resumeSession[ClientInfo]] // 会话恢复
 }
 
 func newTCPClient[ClientInfo any](conn *tcp.TCPConn, event TCPEvent[ClientInfo], md *msger.MsgDispatch, hook []TCPHook[ClientInfo]) *TCPClient[ClientInfo] {
 	tc := &TCPClient[ClientInfo]{
 		conn:         conn,
-		removeAddr:   *conn.RemoteAddr(),
-		localAddr:    *conn.LocalAddr(),
+		removeAddr:   conn.RemoteAddr(),
+		localAddr:    conn.LocalAddr(),
 		event:        event,
 		md:           md,
 		hook:         hook,
@@ -91,11 +94,11 @@ func (tc *TCPClient[ClientInfo]) clear() {
 	})
 }
 
-func (tc *TCPClient[ClientInfo]) RemoteAddr() net.TCPAddr {
+func (tc *TCPClient[ClientInfo]) RemoteAddr() net.Addr {
 	return tc.removeAddr
 }
 
-func (tc *TCPClient[ClientInfo]) LocalAddr() net.TCPAddr {
+func (tc *TCPClient[ClientInfo]) LocalAddr() net.Addr {
 	return tc.localAddr
 }
 
@@ -127,6 +130,16 @@ func (tc *TCPClient[ClientInfo]) LastSendTime() time.Time {
 	return time.UnixMicro(atomic.LoadInt64(&tc.lastSendTime))
 }
 
+// 写队列中还未发送的消息数量和字节数
+func (tc *TCPClient[ClientInfo]) MQLen() (int, int64) {
+	return tc.conn.MQLen(), tc.conn.MQBytes()
+}
+
+// 写队列是否处于高水位，业务层可根据此值控制发送频率
+func (tc *TCPClient[ClientInfo]) HighWater() bool {
+	return tc.conn.HighWater()
+}
+
 func (tc *TCPClient[ClientInfo]) Send(ctx context.Context, data []byte) error {
 	var err error
 	if len(data) == 0 {
@@ -134,6 +147,15 @@ func (tc *TCPClient[ClientInfo]) Send(ctx context.Context, data []byte) error {
 		utils.LogCtx(log.Error(), ctx).Msgf("Send %s error", tc.ConnName())
 		return err
 	}
+	// 会话恢复
+	if rs := tc.resume.Load(); rs != nil {
+		if cur, buffered := rs.send(tc, data, false); buffered {
+			utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("Send %s buffered", tc.ConnName())
+			return nil
+		} else if cur != nil {
+			return cur.Send(ctx, data)
+		}
+	}
 	// 回调
 	defer func() {
 		defer utils.HandlePanic()
@@ -142,16 +164,11 @@ func (tc *TCPClient[ClientInfo]) Send(ctx context.Context, data []byte) error {
 		}
 	}()
 	// 发送
-	if tc.wsh != nil {
-		err = wsutil.WriteServerBinary(tc.wsh, data)
-	} else {
-		err = tc.conn.Send(data)
-	}
+	err = tc.write(data, false)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("Send %s error", tc.ConnName())
 		return err
 	}
-	atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
 	// 日志
 	utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("Send %s", tc.ConnName())
 	return nil
@@ -170,6 +187,15 @@ func (tc *TCPClient[ClientInfo]) SendMsg(ctx context.Context, msg msger.Msger) e
 		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", tc.ConnName())
 		return err
 	}
+	// 会话恢复
+	if rs := tc.resume.Load(); rs != nil {
+		if cur, buffered := rs.send(tc, data, false); buffered {
+			utils.LogCtx(log.Debug(), ctx).Interface("msger", msg).Msgf("SendMsg %s buffered", tc.ConnName())
+			return nil
+		} else if cur != nil {
+			return cur.SendMsg(ctx, msg)
+		}
+	}
 	// 回调
 	defer func() {
 		defer utils.HandlePanic()
@@ -178,16 +204,11 @@ func (tc *TCPClient[ClientInfo]) SendMsg(ctx context.Context, msg msger.Msger) e
 		}
 	}()
 	// 发送
-	if tc.wsh != nil {
-		err = wsutil.WriteServerBinary(tc.wsh, data)
-	} else {
-		err = tc.conn.Send(data)
-	}
+	err = tc.write(data, false)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Interface("msger", msg).Msgf("SendMsg %s error", tc.ConnName())
 		return err
 	}
-	atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
 	// 日志
 	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(msg)
 	if logLevel >= int(log.Logger.GetLevel()) {
@@ -203,6 +224,15 @@ func (tc *TCPClient[ClientInfo]) SendText(ctx context.Context, data []byte) erro
 		utils.LogCtx(log.Error(), ctx).Msgf("SendText %s error", tc.ConnName())
 		return err
 	}
+	// 会话恢复
+	if rs := tc.resume.Load(); rs != nil {
+		if cur, buffered := rs.send(tc, data, true); buffered {
+			utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("SendText %s buffered", tc.ConnName())
+			return nil
+		} else if cur != nil {
+			return cur.SendText(ctx, data)
+		}
+	}
 	// 回调
 	defer func() {
 		defer utils.HandlePanic()
@@ -211,16 +241,11 @@ func (tc *TCPClient[ClientInfo]) SendText(ctx context.Context, data []byte) erro
 		}
 	}()
 	// 发送
-	if tc.wsh != nil {
-		err = wsutil.WriteServerText(tc.wsh, data)
-	} else {
-		err = tc.conn.Send(data)
-	}
+	err = tc.write(data, true)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Int("size", len(data)).Msgf("SendText %s error", tc.ConnName())
 		return err
 	}
-	atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
 	// 日志
 	utils.LogCtx(log.Debug(), ctx).Int("size", len(data)).Msgf("SendText %s", tc.ConnName())
 	return nil
@@ -246,17 +271,13 @@ func (tc *TCPClient[ClientInfo]) SendRPCMsg(ctx context.Context, rpcId interface
 
 	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
 	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
-	if _, loaded := tc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+	if _, loaded := tc.rpcMap().LoadOrStore(rpcIdV, ch); loaded {
 		close(ch) // 关闭新创建的channel
 		err := errors.New("rpcId exist")
 		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", tc.ConnName())
 		return nil, err
 	}
-	defer func() {
-		if _, ok := tc.rpc.LoadAndDelete(rpcIdV); ok {
-			close(ch) // 删除的地方负责关闭
-		}
-	}()
+	defer tc.rpcDelete(rpcIdV, ch)
 	// 回调
 	entry := time.Now()
 	defer func() {
@@ -265,17 +286,15 @@ func (tc *TCPClient[ClientInfo]) SendRPCMsg(ctx context.Context, rpcId interface
 			h.OnSendRPCMsg(tc, rpcId, req, time.Since(entry), len(data))
 		}
 	}()
-	// 发送
-	if tc.wsh != nil {
-		err = wsutil.WriteServerBinary(tc.wsh, data)
-	} else {
-		err = tc.conn.Send(data)
-	}
+	// 发送 开启会话恢复时断开期间缓存，恢复后重发
+	buffered, err := tc.writeRPC(data)
 	if err != nil {
 		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendRPCMsg %s error", tc.ConnName())
 		return nil, err
 	}
-	atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
+	if buffered {
+		utils.LogCtx(log.Debug(), ctx).Str("rpcId", rpcIdV).Msgf("SendRPCMsg %s buffered", tc.ConnName())
+	}
 	// 日志
 	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
 	if logLevel >= int(log.Logger.GetLevel()) {
@@ -344,7 +363,7 @@ func (tc *TCPClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId inte
 
 	// 先添加一个channel记录，防止Send还没出来就收到了回复，并且判断是否存在一样的
 	ch := make(chan msger.RecvMsger, 1) // 使用缓冲channel
-	if _, loaded := tc.rpc.LoadOrStore(rpcIdV, ch); loaded {
+	if _, loaded := tc.rpcMap().LoadOrStore(rpcIdV, ch); loaded {
 		close(ch) // 关闭新创建的channel
 		err := errors.New("rpcId exist")
 		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", tc.ConnName())
@@ -358,21 +377,17 @@ func (tc *TCPClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId inte
 			h.OnSendRPCMsg(tc, rpcId, req, time.Since(entry), len(data))
 		}
 	}()
-	// 发送
-	if tc.wsh != nil {
-		err = wsutil.WriteServerBinary(tc.wsh, data)
-	} else {
-		err = tc.conn.Send(data)
-	}
+	// 发送 开启会话恢复时断开期间缓存，恢复后重发
+	buffered, err := tc.writeRPC(data)
 	if err != nil {
 		// 发送失败，先删除channel记录
-		if _, ok := tc.rpc.LoadAndDelete(rpcIdV); ok {
-			close(ch) // 删除的地方负责关闭
-		}
+		tc.rpcDelete(rpcIdV, ch)
 		utils.LogCtx(log.Error(), ctx).Err(err).Str("rpcId", rpcIdV).Interface("msger", req).Msgf("SendAsyncRPCMsg %s error", tc.ConnName())
 		return err
 	}
-	atomic.StoreInt64(&tc.lastSendTime, time.Now().UnixMicro())
+	if buffered {
+		utils.LogCtx(log.Debug(), ctx).Str("rpcId", rpcIdV).Msgf("SendAsyncRPCMsg %s buffered", tc.ConnName())
+	}
 	// 日志
 	logLevel := msger.ParamConf.Get().LogLevel.MsgLevel(req)
 	if logLevel >= int(log.Logger.GetLevel()) {
@@ -381,11 +396,7 @@ func (tc *TCPClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId inte
 
 	// 异步等待回复
 	utils.Submit(func() {
-		defer func() {
-			if _, ok := tc.rpc.LoadAndDelete(rpcIdV); ok {
-				close(ch) // 删除的地方负责关闭
-			}
-		}()
+		defer tc.rpcDelete(rpcIdV, ch)
 		// 等待rpc回复
 		timer := time.NewTimer(timeout)
 		var resp msger.RecvMsger
@@ -461,17 +472,42 @@ func (tc *TCPClient[ClientInfo]) SendAsyncRPCMsg(ctx context.Context, rpcId inte
 
 // 会回调event的OnClose
 // 若想不回调使用 TCPServer.CloseClient
+// websocket连接会先发送关闭帧，err为wsutil.ClosedError时可指定状态码和原因，等客户端回复关闭帧后再关闭连接
 func (tc *TCPClient[ClientInfo]) Close(err error) {
-	tc.closeReason = err
+	tc.setReason(err)
+	if tc.wsh != nil && tc.wsh.close(err) {
+		return
+	}
 	tc.conn.Close(false)
 }
 
 // 会等待关闭完成后返回，TCPServer.OnClose调用完之后返回
+// websocket连接会先发送关闭帧，等关闭帧发送完成后关闭连接，不等待客户端回复
 func (tc *TCPClient[ClientInfo]) CloseWait(err error) {
-	tc.closeReason = err
+	tc.setReason(err)
+	if tc.wsh != nil && tc.wsh.close(err) {
+		for start := time.Now(); !tc.conn.Flushed() && time.Since(start) < wsCloseTimeout; {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
 	tc.conn.Close(true)
 }
 
+// 记录关闭原因 已经有原因的不覆盖
+func (tc *TCPClient[ClientInfo]) setReason(err error) {
+	if err != nil {
+		tc.closeReason.CompareAndSwap(nil, &err)
+	}
+}
+
+// 关闭原因
+func (tc *TCPClient[ClientInfo]) reason() error {
+	if err := tc.closeReason.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
 // 收到数据时调用
 func (tc *TCPClient[ClientInfo]) recv(ctx context.Context, buf []byte) (int, error) {
 	if tc.event == nil {
@@ -513,9 +549,8 @@ func (tc *TCPClient[ClientInfo]) recv(ctx context.Context, buf []byte) (int, err
 			if rpcId != nil {
 				// rpc
 				rpcIdV := fmt.Sprintf("%v", rpcId)
-				rpc, ok := tc.rpc.LoadAndDelete(rpcIdV)
+				ch, ok := tc.rpcLoad(rpcIdV)
 				if ok {
-					ch := rpc.(chan msger.RecvMsger)
 					ch <- mr
 					close(ch) // 删除的地方负责关闭
 				} else {
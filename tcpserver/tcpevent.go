@@ -5,6 +5,7 @@ package tcpserver
 import (
 	"context"
 	"errors"
+	"net"
 	"time"
 
 	"github.com/yuwf/gobase/msger"
@@ -45,6 +46,42 @@ type TCPEvent[ClientInfo any] interface {
 	OnTick(ctx context.Context, tc *TCPClient[ClientInfo])
 }
 
+// TCPEvent可选择实现的接口，连接的写队列达到高水位和回落到低水位时调用，可用来控制发送频率
+// 发送协程或写协程调用，不要阻塞
+type TCPWaterEvent[ClientInfo any] interface {
+	OnHighWater(tc *TCPClient[ClientInfo])
+	OnLowWater(tc *TCPClient[ClientInfo])
+}
+
+// TCPEvent可选择实现的接口，开启ParamConfig.PingInterval后，连接读空闲达到间隔时调用，用来发送服务器心跳
+// 异步顺序调用
+// ctx    包括 [CtxKey_WS],CtxKey_traceId
+type TCPPingEvent[ClientInfo any] interface {
+	OnPing(ctx context.Context, tc *TCPClient[ClientInfo])
+}
+
+// TCPEvent可选择实现的接口，TCPServer.Shutdown时通知每个连接，比如告诉客户端重连到其他服务器
+// 异步顺序调用 调用后会等待连接的写队列发送完成再关闭连接
+// ctx    包括 [CtxKey_WS],CtxKey_traceId
+type TCPShutdownEvent[ClientInfo any] interface {
+	OnShutdown(ctx context.Context, tc *TCPClient[ClientInfo])
+}
+
+// TCPEvent可选择实现的接口，开启ParamConfig.ResumeGrace后使用
+// 断开的会话超过保留时间没有恢复，用户真正离线，info为会话的ClientInfo
+// tick协程调用
+type TCPResumeEvent[ClientInfo any] interface {
+	OnResumeExpire(ctx context.Context, info *ClientInfo)
+}
+
+// TCPEvent可选择实现的接口，websocket握手请求检查通过后，回复客户端之前调用
+// protocols为客户端请求的Sec-WebSocket-Protocol，返回选择的子协议，为空表示不使用子协议，不在protocols中的也不使用
+// 可通过tc.WSPath、WSQuery、WSCookie获取请求信息做验证，返回error拒绝握手，默认回复403，可返回ws.RejectConnectionError指定状态码
+// 网络协程调用
+type TCPWSUpgradeEvent[ClientInfo any] interface {
+	OnWSUpgrade(tc *TCPClient[ClientInfo], protocols []string) (string, error)
+}
+
 // TCPEventHandler TCPEvent的内置实现
 // 如果不想实现TCPEvent的所有接口，可以继承它实现部分方法
 type TCPEventHandler[ClientInfo any] struct {
@@ -79,9 +116,9 @@ type TCPHook[ClientInfo any] interface {
 	// 添加Client
 	OnRemoveClient(tc *TCPClient[ClientInfo])
 
-	// 发送数据 所有的发送
+	// 发送数据 所有的发送 websocket开启压缩时为压缩后的大小
 	OnSendData(tc *TCPClient[ClientInfo], len int)
-	// 接受数据 所有的接受
+	// 接受数据 所有的接受 websocket开启压缩时为压缩后的大小
 	OnRecvData(tc *TCPClient[ClientInfo], len int)
 
 	// Send后调用
@@ -98,3 +135,29 @@ type TCPHook[ClientInfo any] interface {
 	// 定时调用
 	OnTick()
 }
+
+// TCPHook可选择实现的接口，连接被准入控制拒绝时调用 reason为tcp.ErrAdmit*
+type TCPRejectHook interface {
+	OnReject(addr net.Addr, reason error)
+}
+
+// TCPHook可选择实现的接口，开启加密的连接握手失败或者解密失败时调用 err为tcp.ErrCipher*或者连接的错误
+type TCPCipherHook[ClientInfo any] interface {
+	OnCipherFail(tc *TCPClient[ClientInfo], err error)
+}
+
+// TCPHook可选择实现的接口，开启压缩的连接发送或者接受一个消息后调用，send表示发送，rawLen为原始长度，len为压缩后的长度，未压缩的消息两者相同
+type TCPCompressHook[ClientInfo any] interface {
+	OnCompress(tc *TCPClient[ClientInfo], send bool, rawLen, len int)
+}
+
+// TCPHook可选择实现的接口，写队列达到高水位和回落到低水位时调用
+type TCPWaterHook[ClientInfo any] interface {
+	OnHighWater(tc *TCPClient[ClientInfo])
+	OnLowWater(tc *TCPClient[ClientInfo])
+}
+
+// TCPHook可选择实现的接口，写队列满了MQPolicyDropOldest丢弃数据时调用，len为丢弃数据的长度
+type TCPDropHook[ClientInfo any] interface {
+	OnDropOldest(tc *TCPClient[ClientInfo], len int)
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -14,11 +15,20 @@ import (
 
 	"github.com/yuwf/gobase/msger"
 	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/udp"
 	"github.com/yuwf/gobase/utils"
 
+	"github.com/gobwas/ws"
 	"github.com/rs/zerolog/log"
 )
 
+// 服务器超时检查关闭连接的原因 TCPHook.OnDisConnect的closeReason
+var (
+	ErrReadIdleTimeout  = errors.New("read idle timeout")
+	ErrHandShakeTimeout = errors.New("handshake timeout")
+	ErrServerShutdown   = errors.New("server shutdown")
+)
+
 // TCPServer
 // ClientId客户端ID类型
 // ClientInfo是和业务相关的客户端信息结构类型
@@ -45,6 +55,17 @@ type TCPServer[ClientId any, ClientInfo any] struct {
 	// 请求处理完后回调 不使用锁，默认要求提前注册好
 	hook []TCPHook[ClientInfo]
 
+	// 连接准入控制
+	admission *tcp.Admission
+
+	// 房间
+	rooms *utils.Rooms[*TCPClient[ClientInfo]]
+
+	// 会话恢复 [token:*resumeSession] [ClientId:*resumeSession]
+	sessionMu  sync.Mutex
+	sessions   map[string]*resumeSession[ClientInfo]
+	sessionIds map[interface{}]*resumeSession[ClientInfo]
+
 	// 外部要求退出
 	quit chan int // 退出chan 外部写 内部读
 }
@@ -53,11 +74,27 @@ type TCPServer[ClientId any, ClientInfo any] struct {
 type tClient[ClientId any, ClientInfo any] struct {
 	tc *TCPClient[ClientInfo]
 	id ClientId // 调用GNetServer.AddClient设置的id 目前无锁 不存在复杂使用
+
+	added    int32     // 是否调用过AddClient 原子访问
+	connTime time.Time // 连接时间
+	pingTime time.Time // 上次回调OnPing的时间 tick协程访问
 }
 
 // 创建服务器
 // Msg表示消息类型，必须实现util.Msger接口，否则消息无法分发
 func NewTCPServer[ClientId any, ClientInfo any, Msg any](port int, event TCPEvent[ClientInfo]) (*TCPServer[ClientId, ClientInfo], error) {
+	return NewTCPServerWithAddr[ClientId, ClientInfo, Msg](fmt.Sprintf(":%d", port), event)
+}
+
+// 创建UDP可靠传输服务器 事件回调、消息分发、RPC和Hook与TCP完全一致
+// Msg表示消息类型，必须实现util.Msger接口，否则消息无法分发
+func NewUDPServer[ClientId any, ClientInfo any, Msg any](port int, event TCPEvent[ClientInfo]) (*TCPServer[ClientId, ClientInfo], error) {
+	return NewTCPServerWithAddr[ClientId, ClientInfo, Msg](fmt.Sprintf("%s:%d", tcp.UDPScheme, port), event)
+}
+
+// 创建服务器 address格式 host:port、unix:///path/to/xxx.sock 或者 udp://host:port
+// Msg表示消息类型，必须实现util.Msger接口，否则消息无法分发
+func NewTCPServerWithAddr[ClientId any, ClientInfo any, Msg any](address string, event TCPEvent[ClientInfo]) (*TCPServer[ClientId, ClientInfo], error) {
 	md, err := msger.NewMsgDispatch[Msg, TCPClient[ClientInfo]]()
 	if err != nil {
 		return nil, err
@@ -65,11 +102,15 @@ func NewTCPServer[ClientId any, ClientInfo any, Msg any](port int, event TCPEven
 	s := &TCPServer[ClientId, ClientInfo]{
 		TCPConnEvenHandle: new(tcp.TCPConnEvenHandle),
 		MsgDispatch:       md,
-		Address:           fmt.Sprintf(":%d", port),
+		Address:           address,
 		event:             event,
 		state:             0,
 		connMap:           new(sync.Map),
 		clientMap:         new(sync.Map),
+		admission:         tcp.NewAdmission(),
+		rooms:             utils.NewRooms[*TCPClient[ClientInfo]](),
+		sessions:          map[string]*resumeSession[ClientInfo]{},
+		sessionIds:        map[interface{}]*resumeSession[ClientInfo]{},
 		quit:              make(chan int),
 	}
 
@@ -98,19 +139,21 @@ func NewTCPServerWithWS[ClientId any, ClientInfo any, Msg any](port int, event T
 		state:             0,
 		connMap:           new(sync.Map),
 		clientMap:         new(sync.Map),
+		admission:         tcp.NewAdmission(),
+		rooms:             utils.NewRooms[*TCPClient[ClientInfo]](),
+		sessions:          map[string]*resumeSession[ClientInfo]{},
+		sessionIds:        map[interface{}]*resumeSession[ClientInfo]{},
 		quit:              make(chan int),
 	}
 
 	if certFile != "" || keyFile != "" {
-		var err error
-		config := &tls.Config{}
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
+		// 证书文件修改后自动重新加载
+		cert, err := tcp.NewCertReloader(certFile, keyFile)
 		if err != nil {
 			log.Error().Err(err).Str("Addr", s.Address).Msg("NewTCPServerWithWS error")
 			return nil, err
 		}
-		s.listener, err = tcp.NewTCPListenerTLSConfig(s.Address, s, config)
+		s.listener, err = tcp.NewTCPListenerTLSConfig(s.Address, s, cert.TLSConfig())
 		if err != nil {
 			log.Error().Err(err).Str("Addr", s.Address).Msg("NewTCPServerWithWS error")
 			return nil, err
@@ -138,6 +181,10 @@ func NewTCPServerWithWS2[ClientId any, ClientInfo any](port int, event TCPEvent[
 		state:             0,
 		connMap:           new(sync.Map),
 		clientMap:         new(sync.Map),
+		admission:         tcp.NewAdmission(),
+		rooms:             utils.NewRooms[*TCPClient[ClientInfo]](),
+		sessions:          map[string]*resumeSession[ClientInfo]{},
+		sessionIds:        map[interface{}]*resumeSession[ClientInfo]{},
 		quit:              make(chan int),
 	}
 
@@ -186,6 +233,14 @@ func (s *TCPServer[ClientId, ClientInfo]) Start(reusePort bool) error {
 		s.event.OnMsgReg(s.MsgDispatch)
 	}
 
+	s.listener.ProxyConf = func() *tcp.ProxyProtoConfig {
+		return &ParamConf.Get().Proxy
+	}
+	s.listener.UDPListen = func(pc net.PacketConn) net.Listener {
+		return udp.NewListener(pc, func() *udp.Config {
+			return &ParamConf.Get().UDP
+		})
+	}
 	err := s.listener.Start(reusePort)
 	if err != nil {
 		atomic.StoreInt32(&s.state, 0)
@@ -215,14 +270,118 @@ func (s *TCPServer[ClientId, ClientInfo]) Stop() error {
 	return nil
 }
 
+// Shutdown 优雅关闭，滚动发布时使用，按顺序执行
+// 1.关闭监听，不再接受新连接
+// 2.event实现了TCPShutdownEvent时，通知每个连接
+// 3.等待MsgDispatch消息处理完成、连接的顺序消息处理完成、连接的写队列发送完成
+// 4.关闭剩余连接，关闭原因为ErrServerShutdown，等待连接断开回调完成
+// 都在ctx的截止时间内完成，超时后直接关闭剩余连接，返回ctx.Err()
+func (s *TCPServer[ClientId, ClientInfo]) Shutdown(ctx context.Context) error {
+	log.Info().Str("Addr", s.Address).Msg("TCPServer Shutdown begin")
+
+	// 关闭监听
+	if atomic.CompareAndSwapInt32(&s.state, 1, 0) {
+		if err := s.listener.Close(); err != nil {
+			log.Error().Err(err).Str("Addr", s.Address).Msg("TCPServer Shutdown error")
+		}
+	}
+
+	// 通知客户端
+	if se, ok := s.event.(TCPShutdownEvent[ClientInfo]); ok {
+		s.connMap.Range(func(key, value interface{}) bool {
+			tc := value.(*tClient[ClientId, ClientInfo]).tc
+			tc.seq.Submit(func() {
+				se.OnShutdown(utils.CtxSetTrace(tc.ctx, 0, "Shutdown"), tc)
+			})
+			return true
+		})
+	}
+
+	// 等待消息处理完成
+	err := s.drain(ctx)
+
+	// 关闭剩余连接
+	s.connMap.Range(func(key, value interface{}) bool {
+		value.(*tClient[ClientId, ClientInfo]).tc.Close(ErrServerShutdown)
+		return true
+	})
+	if err == nil {
+		err = s.waitUntil(ctx, func() bool {
+			count, _ := s.ConnCount()
+			return count == 0
+		})
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("Addr", s.Address).Msg("TCPServer Shutdown timeout")
+		return err
+	}
+	log.Info().Str("Addr", s.Address).Msg("TCPServer Shutdown")
+	return nil
+}
+
+// 等待消息处理完成和写队列发送完成
+func (s *TCPServer[ClientId, ClientInfo]) drain(ctx context.Context) error {
+	if s.MsgDispatch != nil {
+		timeout := time.Duration(math.MaxInt64)
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		done := make(chan struct{})
+		go func() {
+			s.MsgDispatch.WaitAllMsgDone(timeout)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.waitUntil(ctx, func() bool {
+		idle := true
+		s.connMap.Range(func(key, value interface{}) bool {
+			tc := value.(*tClient[ClientId, ClientInfo]).tc
+			mqLen, mqBytes := tc.MQLen()
+			if tc.RecvSeqCount() > 0 || mqLen > 0 || mqBytes > 0 {
+				idle = false
+			}
+			return idle
+		})
+		return idle
+	})
+}
+
+// 定时检查f 直到返回true或者ctx结束
+func (s *TCPServer[ClientId, ClientInfo]) waitUntil(ctx context.Context, f func() bool) error {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for !f() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // 添加用户映射
+// 开启ParamConfig.ResumeGrace后，同时创建会话，通过TCPClient.ResumeToken获取token
 func (s *TCPServer[ClientId, ClientInfo]) AddClient(id ClientId, tc *TCPClient[ClientInfo]) {
+	if s.addClient(id, tc) {
+		s.newSession(id, tc)
+	}
+}
+
+func (s *TCPServer[ClientId, ClientInfo]) addClient(id ClientId, tc *TCPClient[ClientInfo]) bool {
 	// 先检查下是否存在连接
 	client, ok := s.connMap.Load(tc.conn)
 	if !ok {
-		return
+		return false
 	}
 	client.(*tClient[ClientId, ClientInfo]).id = id
+	atomic.StoreInt32(&client.(*tClient[ClientId, ClientInfo]).added, 1)
 	s.clientMap.Store(id, client)
 
 	// 回调回调hook
@@ -232,6 +391,7 @@ func (s *TCPServer[ClientId, ClientInfo]) AddClient(id ClientId, tc *TCPClient[C
 			h.OnAddClient(tc)
 		}
 	}()
+	return true
 }
 
 func (s *TCPServer[ClientId, ClientInfo]) GetClient(id ClientId) *TCPClient[ClientInfo] {
@@ -242,7 +402,9 @@ func (s *TCPServer[ClientId, ClientInfo]) GetClient(id ClientId) *TCPClient[Clie
 	return nil
 }
 
+// 会删除会话
 func (s *TCPServer[ClientId, ClientInfo]) RemoveClient(id ClientId) *TCPClient[ClientInfo] {
+	s.dropSession(id)
 	client, ok := s.clientMap.Load(id)
 	if ok {
 		s.clientMap.Delete(id)
@@ -262,13 +424,16 @@ func (s *TCPServer[ClientId, ClientInfo]) RemoveClient(id ClientId) *TCPClient[C
 
 // 主动关闭 不会回调event的OnDisConnect
 // 使用TCPClient.Close会回调OnDisConnect
+// 会删除会话
 func (s *TCPServer[ClientId, ClientInfo]) CloseClient(id ClientId, err error) {
+	s.dropSession(id)
 	client, ok := s.clientMap.Load(id)
 	if ok {
 		tc := client.(*tClient[ClientId, ClientInfo]).tc
 		log.Info().Err(err).Msgf("Closed CloseClient %s", tc.ConnName()) // 日志为Closed 便于和下面的OnClosed统一查找
 		s.connMap.Delete(tc.conn)
 		s.clientMap.Delete(id)
+		s.LeaveAllRoom(tc)
 		tc.Close(nil) // 会回调TCPServer的OnClosed 所以上面先删除对象
 		tc.clear()
 
@@ -331,21 +496,95 @@ func (s *TCPServer[ClientId, ClientInfo]) OnShutdown() {
 
 func (s *TCPServer[ClientId, ClientInfo]) OnAccept(c net.Conn) {
 	logOut := !ParamConf.Get().IsIgnoreIp(c.RemoteAddr().String())
+
+	// 准入控制
+	ip := tcp.AddrIP(c.RemoteAddr())
+	if err := s.admission.Admit(&ParamConf.Get().Admission, ip); err != nil {
+		addr := tcp.PeerAddr(c)
+		if logOut {
+			log.Warn().Err(err).Str("RemoveAddr", addr.String()).Str("LocalAddr", c.LocalAddr().String()).Msg("OnAccept reject")
+		}
+		c.Close()
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				if rh, ok := h.(TCPRejectHook); ok {
+					rh.OnReject(addr, err)
+				}
+			}
+		}()
+		return
+	}
+
+	conf := ParamConf.Get()
+	conn, err := tcp.NewTCPConnedWithConfig(c, s, &conf.Conn)
+	if err != nil {
+		log.Error().Err(err).Str("RemoveAddr", tcp.PeerAddr(c).String()).Str("LocalAddr", c.LocalAddr().String()).Msg("OnAccept NewTCPConned error")
+		c.Close()
+		// 没有创建TCPConn 不会回调OnDisConnect 这里释放准入
+		s.admission.Release(ip)
+		return
+	}
 	if logOut {
-		log.Info().Str("RemoveAddr", c.RemoteAddr().String()).Str("LocalAddr", c.LocalAddr().String()).Msg("OnAccept")
+		l := log.Info().Str("RemoveAddr", conn.RemoteAddr().String()).Str("LocalAddr", c.LocalAddr().String())
+		if proxyAddr := tcp.ProxyAddr(c); proxyAddr != nil {
+			l = l.Str("ProxyAddr", proxyAddr.String())
+		}
+		l.Msg("OnAccept")
 	}
 
-	conn, _ := tcp.NewTCPConned(c, s)
 	tc := newTCPClient(conn, s.event, s.MsgDispatch, s.hook)
 	if s.Scheme == "ws" {
 		tc.ctx = context.WithValue(tc.ctx, CtxKey_WS, 1)
 		tc.wsh = newTCPWSHandler(tc)
+	} else if conf.Cipher.Enable {
+		err := tcp.CheckCipherConn(&conf.Conn)
+		if err == nil {
+			tc.cipher, err = tcp.NewCipher(conf.Cipher, true, conn.Send)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("RemoveAddr", conn.RemoteAddr().String()).Msg("OnAccept NewCipher error")
+			conn.Close(false)
+			// 回调
+			func() {
+				defer utils.HandlePanic()
+				for _, h := range s.hook {
+					if ch, ok := h.(TCPCipherHook[ClientInfo]); ok {
+						ch.OnCipherFail(tc, err)
+					}
+				}
+			}()
+			return
+		}
+	}
+	if s.Scheme != "ws" && conf.Compress.Enable {
+		var err error
+		tc.compress, err = tcp.NewCompressor(conf.Compress, true, tc.sendFrame, func(send bool, rawLen, len int) {
+			// 回调
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				if ch, ok := h.(TCPCompressHook[ClientInfo]); ok {
+					ch.OnCompress(tc, send, rawLen, len)
+				}
+			}
+		})
+		if err != nil {
+			log.Error().Err(err).Str("RemoveAddr", conn.RemoteAddr().String()).Msg("OnAccept NewCompressor error")
+			conn.Close(false)
+			return
+		}
 	}
 	client := &tClient[ClientId, ClientInfo]{
-		tc: tc,
+		tc:       tc,
+		connTime: time.Now(),
 	}
 	// 给gc.connName赋值 优先调用对象的ClientName函数
 	connName := func() string {
+		// id在added置1前赋值 先检查added
+		if atomic.LoadInt32(&client.added) == 0 {
+			return tc.removeAddr.String()
+		}
 		name := fmt.Sprintf("%v", client.id)
 		if len(name) == 0 || name == "0" {
 			return tc.removeAddr.String()
@@ -364,7 +603,7 @@ func (s *TCPServer[ClientId, ClientInfo]) OnAccept(c net.Conn) {
 		}
 	}
 	s.connMap.Store(conn, client)
-	if s.event != nil {
+	if s.event != nil && tc.cipher == nil { // 开启加密的连接握手完成后回调
 		tc.seq.Submit(func() {
 			ctx := utils.CtxSetTrace(tc.ctx, 0, "Connected")
 			s.event.OnConnected(ctx, tc)
@@ -381,20 +620,45 @@ func (s *TCPServer[ClientId, ClientInfo]) OnAccept(c net.Conn) {
 }
 
 func (s *TCPServer[ClientId, ClientInfo]) OnDisConnect(err error, c *tcp.TCPConn) error {
+	// 所有的TCPConn都是准入控制通过后创建的
+	s.admission.Release(tcp.AddrIP(c.RemoteAddr()))
+
 	client, ok := s.connMap.Load(c)
 	if ok {
 		tc := client.(*tClient[ClientId, ClientInfo]).tc
+		if reason := tc.reason(); reason != nil {
+			err = reason
+		}
 		logOut := !ParamConf.Get().IsIgnoreIp(tc.removeAddr.String())
 		if logOut {
-			if tc.closeReason != nil {
-				err = tc.closeReason
-			}
 			log.Info().Err(err).Str("RemoveAddr", tc.removeAddr.String()).Msgf("OnDisConnect %s", tc.ConnName())
 		}
 		s.connMap.Delete(c)
-		_, delClient := s.clientMap.LoadAndDelete(client.(*tClient[ClientId, ClientInfo]).id)
+		delClient := false
+		// 会话进入保留期时保持id映射和房间，会话删除时再清理
+		if !s.detachSession(tc, client) {
+			if atomic.LoadInt32(&client.(*tClient[ClientId, ClientInfo]).added) == 1 {
+				// 会话恢复后id可能已经映射到新的连接
+				delClient = s.clientMap.CompareAndDelete(client.(*tClient[ClientId, ClientInfo]).id, client)
+			}
+			s.LeaveAllRoom(tc)
+		}
 		tc.clear()
-		if s.event != nil {
+		if tc.cipher != nil {
+			if cerr := tc.cipher.Fail(); cerr != nil {
+				log.Warn().Err(cerr).Str("RemoveAddr", tc.removeAddr.String()).Msgf("CipherFail %s", tc.ConnName())
+				// 回调
+				func() {
+					defer utils.HandlePanic()
+					for _, h := range s.hook {
+						if ch, ok := h.(TCPCipherHook[ClientInfo]); ok {
+							ch.OnCipherFail(tc, cerr)
+						}
+					}
+				}()
+			}
+		}
+		if s.event != nil && (tc.cipher == nil || tc.cipher.Established()) {
 			tc.seq.Submit(func() {
 				ctx := utils.CtxSetTrace(tc.ctx, 0, "DisConnected")
 				s.event.OnDisConnect(ctx, tc)
@@ -433,7 +697,7 @@ func (s *TCPServer[ClientId, ClientInfo]) OnRecv(data []byte, c *tcp.TCPConn) (i
 				// 查找真正的ip
 				addr := utils.ClientTCPIPHeader(tc.wsh.Header)
 				if addr != nil {
-					tc.removeAddr = *addr
+					tc.removeAddr = addr
 				}
 				log.Info().Str("RemoveAddr", tc.removeAddr.String()+"("+tc.conn.RemoteAddr().String()+")").Interface("Header", tc.wsh.Header).Msg("HandShake")
 
@@ -446,8 +710,19 @@ func (s *TCPServer[ClientId, ClientInfo]) OnRecv(data []byte, c *tcp.TCPConn) (i
 				}()
 			}
 			return len, err // 返回err后会关闭连接，并调用OnDisConnect
+		} else if tc.cipher != nil {
+			// 先解密 握手完成时回调OnConnected
+			return tc.cipher.Recv(data, func() {
+				log.Debug().Str("RemoveAddr", tc.removeAddr.String()).Msgf("CipherHandShake %s", tc.ConnName())
+				if s.event != nil {
+					tc.seq.Submit(func() {
+						ctx := utils.CtxSetTrace(tc.ctx, 0, "Connected")
+						s.event.OnConnected(ctx, tc)
+					})
+				}
+			}, tc.recvFrame)
 		} else {
-			len, err := tc.recv(tc.ctx, data)
+			len, err := tc.recvFrame(data)
 			return len, err // 返回err后会关闭连接，并调用OnDisConnect
 		}
 	}
@@ -469,6 +744,71 @@ func (s *TCPServer[ClientId, ClientInfo]) OnSend(data []byte, c *tcp.TCPConn) ([
 	return data, nil
 }
 
+func (s *TCPServer[ClientId, ClientInfo]) OnHighWater(c *tcp.TCPConn) {
+	client, ok := s.connMap.Load(c)
+	if ok {
+		tc := client.(*tClient[ClientId, ClientInfo]).tc
+		log.Warn().Int("MQLen", c.MQLen()).Int64("MQBytes", c.MQBytes()).Msgf("HighWater %s", tc.ConnName())
+		if we, ok := s.event.(TCPWaterEvent[ClientInfo]); ok {
+			func() {
+				defer utils.HandlePanic()
+				we.OnHighWater(tc)
+			}()
+		}
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				if wh, ok := h.(TCPWaterHook[ClientInfo]); ok {
+					wh.OnHighWater(tc)
+				}
+			}
+		}()
+	}
+}
+
+func (s *TCPServer[ClientId, ClientInfo]) OnLowWater(c *tcp.TCPConn) {
+	client, ok := s.connMap.Load(c)
+	if ok {
+		tc := client.(*tClient[ClientId, ClientInfo]).tc
+		log.Info().Int("MQLen", c.MQLen()).Int64("MQBytes", c.MQBytes()).Msgf("LowWater %s", tc.ConnName())
+		if we, ok := s.event.(TCPWaterEvent[ClientInfo]); ok {
+			func() {
+				defer utils.HandlePanic()
+				we.OnLowWater(tc)
+			}()
+		}
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				if wh, ok := h.(TCPWaterHook[ClientInfo]); ok {
+					wh.OnLowWater(tc)
+				}
+			}
+		}()
+	}
+}
+
+func (s *TCPServer[ClientId, ClientInfo]) OnDropOldest(data []byte, c *tcp.TCPConn) {
+	client, ok := s.connMap.Load(c)
+	if ok {
+		tc := client.(*tClient[ClientId, ClientInfo]).tc
+		if c.MQDropped() == 1 { // 每个连接只输出第一次 后面的通过Hook统计
+			log.Warn().Int("MQLen", c.MQLen()).Int64("MQBytes", c.MQBytes()).Msgf("DropOldest %s", tc.ConnName())
+		}
+		// 回调
+		func() {
+			defer utils.HandlePanic()
+			for _, h := range s.hook {
+				if dh, ok := h.(TCPDropHook[ClientInfo]); ok {
+					dh.OnDropOldest(tc, len(data))
+				}
+			}
+		}()
+	}
+}
+
 func (s *TCPServer[ClientId, ClientInfo]) loopTick() {
 	for {
 		// 每秒tick下
@@ -485,17 +825,23 @@ func (s *TCPServer[ClientId, ClientInfo]) loopTick() {
 			return
 		case <-timer.C:
 		}
-		if s.event != nil {
-			s.connMap.Range(func(key, value interface{}) bool {
-				tclient := value.(*tClient[ClientId, ClientInfo])
+		conf := ParamConf.Get()
+		now := time.Now()
+		s.checkSession(now)
+		s.connMap.Range(func(key, value interface{}) bool {
+			tclient := value.(*tClient[ClientId, ClientInfo])
+			if s.checkTimeout(tclient, conf, now) {
+				return true
+			}
+			if s.event != nil {
 				tc := tclient.tc
 				ctx := utils.CtxSetTrace(tc.ctx, 0, "Tick")
 				tc.seq.Submit(func() {
 					s.event.OnTick(ctx, tc)
 				})
-				return true
-			})
-		}
+			}
+			return true
+		})
 		// 回调
 		func() {
 			defer utils.HandlePanic()
@@ -505,3 +851,32 @@ func (s *TCPServer[ClientId, ClientInfo]) loopTick() {
 		}()
 	}
 }
+
+// 检查握手超时和读空闲超时，返回true表示已关闭连接
+// 读空闲达到PingInterval时回调TCPPingEvent.OnPing，没有实现时websocket连接发送ping帧
+func (s *TCPServer[ClientId, ClientInfo]) checkTimeout(client *tClient[ClientId, ClientInfo], conf *ParamConfig, now time.Time) bool {
+	tc := client.tc
+	if conf.HandShakeTimeout > 0 && atomic.LoadInt32(&client.added) == 0 && now.Sub(client.connTime) >= time.Duration(conf.HandShakeTimeout)*time.Second {
+		tc.Close(ErrHandShakeTimeout)
+		return true
+	}
+	idle := now.Sub(tc.LastRecvTime())
+	if conf.ReadIdleTimeout > 0 && idle >= time.Duration(conf.ReadIdleTimeout)*time.Second {
+		tc.Close(ErrReadIdleTimeout)
+		return true
+	}
+	if interval := time.Duration(conf.PingInterval) * time.Second; interval > 0 && idle >= interval && now.Sub(client.pingTime) >= interval {
+		if pe, ok := s.event.(TCPPingEvent[ClientInfo]); ok {
+			client.pingTime = now
+			ctx := utils.CtxSetTrace(tc.ctx, 0, "Ping")
+			tc.seq.Submit(func() {
+				pe.OnPing(ctx, tc)
+			})
+		} else if tc.wsh != nil && tc.wsh.upgraded() {
+			// websocket没有实现OnPing时 发送ping帧
+			client.pingTime = now
+			tc.wsh.write(ws.OpPing, nil)
+		}
+	}
+	return false
+}
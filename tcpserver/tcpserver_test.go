@@ -1,8 +1,17 @@
 package tcpserver
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -11,10 +20,16 @@ import (
 	"github.com/yuwf/gobase/goredis"
 	_ "github.com/yuwf/gobase/log"
 	"github.com/yuwf/gobase/nacos"
+	"github.com/yuwf/gobase/tcp"
+	"github.com/yuwf/gobase/udp"
 	"github.com/yuwf/gobase/utils"
 
 	"github.com/yuwf/gobase/msger"
 
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -233,3 +248,1259 @@ func BenchmarkTCPServerWS(b *testing.B) {
 
 	utils.ExitWait()
 }
+
+// 对比合并写入的吞吐 go test -bench=TCPServerWriteBatch -run=^$
+func BenchmarkTCPServerWriteBatch(b *testing.B) {
+	for _, n := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("batch%d", n), func(b *testing.B) {
+			benchmarkTCPServerWrite(b, n, 128)
+		})
+	}
+}
+
+func benchmarkTCPServerWrite(b *testing.B, batch, size int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	c, err := l.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	// 连接单独的参数 不修改全局配置
+	tc, err := tcp.NewTCPConnedWithConfig(c, &tcp.TCPConnEvenHandle{}, &tcp.TCPConnConfig{WriteBatch: batch})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tc.Close(true)
+
+	total := int64(b.N) * int64(size)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.CopyN(io.Discard, conn, total)
+		done <- err
+	}()
+
+	data := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tc.Send(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestTCPServerUnix(t *testing.T) {
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	h := NewHandler()
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := utils.TestDecodeMsg(buf[:n])
+	if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+
+	server.RangeClient(func(tc *TCPClient[ClientInfo]) bool {
+		if tc.RemoteAddr().Network() != "unix" || tc.ConnName() == "" {
+			t.Errorf("client addr %s %q", tc.RemoteAddr().Network(), tc.ConnName())
+		}
+		return true
+	})
+}
+
+func TestTCPServerAcceptError(t *testing.T) {
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, NewHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// net.Pipe不支持创建TCPConn 要关闭连接并释放准入
+	c1, c2 := net.Pipe()
+	server.OnAccept(c1)
+	if n := server.admission.Count(); n != 0 {
+		t.Fatal("admission", n)
+	}
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal(err)
+	}
+}
+
+// 只关注准入拒绝的Hook
+type rejectHook struct {
+	rejects chan error
+}
+
+func (h *rejectHook) OnConnected(tc *TCPClient[ClientInfo])                                {}
+func (h *rejectHook) OnWSHandShake(tc *TCPClient[ClientInfo])                              {}
+func (h *rejectHook) OnDisConnect(tc *TCPClient[ClientInfo], removeClient bool, err error) {}
+func (h *rejectHook) OnAddClient(tc *TCPClient[ClientInfo])                                {}
+func (h *rejectHook) OnRemoveClient(tc *TCPClient[ClientInfo])                             {}
+func (h *rejectHook) OnSendData(tc *TCPClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnRecvData(tc *TCPClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnSend(tc *TCPClient[ClientInfo], len int)                            {}
+func (h *rejectHook) OnSendMsg(tc *TCPClient[ClientInfo], mr msger.Msger, len int)         {}
+func (h *rejectHook) OnSendText(tc *TCPClient[ClientInfo], len int)                        {}
+func (h *rejectHook) OnSendRPCMsg(tc *TCPClient[ClientInfo], rpcId interface{}, mr msger.Msger, elapsed time.Duration, len int) {
+}
+func (h *rejectHook) OnRecvMsg(tc *TCPClient[ClientInfo], mr msger.RecvMsger, len int) {}
+func (h *rejectHook) OnTick()                                                          {}
+
+func (h *rejectHook) OnReject(addr net.Addr, reason error) {
+	h.rejects <- reason
+}
+
+func TestTCPServerAdmission(t *testing.T) {
+	defer loadParamConf(t, nil)
+	loadParamConf(t, func(conf *ParamConfig) { conf.Admission.MaxConn = 2 })
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, NewHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := &rejectHook{rejects: make(chan error, 8)}
+	server.RegHook(hook)
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	waitCount := func(n int) {
+		for i := 0; i < 250 && server.admission.Count() != n; i++ {
+			time.Sleep(time.Millisecond * 20)
+		}
+		if c := server.admission.Count(); c != n {
+			t.Fatalf("admission count %d want %d", c, n)
+		}
+	}
+	// 被拒绝的连接回调OnReject并关闭
+	waitReject := func(conn net.Conn, want error) {
+		select {
+		case err := <-hook.rejects:
+			if err != want {
+				t.Fatalf("reject %v want %v", err, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("wait reject timeout")
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+
+	conn1 := dial()
+	defer conn1.Close()
+	conn2 := dial()
+	defer conn2.Close()
+	waitCount(2)
+	conn3 := dial()
+	defer conn3.Close()
+	waitReject(conn3, tcp.ErrAdmitMaxConn)
+
+	// 断开后释放
+	conn1.Close()
+	waitCount(1)
+	conn4 := dial()
+	defer conn4.Close()
+	waitCount(2)
+
+	// 热更新
+	loadParamConf(t, func(conf *ParamConfig) { conf.Admission.MaxConn = 3 })
+	conn5 := dial()
+	defer conn5.Close()
+	waitCount(3)
+	conn6 := dial()
+	defer conn6.Close()
+	waitReject(conn6, tcp.ErrAdmitMaxConn)
+	if len(hook.rejects) != 0 {
+		t.Fatal("reject count")
+	}
+}
+
+func TestTCPServerUDP(t *testing.T) {
+	// 两端都模拟丢包
+	loadParamConf(t, func(conf *ParamConfig) { conf.UDP = udp.Config{DropRate: 0.2} })
+	defer loadParamConf(t, nil)
+
+	h := NewHandler()
+	server, err := NewUDPServer[int, ClientInfo, utils.TestMsg](1261, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := udp.Dial("127.0.0.1:1261", &udp.Config{DropRate: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const count = 100
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	for i := 0; i < count; i++ {
+		if _, err := conn.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pending []byte
+	buf := make([]byte, 4096)
+	for recv := 0; recv < count; {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d %v", recv, err)
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			m, l, err := utils.TestDecodeMsg(pending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				break
+			}
+			if m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+				t.Fatalf("resp %v", m)
+			}
+			pending = pending[l:]
+			recv++
+		}
+	}
+
+	server.RangeClient(func(tc *TCPClient[ClientInfo]) bool {
+		if tc.RemoteAddr().Network() != "udp" || tc.ConnName() == "" {
+			t.Errorf("client addr %s %q", tc.RemoteAddr().Network(), tc.ConnName())
+		}
+		return true
+	})
+}
+
+type timeoutHandler struct {
+	Handler
+	ping   chan int
+	closed chan error
+}
+
+func (h *timeoutHandler) OnDisConnect(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	h.closed <- tc.reason()
+}
+
+func (h *timeoutHandler) OnPing(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	h.ping <- 1
+}
+
+// 替换整个配置 服务器的tick协程会读取配置，不能直接修改ParamConf.Get()返回的对象
+func loadParamConf(t testing.TB, f func(conf *ParamConfig)) {
+	conf := new(ParamConfig)
+	conf.Create()
+	if f != nil {
+		f(conf)
+	}
+	if err := ParamConf.LoadBy(conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTCPServerTimeout(t *testing.T) {
+	defer loadParamConf(t, nil)
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	h := &timeoutHandler{ping: make(chan int, 8), closed: make(chan error, 8)}
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	wait := func(want error) {
+		select {
+		case err := <-h.closed:
+			if err != want {
+				t.Fatalf("close reason %v want %v", err, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("wait %v timeout", want)
+		}
+	}
+
+	// 未AddClient
+	loadParamConf(t, func(conf *ParamConfig) { conf.HandShakeTimeout = 1 })
+	conn, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wait(ErrHandShakeTimeout)
+
+	// 读空闲 先回调OnPing
+	loadParamConf(t, func(conf *ParamConfig) {
+		conf.ReadIdleTimeout = 3
+		conf.PingInterval = 1
+	})
+	conn2, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	select {
+	case <-h.ping:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait ping timeout")
+	}
+	wait(ErrReadIdleTimeout)
+}
+
+func TestTCPServerRoom(t *testing.T) {
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	h := NewHandler()
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		conn, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+	for i := 0; i < 100; i++ {
+		if count, _ := server.ConnCount(); count == len(conns) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	var sender *TCPClient[ClientInfo]
+	server.RangeClient(func(tc *TCPClient[ClientInfo]) bool {
+		server.JoinRoom("room", tc)
+		server.JoinRoom("room", tc) // 重复加入
+		sender = tc
+		return true
+	})
+	if server.RoomCount("room") != len(conns) || len(server.Rooms(sender)) != 1 {
+		t.Fatalf("room count %d", server.RoomCount("room"))
+	}
+
+	// 除了sender都能收到
+	if err := server.BroadcastMsgExcept(context.TODO(), "room", utils.TestHeatBeatRespMsg, sender); err != nil {
+		t.Fatal(err)
+	}
+	recv := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			continue
+		}
+		m, _, err := utils.TestDecodeMsg(buf[:n])
+		if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+			t.Fatalf("resp %v %v", m, err)
+		}
+		recv++
+	}
+	if recv != len(conns)-1 {
+		t.Fatalf("recv %d", recv)
+	}
+
+	// 断开后自动离开房间
+	conns[0].Close()
+	for i := 0; i < 100 && server.RoomCount("room") != len(conns)-1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.RoomCount("room") != len(conns)-1 {
+		t.Fatalf("room count %d", server.RoomCount("room"))
+	}
+	server.LeaveRoom("room", sender)
+	server.RangeClient(func(tc *TCPClient[ClientInfo]) bool {
+		server.LeaveAllRoom(tc)
+		return true
+	})
+	if server.RoomCount("room") != 0 || server.rooms.Len() != 0 {
+		t.Fatalf("room count %d", server.RoomCount("room"))
+	}
+}
+
+type shutdownHandler struct {
+	Handler
+	closed chan error
+}
+
+func (h *shutdownHandler) OnDisConnect(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	h.closed <- tc.reason()
+}
+
+func (h *shutdownHandler) OnShutdown(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	tc.SendMsg(ctx, utils.TestHeatBeatRespMsg)
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	address := tcp.UnixScheme + filepath.Join(t.TempDir(), "tcpserver.sock")
+	h := &shutdownHandler{closed: make(chan error, 8)}
+	server, err := NewTCPServerWithAddr[int, ClientInfo, utils.TestMsg](address, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 100; i++ {
+		if count, _ := server.ConnCount(); count == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := server.ConnCount(); count != 0 {
+		t.Fatalf("conn count %d", count)
+	}
+	if _, err := net.Dial("unix", strings.TrimPrefix(address, tcp.UnixScheme)); err == nil {
+		t.Fatal("listener not closed")
+	}
+
+	// 先收到通知消息 然后断开
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := utils.TestDecodeMsg(buf[:n])
+	if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("expect closed")
+	}
+	select {
+	case err := <-h.closed:
+		if err != ErrServerShutdown {
+			t.Fatalf("close reason %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait close timeout")
+	}
+}
+
+type resumeHandler struct {
+	Handler
+	expire chan *ClientInfo
+}
+
+func (h *resumeHandler) OnResumeExpire(ctx context.Context, info *ClientInfo) {
+	h.expire <- info
+}
+
+func TestTCPServerResume(t *testing.T) {
+	loadParamConf(t, func(conf *ParamConfig) { conf.ResumeGrace = 5 })
+	defer loadParamConf(t, nil)
+
+	h := &resumeHandler{expire: make(chan *ClientInfo, 8)}
+	server, err := NewTCPServer[int, ClientInfo, utils.TestMsg](1249, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// 连接并返回服务器的连接对象
+	dial := func() (net.Conn, *TCPClient[ClientInfo]) {
+		conn, err := net.Dial("tcp", "127.0.0.1:1249")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			var tc *TCPClient[ClientInfo]
+			server.RangeClient(func(c *TCPClient[ClientInfo]) bool {
+				if c.RemoteAddr().String() == conn.LocalAddr().String() {
+					tc = c
+				}
+				return tc == nil
+			})
+			if tc != nil {
+				return conn, tc
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Fatal("accept timeout")
+		return nil, nil
+	}
+	// 读取count个消息
+	read := func(conn net.Conn, count int) {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		var data []byte
+		buf := make([]byte, 1024)
+		for count > 0 {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, buf[:n]...)
+			for count > 0 {
+				m, l, err := utils.TestDecodeMsg(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if m == nil {
+					break
+				}
+				if m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+					t.Fatalf("resp %v", m)
+				}
+				data = data[l:]
+				count--
+			}
+		}
+	}
+
+	conn1, tc1 := dial()
+	server.AddClient(1, tc1)
+	token := tc1.ResumeToken()
+	if token == "" {
+		t.Fatal("token empty")
+	}
+	heart := time.Now()
+	tc1.Info().SetLastHeart(heart)
+	server.JoinRoom("room", tc1)
+
+	// 断开后保持id映射和房间，发送和广播的数据缓存
+	conn1.Close()
+	for i := 0; i < 100; i++ {
+		if n, _ := server.ConnCount(); n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if server.GetClient(1) != tc1 || server.RoomCount("room") != 1 {
+		t.Fatal("detached client removed")
+	}
+	if err := tc1.SendMsg(context.TODO(), utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.BroadcastMsg(context.TODO(), "room", utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	// 错误的token
+	conn2, tc2 := dial()
+	defer conn2.Close()
+	if _, err := server.ResumeClient(context.TODO(), "bad", tc2); err != ErrResumeToken {
+		t.Fatalf("resume %v", err)
+	}
+
+	// 恢复后收到缓存的数据
+	id, err := server.ResumeClient(context.TODO(), token, tc2)
+	if err != nil || id != 1 {
+		t.Fatalf("resume %d %v", id, err)
+	}
+	if tc2.Info() != tc1.Info() || !tc2.Info().LastHeart().Equal(heart) || server.GetClient(1) != tc2 {
+		t.Fatal("resume info")
+	}
+	if rooms := server.Rooms(tc2); len(rooms) != 1 || rooms[0] != "room" || server.RoomCount("room") != 1 {
+		t.Fatal("resume rooms", rooms)
+	}
+	read(conn2, 2)
+
+	// 恢复后更换token 旧token失效，连接未断开的会话不能恢复
+	if tc2.ResumeToken() == "" || tc2.ResumeToken() == token {
+		t.Fatal("token not rotated")
+	}
+	if _, err := server.ResumeClient(context.TODO(), token, tc1); err != ErrResumeToken {
+		t.Fatalf("resume %v", err)
+	}
+	if _, err := server.ResumeClient(context.TODO(), tc2.ResumeToken(), tc1); err != ErrResumeActive {
+		t.Fatalf("resume %v", err)
+	}
+
+	// 原连接发送的数据转发到新连接
+	if err := tc1.SendMsg(context.TODO(), utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	read(conn2, 1)
+	if err := server.BroadcastMsg(context.TODO(), "room", utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	read(conn2, 1)
+
+	// 超过保留时间 删除id映射和房间
+	loadParamConf(t, func(conf *ParamConfig) { conf.ResumeGrace = 1 })
+	conn2.Close()
+	select {
+	case info := <-h.expire:
+		if info != tc1.Info() {
+			t.Fatal("expire info")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait expire timeout")
+	}
+	if server.GetClient(1) != nil || server.RoomCount("room") != 0 {
+		t.Fatal("expired client not removed")
+	}
+	conn3, tc3 := dial()
+	defer conn3.Close()
+	if _, err := server.ResumeClient(context.TODO(), token, tc3); err != ErrResumeToken {
+		t.Fatalf("resume %v", err)
+	}
+}
+
+// 断开时写队列中还未发送的数据和rpc请求 恢复后重发
+func TestTCPServerResumeQueue(t *testing.T) {
+	// 合并写入等待时间很长 发送的数据留在写队列中
+	loadParamConf(t, func(conf *ParamConfig) {
+		conf.ResumeGrace = 5
+		conf.Conn.WriteBatch = 8
+		conf.Conn.FlushDelay = 5000000
+	})
+	defer loadParamConf(t, nil)
+
+	server, err := NewTCPServer[int, ClientInfo, utils.TestMsg](1269, &Handler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dial := func() (net.Conn, *TCPClient[ClientInfo]) {
+		conn, err := net.Dial("tcp", "127.0.0.1:1269")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			var tc *TCPClient[ClientInfo]
+			server.RangeClient(func(c *TCPClient[ClientInfo]) bool {
+				if c.RemoteAddr().String() == conn.LocalAddr().String() {
+					tc = c
+				}
+				return tc == nil
+			})
+			if tc != nil {
+				return conn, tc
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Fatal("accept timeout")
+		return nil, nil
+	}
+
+	conn1, tc1 := dial()
+	server.AddClient(1, tc1)
+	token := tc1.ResumeToken()
+	if err := tc1.SendMsg(context.TODO(), utils.TestHeatBeatRespMsg); err != nil {
+		t.Fatal(err)
+	}
+	if tc1.conn.MQPushed() == tc1.conn.MQFlushed() {
+		t.Fatal("mq flushed")
+	}
+
+	// 断开 写队列中的数据放回缓存
+	conn1.Close()
+	for i := 0; i < 100; i++ {
+		if n, _ := server.ConnCount(); n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// 断开期间发送rpc 恢复后在新连接上收到回复
+	done := make(chan error, 1)
+	err = tc1.SendAsyncRPCMsg(context.TODO(), utils.TestHeatBeatReqMsg.Msgid, utils.TestHeatBeatReqMsg, time.Second*5, func(resp *utils.TestHeatBeatResp, err error) {
+		if err == nil && resp.Data != "heatrespmsg" {
+			err = fmt.Errorf("resp %v", resp)
+		}
+		done <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadParamConf(t, func(conf *ParamConfig) { conf.ResumeGrace = 5 })
+	conn2, tc2 := dial()
+	defer conn2.Close()
+	if _, err := server.ResumeClient(context.TODO(), token, tc2); err != nil {
+		t.Fatal(err)
+	}
+
+	conn2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	var data []byte
+	var msgs []uint32
+	buf := make([]byte, 1024)
+	for len(msgs) < 2 {
+		n, err := conn2.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+		for {
+			m, l, err := utils.TestDecodeMsg(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				break
+			}
+			msgs = append(msgs, m.Msgid)
+			data = data[l:]
+		}
+	}
+	if len(msgs) != 2 || msgs[0] != utils.TestHeatBeatRespMsg.Msgid || msgs[1] != utils.TestHeatBeatReqMsg.Msgid {
+		t.Fatalf("resend %v", msgs)
+	}
+
+	resp, _ := utils.TestHeatBeatRespMsg.MsgMarshal()
+	if _, err := conn2.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait rpc resp timeout")
+	}
+}
+
+func TestTCPServerWSDeflate(t *testing.T) {
+	ParamConf.Get().WSDeflate = tcp.WSDeflateConfig{Enable: true, MinSize: 512}
+	defer func() { ParamConf.Get().WSDeflate = tcp.WSDeflateConfig{} }()
+
+	server, err := NewTCPServerWithWS[int, ClientInfo, utils.TestMsg](1250, NewHandler(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dialer := ws.Dialer{Extensions: []httphead.Option{(wsflate.Parameters{}).Option()}}
+	conn, _, hs, err := dialer.Dial(context.TODO(), "ws://127.0.0.1:1250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(hs.Extensions) != 1 || string(hs.Extensions[0].Name) != wsflate.ExtensionName {
+		t.Fatalf("extensions %v", hs.Extensions)
+	}
+
+	// 服务器保留了压缩上下文 客户端解压也要保留
+	var dict []byte
+	inflate := func(p []byte) []byte {
+		src := io.MultiReader(bytes.NewReader(p), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}))
+		out, err := io.ReadAll(flate.NewReaderDict(src, dict))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dict = append(dict, out...)
+		return out
+	}
+	echo := func(data []byte, compress bool) ws.Frame {
+		frame := ws.NewTextFrame(append([]byte(nil), data...)) // 发送时会掩码 不修改原数据
+		if compress {
+			var buf bytes.Buffer
+			fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+			fw.Write(data)
+			fw.Flush()
+			frame = ws.NewTextFrame(bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}))
+			frame.Header.Rsv = ws.Rsv(true, false, false)
+		}
+		if err := ws.WriteFrame(conn, ws.MaskFrameInPlace(frame)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		resp, err := ws.ReadFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var text []byte
+	for i := 0; i < 200; i++ {
+		text = append(text, fmt.Sprintf(`{"name":"gobase","index":%d,"value":%d},`, i, i*i*7919)...)
+	}
+	resp := echo(text, true)
+	compressed, _ := wsflate.IsCompressed(resp.Header)
+	if !compressed || len(resp.Payload) >= len(text)/2 || !bytes.Equal(inflate(resp.Payload), text) {
+		t.Fatalf("compressed %v size %d", compressed, len(resp.Payload))
+	}
+	first := len(resp.Payload)
+
+	// 第二次使用上下文压缩 数据更小
+	resp = echo(text, false)
+	compressed, _ = wsflate.IsCompressed(resp.Header)
+	if !compressed || len(resp.Payload) >= first || !bytes.Equal(inflate(resp.Payload), text) {
+		t.Fatalf("compressed %v size %d", compressed, len(resp.Payload))
+	}
+
+	// 小于MinSize不压缩
+	resp = echo([]byte("hello"), false)
+	compressed, _ = wsflate.IsCompressed(resp.Header)
+	if compressed || string(resp.Payload) != "hello" {
+		t.Fatalf("compressed %v payload %s", compressed, resp.Payload)
+	}
+}
+
+type wsUpgradeHandler struct {
+	Handler
+	conns chan *TCPClient[ClientInfo]
+}
+
+func (h *wsUpgradeHandler) OnWSUpgrade(tc *TCPClient[ClientInfo], protocols []string) (string, error) {
+	if tc.WSQuery().Get("token") != "abc" {
+		return "", errors.New("token error")
+	}
+	h.conns <- tc
+	if p := tc.WSQuery().Get("protocol"); p != "" {
+		return p, nil
+	}
+	for _, p := range protocols {
+		if p == "chat" {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+func TestTCPServerWSUpgrade(t *testing.T) {
+	ParamConf.Get().WSOrigin = []string{"https://*.example.com"}
+	defer func() { ParamConf.Get().WSOrigin = nil }()
+
+	h := &wsUpgradeHandler{conns: make(chan *TCPClient[ClientInfo], 8)}
+	server, err := NewTCPServerWithWS[int, ClientInfo, utils.TestMsg](1252, h, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dial := func(url, origin string) (net.Conn, ws.Handshake, error) {
+		dialer := ws.Dialer{
+			Protocols: []string{"json", "chat"},
+			Header: ws.HandshakeHeaderHTTP(http.Header{
+				"Origin": []string{origin},
+				"Cookie": []string{"session=s1"},
+			}),
+		}
+		conn, _, hs, err := dialer.Dial(context.TODO(), url)
+		return conn, hs, err
+	}
+	// 读取一个帧
+	read := func(conn net.Conn) ws.Frame {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		f, err := ws.ReadFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// 连接已被服务器关闭
+	closed := func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("not closed %v", err)
+		}
+	}
+
+	// Origin和验证不通过
+	if _, _, err := dial("ws://127.0.0.1:1252/ws?token=abc", "https://example.org"); err != ws.StatusError(http.StatusForbidden) {
+		t.Fatalf("origin %v", err)
+	}
+	if _, _, err := dial("ws://127.0.0.1:1252/ws?token=bad", "https://a.example.com"); err != ws.StatusError(http.StatusForbidden) {
+		t.Fatalf("token %v", err)
+	}
+
+	// 选择的子协议客户端没有请求 不使用子协议完成握手
+	conn0, hs0, err := dial("ws://127.0.0.1:1252/ws?token=abc&protocol=xml", "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc0 := <-h.conns; hs0.Protocol != "" || tc0.WSProtocol() != "" {
+		t.Fatalf("protocol %s %s", hs0.Protocol, tc0.WSProtocol())
+	}
+	conn0.Close()
+
+	conn, hs, err := dial("ws://127.0.0.1:1252/ws?token=abc", "https://a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tc := <-h.conns
+	if hs.Protocol != "chat" || tc.WSProtocol() != "chat" || tc.WSPath() != "/ws" {
+		t.Fatalf("protocol %s path %s", hs.Protocol, tc.WSPath())
+	}
+	if c, err := tc.WSCookie("session"); err != nil || c.Value != "s1" {
+		t.Fatalf("cookie %v %v", c, err)
+	}
+
+	// ping回复pong
+	ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewPingFrame([]byte("p"))))
+	if f := read(conn); f.Header.OpCode != ws.OpPong || string(f.Payload) != "p" {
+		t.Fatalf("pong %v %s", f.Header.OpCode, f.Payload)
+	}
+
+	// 服务器发起关闭 客户端回复后关闭连接
+	tc.Close(wsutil.ClosedError{Code: 4000, Reason: "bye"})
+	f := read(conn)
+	code, reason := ws.ParseCloseFrameData(f.Payload)
+	if f.Header.OpCode != ws.OpClose || code != 4000 || reason != "bye" {
+		t.Fatalf("close %v %d %s", f.Header.OpCode, code, reason)
+	}
+	ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewCloseFrame(f.Payload)))
+	closed(conn)
+
+	// 客户端发起关闭 服务器回复后关闭连接
+	conn2, _, err := dial("ws://127.0.0.1:1252/ws?token=abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	<-h.conns
+	ws.WriteFrame(conn2, ws.MaskFrameInPlace(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))))
+	f = read(conn2)
+	if code, _ := ws.ParseCloseFrameData(f.Payload); f.Header.OpCode != ws.OpClose || code != ws.StatusNormalClosure {
+		t.Fatalf("close %v %d", f.Header.OpCode, code)
+	}
+	closed(conn2)
+}
+
+type cipherHandler struct {
+	Handler
+	connected chan *TCPClient[ClientInfo]
+}
+
+func (h *cipherHandler) OnConnected(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	h.connected <- tc
+}
+
+func TestTCPServerCipher(t *testing.T) {
+	ParamConf.Get().Cipher = tcp.CipherConfig{Enable: true, Suite: tcp.CipherChaCha20Poly1305}
+	ParamConf.Get().Cipher.Normalize()
+	defer func() { ParamConf.Get().Cipher = tcp.CipherConfig{} }()
+
+	h := &cipherHandler{connected: make(chan *TCPClient[ClientInfo], 8)}
+	server, err := NewTCPServer[int, ClientInfo, utils.TestMsg](1256, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var last []byte // 最后写入的帧
+	c, err := tcp.NewCipher(tcp.CipherConfig{}, false, func(b []byte) error {
+		last = b
+		_, err := conn.Write(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if err := c.Send(data); err != tcp.ErrCipherHandShake {
+		t.Fatalf("send before handshake %v", err)
+	}
+
+	// 握手完成前不回调OnConnected
+	select {
+	case <-h.connected:
+		t.Fatal("connected before handshake")
+	case <-time.After(time.Millisecond * 200):
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []byte
+	buf := make([]byte, 1024)
+	// 读取并解密出一个消息 握手时返回nil
+	read := func() (*utils.TestMsg, error) {
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, buf[:n]...)
+			var m *utils.TestMsg
+			handshake := false
+			l, err := c.Recv(pending, func() { handshake = true }, func(plain []byte) (int, error) {
+				mr, l, err := utils.TestDecodeMsg(plain)
+				m = mr
+				return l, err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending = pending[l:]
+			if m != nil || handshake {
+				return m, nil
+			}
+		}
+	}
+	if m, err := read(); m != nil || err != nil || !c.Established() {
+		t.Fatalf("handshake %v %v", m, err)
+	}
+	select {
+	case <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+
+	// 加密的请求和回复
+	for i := 0; i < 3; i++ {
+		if err := c.Send(data); err != nil {
+			t.Fatal(err)
+		}
+		m, err := read()
+		if err != nil || m == nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+			t.Fatalf("resp %v %v", m, err)
+		}
+	}
+
+	// 重放的帧 服务器关闭连接
+	if _, err := conn.Write(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(); err != io.EOF {
+		t.Fatalf("replay %v", err)
+	}
+	for i := 0; i < 100 && server.ClientCount() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if count, _ := server.ConnCount(); count != 0 {
+		t.Fatalf("conn count %d", count)
+	}
+
+	// 错误的握手数据
+	conn2, err := net.Dial("tcp", "127.0.0.1:1256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.Write([]byte{0, 0, 0, 3, 1, 2, 3})
+	conn2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn2.Read(buf); err != io.EOF {
+		t.Fatalf("bad hello %v", err)
+	}
+}
+
+type compressHandler struct {
+	Handler
+	connected chan *TCPClient[ClientInfo]
+	recv      chan []byte
+}
+
+func (h *compressHandler) OnConnected(ctx context.Context, tc *TCPClient[ClientInfo]) {
+	h.connected <- tc
+}
+
+func (h *compressHandler) OnMsg(ctx context.Context, mr msger.RecvMsger, tc *TCPClient[ClientInfo]) {
+	m, _ := mr.(*utils.TestMsg)
+	h.recv <- append([]byte(nil), m.RecvData...)
+}
+
+func TestTCPServerCompress(t *testing.T) {
+	ParamConf.Get().Compress = tcp.CompressConfig{Enable: true, Algos: []string{tcp.CompressSnappy, tcp.CompressFlate}, MinSize: 64}
+	ParamConf.Get().Compress.Normalize()
+	defer func() { ParamConf.Get().Compress = tcp.CompressConfig{} }()
+
+	h := &compressHandler{connected: make(chan *TCPClient[ClientInfo], 8), recv: make(chan []byte, 8)}
+	server, err := NewTCPServer[int, ClientInfo, utils.TestMsg](1259, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1259")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var sendRaw, sendSize, recvRaw, recvSize int
+	c, err := tcp.NewCompressor(tcp.CompressConfig{Algos: []string{tcp.CompressFlate}, MinSize: 64}, false, func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	}, func(send bool, rawLen, len int) {
+		if send {
+			sendRaw, sendSize = sendRaw+rawLen, sendSize+len
+		} else {
+			recvRaw, recvSize = recvRaw+rawLen, recvSize+len
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tc *TCPClient[ClientInfo]
+	select {
+	case tc = <-h.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait connected timeout")
+	}
+	// 协商完成前发送未压缩的数据
+	data, _ := utils.TestHeatBeatReqMsg.MsgMarshal()
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []byte
+	buf := make([]byte, 4096)
+	read := func() (*utils.TestMsg, error) {
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, buf[:n]...)
+			var m *utils.TestMsg
+			l, err := c.Recv(pending, func(plain []byte) (int, error) {
+				mr, l, err := utils.TestDecodeMsg(plain)
+				if mr != nil {
+					m = &utils.TestMsg{TestMsgHead: mr.TestMsgHead, RecvData: append([]byte(nil), mr.RecvData...)}
+				}
+				return l, err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending = pending[l:]
+			if m != nil {
+				return m, nil
+			}
+		}
+	}
+	if m, err := read(); err != nil || m.Msgid != utils.TestHeatBeatRespMsg.Msgid {
+		t.Fatalf("resp %v %v", m, err)
+	}
+	// 服务器按自己的顺序选择客户端支持的算法
+	if c.Algo() != tcp.CompressFlate {
+		t.Fatalf("algo %s", c.Algo())
+	}
+	// 未压缩的消息也统计
+	if sendRaw != len(data) || sendSize != sendRaw || recvRaw == 0 || recvSize != recvRaw {
+		t.Fatalf("raw stat %d %d %d %d", sendRaw, sendSize, recvRaw, recvSize)
+	}
+	sendRaw, sendSize, recvRaw, recvSize = 0, 0, 0, 0
+
+	// 大消息压缩发送 服务器解压后DecodeMsg
+	body := bytes.Repeat([]byte("gobase compress "), 256)
+	big := make([]byte, 8+len(body))
+	big[0] = 99
+	big[4], big[5] = byte(len(body)), byte(len(body)>>8)
+	copy(big[8:], body)
+	if err := c.Send(big); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-h.recv:
+		if !bytes.Equal(b, body) {
+			t.Fatalf("server recv %d", len(b))
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait recv timeout")
+	}
+	if sendRaw != len(big) || sendSize == 0 || sendSize >= sendRaw {
+		t.Fatalf("send stat %d %d", sendRaw, sendSize)
+	}
+
+	// 服务器压缩发送
+	if err := tc.Send(context.TODO(), big); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := read(); err != nil || m.Msgid != 99 || !bytes.Equal(m.RecvData, body) {
+		t.Fatalf("big resp %v", err)
+	}
+	if recvRaw != len(big) || recvSize == 0 || recvSize >= recvRaw {
+		t.Fatalf("recv stat %d %d", recvRaw, recvSize)
+	}
+
+	// 错误的压缩数据 服务器关闭连接
+	conn.Write([]byte{0, 0, 0, 4, 1, 0xff, 0xff, 0xff})
+	if _, err := read(); err != io.EOF {
+		t.Fatalf("bad frame %v", err)
+	}
+}
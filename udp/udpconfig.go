@@ -0,0 +1,52 @@
+package udp
+
+// https://github.com/yuwf/gobase
+
+import "time"
+
+// UDP可靠传输的参数，可嵌入到各模块的ParamConfig中
+type Config struct {
+	MTU       int     `json:"mtu,omitempty"`       // 一个UDP包的最大长度 默认1400
+	Window    int     `json:"window,omitempty"`    // 发送和接收窗口 单位包 默认256
+	SendQueue int     `json:"sendqueue,omitempty"` // 等待进入发送窗口的包个数上限 超过后Write阻塞 默认4096
+	Interval  int     `json:"interval,omitempty"`  // 内部刷新间隔 检查重传 单位毫秒 默认10
+	MinRTO    int     `json:"minrto,omitempty"`    // 最小重传超时 单位毫秒 默认30
+	DeadLink  int     `json:"deadlink,omitempty"`  // 一个包发送次数达到后断开连接 默认20
+	KeepAlive int     `json:"keepalive,omitempty"` // 超过时间没有发送数据时发送心跳 单位秒 默认5
+	Timeout   int     `json:"timeout,omitempty"`   // 超过时间没有收到数据断开连接 单位秒 默认30
+	DropRate  float64 `json:"droprate,omitempty"`  // 模拟丢包率 0-1 发送时随机丢弃 测试使用
+}
+
+func (c *Config) Normalize() {
+	if c.MTU <= headLen {
+		c.MTU = 1400
+	}
+	if c.Window <= 0 {
+		c.Window = 256
+	}
+	if c.SendQueue <= 0 {
+		c.SendQueue = 4096
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10
+	}
+	if c.MinRTO <= 0 {
+		c.MinRTO = 30
+	}
+	if c.DeadLink <= 0 {
+		c.DeadLink = 20
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 5
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30
+	}
+	if c.Timeout <= c.KeepAlive {
+		c.Timeout = c.KeepAlive * 3
+	}
+}
+
+func (c *Config) interval() time.Duration {
+	return time.Duration(c.Interval) * time.Millisecond
+}
@@ -0,0 +1,204 @@
+package udp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cookie的有效期 当前和上一个周期生成的cookie都有效 单位秒
+const cookiePeriod = 10
+
+// Listener 在一个UDP socket上接收多个Session 协程安全
+type Listener struct {
+	conn net.PacketConn
+	conf func() *Config // 获取Session的参数 每次创建Session时调用，支持配置热更新，为nil使用默认参数
+	key  []byte         // 生成cookie的密钥
+
+	mu       sync.Mutex
+	sessions map[string]*Session // [对方地址:*Session]
+
+	accept chan *Session
+	die    chan struct{}
+	state  int32 // 0：监听中 1：已关闭 原子操作
+}
+
+// Listen 监听UDP地址 address格式 host:port
+func Listen(address string, conf func() *Config) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(conn, conf), nil
+}
+
+// NewListener 使用已经创建好的PacketConn
+func NewListener(conn net.PacketConn, conf func() *Config) *Listener {
+	key := make([]byte, 32)
+	rand.Read(key)
+	l := &Listener{
+		conn:     conn,
+		conf:     conf,
+		key:      key,
+		sessions: map[string]*Session{},
+		accept:   make(chan *Session, 128),
+		die:      make(chan struct{}),
+	}
+	go l.loopRead()
+	go l.loopUpdate()
+	return l
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.die:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭所有的Session和socket
+func (l *Listener) Close() error {
+	if !atomic.CompareAndSwapInt32(&l.state, 0, 1) {
+		return nil
+	}
+	close(l.die)
+	for _, s := range l.snapshot() {
+		s.mu.Lock()
+		s.close(net.ErrClosed, true)
+		s.mu.Unlock()
+	}
+	return l.conn.Close()
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) config() Config {
+	var c Config
+	if l.conf != nil {
+		if conf := l.conf(); conf != nil {
+			c = *conf
+		}
+	}
+	c.Normalize()
+	return c
+}
+
+func (l *Listener) snapshot() []*Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ss := make([]*Session, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// Session关闭时调用 调用者持有Session的锁，这里不能再锁Session
+func (l *Listener) remove(s *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[s.remote.String()] == s {
+		delete(l.sessions, s.remote.String())
+	}
+}
+
+func (l *Listener) loopRead() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if atomic.LoadInt32(&l.state) == 1 {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			l.Close()
+			return
+		}
+		conv, cmd, sn, una, data, ok := parsePacket(buf[:n])
+		if !ok {
+			continue
+		}
+		key := addr.String()
+		l.mu.Lock()
+		s := l.sessions[key]
+		l.mu.Unlock()
+		if s != nil && s.conv == conv {
+			s.input(cmd, sn, una, data)
+			continue
+		}
+		// 不存在的会话只处理带cookie长度数据的SYN，其他的包直接丢弃不回复，防止被伪造源地址的包利用
+		if cmd != cmdSyn || len(data) != cookieLen {
+			continue
+		}
+		if !l.checkCookie(addr, conv, data) {
+			// 回复cookie 和SYN一样长，不保存状态，客户端带上cookie重发SYN后才创建Session
+			l.conn.WriteTo(packet(conv, cmdCookie, 0, 0, l.cookie(addr, conv, time.Now().Unix()/cookiePeriod)), addr)
+			continue
+		}
+		if s != nil {
+			// 客户端用相同的地址重新连接
+			s.mu.Lock()
+			s.close(ErrReset, false)
+			s.mu.Unlock()
+		}
+		if atomic.LoadInt32(&l.state) == 1 {
+			return
+		}
+		s = newSession(conv, l.config(), l.conn, addr, l)
+		l.mu.Lock()
+		l.sessions[key] = s
+		l.mu.Unlock()
+		select {
+		case l.accept <- s:
+		default:
+			l.remove(s)
+			continue // 来不及Accept 丢弃 等客户端重发
+		}
+		s.input(cmd, sn, una, data)
+	}
+}
+
+// 生成cookie 和对方地址、会话号、时间周期绑定
+func (l *Listener) cookie(addr net.Addr, conv uint32, period int64) []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:], uint64(period))
+	binary.BigEndian.PutUint32(b[8:], conv)
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write(b[:])
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)[:cookieLen]
+}
+
+func (l *Listener) checkCookie(addr net.Addr, conv uint32, cookie []byte) bool {
+	period := time.Now().Unix() / cookiePeriod
+	return hmac.Equal(cookie, l.cookie(addr, conv, period)) || hmac.Equal(cookie, l.cookie(addr, conv, period-1))
+}
+
+func (l *Listener) loopUpdate() {
+	conf := l.config()
+	ticker := time.NewTicker(conf.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.die:
+			return
+		case now := <-ticker.C:
+			for _, s := range l.snapshot() {
+				s.update(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package udp
+
+// https://github.com/yuwf/gobase
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 基于UDP的可靠有序传输，Session实现net.Conn，Listener实现net.Listener，可以直接给tcp.TCPConn和tcp.TCPListener使用
+// 包格式：[4字节会话号][1字节命令][4字节序号][4字节期望收到的序号][2字节数据长度][数据] 大端
+// 每个数据包都回复ACK，期望收到的序号之前的包都已确认，发送方超时或者被后面的包的确认跳过2次的包重传，接收方按序号排好后交给Read
+// UDP没有连接过程，客户端Dial后发送SYN直到收到服务器的数据，收到服务器回复前不发送数据
+// 服务器不为没有带cookie的SYN创建Session，只回复一个cookie，cookie由对方地址和会话号生成，客户端带上cookie重发SYN后服务器才创建Session
+// SYN携带cookie长度的数据，回复的cookie包不比SYN长，服务器不回复不存在的会话的其他包，伪造源地址的包不会产生状态也不会被放大反射
+// 一方Close时等待发送的数据确认完再发送FIN，对方Read返回io.EOF；超过Timeout没有收到数据或者一个包的发送次数达到DeadLink断开连接
+
+const (
+	cmdPush   = 1 // 数据
+	cmdAck    = 2 // 确认
+	cmdPing   = 3 // 心跳
+	cmdFin    = 4 // 关闭
+	cmdSyn    = 5 // 客户端创建连接 数据为cookie，没有cookie时填0
+	cmdCookie = 6 // 服务器回复的cookie
+)
+
+const headLen = 15
+
+const cookieLen = 16
+
+const maxRTO = time.Second * 5
+
+var (
+	ErrTimeout  = errors.New("udp session timeout")   // 超过Timeout没有收到数据
+	ErrDeadLink = errors.New("udp session dead link") // 一个包的发送次数达到DeadLink
+	ErrReset    = errors.New("udp session reset")     // 对方用新的会话号重新连接
+)
+
+// 一个发送中的包
+type segment struct {
+	sn       uint32
+	data     []byte
+	xmit     int           // 发送次数
+	fastack  int           // 之后发送的包先确认的次数 达到2次快速重传
+	rto      time.Duration // 重传超时
+	sendAt   time.Time     // 最近一次发送的时间
+	resendAt time.Time     // 下次重传的时间
+}
+
+// Session 一个UDP可靠连接 协程安全
+type Session struct {
+	// 不可修改
+	conv   uint32
+	conf   Config
+	conn   net.PacketConn
+	remote net.Addr
+	l      *Listener // 被动连接所属的Listener 为nil表示Dial创建的，关闭时关闭conn
+
+	mu          sync.Mutex
+	established bool   // 是否收到过对方的数据
+	cookie      []byte // 客户端收到的服务器cookie
+	closing     bool   // 调用了Close 等待发送的数据确认完
+	err         error
+
+	sndNxt   uint32     // 下一个发送的序号
+	sndUna   uint32     // 最小的未确认序号
+	sndQueue [][]byte   // 等待进入发送窗口的包
+	sndBuf   []*segment // 发送窗口中的包 按序号排序
+
+	rcvNxt  uint32            // 期望收到的序号
+	rcvBuf  map[uint32][]byte // 乱序收到的包
+	rcvData []byte            // 排好序等待Read的数据
+
+	srtt, rttvar, rto  time.Duration
+	lastRecv, lastSend time.Time
+
+	readDeadline, writeDeadline time.Time
+
+	chRead  chan struct{} // 有数据可读
+	chWrite chan struct{} // 有空间可写
+	die     chan struct{} // 关闭
+}
+
+func newSession(conv uint32, conf Config, conn net.PacketConn, remote net.Addr, l *Listener) *Session {
+	conf.Normalize()
+	now := time.Now()
+	return &Session{
+		conv:     conv,
+		conf:     conf,
+		conn:     conn,
+		remote:   remote,
+		l:        l,
+		rcvBuf:   map[uint32][]byte{},
+		rto:      time.Millisecond * 200,
+		lastRecv: now,
+		lastSend: now,
+		chRead:   make(chan struct{}, 1),
+		chWrite:  make(chan struct{}, 1),
+		die:      make(chan struct{}),
+	}
+}
+
+// Dial 创建客户端连接 address格式 host:port，conf为nil使用默认参数
+// UDP没有连接过程，创建后立即返回，服务器不可达时在Timeout或者DeadLink后断开
+func Dial(address string, conf *Config) (*Session, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if conf != nil {
+		c = *conf // 拷贝一份 外层修改不影响
+	}
+	s := newSession(rand.Uint32(), c, conn, raddr, nil)
+	s.mu.Lock()
+	s.syn()
+	s.mu.Unlock()
+	go s.loopRead()
+	go s.loopUpdate()
+	return s, nil
+}
+
+func (s *Session) Read(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if len(s.rcvData) > 0 {
+			n := copy(b, s.rcvData)
+			s.rcvData = s.rcvData[n:]
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		timer, timeout, ok := deadlineTimer(s.readDeadline)
+		s.mu.Unlock()
+		if !ok {
+			return 0, os.ErrDeadlineExceeded
+		}
+		select {
+		case <-s.chRead:
+		case <-s.die:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Write 数据分成MTU大小的包放入发送队列，队列满时阻塞
+func (s *Session) Write(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		if s.closing {
+			s.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(s.sndQueue) < s.conf.SendQueue {
+			mss := s.conf.MTU - headLen
+			for p := b; len(p) > 0; {
+				n := len(p)
+				if n > mss {
+					n = mss
+				}
+				s.sndQueue = append(s.sndQueue, append([]byte(nil), p[:n]...))
+				p = p[n:]
+			}
+			s.flush(time.Now())
+			s.mu.Unlock()
+			return len(b), nil
+		}
+		timer, timeout, ok := deadlineTimer(s.writeDeadline)
+		s.mu.Unlock()
+		if !ok {
+			return 0, os.ErrDeadlineExceeded
+		}
+		select {
+		case <-s.chWrite:
+		case <-s.die:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Close 等待发送的数据确认完后发送FIN关闭
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.closing {
+		return nil
+	}
+	s.closing = true
+	if len(s.sndBuf) == 0 && len(s.sndQueue) == 0 {
+		s.close(net.ErrClosed, true)
+	}
+	notify(s.chRead)
+	notify(s.chWrite)
+	return nil
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// 对方地址 *net.UDPAddr
+func (s *Session) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *Session) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline, s.writeDeadline = t, t
+	notify(s.chRead)
+	notify(s.chWrite)
+	return nil
+}
+
+func (s *Session) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	notify(s.chRead)
+	return nil
+}
+
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	notify(s.chWrite)
+	return nil
+}
+
+// Conv 会话号
+func (s *Session) Conv() uint32 {
+	return s.conv
+}
+
+// RTT 平滑后的往返时间
+func (s *Session) RTT() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.srtt
+}
+
+// 处理收到的包
+func (s *Session) input(cmd byte, sn, una uint32, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if cmd == cmdCookie {
+		// 客户端带上cookie重发SYN
+		if s.l == nil && !s.established && len(data) == cookieLen {
+			s.cookie = append(s.cookie[:0], data...)
+			s.syn()
+		}
+		return
+	}
+	now := time.Now()
+	s.lastRecv = now
+	s.established = true
+	acked := s.ackUna(una)
+	switch cmd {
+	case cmdPush:
+		d := int32(sn - s.rcvNxt)
+		if d >= int32(s.conf.Window) {
+			break // 超出接收窗口 不确认 等待重传
+		}
+		if d >= 0 {
+			if _, ok := s.rcvBuf[sn]; !ok {
+				s.rcvBuf[sn] = append([]byte(nil), data...)
+			}
+			for {
+				p, ok := s.rcvBuf[s.rcvNxt]
+				if !ok {
+					break
+				}
+				delete(s.rcvBuf, s.rcvNxt)
+				s.rcvData = append(s.rcvData, p...)
+				s.rcvNxt++
+			}
+			notify(s.chRead)
+		}
+		s.output(cmdAck, sn, nil) // 重复的包也要确认
+	case cmdAck:
+		for i, seg := range s.sndBuf {
+			if seg.sn == sn {
+				if seg.xmit == 1 {
+					s.updateRTT(now.Sub(seg.sendAt))
+				}
+				for _, prev := range s.sndBuf[:i] {
+					if prev.sendAt.Before(seg.sendAt) {
+						prev.fastack++ // 后发送的包先确认了
+					}
+				}
+				s.sndBuf = append(s.sndBuf[:i], s.sndBuf[i+1:]...)
+				acked = true
+				break
+			}
+		}
+	case cmdSyn:
+		s.output(cmdPing, 0, nil) // 回复客户端 让客户端停止发送SYN
+	case cmdFin:
+		s.close(io.EOF, false)
+		return
+	}
+	if acked {
+		s.updateUna()
+		s.flush(now)
+		notify(s.chWrite)
+	}
+}
+
+// 确认una之前的包 返回是否有包被确认
+func (s *Session) ackUna(una uint32) bool {
+	n := 0
+	for n < len(s.sndBuf) && int32(s.sndBuf[n].sn-una) < 0 {
+		n++
+	}
+	if n > 0 {
+		s.sndBuf = s.sndBuf[n:]
+	}
+	return n > 0
+}
+
+func (s *Session) updateUna() {
+	if len(s.sndBuf) > 0 {
+		s.sndUna = s.sndBuf[0].sn
+	} else {
+		s.sndUna = s.sndNxt
+	}
+}
+
+func (s *Session) updateRTT(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt, s.rttvar = rtt, rtt/2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	v := 4 * s.rttvar
+	if v < s.conf.interval() {
+		v = s.conf.interval()
+	}
+	s.rto = s.srtt + v
+	if min := time.Duration(s.conf.MinRTO) * time.Millisecond; s.rto < min {
+		s.rto = min
+	}
+	if s.rto > maxRTO {
+		s.rto = maxRTO
+	}
+}
+
+// 发送队列的包放入窗口，发送新包和超时的包
+func (s *Session) flush(now time.Time) {
+	for len(s.sndQueue) > 0 && int32(s.sndNxt-s.sndUna) < int32(s.conf.Window) {
+		s.sndBuf = append(s.sndBuf, &segment{sn: s.sndNxt, data: s.sndQueue[0], rto: s.rto})
+		s.sndQueue[0] = nil
+		s.sndQueue = s.sndQueue[1:]
+		s.sndNxt++
+	}
+	if s.l == nil && !s.established {
+		return // 客户端未收到服务器回复前不发送数据 服务器只为SYN创建Session
+	}
+	for _, seg := range s.sndBuf {
+		if seg.xmit > 0 && now.Before(seg.resendAt) && seg.fastack < 2 {
+			continue
+		}
+		if seg.xmit >= s.conf.DeadLink {
+			s.close(ErrDeadLink, false)
+			return
+		}
+		if seg.xmit > 0 {
+			seg.rto = seg.rto * 3 / 2
+			if seg.rto > maxRTO {
+				seg.rto = maxRTO
+			}
+		}
+		seg.xmit++
+		seg.fastack = 0
+		seg.sendAt = now
+		seg.resendAt = now.Add(seg.rto)
+		s.output(cmdPush, seg.sn, seg.data)
+	}
+}
+
+// 定时调用 检查超时、重传和心跳
+func (s *Session) update(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if now.Sub(s.lastRecv) > time.Duration(s.conf.Timeout)*time.Second {
+		s.close(ErrTimeout, false)
+		return
+	}
+	s.flush(now)
+	if s.err != nil {
+		return
+	}
+	if s.closing && len(s.sndBuf) == 0 && len(s.sndQueue) == 0 {
+		s.close(net.ErrClosed, true)
+		return
+	}
+	if !s.established {
+		if now.Sub(s.lastSend) >= s.rto {
+			s.syn()
+		}
+	} else if now.Sub(s.lastSend) >= time.Duration(s.conf.KeepAlive)*time.Second {
+		s.output(cmdPing, 0, nil)
+	}
+}
+
+// 客户端发送SYN 没有cookie时填0 调用者加锁
+func (s *Session) syn() {
+	if s.cookie == nil {
+		s.output(cmdSyn, 0, make([]byte, cookieLen))
+	} else {
+		s.output(cmdSyn, 0, s.cookie)
+	}
+}
+
+// 发送一个包 调用者加锁
+func (s *Session) output(cmd byte, sn uint32, data []byte) {
+	s.lastSend = time.Now()
+	if s.conf.DropRate > 0 && rand.Float64() < s.conf.DropRate {
+		return
+	}
+	s.conn.WriteTo(packet(s.conv, cmd, sn, s.rcvNxt, data), s.remote)
+}
+
+// 关闭 调用者加锁
+func (s *Session) close(err error, fin bool) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	if fin {
+		s.output(cmdFin, 0, nil) // 可能丢失 对方超时后断开
+	}
+	close(s.die)
+	if s.l != nil {
+		s.l.remove(s)
+	} else {
+		s.conn.Close()
+	}
+}
+
+// Dial创建的Session读取数据
+func (s *Session) loopRead() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			s.close(err, false)
+			s.mu.Unlock()
+			return
+		}
+		if addr.String() != s.remote.String() {
+			continue
+		}
+		conv, cmd, sn, una, data, ok := parsePacket(buf[:n])
+		if !ok || conv != s.conv {
+			continue
+		}
+		s.input(cmd, sn, una, data)
+	}
+}
+
+// Dial创建的Session定时更新
+func (s *Session) loopUpdate() {
+	ticker := time.NewTicker(s.conf.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.die:
+			return
+		case now := <-ticker.C:
+			s.update(now)
+		}
+	}
+}
+
+func packet(conv uint32, cmd byte, sn, una uint32, data []byte) []byte {
+	buf := make([]byte, headLen+len(data))
+	binary.BigEndian.PutUint32(buf, conv)
+	buf[4] = cmd
+	binary.BigEndian.PutUint32(buf[5:], sn)
+	binary.BigEndian.PutUint32(buf[9:], una)
+	binary.BigEndian.PutUint16(buf[13:], uint16(len(data)))
+	copy(buf[headLen:], data)
+	return buf
+}
+
+func parsePacket(buf []byte) (conv uint32, cmd byte, sn, una uint32, data []byte, ok bool) {
+	if len(buf) < headLen {
+		return
+	}
+	l := int(binary.BigEndian.Uint16(buf[13:]))
+	if len(buf)-headLen < l {
+		return
+	}
+	return binary.BigEndian.Uint32(buf), buf[4], binary.BigEndian.Uint32(buf[5:]), binary.BigEndian.Uint32(buf[9:]), buf[headLen : headLen+l], true
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// 根据deadline创建定时器 ok为false表示已经超时
+func deadlineTimer(deadline time.Time) (*time.Timer, <-chan time.Time, bool) {
+	if deadline.IsZero() {
+		return nil, nil, true
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return nil, nil, false
+	}
+	timer := time.NewTimer(d)
+	return timer, timer.C, true
+}
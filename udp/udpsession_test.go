@@ -0,0 +1,137 @@
+package udp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionLoss(t *testing.T) {
+	conf := &Config{DropRate: 0.2}
+	l, err := Listen("127.0.0.1:0", func() *Config { return conf })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// 服务器原样返回
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	s, err := Dial(l.Addr().String(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 超过MTU的数据分包发送
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	go s.Write(data)
+	recv := make([]byte, 0, len(data))
+	buf := make([]byte, 4096)
+	s.SetReadDeadline(time.Now().Add(time.Second * 20))
+	for len(recv) < len(data) {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d %v", len(recv), err)
+		}
+		recv = append(recv, buf[:n]...)
+	}
+	if !bytes.Equal(recv, data) {
+		t.Fatal("data not equal")
+	}
+
+	// 读超时
+	s.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	if _, err := s.Read(buf); err == nil {
+		t.Fatal("read deadline")
+	}
+
+	// 关闭后对方读到EOF
+	s2, err := Dial(l.Addr().String(), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2.Write([]byte("bye"))
+	s2.Close()
+	c2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	b, err := io.ReadAll(c2)
+	if err != nil || string(b) != "bye" {
+		t.Fatalf("close %q %v", b, err)
+	}
+}
+
+func TestListenerCookie(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 1500)
+	read := func() ([]byte, error) {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+
+	// 不存在的会话的包和没有填充的SYN 不回复不创建
+	conn.Write(packet(1, cmdPush, 0, 0, []byte("hello")))
+	conn.Write(packet(1, cmdPing, 0, 0, nil))
+	conn.Write(packet(1, cmdSyn, 0, 0, nil))
+	if b, err := read(); err == nil {
+		t.Fatalf("reply %v", b)
+	}
+
+	// 没有cookie的SYN 只回复cookie 回复不比SYN长
+	syn := packet(1, cmdSyn, 0, 0, make([]byte, cookieLen))
+	conn.Write(syn)
+	b, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv, cmd, _, _, data, ok := parsePacket(b)
+	if !ok || conv != 1 || cmd != cmdCookie || len(b) > len(syn) {
+		t.Fatalf("cookie %v", b)
+	}
+	cookie := append([]byte(nil), data...)
+	if len(l.snapshot()) != 0 {
+		t.Fatal("session created without cookie")
+	}
+	// cookie和会话号绑定
+	conn.Write(packet(2, cmdSyn, 0, 0, cookie))
+	if b, err := read(); err != nil || b[4] != cmdCookie {
+		t.Fatalf("conv %v %v", b, err)
+	}
+
+	// 带上cookie重发SYN后创建
+	conn.Write(packet(1, cmdSyn, 0, 0, cookie))
+	if b, err := read(); err != nil || b[4] != cmdPing {
+		t.Fatalf("ping %v %v", b, err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.(*Session).Conv() != 1 {
+		t.Fatal(c.(*Session).Conv())
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+// https://github.com/yuwf/gobase
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶 协程安全
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒产生的令牌数
+	burst  int     // 桶容量
+	tokens float64 // 当前令牌数
+	last   time.Time
+}
+
+// NewTokenBucket 创建令牌桶，初始是满的 burst<=0时使用rate向上取整
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := &TokenBucket{last: time.Now()}
+	b.setLimit(rate, burst)
+	b.tokens = float64(b.burst)
+	return b
+}
+
+func (b *TokenBucket) setLimit(rate float64, burst int) {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+		if burst <= 0 {
+			burst = 1
+		}
+	}
+	b.rate = rate
+	b.burst = burst
+}
+
+// SetLimit 修改速率和容量 已有的令牌保留
+func (b *TokenBucket) SetLimit(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	b.setLimit(rate, burst)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+// Limit 返回速率和容量
+func (b *TokenBucket) Limit() (float64, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate, b.burst
+}
+
+func (b *TokenBucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > float64(b.burst) {
+			b.tokens = float64(b.burst)
+		}
+	}
+	b.last = now
+}
+
+// Allow 获取一个令牌
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(time.Now(), 1)
+}
+
+// AllowN 获取n个令牌 令牌不足时不扣除
+func (b *TokenBucket) AllowN(now time.Time, n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// ReturnN 归还n个令牌 获取后没有使用时调用，不超过容量
+func (b *TokenBucket) ReturnN(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += float64(n)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+// Full 令牌桶是否已满，满了表示一段时间内没有使用
+func (b *TokenBucket) Full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(now)
+	return b.tokens >= float64(b.burst)
+}
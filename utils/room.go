@@ -0,0 +1,155 @@
+package utils
+
+// https://github.com/yuwf/gobase
+
+import (
+	"sync"
+)
+
+// Rooms 房间管理 房间是一组成员的集合，用于广播 协程安全
+// 只负责成员关系，发送由使用方遍历Members完成
+type Rooms[T comparable] struct {
+	mu      sync.Mutex
+	rooms   map[string]*room[T]
+	members map[T]map[string]struct{} // 成员加入的房间
+}
+
+type room[T comparable] struct {
+	members map[T]struct{}
+	list    []T // members的快照 广播时使用 成员变化时置空 下次广播重新生成
+}
+
+func (r *room[T]) snapshot() []T {
+	if r.list == nil {
+		r.list = make([]T, 0, len(r.members))
+		for m := range r.members {
+			r.list = append(r.list, m)
+		}
+	}
+	return r.list
+}
+
+func NewRooms[T comparable]() *Rooms[T] {
+	return &Rooms[T]{
+		rooms:   map[string]*room[T]{},
+		members: map[T]map[string]struct{}{},
+	}
+}
+
+// Join 成员加入房间 valid不为nil时在锁内调用，返回false不加入，用于防止和成员离开时的清理交叉
+func (r *Rooms[T]) Join(name string, m T, valid func() bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if valid != nil && !valid() {
+		return false
+	}
+	r.join(name, m)
+	return true
+}
+
+func (r *Rooms[T]) join(name string, m T) {
+	rm, ok := r.rooms[name]
+	if !ok {
+		rm = &room[T]{members: map[T]struct{}{}}
+		r.rooms[name] = rm
+	}
+	if _, ok := rm.members[m]; ok {
+		return
+	}
+	rm.members[m] = struct{}{}
+	rm.list = nil
+	names := r.members[m]
+	if names == nil {
+		names = map[string]struct{}{}
+		r.members[m] = names
+	}
+	names[name] = struct{}{}
+}
+
+// Leave 成员离开房间
+func (r *Rooms[T]) Leave(name string, m T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leave(name, m)
+}
+
+// LeaveAll 成员离开加入的所有房间
+func (r *Rooms[T]) LeaveAll(m T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.members[m] {
+		r.leave(name, m)
+	}
+}
+
+func (r *Rooms[T]) leave(name string, m T) {
+	if names, ok := r.members[m]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(r.members, m)
+		}
+	}
+	rm, ok := r.rooms[name]
+	if !ok {
+		return
+	}
+	if _, ok := rm.members[m]; !ok {
+		return
+	}
+	delete(rm.members, m)
+	rm.list = nil
+	if len(rm.members) == 0 {
+		delete(r.rooms, name)
+	}
+}
+
+// Replace 用to替换from加入的所有房间 to原来加入的房间保留
+func (r *Rooms[T]) Replace(from, to T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if from == to {
+		return
+	}
+	for name := range r.members[from] {
+		r.leave(name, from)
+		r.join(name, to)
+	}
+}
+
+// Names 成员加入的房间
+func (r *Rooms[T]) Names(m T) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.members[m]))
+	for name := range r.members[m] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Count 房间内的成员数量
+func (r *Rooms[T]) Count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm, ok := r.rooms[name]; ok {
+		return len(rm.members)
+	}
+	return 0
+}
+
+// Len 房间数量
+func (r *Rooms[T]) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.rooms)
+}
+
+// Members 房间内成员的快照 返回的切片不能修改
+func (r *Rooms[T]) Members(name string) []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm, ok := r.rooms[name]; ok {
+		return rm.snapshot()
+	}
+	return nil
+}